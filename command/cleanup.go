@@ -0,0 +1,96 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+	"github.com/drone/runner-go/logger"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type cleanupCommand struct {
+	Hosts    []string
+	Username string
+	Password string
+	SSHKey   string
+	OS       string
+	Bases    []string
+	MaxAge   time.Duration
+}
+
+func (c *cleanupCommand) run(*kingpin.ParseContext) error {
+	janitor := &runtime.Janitor{
+		Platform: engine.Platform{
+			OS: c.OS,
+		},
+		Bases:  c.Bases,
+		MaxAge: c.MaxAge,
+	}
+
+	var sshkey string
+	if c.SSHKey != "" {
+		raw, err := ioutil.ReadFile(c.SSHKey)
+		if err != nil {
+			return err
+		}
+		sshkey = string(raw)
+	}
+
+	for _, host := range c.Hosts {
+		janitor.Servers = append(janitor.Servers, engine.Server{
+			Hostname: host,
+			Username: c.Username,
+			Password: c.Password,
+			SSHKey:   sshkey,
+		})
+	}
+
+	logger.Default = logger.Logrus(
+		logrus.NewEntry(
+			logrus.StandardLogger(),
+		),
+	)
+
+	janitor.Prune(nocontext)
+	return nil
+}
+
+func registerCleanup(app *kingpin.Application) {
+	c := new(cleanupCommand)
+
+	cmd := app.Command("cleanup", "removes orphaned workspaces from remote hosts").
+		Action(c.run)
+
+	cmd.Arg("hosts", "remote host addresses").
+		Required().
+		StringsVar(&c.Hosts)
+
+	cmd.Flag("username", "ssh username").
+		Required().
+		StringVar(&c.Username)
+
+	cmd.Flag("password", "ssh password").
+		StringVar(&c.Password)
+
+	cmd.Flag("ssh-key", "ssh private key file").
+		StringVar(&c.SSHKey)
+
+	cmd.Flag("os", "remote host operating system").
+		Default("linux").
+		StringVar(&c.OS)
+
+	cmd.Flag("base", "workspace base directory, may be repeated").
+		StringsVar(&c.Bases)
+
+	cmd.Flag("max-age", "remove workspaces older than the maximum age").
+		Default("24h").
+		DurationVar(&c.MaxAge)
+}
@@ -9,13 +9,12 @@ import (
 	"os"
 
 	"github.com/drone-runners/drone-runner-ssh/command/daemon"
+	"github.com/drone-runners/drone-runner-ssh/command/service"
+	"github.com/drone-runners/drone-runner-ssh/internal/version"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-// program version
-var version = "0.0.0"
-
 // empty context
 var nocontext = context.Background()
 
@@ -25,8 +24,13 @@ func Command() {
 	app := kingpin.New("drone", "drone exec runner")
 	registerCompile(app)
 	registerExec(app)
+	registerCleanup(app)
+	registerKeygen(app)
+	registerMaintenance(app)
 	daemon.Register(app)
+	service.Register(app)
+	registerVersion(app)
 
-	kingpin.Version(version)
+	kingpin.Version(version.Version)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 }
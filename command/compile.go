@@ -12,9 +12,9 @@ import (
 	"strings"
 
 	"github.com/drone-runners/drone-runner-ssh/command/internal"
+	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/compiler"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
-	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/secret"
@@ -25,9 +25,11 @@ import (
 type compileCommand struct {
 	*internal.Flags
 
-	Source  *os.File
-	Environ map[string]string
-	Secrets map[string]string
+	Source      *os.File
+	Environ     map[string]string
+	Secrets     map[string]string
+	Changes     []string
+	DumpScripts string
 }
 
 func (c *compileCommand) run(*kingpin.ParseContext) error {
@@ -59,7 +61,14 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 
 	// evaluates string replacement expressions and returns an
 	// update configuration.
-	config, err := envsubst.Eval(string(rawsource), subf)
+	config, err := resource.Substitute(string(rawsource), subf)
+	if err != nil {
+		return err
+	}
+
+	// converts the configuration generated by a jsonnet or
+	// starlark template to yaml, if required.
+	config, err = resource.Convert(config)
 	if err != nil {
 		return err
 	}
@@ -88,8 +97,20 @@ func (c *compileCommand) run(*kingpin.ParseContext) error {
 		System:   c.System,
 		Environ:  c.Environ,
 		Secret:   secret.StaticVars(c.Secrets),
+		Changes:  c.Changes,
+	}
+	spec, err := comp.Compile(nocontext)
+	if err != nil {
+		return err
+	}
+
+	// write the generated scripts to the local directory,
+	// for debugging purposes.
+	if c.DumpScripts != "" {
+		if err := engine.DumpScripts(spec, c.DumpScripts); err != nil {
+			return err
+		}
 	}
-	spec := comp.Compile(nocontext)
 
 	// encode the pipeline in json format and print to the
 	// console for inspection.
@@ -117,6 +138,12 @@ func registerCompile(app *kingpin.Application) {
 	cmd.Flag("environ", "environment variables").
 		StringMapVar(&c.Environ)
 
+	cmd.Flag("changes", "files changed by the build").
+		StringsVar(&c.Changes)
+
+	cmd.Flag("dump-scripts", "write the generated scripts to the directory").
+		StringVar(&c.DumpScripts)
+
 	// shared pipeline flags
 	c.Flags = internal.ParseFlags(cmd)
 }
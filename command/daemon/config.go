@@ -5,16 +5,28 @@
 package daemon
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
+	"time"
 
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/compiler"
+
+	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 )
 
 // Config stores the system configuration.
 type Config struct {
-	Debug bool `envconfig:"DRONE_DEBUG"`
-	Trace bool `envconfig:"DRONE_TRACE"`
+	Debug       bool   `envconfig:"DRONE_DEBUG"`
+	Trace       bool   `envconfig:"DRONE_TRACE"`
+	DumpScripts string `envconfig:"DRONE_DEBUG_DUMP_SCRIPTS"`
+	HostsFile   string `envconfig:"DRONE_HOSTS_FILE"`
 
 	Logger struct {
 		File       string `envconfig:"DRONE_LOG_FILE"`
@@ -31,6 +43,10 @@ type Config struct {
 		SkipVerify bool   `envconfig:"DRONE_RPC_SKIP_VERIFY"`
 		Dump       bool   `envconfig:"DRONE_RPC_DUMP_HTTP"`
 		DumpBody   bool   `envconfig:"DRONE_RPC_DUMP_HTTP_BODY"`
+		Cert       string `envconfig:"DRONE_RPC_CLIENT_CERT"`
+		Key        string `envconfig:"DRONE_RPC_CLIENT_KEY"`
+		CA         string `envconfig:"DRONE_RPC_CLIENT_CA"`
+		Proxy      string `envconfig:"DRONE_RPC_PROXY"`
 	}
 
 	Dashboard struct {
@@ -38,33 +54,148 @@ type Config struct {
 		Username string `envconfig:"DRONE_UI_USERNAME"`
 		Password string `envconfig:"DRONE_UI_PASSWORD"`
 		Realm    string `envconfig:"DRONE_UI_REALM" default:"MyRealm"`
+		Token    string `envconfig:"DRONE_UI_TOKEN"`
+		Secret   string `envconfig:"DRONE_UI_SESSION_SECRET"`
+
+		OIDC struct {
+			Issuer       string   `envconfig:"DRONE_UI_OIDC_ISSUER"`
+			ClientID     string   `envconfig:"DRONE_UI_OIDC_CLIENT_ID"`
+			ClientSecret string   `envconfig:"DRONE_UI_OIDC_CLIENT_SECRET"`
+			RedirectURL  string   `envconfig:"DRONE_UI_OIDC_REDIRECT_URL"`
+			Allowed      []string `envconfig:"DRONE_UI_OIDC_ALLOWED"`
+		}
 	}
 
 	Server struct {
-		Proto string `envconfig:"DRONE_SERVER_PROTO"`
-		Host  string `envconfig:"DRONE_SERVER_HOST"`
-		Port  string `envconfig:"DRONE_SERVER_PORT" default:":3000"`
-		Acme  bool   `envconfig:"DRONE_SERVER_ACME"`
+		Proto     string `envconfig:"DRONE_SERVER_PROTO"`
+		Host      string `envconfig:"DRONE_SERVER_HOST"`
+		Port      string `envconfig:"DRONE_SERVER_PORT" default:":3000"`
+		Acme      bool   `envconfig:"DRONE_SERVER_ACME"`
+		AcmeEmail string `envconfig:"DRONE_SERVER_ACME_EMAIL"`
+		AcmeCache string `envconfig:"DRONE_SERVER_ACME_CACHE"`
+		Cert      string `envconfig:"DRONE_SERVER_CERT"`
+		Key       string `envconfig:"DRONE_SERVER_KEY"`
 	}
 
 	Runner struct {
-		Name     string            `envconfig:"DRONE_RUNNER_NAME"`
-		Capacity int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"10"`
-		Procs    int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
-		Labels   map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
-		Environ  map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
+		Name       string            `envconfig:"DRONE_RUNNER_NAME"`
+		Capacity   int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"10"`
+		Procs      int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
+		PerHost    bool              `envconfig:"DRONE_RUNNER_MAX_PROCS_PER_HOST"`
+		Labels     map[string]string `envconfig:"DRONE_RUNNER_LABELS"`
+		Environ    map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
+		Secrets    map[string]string `envconfig:"DRONE_RUNNER_SECRETS"`
+		EnvFile    string            `envconfig:"DRONE_RUNNER_ENV_FILE"`
+		Health     time.Duration     `envconfig:"DRONE_RUNNER_HEALTH_CHECK_TIMEOUT" default:"30s"`
+		Debug      bool              `envconfig:"DRONE_RUNNER_DEBUG"`
+		Locale     string            `envconfig:"DRONE_RUNNER_LOCALE" default:"C.UTF-8"`
+		Timestamps string            `envconfig:"DRONE_RUNNER_TIMESTAMPS"`
+		Syslog     bool              `envconfig:"DRONE_RUNNER_SYSLOG_MARKERS"`
+		Cleanup    bool              `envconfig:"DRONE_RUNNER_SETUP_CLEANUP" default:"true"`
+	}
+
+	Workspace struct {
+		Base        string `envconfig:"DRONE_WORKSPACE_BASE"`
+		BaseWindows string `envconfig:"DRONE_WORKSPACE_BASE_WINDOWS"`
+	}
+
+	SSH struct {
+		Ciphers           []string `envconfig:"DRONE_SSH_CIPHERS"`
+		KeyExchanges      []string `envconfig:"DRONE_SSH_KEXS"`
+		MACs              []string `envconfig:"DRONE_SSH_MACS"`
+		AgentSocket       string   `envconfig:"DRONE_SSH_AGENT_SOCKET"`
+		ForwardAgent      string   `envconfig:"DRONE_SSH_FORWARD_AGENT_SOCKET"`
+		X11Display        string   `envconfig:"DRONE_SSH_X11_DISPLAY"`
+		TunnelAllow       []string `envconfig:"DRONE_SSH_TUNNEL_ALLOW"`
+		TransportAllow    []string `envconfig:"DRONE_SSH_TRANSPORT_ALLOW"`
+		ServerTunnelAllow []string `envconfig:"DRONE_SSH_SERVER_TUNNEL_ALLOW"`
+		MaxSessions       int      `envconfig:"DRONE_SSH_MAX_SESSIONS"`
+		PoolFile          string   `envconfig:"DRONE_SSH_POOL_FILE"`
+	}
+
+	Sync struct {
+		Dir       string `envconfig:"DRONE_SYNC_DIR"`
+		Untrusted bool   `envconfig:"DRONE_SYNC_UNTRUSTED"`
 	}
 
 	Limit struct {
-		Repos   []string `envconfig:"DRONE_LIMIT_REPOS"`
-		Events  []string `envconfig:"DRONE_LIMIT_EVENTS"`
-		Trusted bool     `envconfig:"DRONE_LIMIT_TRUSTED"`
+		Repos     []string `envconfig:"DRONE_LIMIT_REPOS"`
+		Ignore    []string `envconfig:"DRONE_LIMIT_REPOS_IGNORE"`
+		Events    []string `envconfig:"DRONE_LIMIT_EVENTS"`
+		Trusted   bool     `envconfig:"DRONE_LIMIT_TRUSTED"`
+		Branches  []string `envconfig:"DRONE_LIMIT_BRANCHES"`
+		Instances []string `envconfig:"DRONE_LIMIT_INSTANCES"`
+		PerHost   int      `envconfig:"DRONE_MAX_STAGES_PER_HOST"`
+		PerRepo   int      `envconfig:"DRONE_LIMIT_REPO_CONCURRENCY"`
+	}
+
+	Log struct {
+		Interval time.Duration `envconfig:"DRONE_LOG_STREAM_INTERVAL" default:"1s"`
+		Batch    int           `envconfig:"DRONE_LOG_BATCH_SIZE"`
+		MaxSize  int           `envconfig:"DRONE_LOG_MAX_SIZE"`
+	}
+
+	Tracing struct {
+		Endpoint string `envconfig:"DRONE_TRACING_ENDPOINT"`
+		Service  string `envconfig:"DRONE_TRACING_SERVICE" default:"drone-runner-ssh"`
 	}
 
 	Secret struct {
 		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT"`
 		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN"`
 		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY"`
+		Strict     bool   `envconfig:"DRONE_SECRET_STRICT" default:"true"`
+		File       string `envconfig:"DRONE_SECRET_FILE"`
+	}
+
+	Credentials struct {
+		Endpoint   string `envconfig:"DRONE_CREDENTIALS_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_CREDENTIALS_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_CREDENTIALS_PLUGIN_SKIP_VERIFY"`
+	}
+
+	Environ struct {
+		Endpoint   string `envconfig:"DRONE_ENV_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_ENV_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_ENV_PLUGIN_SKIP_VERIFY"`
+	}
+
+	Changes struct {
+		Endpoint   string `envconfig:"DRONE_CHANGES_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_CHANGES_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_CHANGES_PLUGIN_SKIP_VERIFY"`
+	}
+
+	Admission struct {
+		Endpoint   string `envconfig:"DRONE_ADMISSION_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_ADMISSION_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_ADMISSION_PLUGIN_SKIP_VERIFY"`
+	}
+
+	Provision struct {
+		Endpoint   string `envconfig:"DRONE_PROVISION_PLUGIN_ENDPOINT"`
+		Token      string `envconfig:"DRONE_PROVISION_PLUGIN_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_PROVISION_PLUGIN_SKIP_VERIFY"`
+		Command    string `envconfig:"DRONE_PROVISION_COMMAND"`
+	}
+
+	Audit struct {
+		File       string `envconfig:"DRONE_AUDIT_FILE"`
+		Endpoint   string `envconfig:"DRONE_AUDIT_ENDPOINT"`
+		Token      string `envconfig:"DRONE_AUDIT_TOKEN"`
+		SkipVerify bool   `envconfig:"DRONE_AUDIT_SKIP_VERIFY"`
+	}
+
+	Janitor struct {
+		Hosts      []string      `envconfig:"DRONE_JANITOR_HOSTS"`
+		Username   string        `envconfig:"DRONE_JANITOR_USERNAME"`
+		Password   string        `envconfig:"DRONE_JANITOR_PASSWORD"`
+		SSHKey     string        `ignored:"true"`
+		SSHKeyFile string        `envconfig:"DRONE_JANITOR_SSH_KEY_FILE"`
+		OS         string        `envconfig:"DRONE_JANITOR_OS"       default:"linux"`
+		Base       string        `envconfig:"DRONE_JANITOR_BASE"`
+		MaxAge     time.Duration `envconfig:"DRONE_JANITOR_MAX_AGE"  default:"24h"`
+		Interval   time.Duration `envconfig:"DRONE_JANITOR_INTERVAL" default:"1h"`
 	}
 }
 
@@ -77,8 +208,78 @@ func fromEnviron() (Config, error) {
 	if config.Runner.Name == "" {
 		config.Runner.Name, _ = os.Hostname()
 	}
+	if config.Dashboard.OIDC.Issuer != "" && config.Dashboard.OIDC.RedirectURL == "" {
+		if config.Server.Host == "" {
+			return config, errors.New("DRONE_SERVER_HOST or DRONE_UI_OIDC_REDIRECT_URL is required when DRONE_UI_OIDC_ISSUER is set")
+		}
+		proto := config.Server.Proto
+		if proto == "" {
+			proto = "http"
+			if config.Server.Acme || config.Server.Cert != "" {
+				proto = "https"
+			}
+		}
+		config.Dashboard.OIDC.RedirectURL = fmt.Sprintf(
+			"%s://%s/login/callback",
+			proto,
+			config.Server.Host,
+		)
+	}
 	if config.Dashboard.Password == "" {
-		config.Dashboard.Disabled = true
+		if config.Dashboard.Token == "" && config.Dashboard.OIDC.Issuer == "" {
+			config.Dashboard.Disabled = true
+		} else {
+			// the dashboard handlers require basic auth. If only
+			// token or oidc authentication is configured, a random
+			// password is generated that is never exposed, which
+			// disables basic auth.
+			config.Dashboard.Password = random()
+		}
+	}
+	if config.Runner.EnvFile != "" {
+		envs, err := godotenv.Read(config.Runner.EnvFile)
+		if err != nil {
+			return config, err
+		}
+		// environment variables defined in DRONE_RUNNER_ENVIRON
+		// take precedence over the environment file.
+		for k, v := range config.Runner.Environ {
+			envs[k] = v
+		}
+		config.Runner.Environ = envs
+	}
+	if config.Janitor.SSHKeyFile != "" {
+		raw, err := ioutil.ReadFile(config.Janitor.SSHKeyFile)
+		if err != nil {
+			return config, err
+		}
+		config.Janitor.SSHKey = string(raw)
+	}
+	if len(config.Janitor.Hosts) != 0 && config.Janitor.Interval <= 0 {
+		return config, errors.New("DRONE_JANITOR_INTERVAL must be greater than zero")
+	}
+	for _, rule := range config.SSH.TunnelAllow {
+		if _, _, err := compiler.ParseTunnelRule(rule); err != nil {
+			return config, fmt.Errorf("DRONE_SSH_TUNNEL_ALLOW: %s", err)
+		}
+	}
+	for _, rule := range config.SSH.TransportAllow {
+		if _, err := path.Match(rule, ""); err != nil || !engine.IsTransport(rule) {
+			return config, fmt.Errorf("DRONE_SSH_TRANSPORT_ALLOW: invalid transport rule %q", rule)
+		}
+	}
+	for _, rule := range config.SSH.ServerTunnelAllow {
+		if _, _, err := compiler.ParseServerTunnelRule(rule); err != nil {
+			return config, fmt.Errorf("DRONE_SSH_SERVER_TUNNEL_ALLOW: %s", err)
+		}
+	}
+	if (config.Client.Cert == "") != (config.Client.Key == "") {
+		return config, errors.New("DRONE_RPC_CLIENT_CERT and DRONE_RPC_CLIENT_KEY must be set together")
+	}
+	// certificates are only issued for the public hostname
+	// of the dashboard.
+	if config.Server.Acme && config.Server.Host == "" {
+		return config, errors.New("DRONE_SERVER_HOST is required when DRONE_SERVER_ACME is enabled")
 	}
 	config.Client.Address = fmt.Sprintf(
 		"%s://%s",
@@ -87,3 +288,10 @@ func fromEnviron() (Config, error) {
 	)
 	return config, nil
 }
+
+// helper function returns a random hex-encoded string.
+func random() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
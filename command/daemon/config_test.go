@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// This test verifies the global environment variables are
+// loaded from the environment file, and that the variables
+// defined in DRONE_RUNNER_ENVIRON take precedence.
+func TestFromEnviron_EnvFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "drone-runner-env")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# global variables\nGOPROXY=https://proxy.golang.org\nGOFLAGS=-mod=vendor\nexport GOPATH=/go\n")
+	file.Close()
+
+	defer setenv(t, "DRONE_RPC_HOST", "drone.company.com")()
+	defer setenv(t, "DRONE_RPC_SECRET", "correct-horse-battery-staple")()
+	defer setenv(t, "DRONE_RUNNER_ENVIRON", "GOFLAGS:-mod=mod,CGO_ENABLED:0")()
+	defer setenv(t, "DRONE_RUNNER_ENV_FILE", file.Name())()
+
+	config, err := fromEnviron()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := map[string]string{
+		"GOPROXY":     "https://proxy.golang.org",
+		"GOFLAGS":     "-mod=mod",
+		"GOPATH":      "/go",
+		"CGO_ENABLED": "0",
+	}
+	if diff := cmp.Diff(config.Runner.Environ, want); diff != "" {
+		t.Errorf("Unexpected runner environment")
+		t.Log(diff)
+	}
+}
+
+// This test verifies an error is returned when the environment
+// file cannot be read.
+func TestFromEnviron_EnvFileNotFound(t *testing.T) {
+	defer setenv(t, "DRONE_RPC_HOST", "drone.company.com")()
+	defer setenv(t, "DRONE_RPC_SECRET", "correct-horse-battery-staple")()
+	defer setenv(t, "DRONE_RUNNER_ENV_FILE", "testdata/does-not-exist.env")()
+
+	if _, err := fromEnviron(); err == nil {
+		t.Errorf("Expect error reading environment file")
+	}
+}
+
+// helper function sets the environment variable and returns a
+// function that restores the previous value.
+func setenv(t *testing.T, key, value string) func() {
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
@@ -6,21 +6,43 @@ package daemon
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+	"github.com/drone-runners/drone-runner-ssh/internal/admission"
+	"github.com/drone-runners/drone-runner-ssh/internal/audit"
+	"github.com/drone-runners/drone-runner-ssh/internal/auth"
+	"github.com/drone-runners/drone-runner-ssh/internal/capacity"
+	"github.com/drone-runners/drone-runner-ssh/internal/changes"
+	"github.com/drone-runners/drone-runner-ssh/internal/credentials"
+	hostspage "github.com/drone-runners/drone-runner-ssh/internal/hosts"
+	"github.com/drone-runners/drone-runner-ssh/internal/livelog"
+	"github.com/drone-runners/drone-runner-ssh/internal/maintenance"
 	"github.com/drone-runners/drone-runner-ssh/internal/match"
+	"github.com/drone-runners/drone-runner-ssh/internal/provision"
+	"github.com/drone-runners/drone-runner-ssh/internal/rerun"
+	"github.com/drone-runners/drone-runner-ssh/internal/secrets"
+	"github.com/drone-runners/drone-runner-ssh/internal/server"
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
+	"github.com/drone-runners/drone-runner-ssh/internal/variables"
+	"github.com/drone-runners/drone-runner-ssh/internal/version"
 	"github.com/drone-runners/drone-runner-ssh/runtime"
 
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/handler/router"
 	"github.com/drone/runner-go/logger"
 	loghistory "github.com/drone/runner-go/logger/history"
+	"github.com/drone/runner-go/pipeline"
 	"github.com/drone/runner-go/pipeline/history"
 	"github.com/drone/runner-go/pipeline/remote"
 	"github.com/drone/runner-go/secret"
-	"github.com/drone/runner-go/server"
 	"github.com/drone/signal"
 
 	"github.com/joho/godotenv"
@@ -49,11 +71,69 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 	// setup the global logrus logger.
 	setupLogger(config)
 
+	// load the secrets file, if configured.
+	secretProvider, err := setupSecret(config)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("cannot load the secrets file")
+		return err
+	}
+
+	// load the named pools of remote hosts, if configured.
+	var pools map[string]*runtime.Pool
+	if config.SSH.PoolFile != "" {
+		pools, err = runtime.ParsePools(config.SSH.PoolFile)
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("cannot load host pools")
+			return err
+		}
+	}
+
+	// open the audit log of remote commands, if configured.
+	sink, err := setupAudit(config)
+	if err != nil {
+		logrus.WithError(err).
+			Errorln("cannot open audit log")
+		return err
+	}
+
+	// load the remote host registry, and add the configured
+	// hosts so they are listed before they are first used.
+	hosts := &runtime.Hosts{Path: config.HostsFile}
+	if err := hosts.Load(); err != nil {
+		logrus.WithError(err).
+			Errorln("cannot load host registry")
+		return err
+	}
+	for _, pool := range pools {
+		for _, host := range pool.Hosts {
+			hosts.Add(host)
+		}
+	}
+	for _, host := range config.Janitor.Hosts {
+		hosts.Add(host)
+	}
+
 	cli := client.New(
 		config.Client.Address,
 		config.Client.Secret,
 		config.Client.SkipVerify,
 	)
+	if config.Client.Cert != "" || config.Client.CA != "" || config.Client.Proxy != "" {
+		transport, err := setupClientTransport(config)
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("cannot configure the rpc client")
+			return err
+		}
+		cli.Client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Transport: transport,
+		}
+	}
 	if config.Client.Dump {
 		cli.Dumper = logger.StandardDumper(
 			config.Client.DumpBody,
@@ -65,35 +145,109 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	engine := engine.New()
+	engine := engine.Traced(engine.New())
+	spans := tracing.New(config.Tracing.Endpoint, config.Tracing.Service)
+	registry := new(runtime.Registry)
+
+	var janitor *runtime.Janitor
+	if len(config.Janitor.Hosts) != 0 {
+		janitor = setupJanitor(config)
+		janitor.Registry = registry
+		if sink != nil {
+			janitor.Audit = audit.NewRunner(sink)
+		}
+	}
 	remote := remote.New(cli)
+	stream := livelog.New(cli, livelog.Config{
+		Interval: config.Log.Interval,
+		Batch:    config.Log.Batch,
+		Limit:    config.Log.MaxSize,
+	})
 	tracer := history.New(remote)
 	hook := loghistory.New()
 	logrus.AddHook(hook)
 
+	execer := setupExecer(config, tracer, stream, engine)
+
 	poller := &runtime.Poller{
 		Client: cli,
 		Runner: &runtime.Runner{
 			Client:   cli,
 			Environ:  config.Runner.Environ,
+			Secrets:  config.Runner.Secrets,
 			Machine:  config.Runner.Name,
 			Reporter: tracer,
 			Match: match.Func(
 				config.Limit.Repos,
+				config.Limit.Ignore,
 				config.Limit.Events,
 				config.Limit.Trusted,
 			),
-			Secret: secret.External(
-				config.Secret.Endpoint,
-				config.Secret.Token,
-				config.Secret.SkipVerify,
+			Limits: match.Limits{
+				Branches:  config.Limit.Branches,
+				Instances: config.Limit.Instances,
+			},
+			Labels:               config.Runner.Labels,
+			HealthCheck:          config.Runner.Health,
+			Debug:                config.Runner.Debug,
+			Retain:               !config.Runner.Cleanup,
+			DumpScripts:          config.DumpScripts,
+			Ciphers:              config.SSH.Ciphers,
+			KeyExchanges:         config.SSH.KeyExchanges,
+			MACs:                 config.SSH.MACs,
+			SSHAgent:             config.SSH.AgentSocket,
+			ForwardAgent:         config.SSH.ForwardAgent,
+			X11Display:           config.SSH.X11Display,
+			SyncDir:              config.Sync.Dir,
+			SyncUntrusted:        config.Sync.Untrusted,
+			TunnelAllow:          config.SSH.TunnelAllow,
+			TransportAllow:       config.SSH.TransportAllow,
+			ServerTunnelAllow:    config.SSH.ServerTunnelAllow,
+			MaxSessions:          config.SSH.MaxSessions,
+			Locale:               config.Runner.Locale,
+			Timestamps:           config.Runner.Timestamps,
+			Syslog:               config.Runner.Syslog,
+			WorkspaceBase:        config.Workspace.Base,
+			WorkspaceBaseWindows: config.Workspace.BaseWindows,
+			Registry:             registry,
+			Janitor:              janitor,
+			MaxStages:            config.Runner.Capacity,
+			Pools:                pools,
+			Maintenance:          new(runtime.Maintenance),
+			Hosts:                hosts,
+			Tracer:               spans,
+			Scheduler: &runtime.Scheduler{
+				Max: config.Limit.PerHost,
+			},
+			RepoScheduler: &runtime.Scheduler{
+				Max: config.Limit.PerRepo,
+			},
+			Dependencies:    new(runtime.Dependencies),
+			Secret:          secretProvider,
+			OptionalSecrets: !config.Secret.Strict,
+			Credentials: credentials.External(
+				config.Credentials.Endpoint,
+				config.Credentials.Token,
+				config.Credentials.SkipVerify,
 			),
-			Execer: runtime.NewExecer(
-				tracer,
-				remote,
-				engine,
-				config.Runner.Procs,
+			Changes: changes.External(
+				config.Changes.Endpoint,
+				config.Changes.Token,
+				config.Changes.SkipVerify,
 			),
+			Variables: variables.External(
+				config.Environ.Endpoint,
+				config.Environ.Token,
+				config.Environ.SkipVerify,
+			),
+			Admission: admission.External(
+				config.Admission.Endpoint,
+				config.Admission.Token,
+				config.Admission.SkipVerify,
+			),
+			Provisioner: setupProvisioner(config),
+			Audit:       sink,
+			Execer:      execer,
 		},
 		Filter: &client.Filter{
 			Kind:   resource.Kind,
@@ -112,17 +266,59 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		cancel()
 	})
 
+	// if the daemon is running as a windows service, the
+	// service manager can request the daemon terminate.
+	watchService("drone-runner-ssh", cancel)
+
 	var g errgroup.Group
-	server := server.Server{
-		Addr: config.Server.Port,
-		Handler: router.New(tracer, hook, router.Config{
-			Username: config.Dashboard.Username,
-			Password: config.Dashboard.Password,
-			Realm:    config.Dashboard.Realm,
-		}),
+	mux := http.NewServeMux()
+	mux.Handle("/api/rerun", rerun.Handler(registry, execer.(runtime.StepExecer), rerun.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+	mux.Handle("/api/capacity", capacity.Handler(poller.Runner, capacity.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+	mux.Handle("/api/maintenance", maintenance.Handler(poller.Runner.Maintenance, maintenance.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+	mux.Handle("/hosts", hostspage.Handler(hosts, hostspage.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+	mux.Handle("/", router.New(tracer, hook, router.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+
+	// the version endpoint does not require authentication. All
+	// other endpoints accept a bearer token or oidc session, in
+	// addition to basic auth.
+	root := http.NewServeMux()
+	root.Handle("/version", version.Handler())
+	root.Handle("/", auth.Handler(setupAuth(config), mux))
+
+	server := &server.Server{
+		Addr:     config.Server.Port,
+		Handler:  root,
+		Cert:     config.Server.Cert,
+		Key:      config.Server.Key,
+		Acme:     config.Server.Acme,
+		Host:     config.Server.Host,
+		Email:    config.Server.AcmeEmail,
+		CacheDir: config.Server.AcmeCache,
 	}
 
 	logrus.WithField("addr", config.Server.Port).
+		WithField("acme", config.Server.Acme).
+		WithField("tls", config.Server.Cert != "").
 		Infoln("starting the server")
 
 	g.Go(func() error {
@@ -151,6 +347,27 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		}
 	}
 
+	if spans != nil {
+		g.Go(func() error {
+			logrus.WithField("endpoint", config.Tracing.Endpoint).
+				Infoln("starting the tracer")
+
+			spans.Start(ctx, 5*time.Second)
+			return nil
+		})
+	}
+
+	if janitor != nil {
+		g.Go(func() error {
+			logrus.WithField("hosts", config.Janitor.Hosts).
+				WithField("max-age", config.Janitor.MaxAge).
+				Infoln("starting the janitor")
+
+			janitor.Start(ctx)
+			return nil
+		})
+	}
+
 	g.Go(func() error {
 		logrus.WithField("capacity", config.Runner.Capacity).
 			WithField("endpoint", config.Client.Address).
@@ -186,6 +403,180 @@ func setupLogger(config Config) {
 	}
 }
 
+// helper function configures the pipeline execer from the
+// loaded configuration. The maximum number of concurrent
+// steps is applied per remote host, if configured.
+func setupExecer(config Config, reporter pipeline.Reporter, streamer pipeline.Streamer, engine engine.Engine) runtime.Execer {
+	if config.Runner.PerHost {
+		return runtime.NewHostExecer(reporter, streamer, engine, config.Runner.Procs)
+	}
+	return runtime.NewExecer(reporter, streamer, engine, config.Runner.Procs)
+}
+
+// helper function configures the host provisioner from the
+// loaded configuration. The command takes precedence over the
+// http endpoint.
+func setupProvisioner(config Config) provision.Provider {
+	if config.Provision.Command != "" {
+		return provision.Command(config.Provision.Command)
+	}
+	return provision.External(
+		config.Provision.Endpoint,
+		config.Provision.Token,
+		config.Provision.SkipVerify,
+	)
+}
+
+// helper function configures the secret provider from the
+// loaded configuration. Secrets defined in the secrets file
+// are combined with the external secret plugin.
+func setupSecret(config Config) (secret.Provider, error) {
+	provider := secrets.External(
+		config.Secret.Endpoint,
+		config.Secret.Token,
+		config.Secret.SkipVerify,
+	)
+	if config.Secret.File == "" {
+		return provider, nil
+	}
+	list, err := secrets.ParseFile(config.Secret.File)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Combine(provider, secrets.File(list)), nil
+}
+
+// helper function configures the audit sink from the loaded
+// configuration. The file takes precedence over the http
+// endpoint. If neither is configured, a nil sink is returned
+// and remote commands are not audited.
+func setupAudit(config Config) (audit.Sink, error) {
+	switch {
+	case config.Audit.File != "":
+		return audit.File(config.Audit.File)
+	case config.Audit.Endpoint != "":
+		return audit.HTTP(
+			config.Audit.Endpoint,
+			config.Audit.Token,
+			config.Audit.SkipVerify,
+		), nil
+	default:
+		return nil, nil
+	}
+}
+
+// helper function configures the rpc client transport. The
+// transport is cloned from the default transport to retain the
+// default timeouts and connection pooling. The proxy defaults
+// to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables, and is overridden by DRONE_RPC_PROXY.
+func setupClientTransport(config Config) (*http.Transport, error) {
+	tlsconf, err := setupClientTLS(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconf
+	if config.Client.Proxy != "" {
+		proxy, err := url.Parse(config.Client.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return transport, nil
+}
+
+// helper function configures the tls client certificate and
+// certificate authority used to mutually authenticate with the
+// drone server.
+func setupClientTLS(config Config) (*tls.Config, error) {
+	tlsconf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.Client.SkipVerify,
+	}
+	if config.Client.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(config.Client.Cert, config.Client.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsconf.Certificates = []tls.Certificate{cert}
+	}
+	if config.Client.CA != "" {
+		raw, err := ioutil.ReadFile(config.Client.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, errors.New("cannot parse the rpc certificate authority")
+		}
+		tlsconf.RootCAs = pool
+	}
+	return tlsconf, nil
+}
+
+// helper function configures the dashboard authentication
+// from the loaded configuration.
+func setupAuth(config Config) auth.Config {
+	conf := auth.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Token:    config.Dashboard.Token,
+		Secret:   []byte(config.Dashboard.Secret),
+	}
+	if config.Dashboard.OIDC.Issuer != "" {
+		conf.OIDC = &auth.OIDC{
+			Issuer:       config.Dashboard.OIDC.Issuer,
+			ClientID:     config.Dashboard.OIDC.ClientID,
+			ClientSecret: config.Dashboard.OIDC.ClientSecret,
+			RedirectURL:  config.Dashboard.OIDC.RedirectURL,
+			Allowed:      config.Dashboard.OIDC.Allowed,
+		}
+	}
+	// if the session secret is not configured, a random secret
+	// is generated and sessions expire when the runner restarts.
+	if len(conf.Secret) == 0 {
+		conf.Secret = []byte(random())
+	}
+	return conf
+}
+
+// helper function configures the workspace janitor from
+// the loaded configuration.
+func setupJanitor(config Config) *runtime.Janitor {
+	janitor := &runtime.Janitor{
+		Platform: engine.Platform{
+			OS: config.Janitor.OS,
+		},
+		MaxAge:   config.Janitor.MaxAge,
+		Interval: config.Janitor.Interval,
+	}
+	// the janitor prunes workspaces in the janitor base
+	// directory and in the workspace base directory, which
+	// defaults to the temporary directory of the platform.
+	base := config.Workspace.Base
+	if config.Janitor.OS == "windows" {
+		base = config.Workspace.BaseWindows
+	}
+	if base == "" {
+		base = engine.TempBase(config.Janitor.OS)
+	}
+	janitor.Bases = append(janitor.Bases, config.Janitor.Base, base)
+	for _, host := range config.Janitor.Hosts {
+		janitor.Servers = append(janitor.Servers, engine.Server{
+			Hostname:     host,
+			Username:     config.Janitor.Username,
+			Password:     config.Janitor.Password,
+			SSHKey:       config.Janitor.SSHKey,
+			Ciphers:      config.SSH.Ciphers,
+			KeyExchanges: config.SSH.KeyExchanges,
+			MACs:         config.SSH.MACs,
+		})
+	}
+	return janitor
+}
+
 // Register the daemon command.
 func Register(app *kingpin.Application) {
 	c := new(daemonCommand)
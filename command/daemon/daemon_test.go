@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+// This test verifies the rpc client transport retains the
+// default transport timeouts when configured with tls.
+func TestSetupClientTransport(t *testing.T) {
+	config := Config{}
+	config.Client.SkipVerify = true
+	transport, err := setupClientTransport(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defaults := http.DefaultTransport.(*http.Transport)
+	if got, want := transport.TLSHandshakeTimeout, defaults.TLSHandshakeTimeout; got != want {
+		t.Errorf("Want tls handshake timeout %s, got %s", want, got)
+	}
+	if got, want := transport.IdleConnTimeout, defaults.IdleConnTimeout; got != want {
+		t.Errorf("Want idle connection timeout %s, got %s", want, got)
+	}
+	if got, want := transport.MaxIdleConns, defaults.MaxIdleConns; got != want {
+		t.Errorf("Want max idle connections %d, got %d", want, got)
+	}
+	if transport.DialContext == nil {
+		t.Errorf("Expect default dialer")
+	}
+	if transport.TLSClientConfig == nil {
+		t.Errorf("Expect tls client config")
+		return
+	}
+	if got, want := transport.TLSClientConfig.MinVersion, uint16(tls.VersionTLS12); got != want {
+		t.Errorf("Want tls min version %d, got %d", want, got)
+	}
+	if !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("Expect tls skip verify")
+	}
+	if defaults.TLSClientConfig != nil && defaults.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("Expect default transport not modified")
+	}
+}
+
+// This test verifies the rpc client transport proxy is
+// overridden by the configured proxy.
+func TestSetupClientTransport_Proxy(t *testing.T) {
+	config := Config{}
+	config.Client.Proxy = "http://proxy.company.com:8080"
+	transport, err := setupClientTransport(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req, _ := http.NewRequest("GET", "https://drone.company.com/rpc/v2/stage", nil)
+	proxy, err := transport.Proxy(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if proxy == nil {
+		t.Errorf("Expect proxy url")
+		return
+	}
+	if got, want := proxy.String(), config.Client.Proxy; got != want {
+		t.Errorf("Want proxy %s, got %s", want, got)
+	}
+	if http.DefaultTransport.(*http.Transport).Proxy == nil {
+		t.Errorf("Expect default transport proxy not modified")
+	}
+}
+
+// This test verifies an error is returned when the configured
+// proxy cannot be parsed.
+func TestSetupClientTransport_ProxyInvalid(t *testing.T) {
+	config := Config{}
+	config.Client.Proxy = "://proxy.company.com"
+	if _, err := setupClientTransport(config); err == nil {
+		t.Errorf("Expect error parsing invalid proxy")
+	}
+}
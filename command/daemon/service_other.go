@@ -0,0 +1,11 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package daemon
+
+// helper function is a no-op on platforms that do not require
+// the daemon to report its status to a service manager.
+func watchService(name string, cancel func()) {}
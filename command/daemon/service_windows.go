@@ -0,0 +1,48 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build windows
+
+package daemon
+
+import (
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+)
+
+// helper function reports the daemon status to the windows
+// service control manager, if running as a windows service,
+// and invokes the cancel function when the service is stopped.
+func watchService(name string, cancel func()) {
+	ok, err := svc.IsWindowsService()
+	if err != nil || !ok {
+		return
+	}
+	go func() {
+		err := svc.Run(name, &handler{cancel})
+		if err != nil {
+			logrus.WithError(err).
+				Errorln("cannot run windows service")
+		}
+	}()
+}
+
+type handler struct {
+	cancel func()
+}
+
+func (h *handler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for req := range r {
+		switch req.Cmd {
+		case svc.Interrogate:
+			s <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			s <- svc.Status{State: svc.StopPending}
+			h.cancel()
+			return false, 0
+		}
+	}
+	return false, 0
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -19,7 +20,6 @@ import (
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
 	"github.com/drone-runners/drone-runner-ssh/runtime"
 	"github.com/drone/drone-go/drone"
-	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
 	"github.com/drone/runner-go/manifest"
@@ -36,14 +36,24 @@ import (
 type execCommand struct {
 	*internal.Flags
 
-	Source  *os.File
-	Environ map[string]string
-	Secrets map[string]string
-	Dump    bool
-	Debug   bool
-	Trace   bool
-	Pretty  bool
-	Procs   int64
+	Source            *os.File
+	Environ           map[string]string
+	Secrets           map[string]string
+	Changes           []string
+	Dump              bool
+	Debug             bool
+	Trace             bool
+	Pretty            bool
+	Procs             int64
+	SourceDir         string
+	Push              string
+	PushCache         string
+	PushChecksum      bool
+	DumpScripts       string
+	SyncDir           string
+	TunnelAllow       []string
+	TransportAllow    []string
+	ServerTunnelAllow []string
 }
 
 func (c *execCommand) run(*kingpin.ParseContext) error {
@@ -75,7 +85,14 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 
 	// evaluates string replacement expressions and returns an
 	// update configuration.
-	config, err := envsubst.Eval(string(rawsource), subf)
+	config, err := resource.Substitute(string(rawsource), subf)
+	if err != nil {
+		return err
+	}
+
+	// converts the configuration generated by a jsonnet or
+	// starlark template to yaml, if required.
+	config, err = resource.Convert(config)
 	if err != nil {
 		return err
 	}
@@ -93,19 +110,54 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		return err
 	}
 
+	// if a local source directory is provided, the directory
+	// is uploaded to the workspace instead of cloning the
+	// repository.
+	if err := c.uploadSource(resource); err != nil {
+		return err
+	}
+
+	// sync steps are enabled when a local sync directory is
+	// provided. the directory is explicitly provided by the
+	// user, and therefore sync steps are enabled regardless of
+	// whether or not the repository is trusted.
+	if c.SyncDir != "" {
+		dir, err := filepath.Abs(c.SyncDir)
+		if err != nil {
+			return err
+		}
+		c.SyncDir = dir
+	}
+
 	// compile the pipeline to an intermediate representation.
 	comp := &compiler.Compiler{
-		Pipeline: resource,
-		Manifest: manifest,
-		Build:    c.Build,
-		Netrc:    c.Netrc,
-		Repo:     c.Repo,
-		Stage:    c.Stage,
-		System:   c.System,
-		Environ:  c.Environ,
-		Secret:   secret.StaticVars(c.Secrets),
-	}
-	spec := comp.Compile(nocontext)
+		Pipeline:          resource,
+		Manifest:          manifest,
+		Build:             c.Build,
+		Netrc:             c.Netrc,
+		Repo:              c.Repo,
+		Stage:             c.Stage,
+		System:            c.System,
+		Environ:           c.Environ,
+		Secret:            secret.StaticVars(c.Secrets),
+		Changes:           c.Changes,
+		Push:              c.Push,
+		PushCache:         c.PushCache,
+		PushChecksum:      c.PushChecksum,
+		DumpScripts:       c.DumpScripts,
+		SSHAgent:          os.Getenv("SSH_AUTH_SOCK"),
+		ForwardAgent:      os.Getenv("SSH_AUTH_SOCK"),
+		TunnelAllow:       c.TunnelAllow,
+		TransportAllow:    c.TransportAllow,
+		ServerTunnelAllow: c.ServerTunnelAllow,
+		X11Display:        os.Getenv("DISPLAY"),
+		SyncDir:           c.SyncDir,
+		SyncUntrusted:     true,
+	}
+	spec, err := comp.Compile(nocontext)
+	if err != nil {
+		return err
+	}
 
 	// create a step object for each pipeline step.
 	for _, step := range spec.Steps {
@@ -162,6 +214,9 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 	).Exec(ctx, spec, state)
 	if c.Dump {
 		dump(state)
+	} else {
+		fmt.Println()
+		runtime.WriteSummary(os.Stdout, state, spec.Server.Hostname)
 	}
 	if err != nil {
 		return err
@@ -173,6 +228,22 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 	return nil
 }
 
+// helper function configures the pipeline to upload the local
+// source directory, if provided, instead of cloning the
+// repository.
+func (c *execCommand) uploadSource(p *resource.Pipeline) error {
+	if c.SourceDir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(c.SourceDir)
+	if err != nil {
+		return err
+	}
+	p.Clone.Disable = true
+	c.Push = dir
+	return nil
+}
+
 func dump(v interface{}) {
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
@@ -197,6 +268,36 @@ func registerExec(app *kingpin.Application) {
 	cmd.Flag("environ", "environment variables").
 		StringMapVar(&c.Environ)
 
+	cmd.Flag("changes", "files changed by the build").
+		StringsVar(&c.Changes)
+
+	cmd.Flag("source", "upload the local directory instead of cloning").
+		StringVar(&c.SourceDir)
+
+	cmd.Flag("push", "push the local working tree when clone is disabled").
+		StringVar(&c.Push)
+
+	cmd.Flag("push-cache", "remote directory caching the pushed working tree").
+		StringVar(&c.PushCache)
+
+	cmd.Flag("push-checksum", "compare pushed files by checksum").
+		BoolVar(&c.PushChecksum)
+
+	cmd.Flag("dump-scripts", "write the generated scripts to the directory").
+		StringVar(&c.DumpScripts)
+
+	cmd.Flag("sync-dir", "local directory of sync step files").
+		StringVar(&c.SyncDir)
+
+	cmd.Flag("tunnel-allow", "address in CIDR:port format that tunnels may dial").
+		StringsVar(&c.TunnelAllow)
+
+	cmd.Flag("transport-allow", "unix socket or websocket url that the server host may connect through").
+		StringsVar(&c.TransportAllow)
+
+	cmd.Flag("server-tunnel-allow", "instance in driver:instance format that server tunnels may connect to").
+		StringsVar(&c.ServerTunnelAllow)
+
 	cmd.Flag("debug", "enable debug level logging").
 		BoolVar(&c.Debug)
 
@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// This test verifies the local source directory is uploaded to
+// the workspace, and the clone step is disabled, when the exec
+// source flag is provided.
+func TestExec_UploadSource(t *testing.T) {
+	c := &execCommand{SourceDir: "testdata"}
+	p := &resource.Pipeline{}
+	if err := c.uploadSource(p); err != nil {
+		t.Error(err)
+		return
+	}
+	if !p.Clone.Disable {
+		t.Errorf("Expect clone disabled")
+	}
+	want, _ := filepath.Abs("testdata")
+	if got := c.Push; got != want {
+		t.Errorf("Want push directory %s, got %s", want, got)
+	}
+}
+
+// This test verifies the pipeline is unchanged when the exec
+// source flag is not provided.
+func TestExec_UploadSourceEmpty(t *testing.T) {
+	c := &execCommand{Push: "/home/octocat/hello-world"}
+	p := &resource.Pipeline{}
+	if err := c.uploadSource(p); err != nil {
+		t.Error(err)
+		return
+	}
+	if p.Clone.Disable {
+		t.Errorf("Expect clone enabled")
+	}
+	if got, want := c.Push, "/home/octocat/hello-world"; got != want {
+		t.Errorf("Want push directory %s, got %s", want, got)
+	}
+}
+
+// This test verifies the exec source flag is registered.
+func TestExec_SourceFlag(t *testing.T) {
+	app := kingpin.New("drone-runner-ssh", "")
+	registerExec(app)
+	cmd := app.GetCommand("exec")
+	if cmd == nil {
+		t.Errorf("Expect exec command registered")
+		return
+	}
+	if cmd.GetFlag("source") == nil {
+		t.Errorf("Expect exec source flag registered")
+	}
+}
@@ -87,6 +87,7 @@ func ParseFlags(cmd *kingpin.CmdClause) *Flags {
 	cmd.Flag("stage-arch", "stage arch").Default("").StringVar(&f.Stage.Arch)
 	cmd.Flag("stage-variant", "stage variant").Default("").StringVar(&f.Stage.Variant)
 	cmd.Flag("stage-kernel", "stage kernel").Default("").StringVar(&f.Stage.Kernel)
+	cmd.Flag("stage-machine", "stage machine").Default("").StringVar(&f.Stage.Machine)
 	cmd.Flag("stage-created", "stage created").Default(now).Int64Var(&f.Stage.Created)
 	cmd.Flag("stage-updated", "stage updated").Default(now).Int64Var(&f.Stage.Updated)
 
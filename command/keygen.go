@@ -0,0 +1,124 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/keygen"
+	"github.com/drone/drone-go/drone"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type keygenCommand struct {
+	Type    string
+	Comment string
+	Output  string
+	Server  string
+	Token   string
+	Repo    string
+	Secret  string
+}
+
+func (c *keygenCommand) run(*kingpin.ParseContext) error {
+	private, public, err := keygen.Generate(c.Type, c.Comment)
+	if err != nil {
+		return err
+	}
+
+	// the private key is written to the output file, and is
+	// only written to the console if it is neither written to
+	// a file nor uploaded as a secret.
+	if c.Output != "" {
+		if err := ioutil.WriteFile(c.Output, private, 0600); err != nil {
+			return err
+		}
+	}
+
+	// the private key is optionally uploaded to the repository
+	// as a secret, which the pipeline references as the server
+	// ssh_key.
+	if c.Secret != "" {
+		owner, name, ok := splitRepo(c.Repo)
+		if !ok {
+			return fmt.Errorf("invalid repository %q", c.Repo)
+		}
+		client := drone.NewClient(c.Server, &http.Client{
+			Transport: &tokenTransport{token: c.Token},
+		})
+		_, err := client.SecretCreate(owner, name, &drone.Secret{
+			Name: c.Secret,
+			Data: string(private),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Output == "" && c.Secret == "" {
+		os.Stdout.Write(private)
+	}
+	os.Stdout.Write(public)
+	return nil
+}
+
+// helper function splits the repository slug into the owner
+// and name.
+func splitRepo(slug string) (owner, name string, ok bool) {
+	parts := strings.Split(slug, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// tokenTransport authenticates requests to the drone server
+// using the bearer token.
+type tokenTransport struct {
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func registerKeygen(app *kingpin.Application) {
+	c := new(keygenCommand)
+
+	cmd := app.Command("keygen", "generates an ssh keypair").
+		Action(c.run)
+
+	cmd.Flag("type", "key type (ed25519 or ecdsa)").
+		Default(keygen.TypeEd25519).
+		EnumVar(&c.Type, keygen.TypeEd25519, keygen.TypeECDSA)
+
+	cmd.Flag("comment", "public key comment").
+		Default("drone-runner-ssh").
+		StringVar(&c.Comment)
+
+	cmd.Flag("output", "private key file").
+		StringVar(&c.Output)
+
+	cmd.Flag("server", "drone server address").
+		Envar("DRONE_SERVER").
+		StringVar(&c.Server)
+
+	cmd.Flag("token", "drone server token").
+		Envar("DRONE_TOKEN").
+		StringVar(&c.Token)
+
+	cmd.Flag("repo", "repository to which the secret is uploaded").
+		StringVar(&c.Repo)
+
+	cmd.Flag("secret", "secret name for the private key").
+		StringVar(&c.Secret)
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type maintenanceCommand struct {
+	Action   string
+	Host     string
+	Addr     string
+	Username string
+	Password string
+	Token    string
+}
+
+func (c *maintenanceCommand) run(*kingpin.ParseContext) error {
+	method := http.MethodGet
+	switch c.Action {
+	case "drain":
+		method = http.MethodPost
+	case "undrain":
+		method = http.MethodDelete
+	}
+	if method != http.MethodGet && c.Host == "" {
+		return errors.New("missing host")
+	}
+
+	endpoint := strings.TrimSuffix(c.Addr, "/") + "/api/maintenance"
+	if c.Host != "" {
+		endpoint = endpoint + "?host=" + url.QueryEscape(c.Host)
+	}
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	_, err = io.Copy(os.Stdout, res.Body)
+	return err
+}
+
+func registerMaintenance(app *kingpin.Application) {
+	c := new(maintenanceCommand)
+
+	cmd := app.Command("maintenance", "drains remote hosts for maintenance").
+		Action(c.run)
+
+	cmd.Arg("action", "drain, undrain or list").
+		Required().
+		EnumVar(&c.Action, "drain", "undrain", "list")
+
+	cmd.Arg("host", "remote host").
+		StringVar(&c.Host)
+
+	cmd.Flag("addr", "runner address").
+		Default("http://localhost:3000").
+		StringVar(&c.Addr)
+
+	cmd.Flag("username", "dashboard username").
+		Envar("DRONE_UI_USERNAME").
+		StringVar(&c.Username)
+
+	cmd.Flag("password", "dashboard password").
+		Envar("DRONE_UI_PASSWORD").
+		StringVar(&c.Password)
+
+	cmd.Flag("token", "dashboard bearer token").
+		Envar("DRONE_UI_TOKEN").
+		StringVar(&c.Token)
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package service provides subcommands to install and manage
+// the runner daemon as a system service.
+package service
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type serviceCommand struct {
+	name    string
+	desc    string
+	envfile string
+}
+
+func (c *serviceCommand) install(*kingpin.ParseContext) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"daemon"}
+	if c.envfile != "" {
+		envfile, err := filepath.Abs(c.envfile)
+		if err != nil {
+			return err
+		}
+		args = append(args, envfile)
+	}
+	return install(c.name, c.desc, exe, args)
+}
+
+func (c *serviceCommand) uninstall(*kingpin.ParseContext) error {
+	return uninstall(c.name)
+}
+
+func (c *serviceCommand) start(*kingpin.ParseContext) error {
+	return start(c.name)
+}
+
+func (c *serviceCommand) stop(*kingpin.ParseContext) error {
+	return stop(c.name)
+}
+
+// Register the service subcommands.
+func Register(app *kingpin.Application) {
+	c := new(serviceCommand)
+
+	cmd := app.Command("service", "manages the runner system service")
+	cmd.Flag("name", "service name").
+		Default("drone-runner-ssh").
+		StringVar(&c.name)
+
+	install := cmd.Command("install", "installs the runner as a system service").
+		Action(c.install)
+	install.Flag("description", "service description").
+		Default("Drone SSH Runner").
+		StringVar(&c.desc)
+	install.Flag("env-file", "environment variable file loaded by the daemon").
+		StringVar(&c.envfile)
+
+	cmd.Command("uninstall", "uninstalls the runner system service").
+		Action(c.uninstall)
+
+	cmd.Command("start", "starts the runner system service").
+		Action(c.start)
+
+	cmd.Command("stop", "stops the runner system service").
+		Action(c.stop)
+}
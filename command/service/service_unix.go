@@ -0,0 +1,117 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// path to the systemd unit directory.
+var unitdir = "/etc/systemd/system"
+
+var unit = template.Must(template.New("unit").Parse(`[Unit]
+Description={{ .Description }}
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{ .Command }}
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// helper function generates the systemd unit file.
+func generate(desc, exe string, args []string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := unit.Execute(buf, map[string]string{
+		"Description": desc,
+		"Command":     command(append([]string{exe}, args...)),
+	})
+	return buf.Bytes(), err
+}
+
+// helper function returns the ExecStart command line, quoting
+// and escaping each argument using the systemd syntax.
+func command(args []string) string {
+	var quoted []string
+	for _, arg := range args {
+		quoted = append(quoted, quote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// helper function quotes the argument using the systemd syntax.
+// Specifiers and variable references are escaped so that the
+// argument is passed to the command unchanged.
+func quote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	switch {
+	case arg == ";":
+		return `\;`
+	case arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\"):
+		return arg
+	}
+	return `"` + strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\t", `\t`,
+	).Replace(arg) + `"`
+}
+
+func install(name, desc, exe string, args []string) error {
+	data, err := generate(desc, exe, args)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(unitdir, name+".service")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", name)
+}
+
+func uninstall(name string) error {
+	if err := systemctl("disable", name); err != nil {
+		return err
+	}
+	path := filepath.Join(unitdir, name+".service")
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func start(name string) error {
+	return systemctl("start", name)
+}
+
+func stop(name string) error {
+	return systemctl("stop", name)
+}
+
+// helper function executes the systemctl command.
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %s: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
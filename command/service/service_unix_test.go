@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	data, err := generate("Drone SSH Runner", "/usr/local/bin/drone-runner-ssh", []string{"daemon", "/etc/drone-runner-ssh.env"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := `[Unit]
+Description=Drone SSH Runner
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=/usr/local/bin/drone-runner-ssh daemon /etc/drone-runner-ssh.env
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+	if got := string(data); got != want {
+		t.Errorf("Unexpected unit file\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestGenerate_Quote(t *testing.T) {
+	data, err := generate("Drone SSH Runner", "/opt/drone runner/drone-runner-ssh", []string{"daemon", "/etc/drone runner/100% \"ssh\".env"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := `ExecStart="/opt/drone runner/drone-runner-ssh" daemon "/etc/drone runner/100%% \"ssh\".env"`
+	if got := string(data); !strings.Contains(got, want+"\n") {
+		t.Errorf("Want unit file command %s, got\n%s", want, got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{arg: "daemon", want: "daemon"},
+		{arg: "", want: `""`},
+		{arg: "/etc/drone runner.env", want: `"/etc/drone runner.env"`},
+		{arg: `C:\drone`, want: `"C:\\drone"`},
+		{arg: "it's", want: `"it's"`},
+		{arg: "$HOME", want: "$$HOME"},
+		{arg: "%h", want: "%%h"},
+		{arg: ";", want: `\;`},
+	}
+	for _, test := range tests {
+		if got := quote(test.arg); got != test.want {
+			t.Errorf("Want %q quoted as %s, got %s", test.arg, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/version"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func runVersion(*kingpin.ParseContext) error {
+	info := version.Get()
+	fmt.Printf("version:    %s\n", info.Version)
+	fmt.Printf("commit:     %s\n", info.Commit)
+	fmt.Printf("go version: %s\n", info.GoVersion)
+	fmt.Printf("kind:       %s\n", info.Kind)
+	fmt.Printf("type:       %s\n", info.Type)
+	return nil
+}
+
+func registerVersion(app *kingpin.Application) {
+	app.Command("version", "display the runner version").
+		Action(runVersion)
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"sync"
+)
+
+// ansi parser states.
+const (
+	ansiText = iota
+	ansiEscape
+	ansiControl
+	ansiCommand
+)
+
+// ansiWriter is an io.Writer that strips ANSI escape sequences
+// from the output. The parser state is retained across writes
+// so that sequences split between writes are stripped.
+type ansiWriter struct {
+	sync.Mutex
+
+	w     io.Writer
+	state int
+}
+
+// helper function returns a writer that strips ANSI escape
+// sequences from the output.
+func stripANSI(w io.Writer) io.Writer {
+	return &ansiWriter{w: w}
+}
+
+func (a *ansiWriter) Write(p []byte) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	buf := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch a.state {
+		case ansiText:
+			if b == 0x1b {
+				a.state = ansiEscape
+			} else {
+				buf = append(buf, b)
+			}
+		case ansiEscape:
+			switch b {
+			case '[':
+				a.state = ansiControl
+			case ']':
+				a.state = ansiCommand
+			default:
+				a.state = ansiText
+			}
+		case ansiControl:
+			// control sequences are terminated by a final
+			// byte in the range 0x40 to 0x7e.
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiCommand:
+			// operating system commands are terminated by
+			// the bell character or a string terminator.
+			if b == 0x07 || b == '\\' {
+				a.state = ansiText
+			}
+		}
+	}
+	if _, err := a.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   string
+	}{
+		{
+			writes: []string{"\x1b[31mred\x1b[0m text\n"},
+			want:   "red text\n",
+		},
+		{
+			writes: []string{"\x1b[1;3", "2mgreen\x1b", "[0m\n"},
+			want:   "green\n",
+		},
+		{
+			writes: []string{"\x1b]0;title\x07hello\n"},
+			want:   "hello\n",
+		},
+		{
+			writes: []string{"plain text\n"},
+			want:   "plain text\n",
+		},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		w := stripANSI(buf)
+		for _, s := range test.writes {
+			w.Write([]byte(s))
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("Want output %q, got %q", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// uploadArchive uploads the files to the remote server as a
+// single compressed archive that is extracted remotely. Files
+// outside the workspace root are uploaded individually.
+func uploadArchive(ctx context.Context, client *ssh.Client, clientftp *sftp.Client, spec *Spec) error {
+	data, outside, err := createArchive(spec.Root, spec.Files)
+	if err != nil {
+		return err
+	}
+
+	path := joinPath(spec.Platform.OS, spec.Root, "files.tar.gz")
+	_, span := tracing.Start(ctx, "sftp.upload")
+	span.SetAttribute("path", path)
+	err = upload(clientftp, path, data, 0600)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	auditUpload(spec, path, data)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	cmd := withShell(spec.Shell, extractCommand(spec.Platform.OS, path, spec.Root))
+	auditCommand(spec, cmd)
+	out, err := session.CombinedOutput(cmd)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("output", string(out)).
+			Debug("cannot extract archive")
+		return fmt.Errorf("cannot extract archive: %s", err)
+	}
+	return uploadFiles(ctx, clientftp, spec, outside)
+}
+
+// helper function creates a compressed tar archive of the files
+// in the workspace root. Files outside the workspace root are
+// excluded from the archive and returned.
+func createArchive(root string, files []*File) ([]byte, []*File, error) {
+	var outside []*File
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		name, ok := relPath(root, file.Path)
+		if !ok {
+			outside = append(outside, file)
+			continue
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     int64(file.Mode),
+			Size:     int64(len(file.Data)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), outside, nil
+}
+
+// helper function returns the slash-separated path of the file
+// relative to the root directory. If the file is not in the
+// root directory, false is returned.
+func relPath(root, path string) (string, bool) {
+	root = strings.TrimRight(root, "/\\")
+	if !strings.HasPrefix(path, root+"/") && !strings.HasPrefix(path, root+"\\") {
+		return "", false
+	}
+	rel := path[len(root)+1:]
+	return strings.Replace(rel, "\\", "/", -1), true
+}
+
+// helper function returns a shell command for extracting the
+// archive to the directory and removing the archive, that is
+// compatible with the operating system.
+func extractCommand(os, path, dir string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"tar -xzf %s -C %s; if ($LastExitCode -ne 0) { exit $LastExitCode }; Remove-Item %s -Force\"", path, dir, path)
+	default:
+		return fmt.Sprintf("tar -xzf %s -C %s && rm -f %s", path, dir, path)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCreateArchive(t *testing.T) {
+	files := []*File{
+		{Path: "/tmp/drone-random/home/drone", IsDir: true},
+		{Path: "/tmp/drone-random/home/drone/.netrc", Mode: 0600, Data: []byte("machine github.com")},
+		{Path: "/tmp/drone-random/opt/build", Mode: 0700, Data: []byte("go build")},
+		{Path: "/etc/docker/config.json", Mode: 0600, Data: []byte("{}")},
+	}
+	data, outside, err := createArchive("/tmp/drone-random", files)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(outside) != 1 || outside[0].Path != "/etc/docker/config.json" {
+		t.Errorf("Expect files outside the root excluded from the archive")
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tr := tar.NewReader(gr)
+	want := []struct {
+		name string
+		mode int64
+		data string
+	}{
+		{"home/drone/.netrc", 0600, "machine github.com"},
+		{"opt/build", 0700, "go build"},
+	}
+	for _, w := range want {
+		header, err := tr.Next()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if header.Name != w.name || header.Mode != w.mode {
+			t.Errorf("Want archive entry %s %o, got %s %o", w.name, w.mode, header.Name, header.Mode)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		if string(b) != w.data {
+			t.Errorf("Want archive entry data %q, got %q", w.data, b)
+		}
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	tests := []struct {
+		root, path, want string
+		ok               bool
+	}{
+		{"/tmp/drone-random", "/tmp/drone-random/opt/build", "opt/build", true},
+		{"/tmp/drone-random", "/tmp/drone-random2/opt/build", "", false},
+		{`C:\Windows\Temp\drone-random`, `C:\Windows\Temp\drone-random\opt\build.ps1`, "opt/build.ps1", true},
+	}
+	for _, test := range tests {
+		got, ok := relPath(test.root, test.path)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Want relative path %q %v, got %q %v", test.want, test.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "golang.org/x/crypto/ssh"
+
+// Auditor records the ssh sessions opened, the commands executed
+// and the files uploaded on the remote server, for compliance
+// review. An Auditor must be safe for concurrent use.
+type Auditor interface {
+	// Open records an ssh connection opened to the host.
+	Open(host string)
+
+	// Close records an ssh connection to the host closed.
+	Close(host string)
+
+	// Command records a command executed on the host.
+	Command(host, command string)
+
+	// Upload records a file uploaded to the host.
+	Upload(host, path string, data []byte)
+}
+
+// helper function records the ssh connection opened, if the
+// pipeline is audited.
+func auditOpen(spec *Spec) {
+	if spec.Audit != nil {
+		spec.Audit.Open(spec.Server.Hostname)
+	}
+}
+
+// helper function closes the ssh connection and records the
+// connection closed, if the pipeline is audited.
+func closeClient(spec *Spec, client *ssh.Client) error {
+	err := client.Close()
+	if spec.Audit != nil {
+		spec.Audit.Close(spec.Server.Hostname)
+	}
+	return err
+}
+
+// helper function records the command executed, if the
+// pipeline is audited.
+func auditCommand(spec *Spec, command string) {
+	if spec.Audit != nil {
+		spec.Audit.Command(spec.Server.Hostname, command)
+	}
+}
+
+// helper function records the file uploaded, if the pipeline
+// is audited.
+func auditUpload(spec *Spec, path string, data []byte) {
+	if spec.Audit != nil {
+		spec.Audit.Upload(spec.Server.Hostname, path, data)
+	}
+}
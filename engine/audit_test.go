@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+type mockAuditor struct {
+	events []string
+}
+
+func (m *mockAuditor) Open(host string)  { m.events = append(m.events, "open "+host) }
+func (m *mockAuditor) Close(host string) { m.events = append(m.events, "close "+host) }
+func (m *mockAuditor) Command(host, command string) {
+	m.events = append(m.events, "command "+host+" "+command)
+}
+func (m *mockAuditor) Upload(host, path string, data []byte) {
+	m.events = append(m.events, "upload "+host+" "+path)
+}
+
+func TestAudit(t *testing.T) {
+	// auditing is disabled if the auditor is nil.
+	spec := &Spec{Server: Server{Hostname: "localhost:22"}}
+	auditOpen(spec)
+	auditCommand(spec, "rm -rf /tmp/drone-random")
+	auditUpload(spec, "/tmp/drone-random/opt/build", nil)
+
+	mock := new(mockAuditor)
+	spec.Audit = mock
+	auditOpen(spec)
+	auditCommand(spec, "rm -rf /tmp/drone-random")
+	auditUpload(spec, "/tmp/drone-random/opt/build", nil)
+
+	want := []string{
+		"open localhost:22",
+		"command localhost:22 rm -rf /tmp/drone-random",
+		"upload localhost:22 /tmp/drone-random/opt/build",
+	}
+	if !reflect.DeepEqual(mock.events, want) {
+		t.Errorf("Unexpected audit events %v", mock.events)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// verifyChecksum computes the SHA-256 checksum of the file on
+// the remote server and returns an error if the checksum does
+// not match the checksum of the data.
+func verifyChecksum(client *ssh.Client, spec *Spec, path string, data []byte) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	cmd := withShell(spec.Shell, checksumCommand(spec.Platform.OS, path))
+	auditCommand(spec, cmd)
+	out, err := session.Output(cmd)
+	if err != nil {
+		return fmt.Errorf("cannot compute checksum of %s: %s", path, err)
+	}
+	return compareChecksum(path, data, string(out))
+}
+
+// helper function returns an error if the checksum output by
+// the remote server does not match the checksum of the data.
+func compareChecksum(path string, data []byte, out string) error {
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return fmt.Errorf("cannot compute checksum of %s", path)
+	}
+	if got := fields[0]; !strings.EqualFold(got, want) {
+		return fmt.Errorf("checksum mismatch for %s: uploaded file may be truncated", path)
+	}
+	return nil
+}
+
+// helper function returns a shell command for computing the
+// SHA-256 checksum of the file that is compatible with the
+// operating system.
+func checksumCommand(os, path string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"(Get-FileHash -Algorithm SHA256 %s).Hash\"", path)
+	default:
+		return fmt.Sprintf("sha256sum %s 2>/dev/null || shasum -a 256 %s", path, path)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestCompareChecksum(t *testing.T) {
+	data := []byte("hello world")
+	sum := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	if err := compareChecksum("/tmp/build", data, sum+"  /tmp/build\n"); err != nil {
+		t.Errorf("Expect sha256sum output to match, got %s", err)
+	}
+	if err := compareChecksum("C:\\build.ps1", data, "B94D27B9934D3E08A52E52D7DA7DABFAC484EFE37A5380EE9088F7ACE2EFCDE9\r\n"); err != nil {
+		t.Errorf("Expect powershell output to match, got %s", err)
+	}
+	if err := compareChecksum("/tmp/build", []byte("hello"), sum+"  /tmp/build\n"); err == nil {
+		t.Errorf("Expect checksum mismatch error")
+	}
+	if err := compareChecksum("/tmp/build", data, ""); err == nil {
+		t.Errorf("Expect error when checksum output is empty")
+	}
+}
@@ -6,11 +6,21 @@ package compiler
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+	"github.com/drone-runners/drone-runner-ssh/internal/credentials"
+	"github.com/drone-runners/drone-runner-ssh/internal/secrets"
+	"github.com/drone-runners/drone-runner-ssh/internal/variables"
 
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/clone"
@@ -69,10 +79,258 @@ type Compiler struct {
 	// Secret returns a named secret value that can be injected
 	// into the pipeline step.
 	Secret secret.Provider
+
+	// OptionalSecrets disables strict secret mode. By default
+	// compilation fails if a step environment variable, file,
+	// docker credential, netrc machine or server field is
+	// loaded from a secret that cannot be found, unless the
+	// step environment variable is marked optional.
+	OptionalSecrets bool
+
+	// Secrets provides a set of secret environment variables
+	// that should be added to each pipeline step, and are
+	// always masked in the build logs.
+	Secrets map[string]string
+
+	// Changes provides the list of files changed by the build,
+	// used to evaluate step path conditions. If nil, the changes
+	// are unknown and path conditions are not evaluated.
+	Changes []string
+
+	// Credentials returns a list of credential files that are
+	// written to the home directory before the pipeline steps
+	// are executed.
+	Credentials credentials.Provider
+
+	// Variables returns a list of environment variables
+	// specific to the remote host, such as local mirror urls.
+	Variables variables.Provider
+
+	// Debug retains the workspace on the remote server when a
+	// pipeline step fails, so that the failure can be debugged.
+	Debug bool
+
+	// Retain retains the partially created workspace on the
+	// remote server when the pipeline setup fails. By default
+	// the workspace is removed on a best-effort basis.
+	Retain bool
+
+	// Ciphers provides the default ssh ciphers, if not defined
+	// in the pipeline. If empty, the default ciphers are used.
+	Ciphers []string
+
+	// KeyExchanges provides the default ssh key exchange
+	// algorithms, if not defined in the pipeline. If empty,
+	// the default algorithms are used.
+	KeyExchanges []string
+
+	// MACs provides the default ssh mac algorithms, if not
+	// defined in the pipeline. If empty, the default
+	// algorithms are used.
+	MACs []string
+
+	// SSHAgent provides the socket of the ssh agent on the
+	// runner host, used by pipelines that authenticate with
+	// the agent. If empty, agent authentication is disabled.
+	SSHAgent string
+
+	// ForwardAgent provides the socket of the ssh agent on the
+	// runner host forwarded to the pipeline steps that request
+	// agent forwarding. It is separate from the agent used for
+	// authentication, so that the keys that access the remote
+	// hosts are not exposed to the pipeline steps. If empty,
+	// agent forwarding is disabled.
+	ForwardAgent string
+
+	// X11Display provides the x11 display of the runner host,
+	// forwarded to pipeline steps that request x11 forwarding.
+	// If empty, x11 forwarding is disabled.
+	X11Display string
+
+	// SyncDir provides the local directory on the runner host
+	// to which the local paths of sync steps are relative. If
+	// empty, sync steps are disabled.
+	SyncDir string
+
+	// SyncUntrusted enables sync steps for repositories that
+	// are not trusted. By default sync steps require a trusted
+	// repository.
+	SyncUntrusted bool
+
+	// TunnelAllow provides the addresses, in CIDR:port format,
+	// that pipeline tunnels may dial from the runner host.
+	// Tunnels may only bind to loopback addresses on the runner
+	// host. If empty, remote tunnels are disabled.
+	TunnelAllow []string
+
+	// TransportAllow provides the unix socket and websocket
+	// urls that the pipeline server host may connect through,
+	// for example unix:///run/sshd/*.sock. The url path may be
+	// a glob pattern. If empty, transport urls are disabled.
+	TransportAllow []string
+
+	// ServerTunnelAllow provides the cloud bastion tunnels, in
+	// driver:instance format, that pipelines of trusted
+	// repositories may connect through using the credentials
+	// of the runner host. The instance may be a glob pattern.
+	// If empty, server tunnels are disabled.
+	ServerTunnelAllow []string
+
+	// MaxSessions provides the default limit of concurrent
+	// sessions over a connection shared by the pipeline steps,
+	// if not defined in the pipeline. If zero, each step opens
+	// its own connection.
+	MaxSessions int
+
+	// DumpScripts provides a local directory to which the
+	// generated scripts are written before upload, for
+	// debugging purposes.
+	DumpScripts string
+
+	// Locale provides the default locale exported to the
+	// pipeline steps, if not defined in the pipeline. If empty,
+	// the C.UTF-8 locale is used.
+	Locale string
+
+	// Timestamps provides the default timestamp format used to
+	// prefix step output, if not defined in the pipeline. If
+	// empty, timestamps are disabled.
+	Timestamps string
+
+	// WorkspaceBase provides the default base directory in
+	// which the workspace is created on posix hosts, if not
+	// defined in the pipeline. If empty, /tmp is used.
+	WorkspaceBase string
+
+	// WorkspaceBaseWindows provides the default base directory
+	// in which the workspace is created on windows hosts, if
+	// not defined in the pipeline. If empty, C:\Windows\Temp
+	// is used.
+	WorkspaceBaseWindows string
+
+	// Push provides a local working tree that is pushed to the
+	// workspace when the pipeline disables cloning.
+	Push string
+
+	// PushCache provides the directory on the remote server in
+	// which the pushed working tree is cached between pushes.
+	// If empty, a directory next to the workspace is used.
+	PushCache string
+
+	// PushChecksum compares pushed files by checksum instead of
+	// size and modification time.
+	PushChecksum bool
+
+	// Traceparent provides the W3C trace context of the stage,
+	// exported to the pipeline steps. If empty, the trace
+	// context is not exported.
+	Traceparent string
+
+	// Syslog writes a marker with the build correlation id to
+	// the remote syslog when each step starts.
+	Syslog bool
+}
+
+// helper function returns the timestamp format used to prefix
+// step output.
+func (c *Compiler) timestamps() string {
+	format := c.Pipeline.Timestamps
+	if format == "" {
+		format = c.Timestamps
+	}
+	switch format {
+	case "true", engine.TimestampsRFC3339:
+		return engine.TimestampsRFC3339
+	case engine.TimestampsRelative:
+		return engine.TimestampsRelative
+	default:
+		return ""
+	}
+}
+
+// helper function returns the repository slug, safe for use
+// in a file path.
+func (c *Compiler) repoSlug() string {
+	if name := slug.Make(c.Repo.Slug); name != "" {
+		return name
+	}
+	return "default"
 }
 
-// Compile compiles the configuration file.
-func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
+// helper function returns the base directory in which the
+// pipeline workspace is created.
+func (c *Compiler) workspaceBase() string {
+	switch {
+	case c.Pipeline.Workspace.Base != "":
+		return c.Pipeline.Workspace.Base
+	case c.Pipeline.Platform.OS == "windows":
+		return c.WorkspaceBaseWindows
+	default:
+		return c.WorkspaceBase
+	}
+}
+
+// helper function returns the build environment variables
+// that may be referenced in the server host, workspace path
+// and step working directory.
+func (c *Compiler) buildEnviron() map[string]string {
+	return environ.Combine(
+		environ.System(c.System),
+		environ.Repo(c.Repo),
+		environ.Build(c.Build),
+		environ.Stage(c.Stage),
+		environ.Link(c.Repo, c.Build, c.System),
+	)
+}
+
+// helper function returns the build environment variables that
+// may be referenced in the server host. All variables may be
+// referenced by trusted repositories.
+func (c *Compiler) hostnameEnviron(vars map[string]string) map[string]string {
+	if c.Repo.Trusted {
+		return vars
+	}
+	envs := map[string]string{}
+	for _, k := range hostVars {
+		if v, ok := vars[k]; ok {
+			envs[k] = v
+		}
+	}
+	return envs
+}
+
+// helper function returns the directories of the source
+// directory path, relative to the workspace. The path defaults
+// to drone/src.
+func (c *Compiler) workspacePath(vars map[string]string) []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(expand(c.Pipeline.Workspace.Path, vars), isSeparator) {
+		if name != "." {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{"drone", "src"}
+	}
+	return names
+}
+
+// helper function returns the locale exported to the pipeline
+// steps.
+func (c *Compiler) locale() string {
+	switch {
+	case c.Pipeline.Locale != "":
+		return c.Pipeline.Locale
+	case c.Locale != "":
+		return c.Locale
+	default:
+		return "C.UTF-8"
+	}
+}
+
+// Compile compiles the configuration file. An error is returned
+// if the secret provider cannot resolve a pipeline secret.
+func (c *Compiler) Compile(ctx context.Context) (*engine.Spec, error) {
 	os := c.Pipeline.Platform.OS
 
 	spec := &engine.Spec{
@@ -83,37 +341,198 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 			Version: c.Pipeline.Platform.Version,
 		},
 		Server: engine.Server{
-			Hostname: c.Pipeline.Server.Host.Value,
-			Username: c.Pipeline.Server.User.Value,
-			Password: c.Pipeline.Server.Password.Value,
-			SSHKey:   c.Pipeline.Server.SSHKey.Value,
+			Hostname:     c.Pipeline.Server.Host.Value,
+			Username:     c.Pipeline.Server.User.Value,
+			Password:     c.Pipeline.Server.Password.Value,
+			SSHKey:       c.Pipeline.Server.SSHKey.Value,
+			Reconnect:    c.Pipeline.Server.Reconnect,
+			Ciphers:      c.Pipeline.Server.Ciphers,
+			KeyExchanges: c.Pipeline.Server.KeyExchanges,
+			MACs:         c.Pipeline.Server.MACs,
+			MaxSessions:  c.Pipeline.Server.MaxSessions,
+			Tunnel:       c.Pipeline.Server.Tunnel,
+			Zone:         c.Pipeline.Server.Zone,
 		},
+		Debug:       c.Pipeline.Debug || c.Debug,
+		Retain:      c.Retain,
+		DumpScripts: c.DumpScripts,
+		Encoding:    c.Pipeline.Encoding,
+		Timestamps:  c.timestamps(),
+		Compress:    c.Pipeline.Compress,
+		Verify:      c.Pipeline.Verify,
+	}
+
+	// the tools required by the pipeline are verified on the
+	// remote server during setup. Invalid requirements are
+	// rejected by the linter and are ignored.
+	for _, s := range c.Pipeline.Requires {
+		if req, err := engine.ParseRequirement(s); err == nil {
+			spec.Requires = append(spec.Requires, req)
+		}
+	}
+
+	// the port forwards are opened during setup and remain
+	// open until the pipeline completes.
+	// The runner host addresses are checked, because the
+	// tunnels otherwise expose the runner network to the
+	// remote host, or the remote host to the runner network.
+	for _, tunnel := range c.Pipeline.Tunnels {
+		forward := convertTunnel(tunnel)
+		switch {
+		case forward.Direction == engine.ForwardLocal && !isLoopback(forward.Local):
+			return nil, &TunnelError{Addr: forward.Local, Message: "must bind to a loopback address"}
+		case forward.Direction == engine.ForwardRemote && !matchTunnel(c.TunnelAllow, forward.Local):
+			return nil, &TunnelError{Addr: forward.Local, Message: "is not allowed by the runner"}
+		}
+		spec.Forwards = append(spec.Forwards, forward)
+	}
+
+	// maybe load the server variables from secrets. The
+	// compiler fails if the secret provider returns an error,
+	// instead of connecting with empty credentials.
+	host, err := c.findVariable(ctx, spec, "", c.Pipeline.Server.Host)
+	if err != nil {
+		return nil, err
+	}
+	port, err := c.findVariable(ctx, spec, "", c.Pipeline.Server.Port)
+	if err != nil {
+		return nil, err
+	}
+	user, err := c.findVariable(ctx, spec, "", c.Pipeline.Server.User)
+	if err != nil {
+		return nil, err
+	}
+	password, err := c.findVariable(ctx, spec, "", c.Pipeline.Server.Password)
+	if err != nil {
+		return nil, err
+	}
+	sshkey, err := c.findVariable(ctx, spec, "", c.Pipeline.Server.SSHKey)
+	if err != nil {
+		return nil, err
 	}
+	// the build environment variables may be referenced in
+	// the server host, for example to compute the host from
+	// the deployment target. Variables that can be influenced
+	// by the commit author, such as the branch, are only
+	// expanded for trusted repositories, since the server
+	// credentials are sent to the host.
+	vars := c.buildEnviron()
+	spec.Server.Hostname = expand(host, c.hostnameEnviron(vars))
 
-	// maybe load the server host variable from secret
-	if s, ok := c.findSecret(ctx, c.Pipeline.Server.Host.Secret); ok {
-		spec.Server.Hostname = s
+	// the pipeline is linted before the variables are
+	// expanded, so the expanded values are validated again.
+	// The transport must be defined in the pipeline, and
+	// cannot be introduced by a variable.
+	if spec.Server.Hostname == "" && host != "" {
+		return nil, &ExpandError{Field: "server.host", Value: spec.Server.Hostname}
 	}
-	// maybe load the server username variable from secret
-	if s, ok := c.findSecret(ctx, c.Pipeline.Server.User.Secret); ok {
-		spec.Server.Username = s
+	if engine.IsTransport(spec.Server.Hostname) && !engine.IsTransport(host) {
+		return nil, &ExpandError{Field: "server.host", Value: spec.Server.Hostname}
 	}
-	// maybe load the server password variable from secret
-	if s, ok := c.findSecret(ctx, c.Pipeline.Server.Password.Secret); ok {
-		spec.Server.Password = s
+	if host != "" && !engine.IsTransport(host) && spec.Server.Tunnel == "" && !isHost(spec.Server.Hostname) {
+		return nil, &ExpandError{Field: "server.host", Value: spec.Server.Hostname}
 	}
-	// maybe load the server ssh_key variable from secret
-	if s, ok := c.findSecret(ctx, c.Pipeline.Server.SSHKey.Secret); ok {
-		spec.Server.SSHKey = s
+	// the transport url is dialed from the runner host, and is
+	// therefore restricted to the urls allowed by the runner.
+	if engine.IsTransport(spec.Server.Hostname) {
+		switch {
+		case len(c.TransportAllow) == 0:
+			return nil, ErrTransportDisabled
+		case !matchTransport(c.TransportAllow, spec.Server.Hostname):
+			return nil, &TransportError{URL: spec.Server.Hostname}
+		}
+	}
+	spec.Server.Username = user
+	spec.Server.Password = password
+	spec.Server.SSHKey = sshkey
+
+	// the server tunnel is opened by the cloud provider command
+	// line tool using the credentials of the runner host, and
+	// is therefore restricted to the instances allowed by the
+	// runner, and to trusted repositories.
+	if spec.Server.Tunnel != "" {
+		instance := spec.Server.Hostname
+		if h, _, err := net.SplitHostPort(instance); err == nil {
+			instance = h
+		}
+		switch {
+		case len(c.ServerTunnelAllow) == 0:
+			return nil, ErrServerTunnelDisabled
+		case !c.Repo.Trusted:
+			return nil, ErrServerTunnelUntrusted
+		case !engine.IsTunnelName(instance):
+			return nil, &ExpandError{Field: "server.host", Value: instance}
+		case !matchServerTunnel(c.ServerTunnelAllow, spec.Server.Tunnel, instance):
+			return nil, &TunnelError{Addr: instance, Message: "is not allowed by the runner"}
+		}
 	}
 
-	// append the port to the hostname if not exists
-	if !strings.Contains(spec.Server.Hostname, ":") {
-		spec.Server.Hostname = spec.Server.Hostname + ":22"
+	// the ssh algorithms default to the runner configuration
+	// if not defined in the pipeline.
+	if len(spec.Server.Ciphers) == 0 {
+		spec.Server.Ciphers = c.Ciphers
+	}
+	if len(spec.Server.KeyExchanges) == 0 {
+		spec.Server.KeyExchanges = c.KeyExchanges
+	}
+	if len(spec.Server.MACs) == 0 {
+		spec.Server.MACs = c.MACs
+	}
+	if spec.Server.MaxSessions == 0 {
+		spec.Server.MaxSessions = c.MaxSessions
 	}
 
+	// the pipeline may authenticate using the ssh agent of the
+	// runner host, if enabled by the runner.
+	if c.Pipeline.Server.Agent {
+		if c.SSHAgent == "" {
+			return nil, ErrAgentDisabled
+		}
+		spec.Server.Agent = c.SSHAgent
+	}
+
+	// combine the hostname and port, defaulting to port 22
+	// if the port is not included in the hostname.
+	spec.Server.Hostname = engine.HostPort(spec.Server.Hostname, port)
+
 	// create the root directory
-	spec.Root = tempdir(os)
+	spec.Root = tempdir(os, c.workspaceBase())
+	spec.RootMode = parseMode(c.Pipeline.Permissions.Workspace, 0)
+	dirmode := parseMode(c.Pipeline.Permissions.Directories, 0700)
+
+	// steps that execute as a different user must be able to
+	// access the workspace directories, which are otherwise
+	// private to the ssh user. If the pipeline defines a group
+	// shared by the ssh user and the step users, the group
+	// owns the directories and new files inherit the group.
+	// Otherwise the directories are readable by the step users
+	// and only the temporary directory is writable.
+	spec.Group = c.Pipeline.Permissions.Group
+	tempmode := dirmode
+	if hasUserSteps(c.Pipeline) {
+		tempmode = 01777
+		if c.Pipeline.Permissions.Directories == "" {
+			dirmode = 0755
+			if spec.Group != "" {
+				dirmode = 02770
+			}
+		}
+	}
+
+	// the artifacts directory is created outside the workspace
+	// and survives workspace removal, unless it is empty. It
+	// is not a workspace and is not removed by the janitor.
+	spec.Artifacts = join(os, parentDir(os, spec.Root), "drone-artifacts",
+		fmt.Sprintf("%s-%d-%d", c.repoSlug(), c.Build.Number, c.Stage.Number))
+
+	// the secrets are optionally renewed during pipeline
+	// execution and written to a file per step that the step
+	// may source to load the renewed values.
+	if d, err := time.ParseDuration(c.Pipeline.Renewal); err == nil && d > 0 {
+		spec.Renewal = &engine.Renewal{
+			Interval: d,
+		}
+	}
 
 	// creates a home directory in the root.
 	// note: mkdirall fails on windows so we need to create all
@@ -121,47 +540,107 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 	homedir := join(os, spec.Root, "home", "drone")
 	spec.Files = append(spec.Files, &engine.File{
 		Path:  join(os, spec.Root, "home"),
-		Mode:  0700,
+		Mode:  dirmode,
 		IsDir: true,
 	})
 	spec.Files = append(spec.Files, &engine.File{
 		Path:  homedir,
-		Mode:  0700,
+		Mode:  dirmode,
 		IsDir: true,
 	})
 
-	// creates a source directory in the root.
-	// note: mkdirall fails on windows so we need to create all
-	// directories in the tree.
-	sourcedir := join(os, spec.Root, "drone", "src")
+	// creates a temporary directory in the root. Anything
+	// written to the workspace root is removed when the
+	// pipeline completes.
+	stagetemp := join(os, spec.Root, "tmp")
 	spec.Files = append(spec.Files, &engine.File{
-		Path:  join(os, spec.Root, "drone"),
-		Mode:  0700,
-		IsDir: true,
-	})
-	spec.Files = append(spec.Files, &engine.File{
-		Path:  sourcedir,
-		Mode:  0700,
+		Path:  stagetemp,
+		Mode:  tempmode,
 		IsDir: true,
 	})
 
+	// creates the application data directories in the home
+	// directory, which are required by windows tools.
+	if os == "windows" {
+		for _, dir := range []string{
+			join(os, homedir, "AppData"),
+			join(os, homedir, "AppData", "Local"),
+			join(os, homedir, "AppData", "Roaming"),
+		} {
+			spec.Files = append(spec.Files, &engine.File{
+				Path:  dir,
+				Mode:  dirmode,
+				IsDir: true,
+			})
+		}
+	}
+
+	// creates a source directory in the root, which may be
+	// overridden by the workspace path.
+	// note: mkdirall fails on windows so we need to create all
+	// directories in the tree.
+	sourcedir := spec.Root
+	workspace := c.workspacePath(vars)
+	if hasParent(workspace) {
+		return nil, &ExpandError{Field: "workspace.path", Value: expand(c.Pipeline.Workspace.Path, vars)}
+	}
+	for _, name := range workspace {
+		sourcedir = join(os, sourcedir, name)
+		spec.Files = append(spec.Files, &engine.File{
+			Path:  sourcedir,
+			Mode:  dirmode,
+			IsDir: true,
+		})
+	}
+
 	// creates the opt directory to hold all scripts.
 	spec.Files = append(spec.Files, &engine.File{
 		Path:  join(os, spec.Root, "opt"),
-		Mode:  0700,
+		Mode:  dirmode,
 		IsDir: true,
 	})
 
-	// creates the netrc file
-	if c.Netrc != nil && c.Netrc.Password != "" {
+	// collects the netrc credentials. The credentials are not
+	// collected if the pipeline disables the netrc file.
+	var machines []*drone.Netrc
+	if c.Netrc != nil && c.Netrc.Password != "" && c.Pipeline.Netrc.Mode != resource.NetrcDisabled {
+		machines = append(machines, c.Netrc)
+	}
+	// appends additional netrc machines defined in the
+	// pipeline, maybe loading credentials from secrets.
+	for _, netrc := range c.Pipeline.Netrc.Machines {
+		if c.Pipeline.Netrc.Mode == resource.NetrcDisabled {
+			break
+		}
+		login, err := c.findVariable(ctx, spec, "", netrc.Login)
+		if err != nil {
+			return nil, err
+		}
+		password, err := c.findVariable(ctx, spec, "", netrc.Password)
+		if err != nil {
+			return nil, err
+		}
+		machines = append(machines, &drone.Netrc{
+			Machine:  netrc.Machine,
+			Login:    login,
+			Password: password,
+		})
+	}
+	// creates the netrc file. The netrc file is not created if
+	// the pipeline restricts the credentials to the clone step.
+	if len(machines) != 0 && c.Pipeline.Netrc.Mode != resource.NetrcCloneOnly {
+		var netrcs []string
+		for _, netrc := range machines {
+			netrcs = append(netrcs, fmt.Sprintf(
+				"machine %s login %s password %s",
+				netrc.Machine,
+				netrc.Login,
+				netrc.Password,
+			))
+		}
 		netrcfile := getNetrc(os)
 		netrcpath := join(os, homedir, netrcfile)
-		netrcdata := fmt.Sprintf(
-			"machine %s login %s password %s",
-			c.Netrc.Machine,
-			c.Netrc.Login,
-			c.Netrc.Password,
-		)
+		netrcdata := strings.Join(netrcs, "\n")
 		spec.Files = append(spec.Files, &engine.File{
 			Path: netrcpath,
 			Mode: 0600,
@@ -169,9 +648,58 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 		})
 	}
 
+	// creates the credential files, maybe
+	if c.Credentials != nil {
+		files, err := c.Credentials.List(ctx, &credentials.Request{
+			Repo:  c.Repo,
+			Build: c.Build,
+		})
+		if err != nil {
+			return nil, &CredentialsError{Err: err}
+		}
+		for _, file := range files {
+			spec.Files = append(spec.Files,
+				convertCredentials(os, homedir, file)...)
+		}
+	}
+
+	// creates the docker configuration file with the registry
+	// credentials defined in the pipeline, which replaces any
+	// docker configuration file provided by the credentials
+	// provider.
+	if len(c.Pipeline.DockerAuth) != 0 {
+		config, err := c.dockerConfig(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		file := &credentials.File{
+			Path: ".docker/config.json",
+			Mode: 0600,
+			Data: config,
+		}
+		spec.Files = removeFile(spec.Files, join(os, homedir, ".docker", "config.json"))
+		spec.Files = append(spec.Files, convertCredentials(os, homedir, file)...)
+	}
+
+	// creates the files declared in the pipeline.
+	files, err := c.createFiles(ctx, spec, "", c.Pipeline.Files, sourcedir, dirmode)
+	if err != nil {
+		return nil, err
+	}
+	spec.Files = append(spec.Files, files...)
+
+	// the environment variables specific to the remote host
+	// are requested from the variables provider.
+	hostenvs, err := c.hostEnviron(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
 	// create the default environment variables.
 	envs := environ.Combine(
+		getLocaleEnviron(os, c.locale()),
 		c.Environ,
+		hostenvs,
 		c.Build.Params,
 		environ.Proxy(),
 		environ.System(c.System),
@@ -187,39 +715,76 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 				Email: c.Build.AuthorEmail,
 			},
 		}),
-		// TODO(bradrydzewski) windows variable HOMEDRIVE
-		// TODO(bradrydzewski) windows variable LOCALAPPDATA
 		map[string]string{
-			"HOME":                homedir,
-			"HOMEPATH":            homedir, // for windows
-			"USERPROFILE":         homedir, // for windows
-			"DRONE_HOME":          sourcedir,
-			"DRONE_WORKSPACE":     sourcedir,
-			"GIT_TERMINAL_PROMPT": "0",
+			"HOME":                  homedir,
+			"HOMEPATH":              homedir, // for windows
+			"USERPROFILE":           homedir, // for windows
+			"DRONE_HOME":            sourcedir,
+			"DRONE_WORKSPACE":       sourcedir,
+			"DRONE_WORKSPACE_BASE":  spec.Root,
+			"DRONE_STAGE_TEMP":      stagetemp,
+			"DRONE_STAGE_ARTIFACTS": spec.Artifacts,
+			"GIT_TERMINAL_PROMPT":   "0",
 		},
+		getHomeEnviron(os, homedir),
+		c.serverEnviron(spec),
 	)
 
+	// the correlation id and trace context are exported so that
+	// remote host logs can be correlated with the build.
+	spec.CorrelationID = correlationID(c.Build)
+	spec.Syslog = c.Syslog
+	envs["DRONE_CORRELATION_ID"] = spec.CorrelationID
+	if c.Traceparent != "" {
+		envs["TRACEPARENT"] = c.Traceparent
+	}
+
 	// create clone step, maybe
 	if c.Pipeline.Clone.Disable == false {
 		clonepath := join(os, spec.Root, "opt", getExt(os, "clone"))
-		clonefile := genScript(os,
-			clone.Commands(
-				clone.Args{
-					Branch: c.Build.Target,
-					Commit: c.Build.After,
-					Ref:    c.Build.Ref,
-					Remote: c.Repo.HTTPURL,
-					Depth:  c.Pipeline.Clone.Depth,
-				},
-			),
-		)
+		cloneargs := clone.Args{
+			Branch: c.Build.Target,
+			Commit: c.Build.After,
+			Ref:    c.Build.Ref,
+			Remote: c.Repo.HTTPURL,
+			Depth:  c.Pipeline.Clone.Depth,
+		}
+		clonefile := genScript(os, clone.Commands(cloneargs))
+
+		// the netrc credentials are provided to the clone step
+		// using environment variables and a git credential helper
+		// per machine, and are never persisted to the netrc file.
+		// The helper is configured with GIT_CONFIG_COUNT, which
+		// requires git 2.31 or higher, and the clone script fails
+		// early if the helper is not recognized.
+		cloneenvs := envs
+		clonesecrets := []*engine.Secret{}
+		if len(machines) != 0 && c.Pipeline.Netrc.Mode == resource.NetrcCloneOnly {
+			cloneenvs = environ.Combine(envs, getCredentialHelper(machines))
+			for i, netrc := range machines {
+				// the secret is not named, and is therefore never
+				// loaded from the secret provider.
+				clonesecrets = append(clonesecrets, &engine.Secret{
+					Env:  fmt.Sprintf("DRONE_NETRC_PASSWORD_%d", i),
+					Data: []byte(netrc.Password),
+					Mask: true,
+				})
+			}
+			clonefile = genScript(os, append(
+				[]string{getCredentialCheck(os)},
+				clone.Commands(cloneargs)...,
+			))
+		}
 
 		cmd, args := getCommand(os, clonepath)
+		if c.Pipeline.CleanEnv {
+			cmd, args = getCleanCommand(os, cmd, args)
+		}
 		spec.Steps = append(spec.Steps, &engine.Step{
 			Name:      "clone",
 			Args:      args,
 			Command:   cmd,
-			Envs:      envs,
+			Envs:      cloneenvs,
 			RunPolicy: engine.RunAlways,
 			Files: []*engine.File{
 				{
@@ -228,33 +793,82 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 					Data: []byte(clonefile),
 				},
 			},
-			Secrets:    []*engine.Secret{},
+			Secrets:    clonesecrets,
 			WorkingDir: sourcedir,
+			Path:       c.Pipeline.Path,
 		})
 	}
 
+	// push the local working tree to the workspace, maybe.
+	// the tree is cached on the remote server per repository
+	// so that unchanged files are not uploaded again. The cache
+	// is not a workspace and is not removed by the janitor.
+	if c.Pipeline.Clone.Disable && c.Push != "" {
+		cache := c.PushCache
+		if cache == "" {
+			cache = join(os, parentDir(os, spec.Root), "drone-push", c.repoSlug())
+		}
+		spec.Push = &engine.Push{
+			Source:   c.Push,
+			Target:   sourcedir,
+			Cache:    cache,
+			Checksum: c.PushChecksum,
+		}
+	}
+
+	// create the setup and teardown scripts, maybe
+	if len(c.Pipeline.Setup) != 0 {
+		spec.Setup = createHook(os, spec.Root, "setup", c.Pipeline.Setup, envs, sourcedir)
+	}
+	if len(c.Pipeline.Teardown) != 0 {
+		spec.Teardown = createHook(os, spec.Root, "teardown", c.Pipeline.Teardown, envs, sourcedir)
+	}
+
 	// create steps
-	for _, src := range c.Pipeline.Steps {
+	for _, src := range expandMatrix(expandGroups(c.Pipeline.Steps)) {
+		// the working directory is validated after the variables
+		// are expanded, since the lint step only validates the
+		// unexpanded value. An absolute directory must be defined
+		// in the pipeline, and cannot be introduced by a variable.
+		rawdir := src.WorkingDir
+		src = expandVars(src, c.Pipeline.Vars)
+		workdir := expand(src.WorkingDir, vars)
+		if hasParent(strings.FieldsFunc(workdir, isSeparator)) || (isAbs(os, workdir) && !isAbs(os, rawdir)) {
+			return nil, &ExpandError{Field: "working_dir", Value: workdir}
+		}
 		buildslug := slug.Make(src.Name)
-		buildpath := join(os, spec.Root, "opt", getExt(os, buildslug))
-		buildfile := genScript(os, src.Commands)
+		shellname := c.Pipeline.GetShell(src)
+		dialect := getDialect(os, shellname)
+		buildpath := join(os, spec.Root, "opt", getExt(dialect, buildslug))
+		buildfile := genStepScript(dialect, src)
 
-		cmd, args := getCommand(os, buildpath)
+		cmd, args := getStepCommand(os, shellname, buildpath)
+		if src.User != "" {
+			cmd, args = getUserCommand(src.User, cmd, args)
+		}
+		if c.Pipeline.CleanEnv {
+			cmd, args = getCleanCommand(os, cmd, args)
+		}
 		dst := &engine.Step{
 			Name:      src.Name,
 			Args:      args,
 			Command:   cmd,
 			Detach:    src.Detach,
+			Ready:     convertReady(src.Ready),
 			DependsOn: src.DependsOn,
 			Envs: environ.Combine(envs,
 				environ.Expand(
-					convertStaticEnv(src.Environment),
+					convertStaticEnv(stepEnviron(src)),
 				),
 			),
-			IgnoreErr:    strings.EqualFold(src.Failure, "ignore"),
+			IgnoreErr:    isIgnoreErr(src),
 			IgnoreStdout: false,
 			IgnoreStderr: false,
 			RunPolicy:    engine.RunOnSuccess,
+			Shell:        getShell(shellname),
+			StripANSI:    src.Color != nil && *src.Color == false,
+			Term:         src.Term,
+			Umask:        src.Umask,
 			Files: []*engine.File{
 				{
 					Path: buildpath,
@@ -262,11 +876,57 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 					Data: []byte(buildfile),
 				},
 			},
-			Secrets:    convertSecretEnv(src.Environment),
-			WorkingDir: sourcedir,
+			Secrets:    convertSecretEnv(stepEnviron(src)),
+			User:       src.User,
+			WorkingDir: workingDir(os, sourcedir, workdir),
+			Path:       c.Pipeline.Path,
 		}
 		spec.Steps = append(spec.Steps, dst)
 
+		// the step may forward the ssh agent or the x11 display
+		// of the runner host to the remote server, if enabled
+		// by the runner.
+		if src.ForwardAgent {
+			if c.ForwardAgent == "" {
+				return nil, ErrAgentForwardingDisabled
+			}
+			if !c.Repo.Trusted {
+				return nil, ErrAgentForwardingUntrusted
+			}
+			dst.ForwardAgent = c.ForwardAgent
+		}
+		if src.ForwardX11 {
+			if c.X11Display == "" {
+				return nil, ErrX11Disabled
+			}
+			dst.ForwardX11 = c.X11Display
+		}
+
+		// the failure policy may apply only to the listed exit
+		// codes, which are ignored or skipped.
+		if len(src.Failure.ExitCodes) != 0 {
+			if strings.EqualFold(src.Failure.Action, resource.FailureSkip) {
+				dst.SkipCodes = src.Failure.ExitCodes
+			} else {
+				dst.IgnoreCodes = src.Failure.ExitCodes
+			}
+		}
+
+		// sync steps copy files between the runner host and the
+		// remote server, and do not execute a script.
+		if src.Sync != nil {
+			if c.SyncDir == "" {
+				return nil, ErrSyncDisabled
+			}
+			if !c.Repo.Trusted && !c.SyncUntrusted {
+				return nil, ErrSyncUntrusted
+			}
+			dst.Command = ""
+			dst.Args = nil
+			dst.Files = nil
+			dst.Sync = createSync(os, sourcedir, c.SyncDir, src.Sync)
+		}
+
 		// set the pipeline step run policy. steps run on
 		// success by default, but may be optionally configured
 		// to run on failure.
@@ -290,9 +950,36 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 		}) {
 			dst.RunPolicy = engine.RunNever
 		}
+
+		// if the pipeline step defines path conditions and none
+		// of the changed files match, the step is skipped.
+		if !src.When.MatchPaths(c.Changes) {
+			dst.RunPolicy = engine.RunNever
+		}
+
+		// if the step is expanded from a matrix and the axis
+		// values do not match the matrix conditions, the step
+		// is skipped.
+		if !src.When.MatchMatrix(src.Axis) {
+			dst.RunPolicy = engine.RunNever
+		}
+
+		// creates the files declared in the pipeline step. The
+		// files are uploaded with the pipeline files before
+		// pipeline execution, unless the step never runs.
+		if dst.RunPolicy != engine.RunNever {
+			files, err := c.createFiles(ctx, spec, src.Name, src.Files, sourcedir, dirmode)
+			if err != nil {
+				return nil, err
+			}
+			spec.Files = append(spec.Files, files...)
+		}
 	}
 
-	if isGraph(spec) == false {
+	// the steps of the first group have no dependencies, and
+	// the pipeline is therefore a graph if steps are grouped,
+	// so that the steps of the first group execute in parallel.
+	if isGraph(spec) == false && isGrouped(c.Pipeline.Steps) == false {
 		configureSerial(spec)
 	} else if c.Pipeline.Clone.Disable == false {
 		configureCloneDeps(spec)
@@ -300,32 +987,463 @@ func (c *Compiler) Compile(ctx context.Context) *engine.Spec {
 		removeCloneDeps(spec)
 	}
 
+	var missing []string
 	for _, step := range spec.Steps {
 		for _, s := range step.Secrets {
-			secret, ok := c.findSecret(ctx, s.Name)
+			// secrets injected by the runner are not named, and
+			// are not loaded from the secret provider.
+			if s.Name == "" {
+				continue
+			}
+			secret, ok, err := c.findSecret(ctx, spec, step.Name, s.Name)
+			if err != nil {
+				return nil, err
+			}
 			if ok {
 				s.Data = []byte(secret)
+				continue
+			}
+			// in strict mode, secrets that cannot be found fail
+			// the pipeline, unless the step is never executed.
+			if !s.Optional && !c.OptionalSecrets && step.RunPolicy != engine.RunNever {
+				missing = appendUnique(missing, s.Name)
 			}
 		}
 	}
+	if len(missing) != 0 {
+		return nil, &MissingSecretsError{Names: missing}
+	}
+
+	// static secrets are added to each pipeline step, and are
+	// overridden by step secrets with the same name.
+	if len(c.Secrets) != 0 {
+		for _, step := range spec.Steps {
+			step.Secrets = append(convertStaticSecrets(c.Secrets, step.Secrets), step.Secrets...)
+		}
+	}
+
+	// the secrets file of each step only contains the secrets
+	// of the step, so that a step cannot read the secrets of
+	// the other steps.
+	if spec.Renewal != nil {
+		for _, step := range spec.Steps {
+			step.SecretsFile = join(os, spec.Root, "opt", getExt(os, slug.Make(step.Name)+".secrets"))
+			step.Envs = environ.Combine(step.Envs, map[string]string{
+				"DRONE_SECRETS_FILE": step.SecretsFile,
+			})
+		}
+	}
+
+	// if batch mode is enabled, the pipeline steps are
+	// executed serially in a single remote script.
+	if c.Pipeline.Batch {
+		spec.BatchNonce = random()
+		spec.Batch = createBatch(os, spec.Root, spec.BatchNonce, spec.Steps, sourcedir)
+	}
 
-	return spec
+	return spec, nil
+}
+
+// helper function returns the environment variables describing
+// the remote server and runner machine. Server values that are
+// loaded from secrets are not exposed.
+func (c *Compiler) serverEnviron(spec *engine.Spec) map[string]string {
+	envs := map[string]string{
+		"DRONE_STAGE_MACHINE": c.Stage.Machine,
+	}
+	if c.Pipeline.Server.Host.Secret == "" && c.Pipeline.Server.Port.Secret == "" {
+		host, port, err := net.SplitHostPort(spec.Server.Hostname)
+		if err != nil {
+			host = spec.Server.Hostname
+		}
+		envs["DRONE_SSH_HOST"] = host
+		envs["DRONE_SSH_PORT"] = port
+	}
+	if c.Pipeline.Server.User.Secret == "" {
+		envs["DRONE_SSH_USER"] = spec.Server.Username
+	}
+	return envs
+}
+
+// helper function returns the environment variables specific
+// to the remote host, requested from the variables provider.
+func (c *Compiler) hostEnviron(ctx context.Context, spec *engine.Spec) (map[string]string, error) {
+	if c.Variables == nil {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(spec.Server.Hostname)
+	if err != nil {
+		host = spec.Server.Hostname
+	}
+	envs, err := c.Variables.List(ctx, &variables.Request{
+		Repo:  c.Repo,
+		Build: c.Build,
+		Host:  host,
+		Platform: variables.Platform{
+			OS:      spec.Platform.OS,
+			Arch:    spec.Platform.Arch,
+			Variant: spec.Platform.Variant,
+			Version: spec.Platform.Version,
+		},
+	})
+	if err != nil {
+		return nil, &EnvironError{Err: err}
+	}
+	return envs, nil
+}
+
+// helper function creates a pipeline hook that executes the
+// commands on the remote host during setup or teardown. The
+// script name is prefixed with a dot, which is never produced
+// by the step name slug, so that the hook script cannot
+// overwrite the script of a step with the same name.
+func createHook(os, root, name string, commands []string, envs map[string]string, workdir string) *engine.Step {
+	path := join(os, root, "opt", getExt(os, "."+name))
+	script := genScript(os, commands)
+	cmd, args := getCommand(os, path)
+	return &engine.Step{
+		Name:    name,
+		Args:    args,
+		Command: cmd,
+		Envs:    envs,
+		Files: []*engine.File{
+			{
+				Path: path,
+				Mode: 0700,
+				Data: []byte(script),
+			},
+		},
+		Secrets:    []*engine.Secret{},
+		WorkingDir: workdir,
+	}
+}
+
+// helper function creates a batch step that executes the
+// pipeline steps serially in a single remote script. Steps
+// that are never executed are excluded from the script. The
+// script name is prefixed with a dot so that it cannot
+// overwrite the script of a step named batch.
+func createBatch(os, root, nonce string, steps []*engine.Step, workdir string) *engine.Step {
+	var batch []*engine.Step
+	for _, step := range steps {
+		if step.RunPolicy != engine.RunNever {
+			batch = append(batch, step)
+		}
+	}
+	path := join(os, root, "opt", getExt(os, ".batch"))
+	script := genBatchScript(os, nonce, batch)
+	cmd, args := getCommand(os, path)
+	return &engine.Step{
+		Name:    "batch",
+		Args:    args,
+		Command: cmd,
+		Files: []*engine.File{
+			{
+				Path: path,
+				Mode: 0700,
+				Data: []byte(script),
+			},
+		},
+		Secrets:    []*engine.Secret{},
+		WorkingDir: workdir,
+	}
+}
+
+// helper function returns the files declared in the pipeline or
+// pipeline step, loading the file content from secrets. Relative
+// paths are resolved against the working directory.
+func (c *Compiler) createFiles(ctx context.Context, spec *engine.Spec, step string, files []*resource.File, workdir string, dirmode uint32) ([]*engine.File, error) {
+	os := c.Pipeline.Platform.OS
+	var out []*engine.File
+	for _, file := range files {
+		data, err := c.findVariable(ctx, spec, step, file.Content)
+		if err != nil {
+			return nil, err
+		}
+		// files loaded from secrets are only readable by the
+		// owner by default.
+		mode := uint32(0644)
+		if file.Content.Secret != "" {
+			mode = 0600
+		}
+		path := file.Path
+		if os == "windows" {
+			path = strings.Replace(path, "/", `\`, -1)
+		}
+		if !isAbs(os, path) {
+			path = join(os, workdir, path)
+			if dir := parentDir(os, path); dir != workdir {
+				out = append(out, &engine.File{
+					Path:  dir,
+					Mode:  dirmode,
+					IsDir: true,
+				})
+			}
+		}
+		out = append(out, &engine.File{
+			Path: path,
+			Mode: parseMode(file.Mode, mode),
+			Data: []byte(data),
+		})
+	}
+	return out, nil
+}
+
+// helper function returns the docker configuration file with
+// the registry credentials defined in the pipeline.
+func (c *Compiler) dockerConfig(ctx context.Context, spec *engine.Spec) (string, error) {
+	auths := map[string]interface{}{}
+	for _, auth := range c.Pipeline.DockerAuth {
+		username, err := c.findVariable(ctx, spec, "", auth.Username)
+		if err != nil {
+			return "", err
+		}
+		password, err := c.findVariable(ctx, spec, "", auth.Password)
+		if err != nil {
+			return "", err
+		}
+		registry := auth.Registry
+		if registry == "" {
+			registry = "https://index.docker.io/v1/"
+		}
+		auths[registry] = map[string]string{
+			"auth": base64.StdEncoding.EncodeToString(
+				[]byte(username + ":" + password),
+			),
+		}
+	}
+	out, _ := json.MarshalIndent(map[string]interface{}{"auths": auths}, "", "  ")
+	return string(out), nil
 }
 
 // helper function attempts to find and return the named secret.
-// from the secret provider.
-func (c *Compiler) findSecret(ctx context.Context, name string) (s string, ok bool) {
+// from the secret provider. The requesting step, pipeline and
+// remote host are passed to the provider in the context.
+func (c *Compiler) findSecret(ctx context.Context, spec *engine.Spec, step, name string) (s string, ok bool, err error) {
 	if name == "" {
 		return
 	}
-	found, _ := c.Secret.Find(ctx, &secret.Request{
+	ctx = secrets.WithContext(ctx, secrets.Context{
+		Step:     step,
+		Pipeline: c.Pipeline.Name,
+		Host:     spec.Server.Hostname,
+	})
+	found, err := c.Secret.Find(ctx, &secret.Request{
 		Name:  name,
 		Build: c.Build,
 		Repo:  c.Repo,
 		Conf:  c.Manifest,
 	})
+	if err != nil {
+		return "", false, &SecretError{Name: name, Err: err}
+	}
 	if found == nil {
 		return
 	}
-	return found.Data, true
+	return found.Data, true, nil
+}
+
+// RenewSecrets returns the current values of the secrets
+// referenced by each pipeline step, keyed by step name,
+// requested from the secret provider. It is used to renew
+// short-lived secrets during pipeline execution. If a secret
+// is no longer found, the previous value is retained.
+func (c *Compiler) RenewSecrets(ctx context.Context, spec *engine.Spec) (map[string][]*engine.Secret, error) {
+	renewed := map[string][]*engine.Secret{}
+	for _, step := range spec.Steps {
+		secrets := []*engine.Secret{}
+		for _, s := range step.Secrets {
+			// static secrets and secrets injected by the runner
+			// are not requested from the secret provider.
+			if _, ok := c.Secrets[s.Env]; (ok && s.Name == s.Env) || s.Name == "" {
+				secrets = append(secrets, s)
+				continue
+			}
+			data, ok, err := c.findSecret(ctx, spec, step.Name, s.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				data = string(s.Data)
+			}
+			secrets = append(secrets, &engine.Secret{
+				Name: s.Name,
+				Env:  s.Env,
+				Data: []byte(data),
+				Mask: s.Mask,
+			})
+		}
+		renewed[step.Name] = secrets
+	}
+	return renewed, nil
+}
+
+// helper function returns the variable value, or the value of
+// the named secret if the variable is loaded from a secret. In
+// strict mode, a secret that cannot be found is an error.
+func (c *Compiler) findVariable(ctx context.Context, spec *engine.Spec, step string, v manifest.Variable) (string, error) {
+	s, ok, err := c.findSecret(ctx, spec, step, v.Secret)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return s, nil
+	}
+	if v.Secret != "" && !c.OptionalSecrets {
+		return "", &MissingSecretsError{Names: []string{v.Secret}}
+	}
+	return v.Value, nil
+}
+
+// ErrAgentDisabled is returned when the pipeline authenticates
+// using the ssh agent, and the runner does not provide an ssh
+// agent socket.
+var ErrAgentDisabled = errors.New("ssh agent authentication is not enabled")
+
+// ErrAgentForwardingDisabled is returned when a pipeline step
+// forwards the ssh agent, and the runner does not provide an
+// ssh agent socket for forwarding.
+var ErrAgentForwardingDisabled = errors.New("ssh agent forwarding is not enabled")
+
+// ErrAgentForwardingUntrusted is returned when a pipeline step
+// forwards the ssh agent, and the repository is not trusted.
+var ErrAgentForwardingUntrusted = errors.New("ssh agent forwarding requires a trusted repository")
+
+// ErrSyncDisabled is returned when the pipeline defines a sync
+// step, and the runner does not provide a sync directory.
+var ErrSyncDisabled = errors.New("sync steps are not enabled")
+
+// ErrTransportDisabled is returned when the pipeline server
+// host is a transport url, and transport urls are not enabled
+// by the runner.
+var ErrTransportDisabled = errors.New("server transport urls are not enabled")
+
+// ErrServerTunnelDisabled is returned when the pipeline
+// connects through a server tunnel, and server tunnels are
+// not enabled by the runner.
+var ErrServerTunnelDisabled = errors.New("server tunnels are not enabled")
+
+// ErrServerTunnelUntrusted is returned when the pipeline
+// connects through a server tunnel, and the repository is not
+// trusted.
+var ErrServerTunnelUntrusted = errors.New("server tunnels require a trusted repository")
+
+// ErrSyncUntrusted is returned when the pipeline defines a sync
+// step, and the repository is not trusted.
+var ErrSyncUntrusted = errors.New("sync steps require a trusted repository")
+
+// ErrX11Disabled is returned when a pipeline step forwards
+// the x11 display, and the runner does not provide a display.
+var ErrX11Disabled = errors.New("x11 forwarding is not enabled")
+
+// SecretError is returned when the secret provider fails to
+// resolve a pipeline secret.
+type SecretError struct {
+	Name string
+	Err  error
+}
+
+func (e *SecretError) Error() string {
+	return fmt.Sprintf("secret backend error: cannot find secret %s: %s", e.Name, e.Err)
+}
+
+// TunnelError is returned when a pipeline tunnel binds to an
+// address on the runner host that is not a loopback address,
+// or dials an address from the runner host that is not allowed.
+type TunnelError struct {
+	Addr    string
+	Message string
+}
+
+func (e *TunnelError) Error() string {
+	return fmt.Sprintf("tunnel address %s %s", e.Addr, e.Message)
+}
+
+// ParseTunnelRule parses the tunnel rule in CIDR:port format,
+// and returns the network and port.
+func ParseTunnelRule(rule string) (*net.IPNet, int, error) {
+	i := strings.LastIndex(rule, ":")
+	if i == -1 {
+		return nil, 0, fmt.Errorf("invalid tunnel rule %q: missing port", rule)
+	}
+	_, network, err := net.ParseCIDR(rule[:i])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid tunnel rule %q: %s", rule, err)
+	}
+	port, err := strconv.Atoi(rule[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, 0, fmt.Errorf("invalid tunnel rule %q: invalid port", rule)
+	}
+	return network, port, nil
+}
+
+// TransportError is returned when the pipeline server host is
+// a transport url that is not allowed by the runner.
+type TransportError struct {
+	URL string
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("server transport %s is not allowed by the runner", e.URL)
+}
+
+// ParseServerTunnelRule parses the server tunnel rule in
+// driver:instance format, and returns the driver and the
+// instance pattern.
+func ParseServerTunnelRule(rule string) (string, string, error) {
+	i := strings.Index(rule, ":")
+	if i == -1 {
+		return "", "", fmt.Errorf("invalid server tunnel rule %q: missing instance", rule)
+	}
+	driver, pattern := rule[:i], rule[i+1:]
+	switch driver {
+	case engine.TunnelAWS, engine.TunnelGCP:
+	default:
+		return "", "", fmt.Errorf("invalid server tunnel rule %q: unsupported tunnel", rule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return "", "", fmt.Errorf("invalid server tunnel rule %q: invalid instance pattern", rule)
+	}
+	return driver, pattern, nil
+}
+
+// CredentialsError is returned when the credentials provider
+// fails to return the credential files.
+type CredentialsError struct {
+	Err error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("credentials backend error: cannot retrieve credential files: %s", e.Err)
+}
+
+// EnvironError is returned when the variables provider fails
+// to return the environment variables for the remote host.
+type EnvironError struct {
+	Err error
+}
+
+func (e *EnvironError) Error() string {
+	return fmt.Sprintf("environ backend error: cannot retrieve environment variables: %s", e.Err)
+}
+
+// ExpandError is returned when a pipeline value is invalid
+// once the variables it references are expanded.
+type ExpandError struct {
+	Field string
+	Value string
+}
+
+func (e *ExpandError) Error() string {
+	return fmt.Sprintf("invalid %s after variable expansion: %q", e.Field, e.Value)
+}
+
+// MissingSecretsError is returned when pipeline steps reference
+// secrets that cannot be found, in strict secret mode.
+type MissingSecretsError struct {
+	Names []string
+}
+
+func (e *MissingSecretsError) Error() string {
+	return "cannot find secrets: " + strings.Join(e.Names, ", ")
 }
@@ -8,20 +8,45 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+	"github.com/drone-runners/drone-runner-ssh/engine/shell"
 	"github.com/drone/runner-go/shell/bash"
 	"github.com/drone/runner-go/shell/powershell"
 )
 
 // helper function returns the base temporary directory based
-// on the target platform.
-func tempdir(os string) string {
+// on the target platform, or in the base directory if not
+// empty.
+func tempdir(os, base string) string {
 	dir := fmt.Sprintf("drone-%s", random())
+	if base != "" {
+		return join(os, strings.TrimRight(base, "/\\"), dir)
+	}
+	return join(os, engine.TempBase(os), dir)
+}
+
+// helper function returns true if the path is absolute.
+func isAbs(os, path string) bool {
 	switch os {
 	case "windows":
-		return join(os, "C:\\Windows\\Temp", dir)
+		return strings.HasPrefix(path, `\\`) ||
+			(len(path) > 1 && path[1] == ':')
 	default:
-		return join(os, "/tmp", dir)
+		return strings.HasPrefix(path, "/")
+	}
+}
+
+// helper function returns the parent directory of the path.
+func parentDir(os, path string) string {
+	sep := "/"
+	if os == "windows" {
+		sep = `\`
 	}
+	if i := strings.LastIndex(path, sep); i > 0 {
+		return path[:i]
+	}
+	return path
 }
 
 // helper function joins the file paths.
@@ -56,6 +81,86 @@ func getCommand(os, script string) (string, []string) {
 	return cmd, append(args, script)
 }
 
+// helper function returns the scripting dialect of the step,
+// which selects the script extension and generator. Steps
+// executed with a powershell profile, such as pwsh, use the
+// windows script helpers on all platforms.
+func getDialect(os, name string) string {
+	if profile, ok := shell.Lookup(name); ok {
+		if profile.Dialect == shell.Powershell {
+			return "windows"
+		}
+	}
+	return os
+}
+
+// helper function returns the shell command and arguments to
+// invoke the step script, using the shell profile requested by
+// the step, or the platform default.
+func getStepCommand(os, name, script string) (string, []string) {
+	if profile, ok := shell.Lookup(name); ok {
+		args := append([]string{}, profile.Args...)
+		return profile.Command, append(args, script)
+	}
+	return getCommand(os, script)
+}
+
+// helper function returns the name of the shell profile
+// requested by the step, or an empty string if the step uses
+// the platform default.
+func getShell(name string) string {
+	if profile, ok := shell.Lookup(name); ok {
+		return profile.Name
+	}
+	return ""
+}
+
+// helper function wraps the shell command and arguments to
+// execute as the named user. sudo is invoked in non-interactive
+// mode, which requires passwordless sudo on the remote host.
+func getUserCommand(user, cmd string, args []string) (string, []string) {
+	return "sudo", append([]string{"-n", "-E", "-u", user, cmd}, args...)
+}
+
+// helper function wraps the shell command and arguments to
+// execute with a clean environment, so that only the pipeline
+// environment variables are present. On windows the process
+// environment is removed, retaining only variables required by
+// the operating system.
+func getCleanCommand(os, cmd string, args []string) (string, []string) {
+	switch os {
+	case "windows":
+		// the script path is the final powershell argument,
+		// which is invoked once the environment is removed.
+		script := args[len(args)-1]
+		args = append([]string{}, args[:len(args)-1]...)
+		return cmd, append(args, fmt.Sprintf(
+			`"Get-ChildItem Env: | Where-Object { @('%s') -notcontains $_.Name } | Remove-Item; $Env:Path = $Env:SystemRoot + '\System32;' + $Env:SystemRoot + ';' + $Env:SystemRoot + '\System32\WindowsPowerShell\v1.0'; & %s"`,
+			strings.Join(cleanEnvWindows, "','"), script,
+		))
+	default:
+		return "env", append([]string{"-i", "PATH=" + cleanPath, cmd}, args...)
+	}
+}
+
+// cleanPath is the default PATH of a clean posix environment.
+const cleanPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// cleanEnvWindows is the list of environment variables retained
+// in a clean windows environment.
+var cleanEnvWindows = []string{
+	"ComSpec",
+	"NUMBER_OF_PROCESSORS",
+	"OS",
+	"PATHEXT",
+	"PROCESSOR_ARCHITECTURE",
+	"SystemDrive",
+	"SystemRoot",
+	"TEMP",
+	"TMP",
+	"windir",
+}
+
 // helper function returns the netrc file name based on the
 // target platform.
 func getNetrc(os string) string {
@@ -79,3 +184,167 @@ func genScript(os string, commands []string) string {
 		return bash.Script(commands)
 	}
 }
+
+// helper function returns a command that fails if git does not
+// recognize the credential helper configured with environment
+// variables, which requires git 2.31 or higher.
+func getCredentialCheck(os string) string {
+	const msg = "git 2.31 or higher is required to clone with clone-only netrc credentials"
+	switch os {
+	case "windows":
+		return fmt.Sprintf("if (-not (git config --get-regexp '^credential\\.')) { throw '%s' }", msg)
+	default:
+		return fmt.Sprintf("git config --get-regexp '^credential\\.' > /dev/null || { echo '%s' >&2; exit 1; }", msg)
+	}
+}
+
+// helper function returns a shell script that executes the
+// step commands, honoring the step errexit, pipefail and trace
+// options. If the options are not set, the default script is
+// returned.
+func genStepScript(os string, step *resource.Step) string {
+	if step.Errexit == nil && step.Pipefail == false && step.Trace == nil {
+		return genScript(os, step.Commands)
+	}
+	errexit := step.Errexit == nil || *step.Errexit
+	switch os {
+	case "windows":
+		return genPowershell(step.Commands, errexit, step.Trace)
+	default:
+		return genPosix(step.Commands, errexit, step.Pipefail, step.Trace)
+	}
+}
+
+// helper function returns a shell script that executes the
+// steps serially. Markers are written to the output when each
+// step starts and exits, identifying the step by index. The
+// markers include the nonce, so that they cannot be forged by
+// the step output. The script exits when a step fails, unless
+// errors are ignored.
+func genBatchScript(os, nonce string, steps []*engine.Step) string {
+	buf := new(strings.Builder)
+	if os != "windows" {
+		// the shell may be invoked with errexit, which must be
+		// disabled to capture the step exit code.
+		fmt.Fprintln(buf)
+		fmt.Fprintln(buf, "set +e")
+	}
+	for i, step := range steps {
+		cmd := step.Command + " " + strings.Join(step.Args, " ")
+		fmt.Fprintln(buf)
+		switch os {
+		case "windows":
+			fmt.Fprintf(buf, "Write-Output \"%s %s %d\"\n", engine.BatchStart, nonce, i)
+			fmt.Fprintf(buf, "& %s\n", cmd)
+			fmt.Fprintln(buf, "$code = $LASTEXITCODE")
+			fmt.Fprintf(buf, "Write-Output \"%s %s %d $code\"\n", engine.BatchExit, nonce, i)
+			if !step.IgnoreErr {
+				fmt.Fprintln(buf, "if ($code -ne 0) { exit $code }")
+			}
+		default:
+			fmt.Fprintf(buf, "echo \"%s %s %d\"\n", engine.BatchStart, nonce, i)
+			fmt.Fprintln(buf, cmd)
+			fmt.Fprintln(buf, "code=$?")
+			fmt.Fprintf(buf, "echo \"%s %s %d $code\"\n", engine.BatchExit, nonce, i)
+			if !step.IgnoreErr {
+				fmt.Fprintln(buf, "if [ $code -ne 0 ]; then exit $code; fi")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// helper function returns a posix shell script. If trace is
+// nil the commands are echoed, otherwise tracing is enabled
+// or disabled using set -x.
+func genPosix(commands []string, errexit, pipefail bool, trace *bool) string {
+	buf := new(strings.Builder)
+	fmt.Fprintln(buf)
+	if errexit {
+		fmt.Fprintln(buf, "set -e")
+	} else {
+		fmt.Fprintln(buf, "set +e")
+	}
+	if pipefail {
+		// pipefail is not supported by all posix shells.
+		fmt.Fprintln(buf, "(set -o pipefail) 2>/dev/null && set -o pipefail")
+	}
+	if trace != nil && *trace {
+		fmt.Fprintln(buf, "set -x")
+	}
+	for _, command := range commands {
+		fmt.Fprintln(buf)
+		if trace == nil {
+			// the command is echoed inside double quotes, where
+			// variables and command substitutions are expanded
+			// unless escaped.
+			escaped := fmt.Sprintf("%q", command)
+			escaped = strings.Replace(escaped, "$", `\$`, -1)
+			escaped = strings.Replace(escaped, "`", "\\`", -1)
+			fmt.Fprintf(buf, "echo + %s\n", escaped)
+		}
+		fmt.Fprintln(buf, command)
+	}
+	return buf.String()
+}
+
+// helper function returns a powershell script. If trace is nil
+// the commands are echoed, otherwise tracing is enabled or
+// disabled using Set-PSDebug.
+func genPowershell(commands []string, errexit bool, trace *bool) string {
+	buf := new(strings.Builder)
+	fmt.Fprintln(buf)
+	if errexit {
+		fmt.Fprintln(buf, `$erroractionpreference = "stop"`)
+	} else {
+		fmt.Fprintln(buf, `$erroractionpreference = "continue"`)
+	}
+	if trace != nil && *trace {
+		fmt.Fprintln(buf, "Set-PSDebug -Trace 1")
+	}
+	for _, command := range commands {
+		fmt.Fprintln(buf)
+		if trace == nil {
+			escaped := fmt.Sprintf("%q", "+ "+command)
+			escaped = strings.Replace(escaped, "$", "`$", -1)
+			fmt.Fprintf(buf, "echo %s\n", escaped)
+		}
+		fmt.Fprintln(buf, command)
+		if errexit {
+			fmt.Fprintln(buf, "if ($LastExitCode -ne 0) { exit $LastExitCode }")
+		}
+	}
+	return buf.String()
+}
+
+// helper function returns the locale environment variables,
+// which prevent garbled output on hosts where the locale is not
+// configured. The locale is configured using the console code
+// page on windows.
+func getLocaleEnviron(os, locale string) map[string]string {
+	if os == "windows" {
+		return nil
+	}
+	return map[string]string{
+		"LANG":   locale,
+		"LC_ALL": locale,
+	}
+}
+
+// helper function returns the home directory environment
+// variables that are required by windows tools.
+func getHomeEnviron(os, homedir string) map[string]string {
+	if os != "windows" {
+		return nil
+	}
+	drive, path := "", homedir
+	if len(homedir) >= 2 && homedir[1] == ':' {
+		drive, path = homedir[:2], homedir[2:]
+	}
+	return map[string]string{
+		"HOMEDRIVE":    drive,
+		"HOMEPATH":     path,
+		"APPDATA":      join(os, homedir, "AppData", "Roaming"),
+		"LOCALAPPDATA": join(os, homedir, "AppData", "Local"),
+	}
+}
@@ -5,9 +5,13 @@
 package compiler
 
 import (
+	"os/exec"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/resource"
 	"github.com/drone/runner-go/shell/bash"
 	"github.com/drone/runner-go/shell/powershell"
 
@@ -37,10 +41,19 @@ func Test_tempdir(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		if got, want := tempdir(test.os), test.path; got != want {
+		if got, want := tempdir(test.os, ""), test.path; got != want {
 			t.Errorf("Want tempdir %s, got %s", want, got)
 		}
 	}
+
+	// the base directory overrides the default temporary
+	// directory for the target platform.
+	if got, want := tempdir("linux", "/var/lib/drone/"), "/var/lib/drone/drone-random"; got != want {
+		t.Errorf("Want tempdir %s, got %s", want, got)
+	}
+	if got, want := tempdir("windows", `D:\drone`), `D:\drone\drone-random`; got != want {
+		t.Errorf("Want tempdir %s, got %s", want, got)
+	}
 }
 
 func Test_join(t *testing.T) {
@@ -59,6 +72,34 @@ func Test_join(t *testing.T) {
 	}
 }
 
+func Test_isAbs(t *testing.T) {
+	tests := []struct {
+		os   string
+		path string
+		abs  bool
+	}{
+		{os: "linux", path: "/etc/app.conf", abs: true},
+		{os: "linux", path: "config/app.conf", abs: false},
+		{os: "windows", path: `C:\ProgramData\app.conf`, abs: true},
+		{os: "windows", path: `\\server\share\app.conf`, abs: true},
+		{os: "windows", path: `config\app.conf`, abs: false},
+	}
+	for _, test := range tests {
+		if got, want := isAbs(test.os, test.path), test.abs; got != want {
+			t.Errorf("Want absolute %v for %s, got %v", want, test.path, got)
+		}
+	}
+}
+
+func Test_parentDir(t *testing.T) {
+	if got, want := parentDir("linux", "/tmp/drone/src/config/app.conf"), "/tmp/drone/src/config"; got != want {
+		t.Errorf("Want parent directory %s, got %s", want, got)
+	}
+	if got, want := parentDir("windows", `C:\drone\src\app.conf`), `C:\drone\src`; got != want {
+		t.Errorf("Want parent directory %s, got %s", want, got)
+	}
+}
+
 func Test_getExt(t *testing.T) {
 	tests := []struct {
 		os string
@@ -93,6 +134,54 @@ func Test_getCommand(t *testing.T) {
 	}
 }
 
+func Test_getStepCommand(t *testing.T) {
+	name := "pwsh"
+	if got, want := getDialect("linux", name), "windows"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+	cmd, args := getStepCommand("linux", name, "/tmp/drone/opt/build.ps1")
+	if got, want := cmd, "pwsh"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	if !reflect.DeepEqual(args, []string{"-noprofile", "-noninteractive", "-command", "/tmp/drone/opt/build.ps1"}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	name = ""
+	if got, want := getDialect("linux", name), "linux"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+	cmd, _ = getStepCommand("linux", name, "/tmp/drone/opt/build")
+	if got, want := cmd, "/bin/sh"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+
+	name = "/usr/local/bin/zsh"
+	if got, want := getDialect("linux", name), "linux"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+	if got, want := getShell(name), "zsh"; got != want {
+		t.Errorf("Want shell %s, got %s", want, got)
+	}
+	cmd, args = getStepCommand("linux", name, "/tmp/drone/opt/build")
+	if got, want := cmd, "/usr/local/bin/zsh"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	if !reflect.DeepEqual(args, []string{"-e", "/tmp/drone/opt/build"}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
+
+func Test_getUserCommand(t *testing.T) {
+	cmd, args := getUserCommand("nobody", "/bin/sh", []string{"-e", "build"})
+	if got, want := cmd, "sudo"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	if !reflect.DeepEqual(args, []string{"-n", "-E", "-u", "nobody", "/bin/sh", "-e", "build"}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
+
 func Test_getNetrc(t *testing.T) {
 	tests := []struct {
 		os   string
@@ -126,3 +215,168 @@ func Test_getScript(t *testing.T) {
 		t.Errorf("Generated invalid linux script")
 	}
 }
+
+func Test_getHomeEnviron(t *testing.T) {
+	if envs := getHomeEnviron("linux", "/tmp/drone-random/home/drone"); len(envs) != 0 {
+		t.Errorf("Expect no home environment variables on linux")
+	}
+
+	got := getHomeEnviron("windows", `C:\Windows\Temp\drone-random\home\drone`)
+	want := map[string]string{
+		"HOMEDRIVE":    `C:`,
+		"HOMEPATH":     `\Windows\Temp\drone-random\home\drone`,
+		"APPDATA":      `C:\Windows\Temp\drone-random\home\drone\AppData\Roaming`,
+		"LOCALAPPDATA": `C:\Windows\Temp\drone-random\home\drone\AppData\Local`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected home environment variables %v", got)
+	}
+}
+
+func Test_genStepScript(t *testing.T) {
+	commands := []string{"go build", "go test | tee report.txt"}
+	yes, no := true, false
+
+	// the default script is returned if no options are set.
+	got := genStepScript("linux", &resource.Step{Commands: commands})
+	if want := bash.Script(commands); got != want {
+		t.Errorf("Want default linux script %q, got %q", want, got)
+	}
+	got = genStepScript("windows", &resource.Step{Commands: commands})
+	if want := powershell.Script(commands); got != want {
+		t.Errorf("Want default windows script %q, got %q", want, got)
+	}
+
+	got = genStepScript("linux", &resource.Step{Commands: commands, Errexit: &no, Pipefail: true, Trace: &yes})
+	want := "\nset +e\n(set -o pipefail) 2>/dev/null && set -o pipefail\nset -x\n" +
+		"\ngo build\n" +
+		"\ngo test | tee report.txt\n"
+	if got != want {
+		t.Errorf("Want linux script %q, got %q", want, got)
+	}
+
+	got = genStepScript("linux", &resource.Step{Commands: commands, Pipefail: true})
+	want = "\nset -e\n(set -o pipefail) 2>/dev/null && set -o pipefail\n" +
+		"\necho + \"go build\"\ngo build\n" +
+		"\necho + \"go test | tee report.txt\"\ngo test | tee report.txt\n"
+	if got != want {
+		t.Errorf("Want linux script %q, got %q", want, got)
+	}
+
+	got = genStepScript("windows", &resource.Step{Commands: []string{"go build"}, Trace: &yes})
+	want = "\n$erroractionpreference = \"stop\"\nSet-PSDebug -Trace 1\n" +
+		"\ngo build\nif ($LastExitCode -ne 0) { exit $LastExitCode }\n"
+	if got != want {
+		t.Errorf("Want windows script %q, got %q", want, got)
+	}
+
+	got = genStepScript("linux", &resource.Step{Commands: []string{"echo $TOKEN $(whoami) `id`"}, Errexit: &no})
+	want = "\nset +e\n" +
+		"\necho + \"echo \\$TOKEN \\$(whoami) \\`id\\`\"\necho $TOKEN $(whoami) `id`\n"
+	if got != want {
+		t.Errorf("Want linux script %q, got %q", want, got)
+	}
+
+	got = genStepScript("windows", &resource.Step{Commands: []string{"echo $Env:HOME"}, Errexit: &no})
+	want = "\n$erroractionpreference = \"continue\"\n" +
+		"\necho \"+ echo `$Env:HOME\"\necho $Env:HOME\n"
+	if got != want {
+		t.Errorf("Want windows script %q, got %q", want, got)
+	}
+}
+
+func Test_getLocaleEnviron(t *testing.T) {
+	if envs := getLocaleEnviron("windows", "C.UTF-8"); len(envs) != 0 {
+		t.Errorf("Expect no locale environment variables on windows")
+	}
+	got := getLocaleEnviron("linux", "en_US.UTF-8")
+	want := map[string]string{
+		"LANG":   "en_US.UTF-8",
+		"LC_ALL": "en_US.UTF-8",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want locale environment %v, got %v", want, got)
+	}
+}
+
+func Test_genBatchScript(t *testing.T) {
+	steps := []*engine.Step{
+		{Name: "clone", Command: "/bin/sh", Args: []string{"-e", "/tmp/opt/clone"}},
+		{Name: "build", Command: "/bin/sh", Args: []string{"-e", "/tmp/opt/build"}, IgnoreErr: true},
+	}
+	got := genBatchScript("linux", "n0nce", steps)
+	want := `
+set +e
+
+echo "##[drone:start] n0nce 0"
+/bin/sh -e /tmp/opt/clone
+code=$?
+echo "##[drone:exit] n0nce 0 $code"
+if [ $code -ne 0 ]; then exit $code; fi
+
+echo "##[drone:start] n0nce 1"
+/bin/sh -e /tmp/opt/build
+code=$?
+echo "##[drone:exit] n0nce 1 $code"
+`
+	if got != want {
+		t.Errorf("Want batch script %q, got %q", want, got)
+	}
+
+	steps = []*engine.Step{
+		{Name: "build", Command: "powershell", Args: []string{"-noprofile", "C:\\opt\\build.ps1"}},
+	}
+	got = genBatchScript("windows", "n0nce", steps)
+	want = `
+Write-Output "##[drone:start] n0nce 0"
+& powershell -noprofile C:\opt\build.ps1
+$code = $LASTEXITCODE
+Write-Output "##[drone:exit] n0nce 0 $code"
+if ($code -ne 0) { exit $code }
+`
+	if got != want {
+		t.Errorf("Want batch script %q, got %q", want, got)
+	}
+}
+
+func Test_getCleanCommand(t *testing.T) {
+	cmd, args := getCleanCommand("linux", "/bin/sh", []string{"-e", "/tmp/opt/build"})
+	if got, want := cmd, "env"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	if !reflect.DeepEqual(args, []string{"-i", "PATH=" + cleanPath, "/bin/sh", "-e", "/tmp/opt/build"}) {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	cmd, args = getCleanCommand("windows", "powershell", []string{"-noprofile", "-noninteractive", "-command", `C:\opt\build.ps1`})
+	if got, want := cmd, "powershell"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	if got, want := len(args), 4; got != want {
+		t.Errorf("Want %d args, got %d", want, got)
+		return
+	}
+	if !strings.HasPrefix(args[3], `"Get-ChildItem Env:`) || !strings.HasSuffix(args[3], `& C:\opt\build.ps1"`) {
+		t.Errorf("Unexpected clean environment command %s", args[3])
+	}
+}
+
+// This test verifies that the echoed command is not expanded
+// by the shell, which would execute command substitutions
+// twice and write variable values to the logs.
+func Test_genPosix_Trace(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh command not found")
+	}
+	script := genPosix([]string{"true $SECRET $(echo run) `echo run`"}, true, false, nil)
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = []string{"SECRET=hunter2"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "+ true $SECRET $(echo run) `echo run`\n"
+	if got := string(out); got != want {
+		t.Errorf("Want trace %q, got %q", want, got)
+	}
+}
@@ -5,12 +5,21 @@
 package compiler
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+	"github.com/drone-runners/drone-runner-ssh/internal/credentials"
 	"github.com/drone/drone-go/drone"
-	"github.com/drone/runner-go/manifest"
 )
 
 // helper function returns true if the step is configured to
@@ -34,6 +43,32 @@ func isRunOnFailure(step *resource.Step) bool {
 	return step.When.Status.Match(drone.StatusFailing)
 }
 
+// helper function parses the octal file mode. If the mode is
+// empty or invalid, the default mode is returned.
+func parseMode(s string, def uint32) uint32 {
+	if s == "" {
+		return def
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return def
+	}
+	return uint32(mode)
+}
+
+// helper function returns the correlation id of the build,
+// which is shared by all stages of the build.
+func correlationID(build *drone.Build) string {
+	return fmt.Sprintf("drone-build-%d", build.ID)
+}
+
+// helper function returns true if the step is configured to
+// ignore all errors.
+func isIgnoreErr(step *resource.Step) bool {
+	return len(step.Failure.ExitCodes) == 0 &&
+		strings.EqualFold(step.Failure.Action, resource.FailureIgnore)
+}
+
 // helper function returns true if the pipeline specification
 // manually defines an execution graph.
 func isGraph(spec *engine.Spec) bool {
@@ -60,7 +95,7 @@ func configureSerial(spec *engine.Spec) {
 // helper function converts the environment variables to a map,
 // returning only inline environment variables not derived from
 // a secret.
-func convertStaticEnv(src map[string]*manifest.Variable) map[string]string {
+func convertStaticEnv(src map[string]*resource.Variable) map[string]string {
 	dst := map[string]string{}
 	for k, v := range src {
 		if v == nil {
@@ -73,10 +108,9 @@ func convertStaticEnv(src map[string]*manifest.Variable) map[string]string {
 	return dst
 }
 
-// helper function converts the environment variables to a map,
-// returning only inline environment variables not derived from
-// a secret.
-func convertSecretEnv(src map[string]*manifest.Variable) []*engine.Secret {
+// helper function converts the environment variables derived
+// from a secret to masked secrets.
+func convertSecretEnv(src map[string]*resource.Variable) []*engine.Secret {
 	dst := []*engine.Secret{}
 	for k, v := range src {
 		if v == nil {
@@ -84,15 +118,313 @@ func convertSecretEnv(src map[string]*manifest.Variable) []*engine.Secret {
 		}
 		if strings.TrimSpace(v.Secret) != "" {
 			dst = append(dst, &engine.Secret{
-				Name: v.Secret,
-				Mask: true,
-				Env:  k,
+				Name:     v.Secret,
+				Mask:     true,
+				Env:      k,
+				Optional: v.Optional,
 			})
 		}
 	}
 	return dst
 }
 
+// helper function converts the step settings to environment
+// variables prefixed with PLUGIN_, for example the setting
+// access_key is exported as PLUGIN_ACCESS_KEY.
+func convertSettings(src map[string]*resource.Variable) map[string]*resource.Variable {
+	dst := map[string]*resource.Variable{}
+	for k, v := range src {
+		dst["PLUGIN_"+strings.ToUpper(k)] = v
+	}
+	return dst
+}
+
+// helper function returns a copy of the step with the
+// ${vars.name} references replaced with the pipeline variables.
+func expandVars(step *resource.Step, vars map[string]string) *resource.Step {
+	if len(vars) == 0 {
+		return step
+	}
+	dup := *step
+	dup.WorkingDir = resource.ExpandVars(step.WorkingDir, vars)
+	dup.Commands = make([]string, len(step.Commands))
+	for i, s := range step.Commands {
+		dup.Commands[i] = resource.ExpandVars(s, vars)
+	}
+	dup.Environment = expandVariables(step.Environment, vars)
+	dup.Settings = expandVariables(step.Settings, vars)
+	return &dup
+}
+
+// helper function returns a copy of the variables with the
+// ${vars.name} references in the values replaced with the
+// pipeline variables.
+func expandVariables(src map[string]*resource.Variable, vars map[string]string) map[string]*resource.Variable {
+	if src == nil {
+		return nil
+	}
+	dst := map[string]*resource.Variable{}
+	for k, v := range src {
+		if v != nil {
+			dup := *v
+			dup.Value = resource.ExpandVars(v.Value, vars)
+			v = &dup
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// helper function returns the step environment variables,
+// including the step settings. The settings and environment
+// variables may be loaded from secrets, and an environment
+// variable replaces a setting with the same name.
+func stepEnviron(step *resource.Step) map[string]*resource.Variable {
+	if len(step.Settings) == 0 {
+		return step.Environment
+	}
+	dst := convertSettings(step.Settings)
+	for k, v := range step.Environment {
+		dst[k] = v
+	}
+	return dst
+}
+
+// helper function converts the static secret environment
+// variables to masked secrets, sorted by name. Variables
+// defined by the step secrets are excluded.
+func convertStaticSecrets(src map[string]string, secrets []*engine.Secret) []*engine.Secret {
+	dst := []*engine.Secret{}
+	for k, v := range src {
+		if hasSecretEnv(secrets, k) {
+			continue
+		}
+		dst = append(dst, &engine.Secret{
+			Name: k,
+			Env:  k,
+			Data: []byte(v),
+			Mask: true,
+		})
+	}
+	sort.Slice(dst, func(i, j int) bool {
+		return dst[i].Name < dst[j].Name
+	})
+	return dst
+}
+
+// helper function returns true if a secret is exported to the
+// named environment variable.
+func hasSecretEnv(secrets []*engine.Secret, env string) bool {
+	for _, s := range secrets {
+		if s.Env == env {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if any pipeline step executes
+// as a different user.
+func hasUserSteps(pipeline *resource.Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if step.User != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function converts the readiness probe of the
+// detached step. By default the probe times out after one
+// minute, and is attempted every second.
+func convertReady(src *resource.Ready) *engine.Probe {
+	if src == nil {
+		return nil
+	}
+	dst := &engine.Probe{
+		Port:     src.Port,
+		Command:  src.Command,
+		Timeout:  time.Minute,
+		Interval: time.Second,
+	}
+	if d, err := time.ParseDuration(src.Timeout); err == nil && d > 0 {
+		dst.Timeout = d
+	}
+	if d, err := time.ParseDuration(src.Interval); err == nil && d > 0 {
+		dst.Interval = d
+	}
+	return dst
+}
+
+// variable matches a ${NAME} variable reference.
+var variable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// helper function expands the ${NAME} variable references in
+// the string. References to undefined variables are not
+// modified.
+func expand(s string, vars map[string]string) string {
+	return variable.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := vars[ref[2:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// hostName matches a valid dns hostname.
+var hostName = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?)*\.?$`)
+
+// hostVars are the build environment variables that may be
+// referenced in the server host of an untrusted repository.
+// The variables cannot be influenced by the author of a
+// commit, unlike the branch, tag or commit variables.
+var hostVars = []string{
+	"DRONE_DEPLOY_TO",
+	"DRONE_REPO",
+	"DRONE_REPO_NAME",
+	"DRONE_REPO_NAMESPACE",
+	"DRONE_STAGE_NAME",
+}
+
+// helper function returns true if the string is a valid
+// hostname or ip address, with an optional port.
+func isHost(s string) bool {
+	host := s
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			return false
+		}
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.ParseIP(host) != nil || hostName.MatchString(host)
+}
+
+// helper function returns the step working directory. A
+// relative directory is resolved against the source directory.
+func workingDir(os, sourcedir, dir string) string {
+	switch {
+	case dir == "":
+		return sourcedir
+	case isAbs(os, dir):
+		return dir
+	default:
+		return join(os, append([]string{sourcedir}, strings.FieldsFunc(dir, isSeparator)...)...)
+	}
+}
+
+// helper function returns true if any of the path names
+// reference the parent directory.
+func hasParent(names []string) bool {
+	for _, name := range names {
+		if name == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the rune is a posix or
+// windows path separator.
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// helper function converts the pipeline tunnel to a port
+// forward. Addresses without a host are bound to the loopback
+// interface, and the direction defaults to a local forward.
+func convertTunnel(src *resource.Tunnel) *engine.Forward {
+	dst := &engine.Forward{
+		Direction: engine.ForwardLocal,
+		Local:     loopback(src.Local),
+		Remote:    loopback(src.Remote),
+	}
+	if src.Direction == resource.TunnelRemote {
+		dst.Direction = engine.ForwardRemote
+	}
+	return dst
+}
+
+// helper function returns the address with the loopback host
+// if the address is a port.
+func loopback(addr string) string {
+	if strings.Contains(addr, ":") {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", addr)
+}
+
+// helper function returns true if the host of the address is
+// a loopback ip address.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// helper function returns true if the address matches a tunnel
+// rule. The address host must be an ip address, and rules that
+// cannot be parsed never match.
+func matchTunnel(rules []string, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, rule := range rules {
+		network, p, err := ParseTunnelRule(rule)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) && strconv.Itoa(p) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the transport url matches
+// a transport rule. Urls with credentials, a query, a fragment
+// or a path that is not clean never match, so that a url
+// cannot match a rule and dial a different address.
+func matchTransport(rules []string, addr string) bool {
+	u, err := url.Parse(addr)
+	if err != nil || u.User != nil || u.Opaque != "" || u.RawQuery != "" || u.Fragment != "" || u.ForceQuery {
+		return false
+	}
+	if clean := path.Clean(u.Path); u.Path != "" && u.Path != clean && u.Path != clean+"/" {
+		return false
+	}
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule, addr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the tunnel driver and
+// instance match a server tunnel rule. Rules that cannot be
+// parsed never match.
+func matchServerTunnel(rules []string, driver, instance string) bool {
+	for _, rule := range rules {
+		d, pattern, err := ParseServerTunnelRule(rule)
+		if err != nil || d != driver {
+			continue
+		}
+		if ok, _ := path.Match(pattern, instance); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // helper function modifies the pipeline dependency graph to
 // account for the clone step.
 func configureCloneDeps(spec *engine.Spec) {
@@ -121,3 +453,232 @@ func removeCloneDeps(spec *engine.Spec) {
 		}
 	}
 }
+
+// helper function converts the credential file to a list of
+// files, including parent directories, that are created in the
+// home directory. Absolute paths and paths outside of the home
+// directory are ignored.
+func convertCredentials(os, homedir string, src *credentials.File) []*engine.File {
+	name := path.Clean(src.Path)
+	if path.IsAbs(name) || name == "." || strings.HasPrefix(name, "..") {
+		return nil
+	}
+	mode := src.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	var dst []*engine.File
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dst = append(dst, &engine.File{
+			Path:  join(os, append([]string{homedir}, parts[:i]...)...),
+			Mode:  0700,
+			IsDir: true,
+		})
+	}
+	dst = append(dst, &engine.File{
+		Path: join(os, append([]string{homedir}, parts...)...),
+		Mode: mode,
+		Data: []byte(src.Data),
+	})
+	return dst
+}
+
+// helper function converts the sync step. Local paths are
+// resolved against the sync directory of the runner host.
+// Relative remote paths are resolved against the working
+// directory, and use forward slashes as required by sftp.
+func createSync(os, workdir, syncdir string, src *resource.Sync) *engine.Sync {
+	dst := &engine.Sync{
+		Direction: src.Direction,
+		Source:    src.Source,
+		Target:    src.Target,
+	}
+	local, remote := &dst.Source, &dst.Target
+	if src.Direction == resource.SyncDownload {
+		local, remote = remote, local
+	}
+	*local = filepath.Join(syncdir, filepath.FromSlash(*local))
+	if !isAbs(os, *remote) {
+		*remote = join(os, workdir, *remote)
+	}
+	if os == "windows" {
+		*remote = strings.Replace(*remote, `\`, "/", -1)
+	}
+	return dst
+}
+
+// helper function converts the legacy step groups to step
+// dependencies. Consecutive steps in the same group execute in
+// parallel, and each step depends on all steps of the previous
+// group. A step without a group is a group of its own.
+func expandGroups(src []*resource.Step) []*resource.Step {
+	if !isGrouped(src) {
+		return src
+	}
+	var dst []*resource.Step
+	var prev, curr []string
+	for i, step := range src {
+		if i == 0 || step.Group == "" || step.Group != src[i-1].Group {
+			prev, curr = curr, nil
+		}
+		dup := *step
+		dup.DependsOn = prev
+		curr = append(curr, step.Name)
+		dst = append(dst, &dup)
+	}
+	return dst
+}
+
+// helper function returns true if any step defines a legacy
+// step group.
+func isGrouped(src []*resource.Step) bool {
+	for _, step := range src {
+		if step.Group != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function expands the steps that define a matrix into
+// a step for each combination of the matrix axes. The axis
+// values are exported to the step as environment variables,
+// and dependencies on an expanded step are replaced with
+// dependencies on each of its combinations.
+func expandMatrix(src []*resource.Step) []*resource.Step {
+	var dst []*resource.Step
+	names := map[string][]string{}
+	for _, step := range src {
+		if len(step.Matrix) == 0 {
+			dst = append(dst, step)
+			continue
+		}
+		uploaded := false
+		for _, axis := range matrixAxes(step.Matrix) {
+			dup := *step
+			dup.Name = fmt.Sprintf("%s (%s)", step.Name, axisString(axis))
+			dup.Matrix = nil
+			dup.Axis = axis
+			dup.Environment = map[string]*resource.Variable{}
+			for k, v := range step.Environment {
+				dup.Environment[k] = v
+			}
+			for k, v := range axis {
+				dup.Environment[k] = &resource.Variable{Value: v}
+			}
+			// the step files are the same for each combination
+			// and are only uploaded once, by the first
+			// combination that is not skipped by the matrix
+			// conditions.
+			if uploaded || !step.When.MatchMatrix(axis) {
+				dup.Files = nil
+			} else {
+				uploaded = true
+			}
+			names[step.Name] = append(names[step.Name], dup.Name)
+			dst = append(dst, &dup)
+		}
+	}
+	if len(names) == 0 {
+		return dst
+	}
+	for i, step := range dst {
+		var deps []string
+		for _, dep := range step.DependsOn {
+			if expanded, ok := names[dep]; ok {
+				deps = append(deps, expanded...)
+			} else {
+				deps = append(deps, dep)
+			}
+		}
+		if len(deps) != 0 {
+			dup := *step
+			dup.DependsOn = deps
+			dst[i] = &dup
+		}
+	}
+	return dst
+}
+
+// helper function returns each combination of the matrix axes,
+// ordered by axis name and then by the order of the values.
+func matrixAxes(matrix map[string][]string) []map[string]string {
+	var keys []string
+	for k := range matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	axes := []map[string]string{{}}
+	for _, k := range keys {
+		var next []map[string]string
+		for _, axis := range axes {
+			for _, v := range matrix[k] {
+				combined := map[string]string{k: v}
+				for kk, vv := range axis {
+					combined[kk] = vv
+				}
+				next = append(next, combined)
+			}
+		}
+		axes = next
+	}
+	return axes
+}
+
+// helper function returns the matrix axis as a string of
+// sorted key=value pairs.
+func axisString(axis map[string]string) string {
+	var pairs []string
+	for k, v := range axis {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// helper function removes the file with the given path from
+// the list of files.
+func removeFile(files []*engine.File, path string) []*engine.File {
+	var dst []*engine.File
+	for _, file := range files {
+		if file.Path != path {
+			dst = append(dst, file)
+		}
+	}
+	return dst
+}
+
+// helper function appends the string to the list, if the list
+// does not already contain the string.
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// helper function returns the environment variables that
+// configure a git credential helper for each netrc machine,
+// which provides the credentials to git without writing them
+// to disk. The passwords are provided separately as masked
+// secrets. The helper is scoped to the machine for both the
+// http and https protocols. The git configuration environment
+// variables require git 2.31 or higher.
+func getCredentialHelper(machines []*drone.Netrc) map[string]string {
+	envs := map[string]string{}
+	count := 0
+	for i, netrc := range machines {
+		envs[fmt.Sprintf("DRONE_NETRC_USERNAME_%d", i)] = netrc.Login
+		helper := fmt.Sprintf(`!f() { echo "username=$DRONE_NETRC_USERNAME_%d"; echo "password=$DRONE_NETRC_PASSWORD_%d"; }; f`, i, i)
+		for _, scheme := range []string{"https", "http"} {
+			envs[fmt.Sprintf("GIT_CONFIG_KEY_%d", count)] = fmt.Sprintf("credential.%s://%s.helper", scheme, netrc.Machine)
+			envs[fmt.Sprintf("GIT_CONFIG_VALUE_%d", count)] = helper
+			count++
+		}
+	}
+	envs["GIT_CONFIG_COUNT"] = strconv.Itoa(count)
+	return envs
+}
@@ -6,11 +6,14 @@ package compiler
 
 import (
 	"testing"
+	"time"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
-	"github.com/drone/runner-go/manifest"
+	"github.com/drone-runners/drone-runner-ssh/internal/credentials"
 
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -88,9 +91,9 @@ func Test_configureSerial(t *testing.T) {
 }
 
 func Test_convertStaticEnv(t *testing.T) {
-	vars := map[string]*manifest.Variable{
-		"username": &manifest.Variable{Value: "octocat"},
-		"password": &manifest.Variable{Secret: "password"},
+	vars := map[string]*resource.Variable{
+		"username": &resource.Variable{Value: "octocat"},
+		"password": &resource.Variable{Secret: "password"},
 	}
 	envs := convertStaticEnv(vars)
 	want := map[string]string{"username": "octocat"}
@@ -101,9 +104,9 @@ func Test_convertStaticEnv(t *testing.T) {
 }
 
 func Test_convertSecretEnv(t *testing.T) {
-	vars := map[string]*manifest.Variable{
-		"USERNAME": &manifest.Variable{Value: "octocat"},
-		"PASSWORD": &manifest.Variable{Secret: "password"},
+	vars := map[string]*resource.Variable{
+		"USERNAME": &resource.Variable{Value: "octocat"},
+		"PASSWORD": &resource.Variable{Secret: "password"},
 	}
 	envs := convertSecretEnv(vars)
 	want := []*engine.Secret{
@@ -189,3 +192,463 @@ func Test_removeCloneDeps_CloneEnabled(t *testing.T) {
 		t.Log(diff)
 	}
 }
+
+func Test_convertCredentials(t *testing.T) {
+	file := &credentials.File{Path: ".docker/config.json", Data: "{}"}
+	got := convertCredentials("linux", "/tmp/drone-random/home/drone", file)
+	want := []*engine.File{
+		{Path: "/tmp/drone-random/home/drone/.docker", Mode: 0700, IsDir: true},
+		{Path: "/tmp/drone-random/home/drone/.docker/config.json", Mode: 0600, Data: []byte("{}")},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected credential files")
+		t.Log(diff)
+	}
+
+	file = &credentials.File{Path: ".npmrc", Mode: 0644}
+	got = convertCredentials("windows", `C:\Windows\Temp\drone-random\home\drone`, file)
+	want = []*engine.File{
+		{Path: `C:\Windows\Temp\drone-random\home\drone\.npmrc`, Mode: 0644, Data: []byte{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected credential files")
+		t.Log(diff)
+	}
+
+	for _, name := range []string{"/etc/passwd", "../.ssh/authorized_keys", ""} {
+		file = &credentials.File{Path: name}
+		if got := convertCredentials("linux", "/tmp/drone-random/home/drone", file); len(got) != 0 {
+			t.Errorf("Expect credential file %q ignored", name)
+		}
+	}
+}
+
+func Test_correlationID(t *testing.T) {
+	if got, want := correlationID(&drone.Build{ID: 42}), "drone-build-42"; got != want {
+		t.Errorf("Want correlation id %s, got %s", want, got)
+	}
+}
+
+func Test_isIgnoreErr(t *testing.T) {
+	step := new(resource.Step)
+	if isIgnoreErr(step) == true {
+		t.Errorf("Want ignore err false if empty failure policy")
+	}
+	step.Failure.Action = "ignore"
+	if isIgnoreErr(step) == false {
+		t.Errorf("Want ignore err true if failure ignore")
+	}
+	step.Failure.ExitCodes = []int{2, 3}
+	if isIgnoreErr(step) == true {
+		t.Errorf("Want ignore err false if failure limited to exit codes")
+	}
+}
+
+func Test_parseMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		def  uint32
+		want uint32
+	}{
+		{"", 0700, 0700},
+		{"0750", 0700, 0750},
+		{"755", 0700, 0755},
+		{"invalid", 0700, 0700},
+	}
+	for _, test := range tests {
+		if got := parseMode(test.s, test.def); got != test.want {
+			t.Errorf("Want mode %o for %q, got %o", test.want, test.s, got)
+		}
+	}
+}
+
+func Test_createSync(t *testing.T) {
+	tests := []struct {
+		os   string
+		src  *resource.Sync
+		want *engine.Sync
+	}{
+		{
+			os:   "linux",
+			src:  &resource.Sync{Direction: "upload", Source: "fixtures/*", Target: "testdata"},
+			want: &engine.Sync{Direction: "upload", Source: "/opt/sync/fixtures/*", Target: "/tmp/drone/src/testdata"},
+		},
+		{
+			os:   "linux",
+			src:  &resource.Sync{Direction: "download", Source: "dist/*.tar.gz", Target: "artifacts"},
+			want: &engine.Sync{Direction: "download", Source: "/tmp/drone/src/dist/*.tar.gz", Target: "/opt/sync/artifacts"},
+		},
+		{
+			os:   "linux",
+			src:  &resource.Sync{Direction: "download", Source: "/var/log/*.log", Target: "logs"},
+			want: &engine.Sync{Direction: "download", Source: "/var/log/*.log", Target: "/opt/sync/logs"},
+		},
+		{
+			os:   "windows",
+			src:  &resource.Sync{Direction: "download", Source: "dist/*.zip", Target: "artifacts"},
+			want: &engine.Sync{Direction: "download", Source: "C:/drone/src/dist/*.zip", Target: "/opt/sync/artifacts"},
+		},
+	}
+	for _, test := range tests {
+		workdir := "/tmp/drone/src"
+		if test.os == "windows" {
+			workdir = `C:\drone\src`
+		}
+		got := createSync(test.os, workdir, "/opt/sync", test.src)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf(diff)
+		}
+	}
+}
+
+func Test_expandMatrix(t *testing.T) {
+	steps := []*resource.Step{
+		{
+			Name: "test",
+			Matrix: map[string][]string{
+				"GO_VERSION": {"1.20", "1.21"},
+				"GOARCH":     {"amd64"},
+			},
+			Environment: map[string]*resource.Variable{
+				"CGO_ENABLED": {Value: "0"},
+			},
+			Files: []*resource.File{{Path: "config.json"}},
+		},
+		{
+			Name:      "publish",
+			DependsOn: []string{"test"},
+		},
+	}
+	got := expandMatrix(steps)
+	if len(got) != 3 {
+		t.Fatalf("Want 3 steps, got %d", len(got))
+	}
+	if got[0].Name != "test (GOARCH=amd64, GO_VERSION=1.20)" {
+		t.Errorf("Unexpected step name %q", got[0].Name)
+	}
+	if got[1].Name != "test (GOARCH=amd64, GO_VERSION=1.21)" {
+		t.Errorf("Unexpected step name %q", got[1].Name)
+	}
+	if v := got[1].Environment["GO_VERSION"]; v == nil || v.Value != "1.21" {
+		t.Errorf("Want matrix axis exported as environment variable")
+	}
+	if v := got[1].Environment["CGO_ENABLED"]; v == nil || v.Value != "0" {
+		t.Errorf("Want step environment preserved")
+	}
+	if len(got[0].Files) != 1 || len(got[1].Files) != 0 {
+		t.Errorf("Want step files uploaded once")
+	}
+	if diff := cmp.Diff(got[2].DependsOn, []string{got[0].Name, got[1].Name}); diff != "" {
+		t.Errorf(diff)
+	}
+	if len(steps[0].Environment) != 1 || len(steps[1].DependsOn) != 1 {
+		t.Errorf("Want source steps unmodified")
+	}
+	if got[1].Axis["GO_VERSION"] != "1.21" {
+		t.Errorf("Want matrix axis values")
+	}
+}
+
+func Test_expandMatrix_Conditions(t *testing.T) {
+	step := &resource.Step{
+		Name:   "test",
+		Matrix: map[string][]string{"GO_VERSION": {"1.20", "1.21"}},
+		Files:  []*resource.File{{Path: "config.json"}},
+	}
+	step.When.Matrix = map[string]manifest.Condition{
+		"GO_VERSION": {Include: []string{"1.21"}},
+	}
+	got := expandMatrix([]*resource.Step{step})
+	if len(got) != 2 {
+		t.Fatalf("Want 2 steps, got %d", len(got))
+	}
+	if got[0].When.MatchMatrix(got[0].Axis) || !got[1].When.MatchMatrix(got[1].Axis) {
+		t.Errorf("Want only the matching combination to run")
+	}
+	if len(got[0].Files) != 0 || len(got[1].Files) != 1 {
+		t.Errorf("Want step files uploaded by the matching combination")
+	}
+}
+
+func Test_expandGroups(t *testing.T) {
+	steps := []*resource.Step{
+		{Name: "backend", Group: "build"},
+		{Name: "frontend", Group: "build"},
+		{Name: "test"},
+		{Name: "publish", Group: "deploy"},
+		{Name: "notify", Group: "deploy"},
+	}
+	got := expandGroups(steps)
+	want := [][]string{
+		nil,
+		nil,
+		{"backend", "frontend"},
+		{"test"},
+		{"test"},
+	}
+	for i, step := range got {
+		if diff := cmp.Diff(step.DependsOn, want[i]); diff != "" {
+			t.Errorf("Unexpected dependencies for step %s: %s", step.Name, diff)
+		}
+	}
+	for _, step := range steps {
+		if len(step.DependsOn) != 0 {
+			t.Errorf("Want source steps unmodified")
+		}
+	}
+
+	// steps without groups are not modified.
+	steps = []*resource.Step{{Name: "build"}, {Name: "test"}}
+	if got := expandGroups(steps); len(got[1].DependsOn) != 0 {
+		t.Errorf("Want no dependencies without step groups")
+	}
+}
+
+func Test_convertReady(t *testing.T) {
+	if convertReady(nil) != nil {
+		t.Errorf("Want nil readiness probe")
+	}
+	got := convertReady(&resource.Ready{Port: 5432})
+	want := &engine.Probe{Port: 5432, Timeout: time.Minute, Interval: time.Second}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+	got = convertReady(&resource.Ready{Command: "pg_isready", Timeout: "2m", Interval: "500ms"})
+	want = &engine.Probe{Command: "pg_isready", Timeout: 2 * time.Minute, Interval: 500 * time.Millisecond}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func Test_expandVars(t *testing.T) {
+	vars := map[string]string{"go_version": "1.21", "bucket": "artifacts"}
+	step := &resource.Step{
+		Name:       "build",
+		Commands:   []string{"go${vars.go_version} build", "go test"},
+		WorkingDir: "go${vars.go_version}",
+		Environment: map[string]*resource.Variable{
+			"GOTOOLCHAIN": {Value: "go${vars.go_version}"},
+			"PASSWORD":    {Secret: "password"},
+		},
+		Settings: map[string]*resource.Variable{
+			"bucket": {Value: "${vars.bucket}"},
+		},
+	}
+	got := expandVars(step, vars)
+	want := &resource.Step{
+		Name:       "build",
+		Commands:   []string{"go1.21 build", "go test"},
+		WorkingDir: "go1.21",
+		Environment: map[string]*resource.Variable{
+			"GOTOOLCHAIN": {Value: "go1.21"},
+			"PASSWORD":    {Secret: "password"},
+		},
+		Settings: map[string]*resource.Variable{
+			"bucket": {Value: "artifacts"},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+	if step.Commands[0] != "go${vars.go_version} build" || step.Environment["GOTOOLCHAIN"].Value != "go${vars.go_version}" {
+		t.Errorf("Want original step unchanged")
+	}
+	if expandVars(step, nil) != step {
+		t.Errorf("Want step returned unchanged without variables")
+	}
+}
+
+func Test_stepEnviron(t *testing.T) {
+	step := &resource.Step{
+		Environment: map[string]*resource.Variable{
+			"GOOS":          {Value: "linux"},
+			"PLUGIN_REGION": {Value: "us-west-2"},
+		},
+		Settings: map[string]*resource.Variable{
+			"access_key": {Secret: "aws_access_key"},
+			"region":     {Value: "us-east-1"},
+		},
+	}
+	want := map[string]*resource.Variable{
+		"GOOS":              {Value: "linux"},
+		"PLUGIN_ACCESS_KEY": {Secret: "aws_access_key"},
+		"PLUGIN_REGION":     {Value: "us-west-2"},
+	}
+	if diff := cmp.Diff(stepEnviron(step), want); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func Test_expand(t *testing.T) {
+	vars := map[string]string{"DRONE_BRANCH": "main", "DRONE_DEPLOY_TO": "prod"}
+	tests := []struct {
+		s, want string
+	}{
+		{"${DRONE_DEPLOY_TO}.company.com", "prod.company.com"},
+		{"${DRONE_DEPLOY_TO}-${DRONE_BRANCH}", "prod-main"},
+		{"${DRONE_UNDEFINED}", "${DRONE_UNDEFINED}"},
+		{"$DRONE_BRANCH", "$DRONE_BRANCH"},
+		{"${env:TEMP}", "${env:TEMP}"},
+		{"localhost", "localhost"},
+	}
+	for _, test := range tests {
+		if got := expand(test.s, vars); got != test.want {
+			t.Errorf("Want %q expanded to %q, got %q", test.s, test.want, got)
+		}
+	}
+}
+
+func Test_workingDir(t *testing.T) {
+	tests := []struct {
+		os, dir, want string
+	}{
+		{"linux", "", "/tmp/drone/src"},
+		{"linux", "cmd/server", "/tmp/drone/src/cmd/server"},
+		{"linux", "/opt/app", "/opt/app"},
+		{"windows", "", `C:\Temp\src`},
+		{"windows", "cmd/server", `C:\Temp\src\cmd\server`},
+		{"windows", `D:\app`, `D:\app`},
+	}
+	for _, test := range tests {
+		sourcedir := "/tmp/drone/src"
+		if test.os == "windows" {
+			sourcedir = `C:\Temp\src`
+		}
+		if got := workingDir(test.os, sourcedir, test.dir); got != test.want {
+			t.Errorf("Want working directory %q, got %q", test.want, got)
+		}
+	}
+}
+
+func Test_convertTunnel(t *testing.T) {
+	tests := []struct {
+		src  *resource.Tunnel
+		want *engine.Forward
+	}{
+		{
+			src:  &resource.Tunnel{Local: "15432", Remote: "5432"},
+			want: &engine.Forward{Direction: "local", Local: "127.0.0.1:15432", Remote: "127.0.0.1:5432"},
+		},
+		{
+			src:  &resource.Tunnel{Direction: "remote", Local: "proxy.internal:3128", Remote: "3128"},
+			want: &engine.Forward{Direction: "remote", Local: "proxy.internal:3128", Remote: "127.0.0.1:3128"},
+		},
+		{
+			src:  &resource.Tunnel{Local: "[::1]:8080", Remote: "0.0.0.0:80"},
+			want: &engine.Forward{Direction: "local", Local: "[::1]:8080", Remote: "0.0.0.0:80"},
+		},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(convertTunnel(test.src), test.want); diff != "" {
+			t.Errorf(diff)
+		}
+	}
+}
+
+func Test_isLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:15432", true},
+		{"[::1]:8080", true},
+		{"0.0.0.0:8080", false},
+		{"10.0.0.1:8080", false},
+		{"localhost:8080", false},
+		{"8080", false},
+	}
+	for _, test := range tests {
+		if got := isLoopback(test.addr); got != test.want {
+			t.Errorf("Want loopback %v for address %s, got %v", test.want, test.addr, got)
+		}
+	}
+}
+
+func Test_matchTunnel(t *testing.T) {
+	rules := []string{"10.0.0.0/8:3128", "::1/128:8080", "invalid"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3:3128", true},
+		{"[::1]:8080", true},
+		{"10.1.2.3:22", false},
+		{"192.168.1.1:3128", false},
+		{"proxy.internal:3128", false},
+	}
+	for _, test := range tests {
+		if got := matchTunnel(rules, test.addr); got != test.want {
+			t.Errorf("Want match %v for address %s, got %v", test.want, test.addr, got)
+		}
+	}
+}
+
+func Test_matchTransport(t *testing.T) {
+	rules := []string{"unix:///run/sshd/*.sock", "wss://bastion.company.com/ssh"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"unix:///run/sshd/build.sock", true},
+		{"wss://bastion.company.com/ssh", true},
+		{"wss://bastion.company.com/ssh/", false},
+		{"unix:///var/run/docker.sock", false},
+		{"unix:///run/sshd/../../var/run/docker.sock", false},
+		{"ws://bastion.company.com/ssh", false},
+		{"wss://bastion.company.com/ssh?host=10.0.0.1", false},
+		{"wss://user@bastion.company.com/ssh", false},
+	}
+	for _, test := range tests {
+		if got := matchTransport(rules, test.addr); got != test.want {
+			t.Errorf("Want match %v for url %s, got %v", test.want, test.addr, got)
+		}
+	}
+}
+
+func Test_matchServerTunnel(t *testing.T) {
+	rules := []string{"aws-ssm:i-0123456789abcdef0", "gcp-iap:build-*", "invalid"}
+	tests := []struct {
+		driver, instance string
+		want             bool
+	}{
+		{"aws-ssm", "i-0123456789abcdef0", true},
+		{"gcp-iap", "build-1", true},
+		{"aws-ssm", "build-1", false},
+		{"gcp-iap", "database-1", false},
+		{"gcp-iap", "i-0123456789abcdef0", false},
+	}
+	for _, test := range tests {
+		if got := matchServerTunnel(rules, test.driver, test.instance); got != test.want {
+			t.Errorf("Want match %v for %s instance %s, got %v", test.want, test.driver, test.instance, got)
+		}
+	}
+}
+
+func Test_isHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"localhost:22", true},
+		{"staging.company.com", true},
+		{"staging.company.com.", true},
+		{"10.0.0.1", true},
+		{"10.0.0.1:2222", true},
+		{"::1", true},
+		{"[::1]:22", true},
+		{"", false},
+		{"-oProxyCommand=id", false},
+		{"company.com/x", false},
+		{"user@company.com", false},
+		{"company.com:0", false},
+		{"company.com:99999", false},
+		{"company.com:ssh", false},
+		{"company .com", false},
+	}
+	for _, test := range tests {
+		if got := isHost(test.host); got != test.want {
+			t.Errorf("Want isHost %v for %q", test.want, test.host)
+		}
+	}
+}
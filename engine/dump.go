@@ -0,0 +1,87 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DumpScripts writes the pipeline scripts to the local
+// directory as they are uploaded to the remote server, so that
+// quoting and escaping errors can be reproduced offline. The
+// secrets are uploaded separately and are not written.
+func DumpScripts(spec *Spec, dir string) error {
+	var steps []*Step
+	for _, step := range []*Step{spec.Setup, spec.Teardown, spec.Batch} {
+		if step != nil {
+			steps = append(steps, step)
+		}
+	}
+	for _, step := range append(steps, spec.Steps...) {
+		if err := dumpStep(spec, step, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function writes the scripts of the step, and of the
+// steps executed by a batch step, to the local directory.
+func dumpStep(spec *Spec, step *Step, dir string) error {
+	for _, sub := range step.Batch {
+		if err := dumpStep(spec, sub, dir); err != nil {
+			return err
+		}
+	}
+	for _, file := range step.Files {
+		if err := dumpScript(dir, spec, file, renderScript(spec, step, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function writes the script to the local directory,
+// in a folder named after the workspace.
+func dumpScript(dir string, spec *Spec, file *File, data []byte) error {
+	path := filepath.Join(dir, baseName(spec.Root), baseName(file.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// helper function returns the script as it is uploaded to the
+// remote server, with the step environment prepended.
+func renderScript(spec *Spec, step *Step, file *File) []byte {
+	dialect := scriptOS(spec, step)
+	w := new(bytes.Buffer)
+	if spec.Syslog {
+		writeMarker(w, dialect, spec.CorrelationID, step.Name)
+	}
+	writeWorkdir(w, step.WorkingDir)
+	writeUmask(w, dialect, step.Umask)
+	writeCodepage(w, dialect)
+	if len(step.Secrets) != 0 {
+		writeSourceSecrets(w, dialect, secretsPath(dialect, file.Path))
+	}
+	writeEnviron(w, dialect, spec.Encoding, step.Envs)
+	writePath(w, dialect, step.Path)
+	w.Write(file.Data)
+	return w.Bytes()
+}
+
+// helper function returns the last element of the posix or
+// windows path.
+func baseName(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i != -1 {
+		return path[i+1:]
+	}
+	return path
+}
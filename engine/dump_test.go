@@ -0,0 +1,67 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spec := &Spec{
+		Root: "/tmp/drone-random",
+		Steps: []*Step{
+			{
+				Name:       "build",
+				Envs:       map[string]string{"GOOS": "linux"},
+				Secrets:    []*Secret{{Env: "TOKEN", Data: []byte("correct-horse-battery-staple")}},
+				WorkingDir: "/tmp/drone-random/drone/src",
+				Files: []*File{
+					{Path: "/tmp/drone-random/opt/build", Mode: 0700, Data: []byte("go build\n")},
+				},
+			},
+		},
+	}
+	if err := DumpScripts(spec, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "drone-random", "build"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(data)
+	if !strings.Contains(script, "GOOS") || !strings.HasSuffix(script, "go build\n") {
+		t.Errorf("Want script with step environment, got %q", script)
+	}
+	if strings.Contains(script, "correct-horse-battery-staple") {
+		t.Errorf("Want secrets excluded from dumped script")
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/tmp/drone-random/opt/build", "build"},
+		{`C:\Windows\Temp\drone-random\opt\build.ps1`, "build.ps1"},
+		{"build", "build"},
+	}
+	for _, test := range tests {
+		if got := baseName(test.path); got != test.want {
+			t.Errorf("Want base name %q, got %q", test.want, got)
+		}
+	}
+}
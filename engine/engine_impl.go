@@ -8,34 +8,42 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
-	"strings"
+	"sort"
 
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
 	"github.com/drone/runner-go/logger"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/sync/errgroup"
 )
 
+// uploadWorkers is the maximum number of files uploaded to the
+// remote server concurrently.
+const uploadWorkers = 8
+
 // New returns a new engine.
 func New() Engine {
 	return new(engine)
 }
 
-type engine struct{}
+type engine struct {
+	shared   sharedClients
+	forwards forwarders
+}
 
 // Setup the pipeline environment.
 func (e *engine) Setup(ctx context.Context, spec *Spec) error {
-	client, err := dial(
-		spec.Server.Hostname,
-		spec.Server.Username,
-		spec.Server.Password,
-		spec.Server.SSHKey,
-	)
+	client, err := dialServer(ctx, spec.Server)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	auditOpen(spec)
+	defer closeClient(spec, client)
 
 	clientftp, err := sftp.NewClient(client)
 	if err != nil {
@@ -43,10 +51,39 @@ func (e *engine) Setup(ctx context.Context, spec *Spec) error {
 	}
 	defer clientftp.Close()
 
+	// the environment variables are base64 encoded by default.
+	// if the remote host does not provide the base64 command
+	// the environment variables are quoted instead.
+	if spec.Encoding == "" && spec.Platform.OS != "windows" {
+		spec.Encoding = detectEncoding(client, spec)
+	}
+
+	// windows servers may not provide windows powershell,
+	// in which case the scripts are executed with pwsh.
+	if spec.Shell == "" && spec.Platform.OS == "windows" {
+		spec.Shell = detectShell(client, spec)
+	}
+
+	// the remote server is probed for the tools required by
+	// the pipeline, failing fast if any tools are missing.
+	if len(spec.Requires) != 0 {
+		err = checkRequirements(client, spec)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				Error("remote host requirements not met")
+			return err
+		}
+	}
+
 	// the pipeline workspace is created before pipeline
 	// execution begins. All files and folders created during
 	// pipeline execution are isolated to this workspace.
-	err = mkdir(clientftp, spec.Root, 0777)
+	mode := spec.RootMode
+	if mode == 0 {
+		mode = 0777
+	}
+	err = mkdir(clientftp, spec.Root, mode)
 	if err != nil {
 		logger.FromContext(ctx).
 			WithError(err).
@@ -55,35 +92,114 @@ func (e *engine) Setup(ctx context.Context, spec *Spec) error {
 		return err
 	}
 
+	// the artifacts directory is created outside of the
+	// workspace, and is not removed when the pipeline
+	// completes unless it is empty.
+	if spec.Artifacts != "" {
+		err = mkdir(clientftp, spec.Artifacts, mode)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", spec.Artifacts).
+				Error("cannot create artifacts directory")
+			return err
+		}
+	}
+
 	// the pipeline specification may define global folders, such
 	// as the pipeline working directory, wich must be created
 	// before pipeline execution begins.
-	for _, file := range spec.Files {
-		if file.IsDir == false {
-			continue
-		}
-		err = mkdir(clientftp, file.Path, file.Mode)
+	err = mkdirs(ctx, clientftp, spec.Files)
+	if err != nil {
+		return err
+	}
+
+	// the workspace directories are owned by the group shared
+	// by the ssh user and the step users, if defined.
+	if spec.Group != "" && spec.Platform.OS != "windows" {
+		err = chgrpDirs(client, spec)
 		if err != nil {
 			logger.FromContext(ctx).
 				WithError(err).
-				WithField("path", file.Path).
-				Error("cannot create directory")
+				WithField("group", spec.Group).
+				Error("cannot change group of workspace directories")
 			return err
 		}
 	}
 
 	// the pipeline specification may define global files such
 	// as authentication credentials that should be uploaded
-	// before pipeline execution begins.
-	for _, file := range spec.Files {
-		if file.IsDir == true {
+	// before pipeline execution begins. The files are uploaded
+	// concurrently, or optionally as a compressed archive.
+	if spec.Compress {
+		err = uploadArchive(ctx, client, clientftp, spec)
+		if err != nil {
+			// if the remote host cannot extract the archive
+			// the files are uploaded individually.
+			logger.FromContext(ctx).
+				WithError(err).
+				Warn("cannot upload compressed archive")
+			err = uploadFiles(ctx, clientftp, spec, spec.Files)
+		}
+	} else {
+		err = uploadFiles(ctx, clientftp, spec, spec.Files)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the local working tree is pushed to the workspace if
+	// configured, uploading only the files that changed since
+	// the previous push.
+	if spec.Push != nil {
+		err = pushTree(ctx, client, clientftp, spec)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("source", spec.Push.Source).
+				Error("cannot push working tree")
+			return err
+		}
+	}
+
+	// the secrets file of each step is written with the
+	// initial secret values of the step if secret renewal is
+	// enabled. The files are periodically rewritten with the
+	// renewed values.
+	for _, step := range spec.Steps {
+		if spec.Renewal == nil || step.SecretsFile == "" {
 			continue
 		}
-		err = upload(clientftp, file.Path, file.Data, file.Mode)
+		err = writeRenewal(client, clientftp, spec, step, step.Secrets)
 		if err != nil {
 			logger.FromContext(ctx).
 				WithError(err).
-				Error("cannot write file")
+				WithField("path", step.SecretsFile).
+				Error("cannot write secrets file")
+			return err
+		}
+	}
+
+	// the pipeline specification may define port forwards,
+	// which are opened before the setup script executes and
+	// remain open until the pipeline environment is destroyed.
+	err = e.forwards.start(ctx, spec)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Error("cannot open port forwards")
+		return err
+	}
+
+	// the pipeline specification may define a setup script
+	// that is executed before pipeline execution begins, used
+	// to provision dependencies on the remote host.
+	if spec.Setup != nil {
+		err = runHook(ctx, client, clientftp, spec, spec.Setup)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				Error("cannot execute setup script")
 			return err
 		}
 	}
@@ -93,22 +209,72 @@ func (e *engine) Setup(ctx context.Context, spec *Spec) error {
 
 // Destroy the pipeline environment.
 func (e *engine) Destroy(ctx context.Context, spec *Spec) error {
-	client, err := dial(
-		spec.Server.Hostname,
-		spec.Server.Username,
-		spec.Server.Password,
-		spec.Server.SSHKey,
-	)
+	// close the connection shared by the pipeline steps, and
+	// the port forwards.
+	e.shared.close(spec, nil)
+	e.forwards.stop(spec)
+
+	client, err := dialServer(ctx, spec.Server)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	auditOpen(spec)
+	defer closeClient(spec, client)
 
 	ftp, err := sftp.NewClient(client)
 	if err != nil {
 		return err
 	}
 	defer ftp.Close()
+
+	// the pipeline specification may define a teardown script
+	// that is executed before the workspace is removed, or
+	// retained. Errors are logged, but do not prevent workspace
+	// removal.
+	if spec.Teardown != nil {
+		err = runHook(ctx, client, ftp, spec, spec.Teardown)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				Warn("cannot execute teardown script")
+		}
+	}
+
+	// the workspace is retained for debugging if debug mode is
+	// enabled and a pipeline step failed. The uploaded files,
+	// which include credentials, are removed regardless.
+	if spec.Debug {
+		if _, err := ftp.Stat(debugPath(spec)); err == nil {
+			removeFiles(ctx, ftp, spec)
+			spec.Retained = true
+			logger.FromContext(ctx).
+				WithField("path", spec.Root).
+				Info("workspace retained for debugging")
+			return nil
+		}
+	}
+
+	// processes started by the pipeline steps, such as test
+	// servers and daemons, may still be running. These processes
+	// are terminated before the workspace is removed.
+	if err = killProcs(client, spec); err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("path", spec.Root).
+			Trace("cannot terminate workspace processes")
+	}
+
+	// the uploaded files, which include the netrc and credential
+	// files, are removed individually to guarantee credentials
+	// are deleted even if the workspace cannot be removed.
+	removeFiles(ctx, ftp, spec)
+
+	// the artifacts directory survives workspace removal,
+	// and is only removed if no artifacts were written.
+	if spec.Artifacts != "" {
+		ftp.RemoveDirectory(spec.Artifacts)
+	}
+
 	if err = ftp.RemoveDirectory(spec.Root); err == nil {
 		return nil
 	}
@@ -128,8 +294,9 @@ func (e *engine) Destroy(ctx context.Context, spec *Spec) error {
 	}
 	defer session.Close()
 
-	err = session.Run(
-		removeCommand(spec.Platform.OS, spec.Root))
+	cmd := withShell(spec.Shell, removeCommand(spec.Platform.OS, spec.Root))
+	auditCommand(spec, cmd)
+	err = session.Run(cmd)
 	if err != nil {
 		logger.FromContext(ctx).
 			WithError(err).
@@ -141,41 +308,72 @@ func (e *engine) Destroy(ctx context.Context, spec *Spec) error {
 
 // Run runs the pipeline step.
 func (e *engine) Run(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
-	client, err := dial(
-		spec.Server.Hostname,
-		spec.Server.Username,
-		spec.Server.Password,
-		spec.Server.SSHKey,
-	)
-	if err != nil {
-		return nil, err
+	// the output of the batch step is prefixed with timestamps
+	// by the caller, after the batch markers are parsed.
+	if len(step.Batch) == 0 {
+		output = WithTimestamps(output, spec.Timestamps)
+	}
+	if step.StripANSI {
+		output = stripANSI(output)
+	}
+
+	// if session limits are configured, the steps share a
+	// single connection and session creation is queued so
+	// that the server limits are not exceeded.
+	var client *ssh.Client
+	var shared *sharedClient
+	if isShared(spec) && !isForwarded(step) {
+		var err error
+		shared, err = e.shared.get(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		if err := shared.sem.Acquire(ctx, stepSessions); err != nil {
+			return nil, err
+		}
+		defer shared.sem.Release(stepSessions)
+		client = shared.client
+	} else {
+		var err error
+		client, err = dialServer(ctx, spec.Server)
+		if err != nil {
+			return nil, err
+		}
+		auditOpen(spec)
+		defer closeClient(spec, client)
 	}
-	defer client.Close()
 
 	clientftp, err := sftp.NewClient(client)
 	if err != nil {
+		// the shared connection is closed if it is broken,
+		// so that subsequent steps dial a new connection.
+		if shared != nil {
+			e.shared.close(spec, shared)
+		}
 		return nil, err
 	}
 	defer clientftp.Close()
 
-	// unlike os/exec there is no good way to set environment
-	// the working directory or configure environment variables.
-	// we work around this by pre-pending these configurations
-	// to the pipeline execution script.
-	for _, file := range step.Files {
-		w := new(bytes.Buffer)
-		writeWorkdir(w, step.WorkingDir)
-		writeSecrets(w, spec.Platform.OS, step.Secrets)
-		writeEnviron(w, spec.Platform.OS, step.Envs)
-		w.Write(file.Data)
-		err = upload(clientftp, file.Path, w.Bytes(), file.Mode)
-		if err != nil {
-			logger.FromContext(ctx).
-				WithError(err).
-				WithField("path", file.Path).
-				Error("cannot write file")
-			return nil, err
+	// sync steps copy files between the runner host and the
+	// remote server, and do not execute a script.
+	if step.Sync != nil {
+		return runSync(ctx, clientftp, step, output)
+	}
+
+	err = uploadScripts(ctx, client, clientftp, spec, step)
+	if err != nil {
+		return nil, err
+	}
+
+	// if reconnect is enabled the step is executed detached
+	// from the ssh session so that it survives a dropped
+	// connection.
+	if spec.Server.Reconnect > 0 {
+		state, err := runResumable(ctx, client, clientftp, spec, step, output)
+		if err == nil && state.ExitCode != 0 && spec.Debug {
+			retain(ctx, clientftp, spec, step, output)
 		}
+		return state, err
 	}
 
 	session, err := client.NewSession()
@@ -186,11 +384,29 @@ func (e *engine) Run(ctx context.Context, spec *Spec, step *Step, output io.Writ
 
 	session.Stdout = output
 	session.Stderr = output
-	cmd := step.Command + " " + strings.Join(step.Args, " ")
+	cmd := execCommand(spec, step)
+
+	// the ssh agent and x11 display of the runner host may
+	// be forwarded to the step session.
+	if isForwarded(step) {
+		if err := requestForwarding(client, session, step); err != nil {
+			return nil, err
+		}
+	}
+
+	// if a terminal is configured, a pseudo-terminal is
+	// requested so that tools emit colored output.
+	if step.Term != "" {
+		modes := ssh.TerminalModes{ssh.ECHO: 0}
+		if err := session.RequestPty(step.Term, 40, 200, modes); err != nil {
+			return nil, err
+		}
+	}
 
 	log := logger.FromContext(ctx)
 	log.Debug("ssh session started")
 
+	auditCommand(spec, cmd)
 	done := make(chan error)
 	go func() {
 		done <- session.Run(cmd)
@@ -208,64 +424,392 @@ func (e *engine) Run(ctx context.Context, spec *Spec, step *Step, output io.Writ
 		}
 
 		log.Debug("ssh session killed")
+		if ctx.Err() == context.DeadlineExceeded {
+			writeReason(output, "step timed out")
+		} else {
+			writeReason(output, "step cancelled")
+		}
 		return nil, ctx.Err()
 	}
 
-	state := &State{
-		ExitCode:  0,
-		Exited:    true,
-		OOMKilled: false,
-	}
+	state, err := convertExit(err)
 	if err != nil {
-		state.ExitCode = 255
-	}
-	if exiterr, ok := err.(*ssh.ExitError); ok {
-		state.ExitCode = exiterr.ExitStatus()
+		log.WithError(err).
+			Debug("ssh session failed")
+		writeReason(output, err.Error())
+		return nil, err
 	}
 
 	log.WithField("ssh.exit", state.ExitCode).
+		WithField("ssh.signal", state.Signal).
 		Debug("ssh session finished")
-	return state, err
+
+	if reason := state.Reason(); reason != "" {
+		writeReason(output, reason)
+	}
+
+	if state.ExitCode != 0 && spec.Debug {
+		retain(ctx, clientftp, spec, step, output)
+	}
+	return state, nil
+}
+
+// helper function marks the workspace to be retained when the
+// pipeline is destroyed, and writes instructions to connect to
+// the remote server to debug the failed step.
+func retain(ctx context.Context, clientftp *sftp.Client, spec *Spec, step *Step, output io.Writer) {
+	err := upload(clientftp, debugPath(spec), []byte(step.Name), 0600)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			Warn("cannot retain workspace for debugging")
+		return
+	}
+	auditUpload(spec, debugPath(spec), []byte(step.Name))
+	writeDebug(output, spec, step)
+}
+
+// helper function removes the files uploaded to the remote
+// server before pipeline execution.
+func removeFiles(ctx context.Context, clientftp *sftp.Client, spec *Spec) {
+	for _, file := range spec.Files {
+		if file.IsDir {
+			continue
+		}
+		// files uploaded outside of the workspace, such as
+		// configuration files declared in the pipeline, are
+		// provisioned on the remote server and not removed.
+		if _, ok := relPath(spec.Root, file.Path); !ok {
+			continue
+		}
+		if err := clientftp.Remove(file.Path); err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", file.Path).
+				Trace("cannot remove file")
+		}
+	}
+}
+
+// helper function uploads and executes a pipeline hook script,
+// returning an error if the script exits with a non-zero code.
+func runHook(ctx context.Context, client *ssh.Client, clientftp *sftp.Client, spec *Spec, step *Step) error {
+	err := uploadScripts(ctx, client, clientftp, spec, step)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// the script output is written to the build logs, so that
+	// users can debug a failing setup or teardown script.
+	out := ioutil.Discard
+	if spec.HookOutput != nil {
+		out = spec.HookOutput
+	}
+	session.Stdout = out
+	session.Stderr = out
+
+	cmd := execCommand(spec, step)
+	auditCommand(spec, cmd)
+	return session.Run(cmd)
+}
+
+// helper function returns the environment variable encoding
+// supported by the remote server.
+func detectEncoding(client *ssh.Client, spec *Spec) string {
+	session, err := client.NewSession()
+	if err != nil {
+		return EncodingBase64
+	}
+	defer session.Close()
+	cmd := "command -v base64 >/dev/null 2>&1"
+	auditCommand(spec, cmd)
+	err = session.Run(cmd)
+	if _, ok := err.(*ssh.ExitError); ok {
+		return EncodingQuote
+	}
+	return EncodingBase64
+}
+
+// helper function terminates all processes on the remote server
+// that reference the pipeline workspace.
+func killProcs(client *ssh.Client, spec *Spec) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	cmd := withShell(spec.Shell, killCommand(spec.Platform.OS, spec.Root, pidsPath(spec)))
+	auditCommand(spec, cmd)
+	return session.Run(cmd)
+}
+
+// helper function writes the step scripts to the remote server.
+// unlike os/exec there is no good way to set environment
+// the working directory or configure environment variables.
+// we work around this by pre-pending these configurations
+// to the pipeline execution script.
+func uploadScripts(ctx context.Context, client *ssh.Client, clientftp *sftp.Client, spec *Spec, step *Step) error {
+	// the scripts for each step executed by a batch step are
+	// uploaded with the step environment.
+	for _, sub := range step.Batch {
+		if err := uploadScripts(ctx, client, clientftp, spec, sub); err != nil {
+			return err
+		}
+	}
+	var owned []string
+	for _, file := range step.Files {
+		// the secrets are uploaded to a separate file that is
+		// sourced and removed by the script before the step
+		// commands are executed, so that secrets are never
+		// persisted in the script on disk.
+		if len(step.Secrets) != 0 {
+			dialect := scriptOS(spec, step)
+			path := secretsPath(dialect, file.Path)
+			s := new(bytes.Buffer)
+			writeSecrets(s, dialect, spec.Encoding, step.Secrets)
+			if err := upload(clientftp, path, s.Bytes(), 0600); err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("path", path).
+					Error("cannot write secrets file")
+				return err
+			}
+			auditUpload(spec, path, s.Bytes())
+			owned = append(owned, path)
+		}
+
+		data := renderScript(spec, step, file)
+
+		// the script is optionally written to the local disk
+		// before upload, for debugging purposes.
+		if spec.DumpScripts != "" {
+			if err := dumpScript(spec.DumpScripts, spec, file, data); err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("path", file.Path).
+					Warn("cannot dump script")
+			}
+		}
+
+		_, span := tracing.Start(ctx, "sftp.upload")
+		span.SetAttribute("path", file.Path)
+		err := upload(clientftp, file.Path, data, file.Mode)
+		span.End(err)
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", file.Path).
+				Error("cannot write file")
+			return err
+		}
+		auditUpload(spec, file.Path, data)
+		// the checksum of the uploaded script is optionally
+		// verified on the remote server to detect truncated
+		// writes before the script is executed.
+		if spec.Verify {
+			err = verifyChecksum(client, spec, file.Path, data)
+			if err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("path", file.Path).
+					Error("cannot verify file")
+				return err
+			}
+		}
+		owned = append(owned, file.Path)
+	}
+	// the scripts of a step that executes as a different user
+	// are owned by the ssh user and private, and are therefore
+	// transferred to the step user.
+	if step.User != "" && len(owned) != 0 {
+		if err := chownFiles(client, spec, step.User, owned); err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("user", step.User).
+				Error("cannot change owner of step scripts")
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function changes the owner of the files on the remote
+// server. sudo is invoked in non-interactive mode, which
+// requires passwordless sudo on the remote host.
+func chownFiles(client *ssh.Client, spec *Spec, user string, paths []string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	cmd := chownCommand(user, paths)
+	auditCommand(spec, cmd)
+	return session.Run(cmd)
+}
+
+// helper function changes the group of the workspace
+// directories on the remote server. The ssh user must be a
+// member of the group.
+func chgrpDirs(client *ssh.Client, spec *Spec) error {
+	paths := []string{spec.Root}
+	for _, file := range spec.Files {
+		if file.IsDir {
+			paths = append(paths, file.Path)
+		}
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	cmd := chgrpCommand(spec.Group, paths)
+	auditCommand(spec, cmd)
+	return session.Run(cmd)
+}
+
+// helper function dials the ssh server, recording a tracing
+// span if the context provides a tracer.
+func dialServer(ctx context.Context, server Server) (*ssh.Client, error) {
+	_, span := tracing.Start(ctx, "ssh.dial")
+	span.SetAttribute("net.peer.name", server.Hostname)
+	client, err := dial(server)
+	span.End(err)
+	return client, err
 }
 
 // helper function configures and dials the ssh server.
-func dial(server, username, password, privatekey string) (*ssh.Client, error) {
+func dial(server Server) (*ssh.Client, error) {
 	config := &ssh.ClientConfig{
-		User:            username,
+		User:            server.Username,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
-	if privatekey != "" {
-		pem := []byte(privatekey)
+	// the ciphers, key exchange and mac algorithms may be
+	// restricted for hardened servers. If empty, the default
+	// algorithms are used.
+	config.Ciphers = server.Ciphers
+	config.KeyExchanges = server.KeyExchanges
+	config.MACs = server.MACs
+	if server.SSHKey != "" {
+		pem := []byte(server.SSHKey)
 		signer, err := ssh.ParsePrivateKey(pem)
 		if err != nil {
 			return nil, err
 		}
 		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
 	}
-	if password != "" {
-		config.Auth = append(config.Auth, ssh.Password(password))
+	if server.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(server.Password))
 	}
-	return ssh.Dial("tcp", server, config)
+	// the ssh agent of the runner host signs the authentication
+	// request, which is required for security keys that never
+	// expose the private key. The agent connection is only
+	// required for the duration of the handshake.
+	if server.Agent != "" {
+		conn, err := net.Dial("unix", server.Agent)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	// the hostname may define an alternate transport, such
+	// as a unix socket or websocket tunnel.
+	if IsTransport(server.Hostname) {
+		return dialTransport(server.Hostname, config)
+	}
+	// the connection may be tunneled through a cloud bastion
+	// service for servers without public ssh access.
+	if server.Tunnel != "" {
+		return dialTunnel(server.Tunnel, server.Hostname, server.Zone, config)
+	}
+	return ssh.Dial("tcp", server.Hostname, config)
 }
 
-// helper function writes the file to the remote server and then
-// configures the file permissions.
+// helper function creates the file on the remote server,
+// configures the file permissions, and then writes the file.
+// The permissions are configured before the data is written,
+// so that the contents of a private file, such as the secrets
+// file, are never readable by other users.
 func upload(client *sftp.Client, path string, data []byte, mode uint32) error {
 	f, err := client.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if _, err := f.Write(data); err != nil {
+	if err := f.Chmod(os.FileMode(mode)); err != nil {
 		return err
 	}
-	err = f.Chmod(os.FileMode(mode))
-	if err != nil {
+	if _, err := f.Write(data); err != nil {
 		return err
 	}
 	return nil
 }
 
+// helper function creates the folders on the remote server.
+// Each folder is created separately, parents before children,
+// so that every folder is created with its own permissions.
+func mkdirs(ctx context.Context, client *sftp.Client, files []*File) error {
+	var dirs []*File
+	for _, file := range files {
+		if file.IsDir {
+			dirs = append(dirs, file)
+		}
+	}
+	// the folders are created parents-first, one at a time,
+	// because the sftp client cannot create nested windows
+	// paths. A parent path is always shorter than its child.
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return len(dirs[i].Path) < len(dirs[j].Path)
+	})
+	for _, dir := range dirs {
+		if err := mkdirOne(client, dir.Path, dir.Mode); err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", dir.Path).
+				Error("cannot create directory")
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function uploads the files to the remote server
+// concurrently, using a bounded number of workers.
+func uploadFiles(ctx context.Context, client *sftp.Client, spec *Spec, files []*File) error {
+	var g errgroup.Group
+	sem := make(chan struct{}, uploadWorkers)
+	for _, f := range files {
+		file := f
+		if file.IsDir {
+			continue
+		}
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			_, span := tracing.Start(ctx, "sftp.upload")
+			span.SetAttribute("path", file.Path)
+			err := upload(client, file.Path, file.Data, file.Mode)
+			span.End(err)
+			if err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("path", file.Path).
+					Error("cannot write file")
+				return err
+			}
+			auditUpload(spec, file.Path, file.Data)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
 // helper function creates the folder on the remote server and
 // then configures the folder permissions.
 func mkdir(client *sftp.Client, path string, mode uint32) error {
@@ -275,3 +819,16 @@ func mkdir(client *sftp.Client, path string, mode uint32) error {
 	}
 	return client.Chmod(path, os.FileMode(mode))
 }
+
+// helper function creates the directory, which may already
+// exist, and sets the directory permissions. The parent
+// directory must exist.
+func mkdirOne(client *sftp.Client, path string, mode uint32) error {
+	if err := client.Mkdir(path); err != nil {
+		info, serr := client.Stat(path)
+		if serr != nil || !info.IsDir() {
+			return err
+		}
+	}
+	return client.Chmod(path, os.FileMode(mode))
+}
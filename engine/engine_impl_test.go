@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// helper function returns an sftp client connected to an
+// in-memory sftp server.
+func newMemClient(t *testing.T) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, sftp.InMemHandler())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+func Test_mkdirs(t *testing.T) {
+	client := newMemClient(t)
+	if err := client.Mkdir("/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	files := []*File{
+		{Path: "/tmp/drone-random/home/drone", Mode: 0700, IsDir: true},
+		{Path: "/tmp/drone-random/opt/config.json", Mode: 0600},
+		{Path: "/tmp/drone-random", Mode: 0700, IsDir: true},
+		{Path: "/tmp/drone-random/home", Mode: 0700, IsDir: true},
+		{Path: "/tmp", Mode: 0777, IsDir: true},
+	}
+	if err := mkdirs(context.Background(), client, files); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/tmp/drone-random", "/tmp/drone-random/home", "/tmp/drone-random/home/drone"} {
+		info, err := client.Stat(path)
+		if err != nil {
+			t.Errorf("Expect directory %s created, got %s", path, err)
+			continue
+		}
+		if !info.IsDir() {
+			t.Errorf("Expect %s to be a directory", path)
+		}
+	}
+	if _, err := client.Stat("/tmp/drone-random/opt"); err == nil {
+		t.Errorf("Expect file parent directories not created")
+	}
+}
+
+// recorder wraps the in-memory sftp handlers and records the
+// file operations in the order they are received.
+type recorder struct {
+	sftp.Handlers
+	sync.Mutex
+	ops []string
+}
+
+func (r *recorder) record(op string) {
+	r.Lock()
+	r.ops = append(r.ops, op)
+	r.Unlock()
+}
+
+func (r *recorder) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	w, err := r.FilePut.Filewrite(req)
+	if err != nil {
+		return nil, err
+	}
+	return &recordWriter{w, r}, nil
+}
+
+func (r *recorder) Filecmd(req *sftp.Request) error {
+	r.record(req.Method)
+	return r.FileCmd.Filecmd(req)
+}
+
+type recordWriter struct {
+	io.WriterAt
+	r *recorder
+}
+
+func (w *recordWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.r.record("Write")
+	return w.WriterAt.WriteAt(p, off)
+}
+
+func Test_upload_ChmodBeforeWrite(t *testing.T) {
+	rec := &recorder{Handlers: sftp.InMemHandler()}
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw}, sftp.Handlers{
+		FileGet:  rec.FileGet,
+		FilePut:  rec,
+		FileCmd:  rec,
+		FileList: rec.FileList,
+	})
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		server.Close()
+		client.Close()
+	}()
+
+	if err := upload(client, "/drone.secrets", []byte("export PASSWORD=correct-horse-battery-staple"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+	if len(rec.ops) == 0 || rec.ops[0] != "Setstat" {
+		t.Errorf("Expect file permissions configured before data is written, got operations %v", rec.ops)
+	}
+	var written bool
+	for _, op := range rec.ops {
+		if op == "Write" {
+			written = true
+		}
+	}
+	if !written {
+		t.Errorf("Expect file data written")
+	}
+}
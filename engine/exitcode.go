@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+// ntstatus provides explanations for the windows NTSTATUS
+// codes commonly returned when a process crashes.
+var ntstatus = map[uint32]string{
+	0xC0000005: "access violation",
+	0xC000001D: "illegal instruction",
+	0xC0000017: "out of memory",
+	0xC0000094: "integer divide by zero",
+	0xC00000FD: "stack overflow",
+	0xC0000135: "dll not found",
+	0xC0000139: "entry point not found",
+	0xC0000142: "dll initialization failed",
+	0xC000013A: "terminated by ctrl+c",
+	0xC0000374: "heap corruption",
+	0xC0000409: "stack buffer overrun",
+}
+
+// helper function returns the exit code as the unsigned 32-bit
+// exit status of the remote process. Windows exit codes, such
+// as NTSTATUS codes, may be reported as negative integers.
+func exitCode(code int) int {
+	return int(uint32(code))
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/drone/runner-go/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forwarder holds the ssh connection and listeners of the
+// port forwards of a pipeline.
+type forwarder struct {
+	client    *ssh.Client
+	listeners []net.Listener
+}
+
+// forwarders tracks the open port forwards, keyed by the
+// pipeline specification.
+type forwarders struct {
+	sync.Mutex
+	open map[*Spec]*forwarder
+}
+
+// helper function opens the port forwards of the pipeline. The
+// forwards share a dedicated ssh connection that remains open
+// until the forwards are closed.
+func (f *forwarders) start(ctx context.Context, spec *Spec) error {
+	if len(spec.Forwards) == 0 {
+		return nil
+	}
+	client, err := dialServer(ctx, spec.Server)
+	if err != nil {
+		return err
+	}
+	auditOpen(spec)
+	fw := &forwarder{client: client}
+	for _, forward := range spec.Forwards {
+		l, err := listen(client, forward)
+		if err != nil {
+			fw.close(spec)
+			return err
+		}
+		fw.listeners = append(fw.listeners, l)
+		go serveForward(ctx, client, forward, l)
+	}
+	f.Lock()
+	if f.open == nil {
+		f.open = map[*Spec]*forwarder{}
+	}
+	f.open[spec] = fw
+	f.Unlock()
+	return nil
+}
+
+// helper function closes the port forwards of the pipeline,
+// if open.
+func (f *forwarders) stop(spec *Spec) {
+	f.Lock()
+	fw, ok := f.open[spec]
+	delete(f.open, spec)
+	f.Unlock()
+	if ok {
+		fw.close(spec)
+	}
+}
+
+func (fw *forwarder) close(spec *Spec) {
+	for _, l := range fw.listeners {
+		l.Close()
+	}
+	closeClient(spec, fw.client)
+}
+
+// helper function opens the listener of the port forward,
+// which is opened on the runner host for local forwards and
+// on the remote server for remote forwards.
+func listen(client *ssh.Client, forward *Forward) (net.Listener, error) {
+	if forward.Direction == ForwardRemote {
+		return client.Listen("tcp", forward.Remote)
+	}
+	return net.Listen("tcp", forward.Local)
+}
+
+// helper function accepts connections until the listener is
+// closed, and forwards each connection to the target address.
+func serveForward(ctx context.Context, client *ssh.Client, forward *Forward, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			var target net.Conn
+			var err error
+			if forward.Direction == ForwardRemote {
+				target, err = net.Dial("tcp", forward.Local)
+			} else {
+				target, err = client.Dial("tcp", forward.Remote)
+			}
+			if err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					WithField("local", forward.Local).
+					WithField("remote", forward.Remote).
+					Warn("cannot forward connection")
+				conn.Close()
+				return
+			}
+			pipe(conn, target)
+		}()
+	}
+}
+
+// helper function copies data between the connections in both
+// directions, and closes both connections when either
+// direction completes.
+func pipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	cp := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	client, a := net.Pipe()
+	b, server := net.Pipe()
+	go pipe(a, b)
+
+	go client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(buf), "hello"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+
+	go server.Write([]byte("world"))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(buf), "world"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+
+	// closing one side closes the other connection.
+	client.Close()
+	if _, err := server.Read(buf); err == nil {
+		t.Errorf("Want connection closed")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Ping verifies the remote server is reachable by opening an
+// ssh connection and executing a trivial command. An error is
+// returned if the server does not respond before the context
+// deadline is exceeded.
+func Ping(ctx context.Context, server Server) error {
+	type result struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		client, err := dial(server)
+		done <- result{client, err}
+	}()
+
+	var client *ssh.Client
+	select {
+	case <-ctx.Done():
+		// close the connection if the dial completes after
+		// the context is cancelled.
+		go func() {
+			if res := <-done; res.client != nil {
+				res.client.Close()
+			}
+		}()
+		return fmt.Errorf("host unreachable: %s", ctx.Err())
+	case res := <-done:
+		if res.err != nil {
+			return fmt.Errorf("host unreachable: %s", res.err)
+		}
+		client = res.client
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("host unreachable: %s", err)
+	}
+	defer session.Close()
+
+	out, err := session.Output("echo ok")
+	if err != nil {
+		return fmt.Errorf("host unreachable: %s", err)
+	}
+	if strings.TrimSpace(string(out)) != "ok" {
+		return fmt.Errorf("host unreachable: unexpected output %q", out)
+	}
+	return nil
+}
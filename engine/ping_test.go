@@ -0,0 +1,54 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPing_Unreachable(t *testing.T) {
+	// start and immediately stop a listener to obtain an
+	// address that refuses connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = Ping(ctx, Server{Hostname: addr, Username: "root", Password: "root"})
+	if err == nil {
+		t.Errorf("Expect error when host is unreachable")
+		return
+	}
+	if !strings.HasPrefix(err.Error(), "host unreachable") {
+		t.Errorf("Want host unreachable error, got %s", err)
+	}
+}
+
+func TestPing_Timeout(t *testing.T) {
+	// the listener accepts connections but never completes
+	// the ssh handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = Ping(ctx, Server{Hostname: l.Addr().String(), Username: "root", Password: "root"})
+	if err == nil {
+		t.Errorf("Expect error when host does not respond")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// WaitReady waits until the readiness probe of the detached step
+// succeeds. An error is returned if the probe does not succeed
+// before the probe timeout elapses.
+func WaitReady(ctx context.Context, spec *Spec, step *Step) error {
+	probe := step.Ready
+	if probe == nil {
+		return nil
+	}
+	client, err := dialServer(ctx, spec.Server)
+	if err != nil {
+		return fmt.Errorf("readiness probe failed: %s", err)
+	}
+	auditOpen(spec)
+	defer closeClient(spec, client)
+
+	ctx, cancel := context.WithTimeout(ctx, probe.Timeout)
+	defer cancel()
+	for {
+		// each attempt is bounded by the probe interval, so
+		// that an attempt that hangs does not delay the next
+		// attempt until the probe timeout elapses.
+		err := probeAttempt(ctx, client, spec, step)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("readiness probe failed: %s", err)
+		case <-time.After(probe.Interval):
+		}
+	}
+}
+
+// helper function attempts the readiness probe once, and
+// returns an error if the attempt does not complete before
+// the probe interval elapses.
+func probeAttempt(ctx context.Context, client *ssh.Client, spec *Spec, step *Step) error {
+	if step.Ready.Interval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Ready.Interval)
+		defer cancel()
+	}
+	if port := step.Ready.Port; port != 0 {
+		return readyPort(ctx, client, port)
+	}
+	return readyRun(ctx, client, spec, step)
+}
+
+// helper function dials the port from the remote server using
+// the ssh connection.
+func readyPort(ctx context.Context, client *ssh.Client, port int) error {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		done <- result{conn, err}
+	}()
+	select {
+	case <-ctx.Done():
+		// close the connection if the dial completes after
+		// the attempt times out.
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return res.err
+		}
+		return res.conn.Close()
+	}
+}
+
+// helper function executes the probe command on the remote
+// server. The session is closed if the command does not
+// complete before the context deadline is exceeded.
+func readyRun(ctx context.Context, client *ssh.Client, spec *Spec, step *Step) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	cmd := withShell(spec.Shell, readyCommand(spec.Platform.OS, step.WorkingDir, step.Ready.Command))
+	auditCommand(spec, cmd)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// helper function returns a shell command that executes the
+// readiness probe command in the working directory, that is
+// compatible with the operating system.
+func readyCommand(os, dir, cmd string) string {
+	switch os {
+	case "windows":
+		dir = strings.Replace(dir, "'", "''", -1)
+		cmd = strings.Replace(cmd, `"`, `\"`, -1)
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"Set-Location '%s'; %s\"", dir, cmd)
+	default:
+		return fmt.Sprintf("cd %s && %s", shellQuote(dir), cmd)
+	}
+}
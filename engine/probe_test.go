@@ -0,0 +1,42 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWaitReady_Disabled(t *testing.T) {
+	if err := WaitReady(context.Background(), &Spec{}, &Step{}); err != nil {
+		t.Errorf("Expect nil error when readiness probe disabled, got %s", err)
+	}
+}
+
+func TestReadyCommand(t *testing.T) {
+	got := readyCommand("linux", "/tmp/drone-random/drone/src", "pg_isready -h localhost")
+	want := "cd '/tmp/drone-random/drone/src' && pg_isready -h localhost"
+	if got != want {
+		t.Errorf("Want probe command %q, got %q", want, got)
+	}
+	got = readyCommand("windows", `C:\Windows\Temp\drone-random\drone\src`, `Get-Process "redis-server"`)
+	want = `powershell -noprofile -noninteractive -command "Set-Location 'C:\Windows\Temp\drone-random\drone\src'; Get-Process \"redis-server\""`
+	if got != want {
+		t.Errorf("Want probe command %q, got %q", want, got)
+	}
+}
+
+func TestReadyCommand_Quote(t *testing.T) {
+	got := readyCommand("linux", "/tmp/it's/drone/src", "pg_isready")
+	want := `cd '/tmp/it'\''s/drone/src' && pg_isready`
+	if got != want {
+		t.Errorf("Want probe command %q, got %q", want, got)
+	}
+	got = readyCommand("windows", `C:\Temp\it's\src`, "Get-Process redis-server")
+	want = `powershell -noprofile -noninteractive -command "Set-Location 'C:\Temp\it''s\src'; Get-Process redis-server"`
+	if got != want {
+		t.Errorf("Want probe command %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+)
+
+// Prune removes pipeline workspaces in the base directory of
+// the remote server that are older than the maximum age, and
+// returns the paths of the removed workspaces. Workspaces are
+// orphaned when the runner is terminated before the pipeline
+// environment is destroyed. The specification provides the
+// remote server, the platform and the optional auditor.
+func Prune(ctx context.Context, spec *Spec, base string, age time.Duration) ([]string, error) {
+	client, err := dialServer(ctx, spec.Server)
+	if err != nil {
+		return nil, err
+	}
+	auditOpen(spec)
+	defer closeClient(spec, client)
+
+	clientftp, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer clientftp.Close()
+
+	files, err := clientftp.ReadDir(base)
+	if err != nil {
+		return nil, err
+	}
+
+	// windows servers may not provide windows powershell,
+	// in which case the workspaces are removed with pwsh.
+	if spec.Shell == "" && spec.Platform.OS == "windows" {
+		spec.Shell = detectShell(client, spec)
+	}
+
+	var removed []string
+	for _, file := range files {
+		if !file.IsDir() || !IsWorkspace(file.Name()) {
+			continue
+		}
+		if time.Since(file.ModTime()) < age {
+			continue
+		}
+
+		path := joinPath(spec.Platform.OS, base, file.Name())
+		session, err := client.NewSession()
+		if err != nil {
+			return removed, err
+		}
+		cmd := withShell(spec.Shell, removeCommand(spec.Platform.OS, path))
+		auditCommand(spec, cmd)
+		err = session.Run(cmd)
+		session.Close()
+		if err != nil {
+			logger.FromContext(ctx).
+				WithError(err).
+				WithField("path", path).
+				Warn("cannot remove orphaned workspace")
+			continue
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// pushEntry describes a file in the pushed working tree.
+type pushEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// pushTree pushes the local working tree to the workspace. The
+// tree is mirrored to a cache directory on the remote server,
+// uploading only files that changed since the previous push,
+// and is then copied to the workspace.
+func pushTree(ctx context.Context, client *ssh.Client, clientftp *sftp.Client, spec *Spec) error {
+	push := spec.Push
+	local, err := indexTree(push.Source, push.Checksum)
+	if err != nil {
+		return err
+	}
+	remote := readPushIndex(clientftp, pushIndexPath(push))
+	uploads, removes := diffTree(local, remote, push.Checksum)
+
+	logger.FromContext(ctx).
+		WithField("source", push.Source).
+		WithField("cache", push.Cache).
+		WithField("upload", len(uploads)).
+		WithField("remove", len(removes)).
+		Debug("push working tree")
+
+	if err := clientftp.MkdirAll(push.Cache); err != nil {
+		return err
+	}
+	for _, name := range removes {
+		path := joinPath(spec.Platform.OS, push.Cache, fromSlash(spec.Platform.OS, name))
+		if err := clientftp.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, name := range uploads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		src := filepath.Join(push.Source, filepath.FromSlash(name))
+		dst := joinPath(spec.Platform.OS, push.Cache, fromSlash(spec.Platform.OS, name))
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if err := clientftp.MkdirAll(parentPath(spec.Platform.OS, dst)); err != nil {
+			return err
+		}
+		if err := syncFileUp(clientftp, src, dst, info.Mode()); err != nil {
+			return err
+		}
+		mtime := info.ModTime()
+		if err := clientftp.Chtimes(dst, mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	// the index is written after the files are uploaded, so
+	// that an interrupted push is resumed by the next push.
+	data, _ := json.Marshal(local)
+	if err := upload(clientftp, pushIndexPath(push), data, 0600); err != nil {
+		return err
+	}
+	auditUpload(spec, pushIndexPath(push), data)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	cmd := withShell(spec.Shell, copyCommand(spec.Platform.OS, push.Cache, push.Target))
+	auditCommand(spec, cmd)
+	out, err := session.CombinedOutput(cmd)
+	if err != nil {
+		logger.FromContext(ctx).
+			WithError(err).
+			WithField("output", string(out)).
+			Debug("cannot copy working tree")
+		return fmt.Errorf("cannot copy working tree to workspace: %s", err)
+	}
+	return nil
+}
+
+// helper function returns the index of the regular files in
+// the local directory, keyed by the slash separated path
+// relative to the directory.
+func indexTree(dir string, checksum bool) (map[string]*pushEntry, error) {
+	index := map[string]*pushEntry{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entry := &pushEntry{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+		}
+		if checksum {
+			entry.Checksum, err = fileChecksum(path)
+			if err != nil {
+				return err
+			}
+		}
+		index[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	return index, err
+}
+
+// helper function returns the files that must be uploaded and
+// the files that must be removed so that the remote index
+// matches the local index. Files are compared by checksum if
+// enabled, otherwise by size and modification time.
+func diffTree(local, remote map[string]*pushEntry, checksum bool) (uploads, removes []string) {
+	for name, entry := range local {
+		prev, ok := remote[name]
+		switch {
+		case !ok:
+			uploads = append(uploads, name)
+		case checksum && prev.Checksum != entry.Checksum:
+			uploads = append(uploads, name)
+		case !checksum && (prev.Size != entry.Size || prev.ModTime != entry.ModTime):
+			uploads = append(uploads, name)
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			removes = append(removes, name)
+		}
+	}
+	sort.Strings(uploads)
+	sort.Strings(removes)
+	return
+}
+
+// helper function reads the index of the previously pushed
+// working tree. An empty index is returned if the index does
+// not exist or cannot be parsed.
+func readPushIndex(clientftp *sftp.Client, path string) map[string]*pushEntry {
+	index := map[string]*pushEntry{}
+	f, err := clientftp.Open(path)
+	if err != nil {
+		return index
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return map[string]*pushEntry{}
+	}
+	return index
+}
+
+// helper function returns the path of the index file that
+// describes the files in the cache directory.
+func pushIndexPath(push *Push) string {
+	return strings.TrimRight(push.Cache, "/\\") + ".json"
+}
+
+// helper function returns the SHA-256 checksum of the file.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// helper function converts the slash separated path to use
+// the path separator of the operating system.
+func fromSlash(os, path string) string {
+	if os == "windows" {
+		return strings.Replace(path, "/", "\\", -1)
+	}
+	return path
+}
+
+// helper function returns the parent directory of the path.
+func parentPath(os, path string) string {
+	sep := "/"
+	if os == "windows" {
+		sep = "\\"
+	}
+	if i := strings.LastIndex(path, sep); i > 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// helper function returns a shell command for copying the
+// contents of the cache directory to the workspace, that is
+// compatible with the operating system.
+func copyCommand(os, src, dst string) string {
+	switch os {
+	case "windows":
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"New-Item -ItemType Directory -Force -Path %s | Out-Null; Copy-Item -Path %s\\* -Destination %s -Recurse -Force\"", dst, src, dst)
+	default:
+		return fmt.Sprintf("mkdir -p %s && cp -R %s/. %s", dst, src, dst)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "cmd", "app"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module app"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cmd", "app", "main.go"), []byte("package main"), 0644)
+
+	index, err := indexTree(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 {
+		t.Errorf("Want 2 files indexed, got %d", len(index))
+	}
+	entry := index["cmd/app/main.go"]
+	if entry == nil {
+		t.Fatalf("Want file indexed by slash separated relative path")
+	}
+	if entry.Size != 12 {
+		t.Errorf("Want file size 12, got %d", entry.Size)
+	}
+	if got, want := entry.Checksum, "512843855fcc92a51c810b1b58e0731c01eac9a6a23c157bfa02aad71edffbe7"; got != want {
+		t.Errorf("Want checksum %s, got %s", want, got)
+	}
+
+	index, err = indexTree(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index["go.mod"].Checksum != "" {
+		t.Errorf("Want checksum skipped when disabled")
+	}
+}
+
+func TestDiffTree(t *testing.T) {
+	local := map[string]*pushEntry{
+		"go.mod":    {Size: 10, ModTime: 1, Checksum: "a"},
+		"main.go":   {Size: 12, ModTime: 2, Checksum: "b"},
+		"README.md": {Size: 5, ModTime: 3, Checksum: "c"},
+	}
+	remote := map[string]*pushEntry{
+		"go.mod":    {Size: 10, ModTime: 1, Checksum: "a"},
+		"main.go":   {Size: 12, ModTime: 1, Checksum: "b"},
+		"old.go":    {Size: 8, ModTime: 1, Checksum: "d"},
+		"README.md": {Size: 5, ModTime: 3, Checksum: "e"},
+	}
+
+	uploads, removes := diffTree(local, remote, false)
+	if want := []string{"main.go"}; !reflect.DeepEqual(uploads, want) {
+		t.Errorf("Want uploads %v compared by size and mtime, got %v", want, uploads)
+	}
+	if want := []string{"old.go"}; !reflect.DeepEqual(removes, want) {
+		t.Errorf("Want removes %v, got %v", want, removes)
+	}
+
+	uploads, _ = diffTree(local, remote, true)
+	if want := []string{"README.md"}; !reflect.DeepEqual(uploads, want) {
+		t.Errorf("Want uploads %v compared by checksum, got %v", want, uploads)
+	}
+
+	uploads, removes = diffTree(local, map[string]*pushEntry{}, false)
+	if len(uploads) != 3 || len(removes) != 0 {
+		t.Errorf("Want all files uploaded without a previous push")
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	got := copyCommand("linux", "/tmp/drone-push/default", "/tmp/drone-random/drone/src")
+	want := "mkdir -p /tmp/drone-random/drone/src && cp -R /tmp/drone-push/default/. /tmp/drone-random/drone/src"
+	if got != want {
+		t.Errorf("Want copy command %q, got %q", want, got)
+	}
+}
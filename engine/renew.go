@@ -0,0 +1,74 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Renew writes the renewed secrets of each step, keyed by step
+// name, to the secrets file of the step on the remote server,
+// which the step may source to load the current secret values.
+func Renew(ctx context.Context, spec *Spec, renewed map[string][]*Secret) error {
+	if spec.Renewal == nil {
+		return nil
+	}
+	client, err := dialServer(ctx, spec.Server)
+	if err != nil {
+		return err
+	}
+	auditOpen(spec)
+	defer closeClient(spec, client)
+
+	clientftp, err := sftp.NewClient(client)
+	if err != nil {
+		return err
+	}
+	defer clientftp.Close()
+
+	for _, step := range spec.Steps {
+		secrets, ok := renewed[step.Name]
+		if !ok || step.SecretsFile == "" {
+			continue
+		}
+		if err := writeRenewal(client, clientftp, spec, step, secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function writes the secrets file of the step. The file
+// is written to a temporary path and renamed, so that a step
+// never sources a partially written file. The file is owned by
+// the step user, if set, so that the step can read the file.
+func writeRenewal(client *ssh.Client, clientftp *sftp.Client, spec *Spec, step *Step, secrets []*Secret) error {
+	w := new(bytes.Buffer)
+	writeSecrets(w, spec.Platform.OS, spec.Encoding, secrets)
+
+	path := step.SecretsFile
+	temp := path + ".tmp"
+	if err := upload(clientftp, temp, w.Bytes(), 0600); err != nil {
+		return err
+	}
+	auditUpload(spec, temp, w.Bytes())
+	if step.User != "" {
+		if err := chownFiles(client, spec, step.User, []string{temp}); err != nil {
+			clientftp.Remove(temp)
+			return err
+		}
+	}
+	// the posix rename extension, which replaces an existing
+	// file, may not be supported by the remote server.
+	if err := clientftp.PosixRename(temp, path); err != nil {
+		clientftp.Remove(path)
+		return clientftp.Rename(temp, path)
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 )
@@ -24,6 +25,54 @@ type Replacer struct {
 
 // New returns a replacer that wraps writer w.
 func New(w io.WriteCloser, secrets []*engine.Secret) io.WriteCloser {
+	oldnew := pairs(secrets)
+	if len(oldnew) == 0 {
+		return w
+	}
+	return &Replacer{
+		w: w,
+		r: strings.NewReplacer(oldnew...),
+	}
+}
+
+// Renewed returns a replacer that wraps writer w and masks the
+// secret values renewed during pipeline execution. The values
+// are masked as soon as they are renewed.
+func Renewed(w io.WriteCloser, renewal *engine.Renewal) io.WriteCloser {
+	return &renewed{w: w, renewal: renewal}
+}
+
+type renewed struct {
+	sync.Mutex
+	w       io.WriteCloser
+	renewal *engine.Renewal
+	count   int
+	r       *strings.Replacer
+}
+
+func (r *renewed) Write(p []byte) (n int, err error) {
+	r.Lock()
+	defer r.Unlock()
+	// the renewed secrets are only appended, and the replacer
+	// is rebuilt when secrets are added.
+	if secrets := r.renewal.Renewed(); len(secrets) != r.count {
+		r.count = len(secrets)
+		r.r = strings.NewReplacer(pairs(secrets)...)
+	}
+	if r.r == nil {
+		return r.w.Write(p)
+	}
+	_, err = r.w.Write([]byte(r.r.Replace(string(p))))
+	return len(p), err
+}
+
+func (r *renewed) Close() error {
+	return r.w.Close()
+}
+
+// helper function returns the secret values and masked
+// values, used to create a strings.Replacer.
+func pairs(secrets []*engine.Secret) []string {
 	var oldnew []string
 	for _, secret := range secrets {
 		if len(secret.Data) == 0 || secret.Mask == false {
@@ -34,13 +83,7 @@ func New(w io.WriteCloser, secrets []*engine.Secret) io.WriteCloser {
 		oldnew = append(oldnew, string(secret.Data))
 		oldnew = append(oldnew, masked)
 	}
-	if len(oldnew) == 0 {
-		return w
-	}
-	return &Replacer{
-		w: w,
-		r: strings.NewReplacer(oldnew...),
-	}
+	return oldnew
 }
 
 // Write writes p to the base writer. The method scans for any
@@ -54,3 +54,33 @@ type nopCloser struct {
 func (*nopCloser) Close() error {
 	return nil
 }
+
+// this test verifies that secret values renewed during pipeline
+// execution are masked once renewed, including in a stream that
+// was opened before the secrets were renewed.
+func TestReplaceRenewed(t *testing.T) {
+	renewal := new(engine.Renewal)
+
+	buf := new(bytes.Buffer)
+	w := Renewed(&nopCloser{buf}, renewal)
+	w.Write([]byte("token 8d6e2ce8\n"))
+
+	renewal.Add([]*engine.Secret{
+		{Name: "VAULT_TOKEN", Data: []byte("8d6e2ce8"), Mask: true},
+		{Name: "VAULT_ADDR", Data: []byte("https://vault"), Mask: false},
+	})
+	w.Write([]byte("token 8d6e2ce8\n"))
+
+	renewal.Add([]*engine.Secret{
+		{Name: "VAULT_TOKEN", Data: []byte("f3a1b9c0"), Mask: true},
+	})
+	w.Write([]byte("token 8d6e2ce8 f3a1b9c0 https://vault\n"))
+	w.Close()
+
+	want := "token 8d6e2ce8\n" +
+		"token [secret:vault_token]\n" +
+		"token [secret:vault_token] [secret:vault_token] https://vault\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want masked string %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// requirement matches a tool name with an optional
+	// version constraint, for example go>=1.21.
+	requirement = regexp.MustCompile(`^([A-Za-z0-9_.+-]+?)\s*(?:(>=|<=|==|=|>|<)\s*([0-9]+(?:\.[0-9]+)*))?$`)
+
+	// version matches the first version number in the output
+	// of the tool version command.
+	version = regexp.MustCompile(`[0-9]+(?:\.[0-9]+)+`)
+)
+
+// ParseRequirement parses the tool requirement, for example
+// git or go>=1.21.
+func ParseRequirement(s string) (*Requirement, error) {
+	match := requirement.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return nil, fmt.Errorf("invalid requirement: %s", s)
+	}
+	return &Requirement{
+		Name:    match[1],
+		Op:      match[2],
+		Version: match[3],
+	}, nil
+}
+
+// String returns the requirement string.
+func (r *Requirement) String() string {
+	return r.Name + r.Op + r.Version
+}
+
+// checkRequirements probes the remote server for the required
+// tools and returns an error describing the tools that are
+// missing or do not satisfy the version constraint.
+func checkRequirements(client *ssh.Client, spec *Spec) error {
+	var failed []string
+	for _, req := range spec.Requires {
+		cmd := withShell(spec.Shell, probeCommand(spec.Platform.OS, req))
+		auditCommand(spec, cmd)
+		out, err := probe(client, cmd)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s not found", req.Name))
+			continue
+		}
+		if req.Op == "" {
+			continue
+		}
+		found := version.FindString(out)
+		if found == "" {
+			failed = append(failed, fmt.Sprintf("%s version unknown, want %s", req.Name, req))
+			continue
+		}
+		if !satisfies(found, req.Op, req.Version) {
+			failed = append(failed, fmt.Sprintf("%s version %s found, want %s", req.Name, found, req))
+		}
+	}
+	if len(failed) != 0 {
+		return errors.New("remote host requirements not met: " + strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// helper function runs the command and returns the combined
+// output.
+func probe(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(cmd)
+	return string(out), err
+}
+
+// helper function returns a shell command that verifies the
+// tool exists and, if the requirement defines a version
+// constraint, prints the tool version.
+func probeCommand(os string, req *Requirement) string {
+	switch os {
+	case "windows":
+		if req.Op == "" {
+			return fmt.Sprintf("powershell -noprofile -noninteractive -command \"Get-Command %s -ErrorAction Stop | Out-Null\"", req.Name)
+		}
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"Get-Command %s -ErrorAction Stop | Out-Null; & %s --version 2>&1; if ($LastExitCode -ne 0) { & %s version 2>&1 }; exit 0\"", req.Name, req.Name, req.Name)
+	default:
+		if req.Op == "" {
+			return fmt.Sprintf("command -v %s", req.Name)
+		}
+		return fmt.Sprintf("command -v %s >/dev/null && { %s --version 2>&1 || %s version 2>&1 || true; }", req.Name, req.Name, req.Name)
+	}
+}
+
+// helper function returns true if the version satisfies the
+// version constraint.
+func satisfies(found, op, want string) bool {
+	c := compareVersions(found, want)
+	switch op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	default:
+		return c == 0
+	}
+}
+
+// helper function compares the dot-separated version numbers,
+// returning -1, 0 or 1. Missing components are treated as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
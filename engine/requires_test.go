@@ -0,0 +1,64 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Requirement
+	}{
+		{"git", Requirement{Name: "git"}},
+		{"go>=1.21", Requirement{Name: "go", Op: ">=", Version: "1.21"}},
+		{"docker >= 20.10", Requirement{Name: "docker", Op: ">=", Version: "20.10"}},
+		{"node=18", Requirement{Name: "node", Op: "=", Version: "18"}},
+	}
+	for _, test := range tests {
+		got, err := ParseRequirement(test.s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("Want requirement %v, got %v", test.want, *got)
+		}
+	}
+	if _, err := ParseRequirement("go>=latest"); err == nil {
+		t.Errorf("Expect error for invalid version")
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		found, op, want string
+		ok              bool
+	}{
+		{"1.21.0", ">=", "1.21", true},
+		{"1.19.3", ">=", "1.21", false},
+		{"1.21", "==", "1.21.0", true},
+		{"2.39.2", ">", "2.39", true},
+		{"20.10.7", "<", "20.10", false},
+		{"20.9", "<=", "20.10", true},
+	}
+	for _, test := range tests {
+		if got := satisfies(test.found, test.op, test.want); got != test.ok {
+			t.Errorf("Want %s %s %s to be %v", test.found, test.op, test.want, test.ok)
+		}
+	}
+}
+
+func TestVersionOutput(t *testing.T) {
+	tests := map[string]string{
+		"go version go1.21.3 linux/amd64":      "1.21.3",
+		"git version 2.39.2":                   "2.39.2",
+		"Docker version 24.0.5, build ced0996": "24.0.5",
+	}
+	for out, want := range tests {
+		if got := version.FindString(out); got != want {
+			t.Errorf("Want version %s, got %s", want, got)
+		}
+	}
+}
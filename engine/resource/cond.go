@@ -0,0 +1,115 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+// Conditions defines a group of step conditions. In addition
+// to the conditions supported by the manifest package, a step
+// may be conditionally executed based on the files changed by
+// the build, and based on the matrix axis values of a step
+// expanded from a matrix.
+type Conditions struct {
+	Action   manifest.Condition            `json:"action,omitempty"`
+	Branch   manifest.Condition            `json:"branch,omitempty"`
+	Cron     manifest.Condition            `json:"cron,omitempty"`
+	Event    manifest.Condition            `json:"event,omitempty"`
+	Instance manifest.Condition            `json:"instance,omitempty"`
+	Matrix   map[string]manifest.Condition `json:"matrix,omitempty"`
+	Paths    manifest.Condition            `json:"paths,omitempty"`
+	Ref      manifest.Condition            `json:"ref,omitempty"`
+	Repo     manifest.Condition            `json:"repo,omitempty"`
+	Status   manifest.Condition            `json:"status,omitempty"`
+	Target   manifest.Condition            `json:"target,omitempty"`
+}
+
+// Match returns true if the build matches the conditions.
+func (c *Conditions) Match(m manifest.Match) bool {
+	return c.Action.Match(m.Action) &&
+		c.Branch.Match(m.Branch) &&
+		c.Cron.Match(m.Cron) &&
+		c.Event.Match(m.Event) &&
+		c.Instance.Match(m.Instance) &&
+		c.Ref.Match(m.Ref) &&
+		c.Repo.Match(m.Repo) &&
+		c.Target.Match(m.Target)
+}
+
+// MatchPaths returns true if any of the changed files match
+// the path conditions. If the list of changed files is nil the
+// changes are unknown, and the path conditions are considered
+// a match.
+func (c *Conditions) MatchPaths(files []string) bool {
+	if files == nil {
+		return true
+	}
+	if len(c.Paths.Include) == 0 && len(c.Paths.Exclude) == 0 {
+		return true
+	}
+	for _, file := range files {
+		if matchAny(c.Paths.Exclude, file) {
+			continue
+		}
+		if len(c.Paths.Include) == 0 || matchAny(c.Paths.Include, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the file matches any of the
+// glob patterns.
+func matchAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if glob(pattern).MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function converts the glob pattern to a regular
+// expression. The double star matches any number of folders,
+// including none, so that **/*.go matches main.go.
+func glob(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// MatchMatrix returns true if the matrix axis values match the
+// matrix conditions. A step that is not expanded from a matrix
+// has no axis values, and only matches if it has no matrix
+// conditions.
+func (c *Conditions) MatchMatrix(axis map[string]string) bool {
+	for name, cond := range c.Matrix {
+		if !cond.Match(axis[name]) {
+			return false
+		}
+	}
+	return true
+}
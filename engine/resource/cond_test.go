@@ -0,0 +1,97 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func TestConditions_Match(t *testing.T) {
+	c := Conditions{
+		Branch: manifest.Condition{Include: []string{"master"}},
+		Event:  manifest.Condition{Exclude: []string{"pull_request"}},
+	}
+	if !c.Match(manifest.Match{Branch: "master", Event: "push"}) {
+		t.Errorf("Expect match branch and event")
+	}
+	if c.Match(manifest.Match{Branch: "develop", Event: "push"}) {
+		t.Errorf("Expect branch mismatch")
+	}
+	if c.Match(manifest.Match{Branch: "master", Event: "pull_request"}) {
+		t.Errorf("Expect event excluded")
+	}
+}
+
+func TestConditions_MatchPaths(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		files   []string
+		match   bool
+	}{
+		// no path conditions
+		{files: []string{"README.md"}, match: true},
+		// changes unknown
+		{include: []string{"docs/**"}, files: nil, match: true},
+		// no files changed
+		{include: []string{"docs/**"}, files: []string{}, match: false},
+		// include matching
+		{include: []string{"docs/**"}, files: []string{"docs/guide/index.md"}, match: true},
+		{include: []string{"docs/**"}, files: []string{"main.go"}, match: false},
+		{include: []string{"*.go"}, files: []string{"main.go"}, match: true},
+		{include: []string{"*.go"}, files: []string{"engine/engine.go"}, match: false},
+		{include: []string{"**/*.go"}, files: []string{"engine/engine.go"}, match: true},
+		{include: []string{"**/*.go"}, files: []string{"main.go"}, match: true},
+		{include: []string{"src/**/*.go"}, files: []string{"src/main.go"}, match: true},
+		{include: []string{"src/**/*.go"}, files: []string{"main.go"}, match: false},
+		{include: []string{"src/**/*.go"}, files: []string{"src/engine/engine.go"}, match: true},
+		{include: []string{"docs/**"}, files: []string{"docs/README.md"}, match: true},
+		{include: []string{"**"}, files: []string{"main.go"}, match: true},
+		{include: []string{"docs/*.md"}, files: []string{"docs/guide/index.md"}, match: false},
+		{include: []string{"main.go?"}, files: []string{"main.go"}, match: false},
+		// exclude matching
+		{exclude: []string{"*.md"}, files: []string{"README.md"}, match: false},
+		{exclude: []string{"*.md"}, files: []string{"README.md", "main.go"}, match: true},
+		{include: []string{"docs/**"}, exclude: []string{"docs/*.txt"}, files: []string{"docs/notes.txt"}, match: false},
+		{exclude: []string{"**/*.md"}, files: []string{"docs/guide/index.md"}, match: false},
+	}
+	for i, test := range tests {
+		c := Conditions{
+			Paths: manifest.Condition{
+				Include: test.include,
+				Exclude: test.exclude,
+			},
+		}
+		if got := c.MatchPaths(test.files); got != test.match {
+			t.Errorf("Want path match %v at index %d", test.match, i)
+		}
+	}
+}
+
+func TestConditions_MatchMatrix(t *testing.T) {
+	c := Conditions{
+		Matrix: map[string]manifest.Condition{
+			"GO_VERSION": {Include: []string{"1.2*"}},
+			"GOARCH":     {Exclude: []string{"arm64"}},
+		},
+	}
+	if !c.MatchMatrix(map[string]string{"GO_VERSION": "1.21", "GOARCH": "amd64"}) {
+		t.Errorf("Expect matrix axis match")
+	}
+	if c.MatchMatrix(map[string]string{"GO_VERSION": "1.19", "GOARCH": "amd64"}) {
+		t.Errorf("Expect matrix axis include mismatch")
+	}
+	if c.MatchMatrix(map[string]string{"GO_VERSION": "1.21", "GOARCH": "arm64"}) {
+		t.Errorf("Expect matrix axis excluded")
+	}
+	if c.MatchMatrix(nil) {
+		t.Errorf("Expect no match without axis values")
+	}
+	if !new(Conditions).MatchMatrix(nil) {
+		t.Errorf("Expect match without matrix conditions")
+	}
+}
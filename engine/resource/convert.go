@@ -0,0 +1,40 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Convert converts a configuration generated by a jsonnet or
+// starlark template to a multi-document yaml stream that can
+// be parsed as a manifest. The generated configuration is a
+// single json object, or a json array with an object for each
+// resource. All other configurations are returned unchanged.
+func Convert(config string) (string, error) {
+	trimmed := strings.TrimSpace(config)
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		var resources []json.RawMessage
+		if err := json.Unmarshal([]byte(trimmed), &resources); err != nil {
+			return "", err
+		}
+		// json is a subset of yaml, and each resource is
+		// therefore written as a yaml document.
+		var docs []string
+		for _, resource := range resources {
+			docs = append(docs, "---\n"+string(resource)+"\n")
+		}
+		return strings.Join(docs, ""), nil
+	case strings.HasPrefix(trimmed, "{"):
+		if !json.Valid([]byte(trimmed)) {
+			return config, nil
+		}
+		return "---\n" + trimmed + "\n", nil
+	default:
+		return config, nil
+	}
+}
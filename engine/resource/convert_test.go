@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func TestConvert(t *testing.T) {
+	config := `[
+  {"kind": "pipeline", "type": "ssh", "name": "amd64", "server": {"host": "amd64.example.com", "user": "root", "password": "root"}, "steps": [{"name": "test", "commands": ["go test"]}]},
+  {"kind": "pipeline", "type": "ssh", "name": "arm64", "server": {"host": "arm64.example.com", "user": "root", "password": "root"}, "steps": [{"name": "test", "commands": ["go test"]}]}
+]`
+	converted, err := Convert(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.ParseString(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Resources) != 2 {
+		t.Fatalf("Want 2 resources, got %d", len(m.Resources))
+	}
+	pipeline, err := Lookup("arm64", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pipeline.Server.Host.Value, "arm64.example.com"; got != want {
+		t.Errorf("Want server host %q, got %q", want, got)
+	}
+}
+
+func TestConvert_Object(t *testing.T) {
+	config := `{"kind": "pipeline", "type": "ssh", "name": "default", "server": {"host": "localhost", "user": "root", "password": "root"}, "steps": [{"name": "test", "commands": ["go test"]}]}`
+	converted, err := Convert(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.ParseString(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Resources) != 1 {
+		t.Errorf("Want 1 resource, got %d", len(m.Resources))
+	}
+}
+
+func TestConvert_YAML(t *testing.T) {
+	config := "kind: pipeline\ntype: ssh\nname: default\n"
+	converted, err := Convert(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted != config {
+		t.Errorf("Want yaml configuration unchanged")
+	}
+}
+
+func TestConvert_Invalid(t *testing.T) {
+	if _, err := Convert(`[{"kind": "pipeline"`); err == nil {
+		t.Errorf("Want error for invalid json array")
+	}
+}
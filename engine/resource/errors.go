@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LintError is returned when the pipeline fails validation. It
+// identifies the pipeline, step and field that are invalid, which
+// is helpful when yaml anchors and aliases expand into invalid
+// configurations.
+type LintError struct {
+	// Pipeline is the pipeline name.
+	Pipeline string
+
+	// Step is the step name, if the error is specific to a
+	// pipeline step.
+	Step string
+
+	// Index is the position of the step in the pipeline,
+	// starting at 1. If zero, the error is not specific to a
+	// pipeline step.
+	Index int
+
+	// Field is the invalid yaml field.
+	Field string
+
+	// Message describes the error.
+	Message string
+}
+
+// Error returns the error message.
+func (e *LintError) Error() string {
+	var details []string
+	if e.Pipeline != "" {
+		details = append(details, fmt.Sprintf("pipeline %q", e.Pipeline))
+	}
+	if e.Index > 0 {
+		if e.Step != "" {
+			details = append(details, fmt.Sprintf("step %d %q", e.Index, e.Step))
+		} else {
+			details = append(details, fmt.Sprintf("step %d", e.Index))
+		}
+	}
+	if e.Field != "" {
+		details = append(details, "field "+e.Field)
+	}
+	if len(details) == 0 {
+		return "Linter: " + e.Message
+	}
+	return fmt.Sprintf("Linter: %s (%s)", e.Message, strings.Join(details, ", "))
+}
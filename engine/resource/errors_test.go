@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func TestLintError(t *testing.T) {
+	tests := []struct {
+		err  *LintError
+		want string
+	}{
+		{
+			err:  &LintError{Message: "invalid or missing server host"},
+			want: "Linter: invalid or missing server host",
+		},
+		{
+			err:  &LintError{Pipeline: "default", Field: "server.host", Message: "invalid or missing server host"},
+			want: `Linter: invalid or missing server host (pipeline "default", field server.host)`,
+		},
+		{
+			err:  &LintError{Pipeline: "default", Step: "build", Index: 2, Field: "name", Message: "duplicate step name"},
+			want: `Linter: duplicate step name (pipeline "default", step 2 "build", field name)`,
+		},
+		{
+			err:  &LintError{Index: 3, Message: "invalid or missing step"},
+			want: `Linter: invalid or missing step (step 3)`,
+		},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("Want error %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestLint_StepError(t *testing.T) {
+	p := new(Pipeline)
+	p.Name = "default"
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "build"}, {Name: "test"}, {Name: "build"}}
+
+	err, ok := lint(p).(*LintError)
+	if !ok {
+		t.Errorf("Expect lint error")
+		return
+	}
+	if got, want := err.Index, 3; got != want {
+		t.Errorf("Want step index %d, got %d", want, got)
+	}
+	if got, want := err.Step, "build"; got != want {
+		t.Errorf("Want step name %q, got %q", want, got)
+	}
+	if got, want := err.Field, "name"; got != want {
+		t.Errorf("Want field %q, got %q", want, got)
+	}
+
+	p.Steps = []*Step{{Name: "build"}, nil}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for missing step")
+	}
+}
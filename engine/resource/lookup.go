@@ -10,15 +10,87 @@ import (
 	"github.com/drone/runner-go/manifest"
 )
 
-// Lookup returns the named pipeline from the Manifest.
+// Lookup returns the named pipeline from the Manifest. If the
+// pipeline references server defaults, the defaults are applied
+// to the pipeline server before it is returned.
 func Lookup(name string, manifest *manifest.Manifest) (*Pipeline, error) {
 	for _, resource := range manifest.Resources {
 		if resource.GetName() != name {
 			continue
 		}
 		if pipeline, ok := resource.(*Pipeline); ok {
+			if err := applyDefaults(pipeline, manifest); err != nil {
+				return nil, err
+			}
 			return pipeline, nil
 		}
 	}
 	return nil, errors.New("resource not found")
 }
+
+// helper function applies the server defaults referenced by the
+// pipeline, and then validates the server configuration.
+func applyDefaults(pipeline *Pipeline, manifest *manifest.Manifest) error {
+	if pipeline.Server.Defaults == "" {
+		return nil
+	}
+	var defaults *ServerDefaults
+	for _, resource := range manifest.Resources {
+		if d, ok := resource.(*ServerDefaults); ok && d.Name == pipeline.Server.Defaults {
+			defaults = d
+			break
+		}
+	}
+	if defaults == nil {
+		return lintError(pipeline, "server.defaults", "server defaults not found")
+	}
+
+	server := &pipeline.Server
+	mergeVariable(&server.Host, defaults.Server.Host)
+	mergeVariable(&server.Port, defaults.Server.Port)
+	mergeVariable(&server.User, defaults.Server.User)
+	mergeVariable(&server.Password, defaults.Server.Password)
+	mergeVariable(&server.SSHKey, defaults.Server.SSHKey)
+	if server.Reconnect == 0 {
+		server.Reconnect = defaults.Server.Reconnect
+	}
+	if len(server.Ciphers) == 0 {
+		server.Ciphers = defaults.Server.Ciphers
+	}
+	if len(server.KeyExchanges) == 0 {
+		server.KeyExchanges = defaults.Server.KeyExchanges
+	}
+	if len(server.MACs) == 0 {
+		server.MACs = defaults.Server.MACs
+	}
+	if !server.Agent {
+		server.Agent = defaults.Server.Agent
+	}
+	if server.MaxSessions == 0 {
+		server.MaxSessions = defaults.Server.MaxSessions
+	}
+	if server.Tunnel == "" {
+		server.Tunnel = defaults.Server.Tunnel
+	}
+	if server.Zone == "" {
+		server.Zone = defaults.Server.Zone
+	}
+	if server.Pool == "" {
+		server.Pool = defaults.Server.Pool
+	}
+	if !server.Sticky {
+		server.Sticky = defaults.Server.Sticky
+	}
+	if !server.Provision {
+		server.Provision = defaults.Server.Provision
+	}
+	return lintServer(pipeline)
+}
+
+// helper function sets the variable to the default value if the
+// variable is empty.
+func mergeVariable(v *manifest.Variable, defaults manifest.Variable) {
+	if v.Value == "" && v.Secret == "" {
+		*v = defaults
+	}
+}
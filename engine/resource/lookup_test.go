@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/drone/runner-go/manifest"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestLookup(t *testing.T) {
@@ -43,3 +44,66 @@ func TestLookupNotFound(t *testing.T) {
 		t.Errorf("Expect resource not found error")
 	}
 }
+
+func TestLookupDefaults(t *testing.T) {
+	m, err := manifest.ParseFile("testdata/defaults.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	backend, err := Lookup("backend", m)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := Server{
+		Host:     manifest.Variable{Value: "10.0.0.1"},
+		Port:     manifest.Variable{Value: "2222"},
+		User:     manifest.Variable{Value: "root"},
+		SSHKey:   manifest.Variable{Secret: "ssh_key"},
+		Defaults: "production",
+
+		Ciphers:      []string{"aes256-gcm@openssh.com"},
+		KeyExchanges: []string{"ecdh-sha2-nistp384"},
+	}
+	if diff := cmp.Diff(backend.Server, want); diff != "" {
+		t.Errorf("Unexpected server defaults")
+		t.Log(diff)
+	}
+
+	frontend, err := Lookup("frontend", m)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := frontend.Server.Host.Value, "10.0.0.2"; got != want {
+		t.Errorf("Want pipeline host %q to override defaults, got %q", want, got)
+	}
+	if diff := cmp.Diff(frontend.Server.Ciphers, []string{"aes128-ctr"}); diff != "" {
+		t.Errorf("Want pipeline ciphers to override defaults")
+		t.Log(diff)
+	}
+
+	if _, err := Lookup("missing", m); err == nil {
+		t.Errorf("Expect error when server defaults not found")
+	}
+}
+
+func TestLookupDefaults_Invalid(t *testing.T) {
+	m := &manifest.Manifest{
+		Resources: []manifest.Resource{
+			&ServerDefaults{
+				Name:   "production",
+				Server: Server{Host: manifest.Variable{Value: "10.0.0.1"}},
+			},
+			&Pipeline{
+				Name:   "default",
+				Server: Server{Defaults: "production"},
+			},
+		},
+	}
+	if _, err := Lookup("default", m); err == nil {
+		t.Errorf("Expect lint error when server defaults are incomplete")
+	}
+}
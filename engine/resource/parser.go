@@ -5,15 +5,32 @@
 package resource
 
 import (
-	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/drone-runners/drone-runner-ssh/engine/shell"
 	"github.com/drone/runner-go/manifest"
 
 	"github.com/buildkite/yaml"
 )
 
+// requirement matches a tool name with an optional version
+// constraint, for example go>=1.21.
+var requirement = regexp.MustCompile(`^[A-Za-z0-9_.+-]+?\s*(?:(>=|<=|==|=|>|<)\s*[0-9]+(?:\.[0-9]+)*)?$`)
+
+// envName matches a valid environment variable name.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// tunnelName matches a valid tunnel instance or zone name.
+var tunnelName = regexp.MustCompile(`^[A-Za-z0-9._:][A-Za-z0-9._:-]*$`)
+
 func init() {
 	manifest.Register(parse)
+	manifest.Register(parseDefaults)
 }
 
 // parse parses the raw resource and returns an Exec pipeline.
@@ -35,33 +52,719 @@ func match(r *manifest.RawResource) bool {
 	return r.Kind == Kind && r.Type == Type
 }
 
+// parseDefaults parses the raw resource and returns the server
+// defaults.
+func parseDefaults(r *manifest.RawResource) (manifest.Resource, bool, error) {
+	if r.Kind != KindServerDefaults || r.Type != Type {
+		return nil, false, nil
+	}
+	out := new(ServerDefaults)
+	err := yaml.Unmarshal(r.Data, out)
+	if err != nil {
+		return out, true, err
+	}
+	err = lintDefaults(out)
+	return out, true, err
+}
+
 // lint returns an error if any pipeline values are invalid.
 func lint(pipeline *Pipeline) error {
+	// the server configuration is validated after the server
+	// defaults are applied, since the defaults are defined in
+	// a separate resource.
+	if pipeline.Server.Defaults == "" {
+		if err := lintServer(pipeline); err != nil {
+			return err
+		}
+	}
+	return lintPipeline(pipeline)
+}
+
+// lintDefaults returns an error if any server defaults values
+// are invalid.
+func lintDefaults(defaults *ServerDefaults) error {
+	if defaults.Name == "" {
+		return &LintError{Field: "name", Message: "invalid or missing server defaults name"}
+	}
+	if defaults.Server.Port.Value != "" && !isPort(defaults.Server.Port.Value) {
+		return &LintError{Field: "server.port", Message: "invalid server port"}
+	}
+	if defaults.Server.Reconnect < 0 {
+		return &LintError{Field: "server.reconnect", Message: "invalid server reconnect attempts"}
+	}
+	if defaults.Server.Defaults != "" {
+		return &LintError{Field: "server.defaults", Message: "server defaults cannot reference other defaults"}
+	}
+	return nil
+}
+
+// lintServer returns an error if any pipeline server values are
+// invalid.
+func lintServer(pipeline *Pipeline) error {
+	if pipeline.Server.Sticky && pipeline.Server.Pool == "" {
+		return lintError(pipeline, "server.sticky", "server sticky requires a server pool")
+	}
+	// hosts selected from a runner pool are assigned, along
+	// with the credentials, when the stage is scheduled.
+	if pipeline.Server.Pool != "" {
+		if pipeline.Server.Host.Value != "" || pipeline.Server.Host.Secret != "" {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server host")
+		}
+		if pipeline.Server.Tunnel != "" {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server tunnel")
+		}
+		if pipeline.Server.Provision {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with server provisioning")
+		}
+		// the port and credentials are assigned by the pool,
+		// and would otherwise silently override the pool values.
+		if !isEmpty(pipeline.Server.Port) {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server port")
+		}
+		if !isEmpty(pipeline.Server.User) {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server user")
+		}
+		if !isEmpty(pipeline.Server.Password) {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server password")
+		}
+		if !isEmpty(pipeline.Server.SSHKey) {
+			return lintError(pipeline, "server.pool", "server pool cannot be used with a server ssh key")
+		}
+		return lintServerOptions(pipeline)
+	}
+	// provisioned hosts are assigned, along with the
+	// credentials, by the runner provisioning hook.
+	if pipeline.Server.Provision {
+		if pipeline.Server.Host.Value != "" || pipeline.Server.Host.Secret != "" {
+			return lintError(pipeline, "server.provision", "server provisioning cannot be used with a server host")
+		}
+		if pipeline.Server.Tunnel != "" {
+			return lintError(pipeline, "server.provision", "server provisioning cannot be used with a server tunnel")
+		}
+		return lintServerOptions(pipeline)
+	}
 	// ensure server configuration provided.
 	if pipeline.Server.Host.Value == "" && pipeline.Server.Host.Secret == "" {
-		return errors.New("Linter: invalid or missing server host")
+		return lintError(pipeline, "server.host", "invalid or missing server host")
+	}
+	if pipeline.Server.Port.Value != "" && !isPort(pipeline.Server.Port.Value) {
+		return lintError(pipeline, "server.port", "invalid server port")
+	}
+	if !isTransport(pipeline.Server.Host.Value) {
+		return lintError(pipeline, "server.host", "unsupported server host transport")
+	}
+	switch pipeline.Server.Tunnel {
+	case "", "aws-ssm", "gcp-iap":
+	default:
+		return lintError(pipeline, "server.tunnel", "unsupported server tunnel")
+	}
+	if pipeline.Server.Tunnel != "" && strings.Contains(pipeline.Server.Host.Value, "://") {
+		return lintError(pipeline, "server.tunnel", "server tunnel cannot be used with a transport url")
+	}
+	// the instance and zone are passed to the cloud provider
+	// command line tool, and cannot be parsed as flags. Hosts
+	// that reference variables are validated once expanded.
+	if pipeline.Server.Tunnel != "" && !strings.Contains(pipeline.Server.Host.Value, "$") &&
+		pipeline.Server.Host.Value != "" && !isTunnelName(pipeline.Server.Host.Value) {
+		return lintError(pipeline, "server.host", "invalid server tunnel instance")
+	}
+	if pipeline.Server.Zone != "" && pipeline.Server.Tunnel != "gcp-iap" {
+		return lintError(pipeline, "server.zone", "server zone requires the gcp-iap tunnel")
+	}
+	if pipeline.Server.Zone != "" && !isTunnelName(pipeline.Server.Zone) {
+		return lintError(pipeline, "server.zone", "invalid server zone")
 	}
 	if pipeline.Server.User.Value == "" && pipeline.Server.User.Secret == "" {
-		return errors.New("Linter: invalid or missing server user")
+		return lintError(pipeline, "server.user", "invalid or missing server user")
 	}
 	if pipeline.Server.Password.Value == "" && pipeline.Server.Password.Secret == "" &&
-		pipeline.Server.SSHKey.Value == "" && pipeline.Server.SSHKey.Secret == "" {
-		return errors.New("Linter: invalid or missing server password or ssh_key")
+		pipeline.Server.SSHKey.Value == "" && pipeline.Server.SSHKey.Secret == "" &&
+		!pipeline.Server.Agent {
+		return lintError(pipeline, "server.password", "invalid or missing server password or ssh_key")
+	}
+	return lintServerOptions(pipeline)
+}
+
+// lintServerOptions returns an error if the pipeline server
+// connection options are invalid.
+func lintServerOptions(pipeline *Pipeline) error {
+	if pipeline.Server.Reconnect < 0 {
+		return lintError(pipeline, "server.reconnect", "invalid server reconnect attempts")
+	}
+	if pipeline.Server.Reconnect > 0 && pipeline.Platform.OS == "windows" {
+		return lintError(pipeline, "server.reconnect", "server reconnect is not supported on windows")
+	}
+	// each step requires an sftp session and a command session.
+	if pipeline.Server.MaxSessions < 0 || pipeline.Server.MaxSessions == 1 {
+		return lintError(pipeline, "server.max_sessions", "invalid server max sessions")
+	}
+	return nil
+}
+
+// helper function returns true if the variable has no value
+// and is not loaded from a secret.
+func isEmpty(v manifest.Variable) bool {
+	return v.Value == "" && v.Secret == ""
+}
+
+// lintPipeline returns an error if any pipeline values, other
+// than the server values, are invalid.
+func lintPipeline(pipeline *Pipeline) error {
+	// ensure the environment encoding is valid.
+	switch pipeline.Encoding {
+	case "", "base64", "quote":
+	default:
+		return lintError(pipeline, "env_encoding", "invalid environment encoding")
+	}
+
+	// ensure the log timestamp format is valid.
+	switch pipeline.Timestamps {
+	case "", "true", "false", "rfc3339", "relative":
+	default:
+		return lintError(pipeline, "timestamps", "invalid timestamp format")
+	}
+
+	// ensure the workspace path does not escape the workspace.
+	if hasParent(pipeline.Workspace.Path) {
+		return lintError(pipeline, "workspace.path", "invalid workspace path")
+	}
+
+	// ensure the workspace permissions are valid.
+	if !isMode(pipeline.Permissions.Workspace) {
+		return lintError(pipeline, "permissions.workspace", "invalid workspace directory mode")
+	}
+	if !isMode(pipeline.Permissions.Directories) {
+		return lintError(pipeline, "permissions.directories", "invalid directory mode")
+	}
+
+	// ensure the tool requirements are valid.
+	for _, s := range pipeline.Requires {
+		if !requirement.MatchString(strings.TrimSpace(s)) {
+			return lintError(pipeline, "requires", "invalid tool requirement "+s)
+		}
+	}
+
+	// ensure docker registry credentials are valid.
+	for _, auth := range pipeline.DockerAuth {
+		if auth == nil {
+			return lintError(pipeline, "docker_auth", "invalid or missing docker credentials")
+		}
+		if auth.Username.Value == "" && auth.Username.Secret == "" {
+			return lintError(pipeline, "docker_auth.username", "invalid or missing docker username")
+		}
+		if auth.Password.Value == "" && auth.Password.Secret == "" {
+			return lintError(pipeline, "docker_auth.password", "invalid or missing docker password")
+		}
+	}
+
+	// ensure the secret renewal interval is valid.
+	if pipeline.Renewal != "" {
+		if d, err := time.ParseDuration(pipeline.Renewal); err != nil || d <= 0 {
+			return lintError(pipeline, "secret_renewal", "invalid secret renewal interval")
+		}
+	}
+
+	// ensure the pipeline files are valid.
+	for _, file := range pipeline.Files {
+		if msg := lintFile(file); msg != "" {
+			return lintError(pipeline, "files", msg)
+		}
+	}
+
+	// ensure netrc configuration is valid.
+	switch pipeline.Netrc.Mode {
+	case "", NetrcDisabled, NetrcCloneOnly:
+	default:
+		return lintError(pipeline, "netrc", "invalid netrc mode")
+	}
+	for _, netrc := range pipeline.Netrc.Machines {
+		if netrc == nil || netrc.Machine == "" {
+			return lintError(pipeline, "netrc.machine", "invalid or missing netrc machine")
+		}
+	}
+
+	// ensure the port forwards are valid.
+	for _, tunnel := range pipeline.Tunnels {
+		if tunnel == nil {
+			return lintError(pipeline, "tunnel", "invalid or missing tunnel")
+		}
+		if msg := lintTunnel(tunnel); msg != "" {
+			return lintError(pipeline, "tunnel", msg)
+		}
+	}
+
+	// ensure the pipeline variable names are valid.
+	for name := range pipeline.Vars {
+		if !varName.MatchString(name) {
+			return lintError(pipeline, "vars", "invalid variable name "+name)
+		}
+	}
+
+	// ensure the default step shell is valid.
+	if pipeline.Shell != "" {
+		if _, ok := shell.Lookup(pipeline.Shell); !ok {
+			return lintError(pipeline, "shell", "unsupported shell")
+		}
+	}
+
+	// the legacy step groups are converted to step
+	// dependencies, and cannot be combined with explicit step
+	// dependencies.
+	if hasGroups(pipeline) {
+		for i, step := range pipeline.Steps {
+			if step != nil && len(step.DependsOn) != 0 {
+				return lintStepError(pipeline, i, step.Name, "depends_on", "step dependencies cannot be used with step groups")
+			}
+		}
 	}
 
 	// ensure pipeline steps are not unique.
 	names := map[string]struct{}{}
-	for _, step := range pipeline.Steps {
-		if step.Detach {
-			return errors.New("Linter: detached steps are not allowed")
+	for i, step := range pipeline.Steps {
+		if step == nil {
+			return lintStepError(pipeline, i, "", "", "invalid or missing step")
+		}
+		if step.Detach && step.Sync != nil {
+			return lintStepError(pipeline, i, step.Name, "detach", "sync steps cannot be detached")
+		}
+		if step.Ready != nil {
+			if !step.Detach {
+				return lintStepError(pipeline, i, step.Name, "ready", "readiness probe requires a detached step")
+			}
+			if msg := lintReady(step.Ready); msg != "" {
+				return lintStepError(pipeline, i, step.Name, "ready", msg)
+			}
 		}
 		if step.Name == "" {
-			return errors.New("Linter: invalid or missing step name")
+			return lintStepError(pipeline, i, step.Name, "name", "invalid or missing step name")
 		}
 		if _, ok := names[step.Name]; ok {
-			return errors.New("Linter: duplicate step name")
+			return lintStepError(pipeline, i, step.Name, "name", "duplicate step name")
+		}
+		// runas does not wait for the process to exit or capture
+		// its output, and therefore cannot be used to execute a
+		// pipeline step as a different user.
+		if step.User != "" && pipeline.Platform.OS == "windows" {
+			return lintStepError(pipeline, i, step.Name, "user", "step user is not supported on windows")
+		}
+		if hasParent(step.WorkingDir) {
+			return lintStepError(pipeline, i, step.Name, "working_dir", "invalid step working directory")
+		}
+		if !isMode(step.Umask) {
+			return lintStepError(pipeline, i, step.Name, "umask", "invalid step umask")
+		}
+		if step.Umask != "" && pipeline.Platform.OS == "windows" {
+			return lintStepError(pipeline, i, step.Name, "umask", "step umask is not supported on windows")
+		}
+		if name := pipeline.GetShell(step); name != "" {
+			profile, ok := shell.Lookup(name)
+			if !ok {
+				return lintStepError(pipeline, i, step.Name, "shell", "unsupported step shell")
+			}
+			if profile.Dialect == shell.Posix && pipeline.Platform.OS == "windows" {
+				return lintStepError(pipeline, i, step.Name, "shell", "step shell is not supported on windows")
+			}
+			if profile.Dialect == shell.Powershell && step.Umask != "" {
+				return lintStepError(pipeline, i, step.Name, "umask", "step umask is not supported with powershell")
+			}
+		}
+		// a pseudo-terminal cannot be attached to a step that
+		// is executed detached from the ssh session.
+		if step.Term != "" && pipeline.Server.Reconnect > 0 {
+			return lintStepError(pipeline, i, step.Name, "term", "step term is not supported with server reconnect")
+		}
+		// agent and x11 forwarding are requested on the step
+		// ssh session, which is not used by sync steps or by
+		// steps executed detached from the ssh session.
+		if step.ForwardAgent || step.ForwardX11 {
+			if step.Sync != nil {
+				return lintStepError(pipeline, i, step.Name, "sync", "sync steps cannot use agent or x11 forwarding")
+			}
+			if pipeline.Server.Reconnect > 0 {
+				return lintStepError(pipeline, i, step.Name, "server.reconnect", "agent and x11 forwarding are not supported with server reconnect")
+			}
+		}
+		if step.ForwardX11 && pipeline.Platform.OS == "windows" {
+			return lintStepError(pipeline, i, step.Name, "forward_x11", "x11 forwarding is not supported on windows")
+		}
+		// the skip action only applies to the listed exit
+		// codes, and is therefore invalid without exit codes.
+		if step.Failure.Action == FailureSkip && len(step.Failure.ExitCodes) == 0 {
+			return lintStepError(pipeline, i, step.Name, "failure.exit_codes", "skip failure action requires exit codes")
+		}
+		for _, code := range step.Failure.ExitCodes {
+			if !isExitCode(pipeline.Platform.OS, code) {
+				return lintStepError(pipeline, i, step.Name, "failure.exit_codes", "invalid failure exit code")
+			}
+		}
+		for _, file := range step.Files {
+			if msg := lintFile(file); msg != "" {
+				return lintStepError(pipeline, i, step.Name, "files", msg)
+			}
+		}
+		if name := undefinedVar(pipeline.Vars, stepValues(step)...); name != "" {
+			return lintStepError(pipeline, i, step.Name, "vars", "step references undefined variable "+name)
+		}
+		for name := range step.Settings {
+			if !isEnvName(name) {
+				return lintStepError(pipeline, i, step.Name, "settings", "invalid step setting "+name)
+			}
+		}
+		for axis, values := range step.Matrix {
+			if !isEnvName(axis) || len(values) == 0 {
+				return lintStepError(pipeline, i, step.Name, "matrix", "invalid matrix axis")
+			}
+		}
+		for axis := range step.When.Matrix {
+			if _, ok := step.Matrix[axis]; !ok {
+				return lintStepError(pipeline, i, step.Name, "when.matrix", "undefined matrix axis "+axis)
+			}
+		}
+		if msg := lintStatus(step.When.Status); msg != "" {
+			return lintStepError(pipeline, i, step.Name, "when.status", msg)
+		}
+		if step.Sync != nil {
+			if msg := lintSync(step); msg != "" {
+				return lintStepError(pipeline, i, step.Name, "sync", msg)
+			}
+		}
+		if pipeline.Batch {
+			if err := lintBatchStep(pipeline, i, step); err != nil {
+				return err
+			}
 		}
 		names[step.Name] = struct{}{}
 	}
+	return lintDependencies(pipeline)
+}
+
+// lintDependencies returns an error if a pipeline step depends
+// on a step that does not exist, or if the step dependencies
+// contain a cycle, which would prevent the steps from running.
+func lintDependencies(pipeline *Pipeline) error {
+	index := map[string]int{}
+	for i, step := range pipeline.Steps {
+		index[step.Name] = i
+	}
+	for i, step := range pipeline.Steps {
+		for _, dep := range step.DependsOn {
+			switch _, ok := index[dep]; {
+			case dep == step.Name:
+				return lintStepError(pipeline, i, step.Name, "depends_on", "step cannot depend on itself")
+			case !ok && dep != "clone":
+				return lintStepError(pipeline, i, step.Name, "depends_on", fmt.Sprintf("step depends on unknown step %q", dep))
+			}
+		}
+	}
+
+	// the dependency graph is traversed depth first. A step
+	// that is visited while its dependencies are still being
+	// visited closes a cycle.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(pipeline.Steps))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		step := pipeline.Steps[i]
+		state[i] = visiting
+		path = append(path, step.Name)
+		for _, dep := range step.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				continue
+			}
+			switch state[j] {
+			case visiting:
+				cycle := append(path[indexOf(path, dep):], dep)
+				return lintStepError(pipeline, i, step.Name, "depends_on", "step dependency cycle "+strings.Join(cycle, " -> "))
+			case unvisited:
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range pipeline.Steps {
+		if state[i] == unvisited {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lintBatchStep returns an error if the pipeline step uses
+// features that are not supported in batch mode, where steps
+// are executed serially in a single remote script.
+func lintBatchStep(pipeline *Pipeline, i int, step *Step) error {
+	switch {
+	case len(step.DependsOn) != 0:
+		return lintStepError(pipeline, i, step.Name, "depends_on", "step dependencies are not supported in batch mode")
+	case step.Group != "":
+		return lintStepError(pipeline, i, step.Name, "group", "step groups are not supported in batch mode")
+	case step.Detach:
+		return lintStepError(pipeline, i, step.Name, "detach", "detached steps are not supported in batch mode")
+	case len(step.When.Status.Include) != 0 || len(step.When.Status.Exclude) != 0:
+		return lintStepError(pipeline, i, step.Name, "when.status", "step status conditions are not supported in batch mode")
+	case len(step.Failure.ExitCodes) != 0:
+		return lintStepError(pipeline, i, step.Name, "failure.exit_codes", "failure exit codes are not supported in batch mode")
+	case step.Term != "":
+		return lintStepError(pipeline, i, step.Name, "term", "step term is not supported in batch mode")
+	case step.Sync != nil:
+		return lintStepError(pipeline, i, step.Name, "sync", "sync steps are not supported in batch mode")
+	case step.ForwardAgent:
+		return lintStepError(pipeline, i, step.Name, "forward_agent", "agent forwarding is not supported in batch mode")
+	case step.ForwardX11:
+		return lintStepError(pipeline, i, step.Name, "forward_x11", "x11 forwarding is not supported in batch mode")
+	case isPowershell(pipeline.GetShell(step)) && pipeline.Platform.OS != "windows":
+		return lintStepError(pipeline, i, step.Name, "shell", "step shell is not supported in batch mode")
+	}
 	return nil
 }
+
+// helper function returns a message describing why the file is
+// invalid, or an empty string if the file is valid.
+func lintFile(file *File) string {
+	switch {
+	case file == nil || file.Path == "":
+		return "invalid or missing file path"
+	case !isMode(file.Mode):
+		return "invalid file mode"
+	}
+	return ""
+}
+
+// helper function returns a message describing why the
+// readiness probe is invalid, or an empty string if the probe
+// is valid.
+func lintReady(ready *Ready) string {
+	switch {
+	case ready.Port == 0 && ready.Command == "":
+		return "readiness probe requires a port or command"
+	case ready.Port != 0 && ready.Command != "":
+		return "readiness probe cannot define both a port and command"
+	case ready.Port < 0 || ready.Port > 65535:
+		return "invalid readiness probe port"
+	case !isDuration(ready.Timeout):
+		return "invalid readiness probe timeout"
+	case !isDuration(ready.Interval):
+		return "invalid readiness probe interval"
+	}
+	return ""
+}
+
+// helper function returns a message describing why the status
+// condition is invalid, or an empty string if the condition is
+// valid. The build status is either success or failure when
+// the step is evaluated.
+func lintStatus(status manifest.Condition) string {
+	for _, list := range [][]string{status.Include, status.Exclude} {
+		for _, s := range list {
+			switch s {
+			case "success", "failure":
+			default:
+				return "invalid status condition " + s
+			}
+		}
+	}
+	return ""
+}
+
+// helper function returns a message describing why the sync
+// step is invalid, or an empty string if the step is valid.
+func lintSync(step *Step) string {
+	switch {
+	case len(step.Commands) != 0:
+		return "sync steps cannot define commands"
+	case step.Sync.Direction != SyncUpload && step.Sync.Direction != SyncDownload:
+		return "invalid or missing sync direction"
+	case step.Sync.Source == "":
+		return "invalid or missing sync source"
+	case step.Sync.Target == "":
+		return "invalid or missing sync target"
+	case hasParent(step.Sync.Source) || hasParent(step.Sync.Target):
+		return "sync paths cannot reference a parent directory"
+	}
+	// the local path is relative to the sync directory of the
+	// runner host.
+	local := step.Sync.Source
+	if step.Sync.Direction == SyncDownload {
+		local = step.Sync.Target
+	}
+	if isLocalAbs(local) {
+		return "sync local path must be relative"
+	}
+	return ""
+}
+
+// helper function returns true if the local path is a posix
+// or windows absolute path.
+func isLocalAbs(path string) bool {
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, `\`) {
+		return true
+	}
+	return len(path) > 1 && path[1] == ':'
+}
+
+// helper function returns a message describing why the tunnel
+// is invalid, or an empty string if the tunnel is valid.
+func lintTunnel(tunnel *Tunnel) string {
+	switch {
+	case tunnel.Direction != "" && tunnel.Direction != TunnelLocal && tunnel.Direction != TunnelRemote:
+		return "invalid tunnel direction"
+	case !isAddress(tunnel.Local):
+		return "invalid or missing tunnel local address"
+	case !isAddress(tunnel.Remote):
+		return "invalid or missing tunnel remote address"
+	}
+	return ""
+}
+
+// helper function returns a lint error for the pipeline field.
+func lintError(pipeline *Pipeline, field, message string) error {
+	return &LintError{
+		Pipeline: pipeline.Name,
+		Field:    field,
+		Message:  message,
+	}
+}
+
+// helper function returns a lint error for the field of the
+// pipeline step at index i.
+func lintStepError(pipeline *Pipeline, i int, step, field, message string) error {
+	return &LintError{
+		Pipeline: pipeline.Name,
+		Step:     step,
+		Index:    i + 1,
+		Field:    field,
+		Message:  message,
+	}
+}
+
+// helper function returns true if any pipeline step defines
+// a legacy step group.
+func hasGroups(pipeline *Pipeline) bool {
+	for _, step := range pipeline.Steps {
+		if step != nil && step.Group != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the string is empty or a
+// positive duration.
+func isDuration(s string) bool {
+	if s == "" {
+		return true
+	}
+	d, err := time.ParseDuration(s)
+	return err == nil && d > 0
+}
+
+// helper function returns the index of the string in the
+// slice, or -1 if not found.
+func indexOf(s []string, v string) int {
+	for i, ss := range s {
+		if ss == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// helper function returns true if the step shell executes
+// powershell scripts.
+func isPowershell(name string) bool {
+	profile, ok := shell.Lookup(name)
+	return ok && profile.Dialect == shell.Powershell
+}
+
+// helper function returns true if the string is empty or a
+// valid octal file mode.
+func isMode(s string) bool {
+	if s == "" {
+		return true
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	return err == nil && mode <= 0777
+}
+
+// helper function returns true if the string is a valid
+// tcp port number.
+func isPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port <= 65535
+}
+
+// helper function returns true if the path references a
+// parent directory.
+func hasParent(path string) bool {
+	for _, name := range strings.FieldsFunc(path, isSeparator) {
+		if name == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function returns true if the rune is a posix or
+// windows path separator.
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// helper function returns true if the string is a port, or a
+// host and port.
+func isAddress(s string) bool {
+	if !strings.Contains(s, ":") {
+		return isPort(s)
+	}
+	_, port, err := net.SplitHostPort(s)
+	return err == nil && isPort(port)
+}
+
+// helper function returns true if the host is a tcp host or
+// a transport url with a supported scheme.
+func isTransport(host string) bool {
+	i := strings.Index(host, "://")
+	if i == -1 {
+		return true
+	}
+	switch host[:i] {
+	case "unix", "ws", "wss":
+		return true
+	default:
+		return false
+	}
+}
+
+// helper function returns true if the string is a valid
+// tunnel instance or zone name, which cannot start with a dash.
+func isTunnelName(s string) bool {
+	return tunnelName.MatchString(s)
+}
+
+// helper function returns true if the string is a valid
+// environment variable name.
+func isEnvName(s string) bool {
+	return envName.MatchString(s)
+}
+
+// helper function returns true if the exit code is a valid
+// non-zero exit code. Windows exit codes are unsigned 32-bit
+// integers, which include NTSTATUS codes.
+func isExitCode(os string, code int) bool {
+	if os == "windows" {
+		return code > 0 && int64(code) <= 0xFFFFFFFF
+	}
+	return code > 0 && code <= 255
+}
@@ -7,6 +7,7 @@ package resource
 import (
 	"testing"
 
+	"github.com/buildkite/yaml"
 	"github.com/drone/runner-go/manifest"
 
 	"github.com/google/go-cmp/cmp"
@@ -37,11 +38,12 @@ func TestParse(t *testing.T) {
 			Version: "1",
 			Server: Server{
 				Host:     manifest.Variable{Value: "localhost"},
+				Port:     manifest.Variable{Value: "2222"},
 				User:     manifest.Variable{Value: "root"},
 				Password: manifest.Variable{Value: "correct-horse-battery-staple"},
 				SSHKey:   manifest.Variable{Secret: "private_key"},
 			},
-			Workspace: manifest.Workspace{
+			Workspace: Workspace{
 				Path: "/drone/src",
 			},
 			Platform: manifest.Platform{
@@ -66,12 +68,12 @@ func TestParse(t *testing.T) {
 						"go build",
 						"go test",
 					},
-					Environment: map[string]*manifest.Variable{
-						"GOOS":   &manifest.Variable{Value: "linux"},
-						"GOARCH": &manifest.Variable{Value: "arm64"},
+					Environment: map[string]*Variable{
+						"GOOS":   &Variable{Value: "linux"},
+						"GOARCH": &Variable{Value: "arm64"},
 					},
-					Failure: "never",
-					When: manifest.Conditions{
+					Failure: Failure{Action: "never"},
+					When: Conditions{
 						Event: manifest.Condition{
 							Include: []string{"push"},
 						},
@@ -159,9 +161,19 @@ func TestLint(t *testing.T) {
 		t.Errorf("Expect error when empty name")
 	}
 
-	p.Steps = []*Step{{Name: "build", Detach: true}}
+	p.Steps = []*Step{{Name: "build", Ready: &Ready{Port: 8080}}}
 	if err := lint(p); err == nil {
-		t.Errorf("Expect error when step detached")
+		t.Errorf("Expect error when readiness probe and step not detached")
+	}
+
+	p.Steps = []*Step{{Name: "build", User: "nobody"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Platform.OS = "windows"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect error when step user on windows")
 	}
 }
 
@@ -201,3 +213,989 @@ func TestLint_ServerError(t *testing.T) {
 		t.Errorf("Expect lint error for missing passwords")
 	}
 }
+
+func TestLint_ServerPort(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		Port:     manifest.Variable{Value: "2222"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Server.Port = manifest.Variable{Secret: "ssh_port"}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	for _, port := range []string{"ssh", "0", "-1", "65536"} {
+		p.Server.Port = manifest.Variable{Value: port}
+		if err := lint(p); err == nil {
+			t.Errorf("Expect lint error for invalid port %q", port)
+		}
+	}
+}
+
+func TestLint_ServerAgent(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host: manifest.Variable{Value: "localhost"},
+		User: manifest.Variable{Value: "root"},
+	}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for missing password or ssh_key")
+	}
+
+	p.Server.Agent = true
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error with agent authentication, got %s", err)
+	}
+}
+
+func TestLint_ServerTransport(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	for _, host := range []string{"localhost", "unix:///run/sshd.sock", "ws://localhost:8080", "wss://gateway.company.com/ssh"} {
+		p.Server.Host = manifest.Variable{Value: host}
+		if err := lint(p); err != nil {
+			t.Errorf("Expect no lint error for host %s, got %s", host, err)
+		}
+	}
+
+	p.Server.Host = manifest.Variable{Value: "http://localhost"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for unsupported transport")
+	}
+}
+
+func TestLint_ServerTunnel(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "i-0123456789abcdef0"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	for _, tunnel := range []string{"", "aws-ssm", "gcp-iap"} {
+		p.Server.Tunnel = tunnel
+		if err := lint(p); err != nil {
+			t.Errorf("Expect no lint error for tunnel %q, got %s", tunnel, err)
+		}
+	}
+
+	p.Server.Tunnel = "azure-bastion"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for unsupported tunnel")
+	}
+
+	p.Server.Tunnel = "aws-ssm"
+	p.Server.Host = manifest.Variable{Value: "unix:///run/sshd.sock"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for tunnel with transport url")
+	}
+
+	p.Server.Host = manifest.Variable{Value: "-i-0123456789abcdef0"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for tunnel instance parsed as a flag")
+	}
+
+	p.Server.Host = manifest.Variable{Value: "build-1"}
+	p.Server.Zone = "us-central1-a"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for zone without gcp-iap tunnel")
+	}
+	p.Server.Tunnel = "gcp-iap"
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for gcp-iap zone, got %s", err)
+	}
+	p.Server.Zone = "--project=other"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid zone")
+	}
+}
+
+func TestLint_ServerPool(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{Pool: "linux-large"}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for server pool, got %s", err)
+	}
+
+	p.Server.Host = manifest.Variable{Value: "10.0.0.1"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server pool with host")
+	}
+
+	p.Server.Host = manifest.Variable{}
+	p.Server.MaxSessions = 1
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid server pool options")
+	}
+
+	p.Server.MaxSessions = 0
+	p.Server.Port = manifest.Variable{Value: "2222"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server pool with port")
+	}
+
+	p.Server.Port = manifest.Variable{}
+	p.Server.User = manifest.Variable{Secret: "username"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server pool with user")
+	}
+
+	p.Server.User = manifest.Variable{}
+	p.Server.Password = manifest.Variable{Secret: "password"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server pool with password")
+	}
+
+	p.Server.Password = manifest.Variable{}
+	p.Server.SSHKey = manifest.Variable{Secret: "ssh_key"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server pool with ssh key")
+	}
+}
+
+func TestLint_ServerSticky(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{Pool: "linux-large", Sticky: true}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for sticky server pool, got %s", err)
+	}
+
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "10.0.0.1"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+		Sticky:   true,
+	}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for sticky server without pool")
+	}
+}
+
+func TestLint_ServerProvision(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{Provision: true}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for server provisioning, got %s", err)
+	}
+
+	p.Server.Host = manifest.Variable{Value: "10.0.0.1"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server provisioning with host")
+	}
+
+	p.Server.Host = manifest.Variable{}
+	p.Server.Pool = "linux-large"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for server provisioning with pool")
+	}
+}
+
+func TestLint_ServerMaxSessions(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:        manifest.Variable{Value: "localhost"},
+		User:        manifest.Variable{Value: "root"},
+		Password:    manifest.Variable{Value: "root"},
+		MaxSessions: 10,
+	}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	for _, n := range []int{-1, 1} {
+		p.Server.MaxSessions = n
+		if err := lint(p); err == nil {
+			t.Errorf("Expect lint error for max sessions %d", n)
+		}
+	}
+}
+
+func TestLint_ServerReconnect(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:      manifest.Variable{Value: "localhost"},
+		User:      manifest.Variable{Value: "root"},
+		Password:  manifest.Variable{Value: "root"},
+		Reconnect: 3,
+	}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Server.Reconnect = -1
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for negative reconnect attempts")
+	}
+
+	p.Server.Reconnect = 3
+	p.Platform.OS = "windows"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for reconnect on windows")
+	}
+}
+
+func TestNetrc_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want Netrc
+	}{
+		{yaml: "true", want: Netrc{}},
+		{yaml: "false", want: Netrc{Mode: NetrcDisabled}},
+		{yaml: "clone-only", want: Netrc{Mode: NetrcCloneOnly}},
+		{
+			yaml: "[ { machine: gitlab.com, login: octocat } ]",
+			want: Netrc{Machines: []*NetrcMachine{
+				{Machine: "gitlab.com", Login: manifest.Variable{Value: "octocat"}},
+			}},
+		},
+		{
+			yaml: "{ mode: clone-only, machines: [ { machine: gitlab.com } ] }",
+			want: Netrc{Mode: NetrcCloneOnly, Machines: []*NetrcMachine{
+				{Machine: "gitlab.com"},
+			}},
+		},
+	}
+	for _, test := range tests {
+		got := Netrc{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &got); err != nil {
+			t.Error(err)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("Unexpected netrc for %q", test.yaml)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestLint_Netrc(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	for _, mode := range []string{"", NetrcDisabled, NetrcCloneOnly} {
+		p.Netrc.Mode = mode
+		if err := lint(p); err != nil {
+			t.Errorf("Expect no lint error for netrc mode %q, got %s", mode, err)
+		}
+	}
+
+	p.Netrc.Mode = "always"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid netrc mode")
+	}
+}
+
+func TestLintDefaults(t *testing.T) {
+	d := &ServerDefaults{Name: "production"}
+	if err := lintDefaults(d); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	d.Name = ""
+	if err := lintDefaults(d); err == nil {
+		t.Errorf("Expect lint error when name is missing")
+	}
+
+	d.Name = "production"
+	d.Server.Port = manifest.Variable{Value: "ssh"}
+	if err := lintDefaults(d); err == nil {
+		t.Errorf("Expect lint error when port is invalid")
+	}
+
+	d.Server.Port = manifest.Variable{}
+	d.Server.Defaults = "staging"
+	if err := lintDefaults(d); err == nil {
+		t.Errorf("Expect lint error when defaults reference other defaults")
+	}
+}
+
+func TestLint_Encoding(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	for _, encoding := range []string{"", "base64", "quote"} {
+		p.Encoding = encoding
+		if err := lint(p); err != nil {
+			t.Errorf("Expect no lint error for encoding %q, got %s", encoding, err)
+		}
+	}
+
+	p.Encoding = "hex"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid encoding")
+	}
+}
+
+func TestFailure_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want Failure
+	}{
+		{yaml: "ignore", want: Failure{Action: "ignore"}},
+		{yaml: "{ exit_codes: [ 2, 3 ] }", want: Failure{ExitCodes: []int{2, 3}}},
+		{yaml: "{ action: skip, exit_codes: [ 2 ] }", want: Failure{Action: "skip", ExitCodes: []int{2}}},
+	}
+	for _, test := range tests {
+		got := Failure{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &got); err != nil {
+			t.Error(err)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("Unexpected failure policy for %q", test.yaml)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestVariable_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want Variable
+	}{
+		{yaml: "linux", want: Variable{Value: "linux"}},
+		{yaml: "{ from_secret: password }", want: Variable{Secret: "password"}},
+		{yaml: "{ from_secret: password, optional: true }", want: Variable{Secret: "password", Optional: true}},
+	}
+	for _, test := range tests {
+		got := Variable{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &got); err != nil {
+			t.Error(err)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("Unexpected variable for %q", test.yaml)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestLint_Failure(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "build", Failure: Failure{Action: "skip", ExitCodes: []int{2}}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps = []*Step{{Name: "build", Failure: Failure{Action: "skip"}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when skip action without exit codes")
+	}
+
+	p.Steps = []*Step{{Name: "build", Failure: Failure{ExitCodes: []int{0}}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when exit code is zero")
+	}
+
+	p.Steps = []*Step{{Name: "build", Failure: Failure{ExitCodes: []int{1000}}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when posix exit code exceeds 255")
+	}
+
+	p.Platform.OS = "windows"
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for windows exit code, got %s", err)
+	}
+}
+
+func TestLint_Term(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "build", Term: "xterm-256color"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Server.Reconnect = 3
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when term with server reconnect")
+	}
+}
+
+func TestLint_Batch(t *testing.T) {
+	p := new(Pipeline)
+	p.Batch = true
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "build"}, {Name: "test"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps = []*Step{{Name: "build"}, {Name: "test", DependsOn: []string{"build"}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when step dependencies in batch mode")
+	}
+
+	p.Steps = []*Step{{Name: "build", Failure: Failure{ExitCodes: []int{2}}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when failure exit codes in batch mode")
+	}
+
+	p.Steps = []*Step{{Name: "build", Shell: "pwsh"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when pwsh step in batch mode")
+	}
+}
+
+func TestLint_StepShell(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "build", Shell: "pwsh"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps = []*Step{{Name: "build", Shell: "pwsh", Umask: "022"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when step umask with pwsh")
+	}
+
+	p.Steps = []*Step{{Name: "build", Shell: "zsh"}, {Name: "test", Shell: "/bin/ksh"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps = []*Step{{Name: "build", Shell: "fish"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when unsupported step shell")
+	}
+
+	p.Platform.OS = "windows"
+	p.Steps = []*Step{{Name: "build", Shell: "zsh"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when posix step shell on windows")
+	}
+}
+
+func TestLint_PipelineShell(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Shell = "pwsh"
+	p.Steps = []*Step{{Name: "build"}, {Name: "test", Shell: "bash"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+	if got, want := p.GetShell(p.Steps[0]), "pwsh"; got != want {
+		t.Errorf("Want pipeline shell %s, got %s", want, got)
+	}
+	if got, want := p.GetShell(p.Steps[1]), "bash"; got != want {
+		t.Errorf("Want step shell %s, got %s", want, got)
+	}
+
+	p.Steps = []*Step{{Name: "build", Umask: "022"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when step umask with pipeline pwsh")
+	}
+
+	p.Shell = "fish"
+	p.Steps = []*Step{{Name: "build"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when unsupported pipeline shell")
+	}
+}
+
+func TestLint_Dependencies(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{
+		{Name: "build", DependsOn: []string{"clone"}},
+		{Name: "test", DependsOn: []string{"build"}},
+		{Name: "deploy", DependsOn: []string{"build", "test"}},
+	}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	tests := []struct {
+		steps   []*Step
+		message string
+	}{
+		{
+			steps:   []*Step{{Name: "build", DependsOn: []string{"build"}}},
+			message: "step cannot depend on itself",
+		},
+		{
+			steps:   []*Step{{Name: "build"}, {Name: "test", DependsOn: []string{"biuld"}}},
+			message: `step depends on unknown step "biuld"`,
+		},
+		{
+			steps: []*Step{
+				{Name: "build", DependsOn: []string{"deploy"}},
+				{Name: "test", DependsOn: []string{"build"}},
+				{Name: "deploy", DependsOn: []string{"test"}},
+			},
+			message: "step dependency cycle build -> deploy -> test -> build",
+		},
+	}
+	for _, test := range tests {
+		p.Steps = test.steps
+		err, ok := lint(p).(*LintError)
+		if !ok {
+			t.Errorf("Expect lint error %q", test.message)
+			continue
+		}
+		if got, want := err.Message, test.message; got != want {
+			t.Errorf("Want lint error %q, got %q", want, got)
+		}
+	}
+}
+
+func TestLint_Groups(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{
+		{Name: "backend", Group: "build"},
+		{Name: "frontend", Group: "build"},
+		{Name: "test"},
+	}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[2].DependsOn = []string{"backend"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when step groups with depends_on")
+	}
+
+	p.Steps[2].DependsOn = nil
+	p.Batch = true
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when step groups in batch mode")
+	}
+}
+
+func TestLint_Ready(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	tests := []struct {
+		ready *Ready
+		valid bool
+	}{
+		{ready: nil, valid: true},
+		{ready: &Ready{Port: 5432}, valid: true},
+		{ready: &Ready{Command: "pg_isready", Timeout: "2m", Interval: "500ms"}, valid: true},
+		{ready: &Ready{}, valid: false},
+		{ready: &Ready{Port: 5432, Command: "pg_isready"}, valid: false},
+		{ready: &Ready{Port: 70000}, valid: false},
+		{ready: &Ready{Port: 5432, Timeout: "forever"}, valid: false},
+		{ready: &Ready{Port: 5432, Interval: "-1s"}, valid: false},
+	}
+	for i, test := range tests {
+		p.Steps = []*Step{{Name: "database", Detach: true, Ready: test.ready}, {Name: "test"}}
+		if err := lint(p); (err == nil) != test.valid {
+			t.Errorf("Want readiness probe %d valid %v, got error %v", i, test.valid, err)
+		}
+	}
+
+	p.Batch = true
+	p.Steps = []*Step{{Name: "database", Detach: true}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when detached step in batch mode")
+	}
+}
+
+func TestLint_Tunnels(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	tests := []struct {
+		tunnel *Tunnel
+		valid  bool
+	}{
+		{tunnel: &Tunnel{Local: "15432", Remote: "5432"}, valid: true},
+		{tunnel: &Tunnel{Direction: "local", Local: "0.0.0.0:15432", Remote: "db.internal:5432"}, valid: true},
+		{tunnel: &Tunnel{Direction: "remote", Local: "3128", Remote: "127.0.0.1:3128"}, valid: true},
+		{tunnel: &Tunnel{Local: "[::1]:15432", Remote: "5432"}, valid: true},
+		{tunnel: &Tunnel{Direction: "dynamic", Local: "1080", Remote: "1080"}, valid: false},
+		{tunnel: &Tunnel{Remote: "5432"}, valid: false},
+		{tunnel: &Tunnel{Local: "15432"}, valid: false},
+		{tunnel: &Tunnel{Local: "localhost", Remote: "5432"}, valid: false},
+		{tunnel: &Tunnel{Local: "15432", Remote: "db.internal:postgres"}, valid: false},
+		{tunnel: &Tunnel{Local: "70000", Remote: "5432"}, valid: false},
+		{tunnel: nil, valid: false},
+	}
+	for i, test := range tests {
+		p.Tunnels = []*Tunnel{test.tunnel}
+		if err := lint(p); (err == nil) != test.valid {
+			t.Errorf("Want tunnel %d valid %v, got error %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestLint_Forwarding(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "deploy", ForwardAgent: true}, {Name: "test", ForwardX11: true}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error for forwarding, got %s", err)
+	}
+
+	p.Platform.OS = "windows"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for x11 forwarding on windows")
+	}
+	p.Platform.OS = ""
+
+	p.Server.Reconnect = 3
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for forwarding with server reconnect")
+	}
+	p.Server.Reconnect = 0
+
+	p.Steps = []*Step{{Name: "upload", ForwardAgent: true, Sync: &Sync{Direction: "upload", Source: "dist", Target: "dist"}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for forwarding in sync step")
+	}
+
+	p.Batch = true
+	p.Steps = []*Step{{Name: "deploy", ForwardAgent: true}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for forwarding in batch mode")
+	}
+}
+
+func TestLint_Settings(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "publish", Settings: map[string]*Variable{
+		"access_key": {Secret: "aws_access_key"},
+		"bucket":     {Value: "artifacts"},
+	}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].Settings["access-key"] = &Variable{Secret: "aws_access_key"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid setting name")
+	}
+}
+
+func TestLint_Vars(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Vars = map[string]string{"go_version": "1.21"}
+	p.Steps = []*Step{{
+		Name:        "build",
+		Commands:    []string{"go${vars.go_version} build"},
+		Environment: map[string]*Variable{"GOTOOLCHAIN": {Value: "go${vars.go_version}"}},
+	}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].Commands = []string{"GOOS=${vars.goos} go build"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for undefined variable")
+	}
+
+	p.Steps[0].Commands = nil
+	p.Vars["go version"] = "1.21"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid variable name")
+	}
+}
+
+func TestLint_WorkingDir(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Workspace.Path = "src/${DRONE_REPO}"
+	p.Steps = []*Step{{Name: "build", WorkingDir: "cmd/${DRONE_BRANCH}"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Workspace.Path = "../src"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when workspace path escapes the workspace")
+	}
+
+	p.Workspace.Path = ""
+	p.Steps = []*Step{{Name: "build", WorkingDir: `cmd\..\..`}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when working directory references a parent directory")
+	}
+}
+
+func TestLint_Permissions(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Permissions = Permissions{Workspace: "0750", Directories: "0700"}
+	p.Steps = []*Step{{Name: "build", Umask: "027"}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Permissions.Workspace = "0999"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when invalid workspace mode")
+	}
+
+	p.Permissions.Workspace = ""
+	p.Steps = []*Step{{Name: "build", Umask: "rwx"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when invalid umask")
+	}
+}
+
+func TestLint_Requires(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Requires = []string{"git", "docker", "go>=1.21"}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Requires = []string{"go>=latest"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when invalid requirement")
+	}
+}
+
+func TestLint_DockerAuth(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.DockerAuth = []*DockerAuth{{
+		Username: manifest.Variable{Value: "octocat"},
+		Password: manifest.Variable{Secret: "docker_password"},
+	}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.DockerAuth[0].Password = manifest.Variable{}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error when missing docker password")
+	}
+}
+
+func TestLint_Renewal(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	for _, s := range []string{"", "30s", "15m"} {
+		p.Renewal = s
+		if err := lint(p); err != nil {
+			t.Errorf("Expect no lint error for renewal %q, got %s", s, err)
+		}
+	}
+	for _, s := range []string{"15", "0s", "-1m"} {
+		p.Renewal = s
+		if err := lint(p); err == nil {
+			t.Errorf("Expect lint error for renewal %q", s)
+		}
+	}
+}
+
+func TestLint_Files(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Files = []*File{{Path: "/etc/app.conf", Mode: "0644"}}
+	p.Steps = []*Step{{Name: "build", Files: []*File{{Path: "config/app.conf"}}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Files[0].Mode = "999"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid file mode")
+	}
+
+	p.Files[0].Mode = ""
+	p.Steps[0].Files[0].Path = ""
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for missing step file path")
+	}
+}
+
+func TestLint_Sync(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "fetch", Sync: &Sync{Direction: SyncDownload, Source: "dist/*.tar.gz", Target: "artifacts"}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].Sync.Direction = "sideways"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid sync direction")
+	}
+
+	p.Steps[0].Sync.Direction = SyncUpload
+	p.Steps[0].Sync.Target = ""
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for missing sync target")
+	}
+
+	p.Steps[0].Sync.Target = "../fixtures"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for sync path with parent directory")
+	}
+
+	p.Steps[0].Sync.Source = "/etc/*"
+	p.Steps[0].Sync.Target = "fixtures"
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for absolute sync local path")
+	}
+
+	p.Steps[0].Sync.Source = "testdata/*"
+	p.Steps[0].Commands = []string{"make"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for sync step with commands")
+	}
+
+	p.Steps[0].Commands = nil
+	p.Batch = true
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for sync step in batch mode")
+	}
+}
+
+func TestLint_Matrix(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "test", Matrix: map[string][]string{"GO_VERSION": {"1.20", "1.21"}}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].Matrix = map[string][]string{"GO-VERSION": {"1.20"}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid matrix axis name")
+	}
+
+	p.Steps[0].Matrix = map[string][]string{"GO_VERSION": {}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for empty matrix axis")
+	}
+
+	p.Steps[0].Matrix = map[string][]string{"GO_VERSION": {"1.20", "1.21"}}
+	p.Steps[0].When.Matrix = map[string]manifest.Condition{"GO_VERSION": {Include: []string{"1.21"}}}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].When.Matrix = map[string]manifest.Condition{"GOARCH": {Include: []string{"amd64"}}}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for undefined matrix condition axis")
+	}
+}
+
+func TestLint_Status(t *testing.T) {
+	p := new(Pipeline)
+	p.Server = Server{
+		Host:     manifest.Variable{Value: "localhost"},
+		User:     manifest.Variable{Value: "root"},
+		Password: manifest.Variable{Value: "root"},
+	}
+	p.Steps = []*Step{{Name: "notify"}}
+	p.Steps[0].When.Status.Include = []string{"success", "failure"}
+	if err := lint(p); err != nil {
+		t.Errorf("Expect no lint error, got %s", err)
+	}
+
+	p.Steps[0].When.Status.Include = []string{"failed"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid status condition")
+	}
+
+	p.Steps[0].When.Status.Include = nil
+	p.Steps[0].When.Status.Exclude = []string{"passing"}
+	if err := lint(p); err == nil {
+		t.Errorf("Expect lint error for invalid status condition")
+	}
+}
@@ -13,51 +13,345 @@ var (
 	_ manifest.PlatformResource  = (*Pipeline)(nil)
 )
 
+// Defines the netrc modes.
+const (
+	NetrcDisabled  = "disabled"
+	NetrcCloneOnly = "clone-only"
+)
+
 // Defines the Resource Kind and Type.
 const (
 	Kind = "pipeline"
 	Type = "ssh"
 )
 
+// Defines the step failure actions.
+const (
+	FailureIgnore = "ignore"
+	FailureSkip   = "skip"
+)
+
+// Defines the sync step directions.
+const (
+	SyncUpload   = "upload"
+	SyncDownload = "download"
+)
+
+// Defines the tunnel directions.
+const (
+	TunnelLocal  = "local"
+	TunnelRemote = "remote"
+)
+
+// KindServerDefaults defines the server defaults Resource Kind.
+const KindServerDefaults = "server_defaults"
+
 type (
 	// Pipeline is a pipeline resource that executes pipelines
 	// on the host machine without any virtualization.
 	Pipeline struct {
-		Version   string              `json:"version,omitempty"`
-		Kind      string              `json:"kind,omitempty"`
-		Type      string              `json:"type,omitempty"`
-		Name      string              `json:"name,omitempty"`
-		Deps      []string            `json:"depends_on,omitempty"`
-		Server    Server              `json:"server,omitempty"`
-		Clone     manifest.Clone      `json:"clone,omitempty"`
-		Platform  manifest.Platform   `json:"platform,omitempty"`
-		Trigger   manifest.Conditions `json:"conditions,omitempty"`
-		Workspace manifest.Workspace  `json:"workspace,omitempty"`
+		Version     string              `json:"version,omitempty"`
+		Kind        string              `json:"kind,omitempty"`
+		Type        string              `json:"type,omitempty"`
+		Name        string              `json:"name,omitempty"`
+		Deps        []string            `json:"depends_on,omitempty"`
+		Server      Server              `json:"server,omitempty"`
+		Clone       manifest.Clone      `json:"clone,omitempty"`
+		Platform    manifest.Platform   `json:"platform,omitempty"`
+		Trigger     manifest.Conditions `json:"conditions,omitempty"`
+		Workspace   Workspace           `json:"workspace,omitempty"`
+		Netrc       Netrc               `json:"netrc,omitempty"`
+		Path        []string            `json:"path,omitempty"`
+		Node        map[string]string   `json:"node,omitempty"`
+		Debug       bool                `json:"debug,omitempty"`
+		Encoding    string              `json:"env_encoding,omitempty" yaml:"env_encoding"`
+		Locale      string              `json:"locale,omitempty"`
+		Timestamps  string              `json:"timestamps,omitempty"`
+		Batch       bool                `json:"batch,omitempty"`
+		Compress    bool                `json:"compress,omitempty"`
+		Verify      bool                `json:"verify,omitempty"`
+		CleanEnv    bool                `json:"clean_env,omitempty" yaml:"clean_env"`
+		Permissions Permissions         `json:"permissions,omitempty"`
+		Requires    []string            `json:"requires,omitempty"`
+		DockerAuth  []*DockerAuth       `json:"docker_auth,omitempty" yaml:"docker_auth"`
+		Renewal     string              `json:"secret_renewal,omitempty" yaml:"secret_renewal"`
+		Files       []*File             `json:"files,omitempty"`
+		Shell       string              `json:"shell,omitempty"`
+		Tunnels     []*Tunnel           `json:"tunnel,omitempty" yaml:"tunnel"`
+		Vars        map[string]string   `json:"vars,omitempty"`
 
-		Steps []*Step `json:"steps,omitempty"`
+		Setup    []string `json:"setup,omitempty"`
+		Teardown []string `json:"teardown,omitempty"`
+		Steps    []*Step  `json:"steps,omitempty"`
+	}
+
+	// ServerDefaults is a resource that defines default server
+	// values that can be referenced by pipelines in the same
+	// configuration file.
+	ServerDefaults struct {
+		Version string `json:"version,omitempty"`
+		Kind    string `json:"kind,omitempty"`
+		Type    string `json:"type,omitempty"`
+		Name    string `json:"name,omitempty"`
+		Server  Server `json:"server,omitempty"`
 	}
 
 	// Server defines a remote server.
 	Server struct {
-		Host     manifest.Variable `json:"host,omitempty"`
-		User     manifest.Variable `json:"user,omitempty"`
+		Host      manifest.Variable `json:"host,omitempty"`
+		Port      manifest.Variable `json:"port,omitempty"`
+		User      manifest.Variable `json:"user,omitempty"`
+		Password  manifest.Variable `json:"password,omitempty"`
+		SSHKey    manifest.Variable `json:"ssh_key,omitempty" yaml:"ssh_key"`
+		Reconnect int               `json:"reconnect,omitempty"`
+		Defaults  string            `json:"defaults,omitempty"`
+
+		// Ciphers, KeyExchanges and MACs restrict the ssh
+		// algorithms used to connect to the server.
+		Ciphers      []string `json:"ciphers,omitempty"`
+		KeyExchanges []string `json:"key_exchanges,omitempty" yaml:"key_exchanges"`
+		MACs         []string `json:"macs,omitempty"`
+
+		// Agent authenticates using the ssh agent of the runner
+		// host, which supports hardware-backed security keys.
+		Agent bool `json:"agent,omitempty"`
+
+		// MaxSessions limits the number of concurrent sessions
+		// opened over a single connection shared by the steps.
+		// If zero, each step opens its own connection.
+		MaxSessions int `json:"max_sessions,omitempty" yaml:"max_sessions"`
+
+		// Tunnel connects through a cloud bastion service, such
+		// as aws-ssm or gcp-iap, in which case the host is the
+		// instance identifier.
+		Tunnel string `json:"tunnel,omitempty"`
+
+		// Zone defines the zone of the instance, which is
+		// required by gcp-iap if the project does not define
+		// a default zone.
+		Zone string `json:"zone,omitempty"`
+
+		// Pool selects a named pool of hosts configured on the
+		// runner, which provides the host and credentials.
+		Pool string `json:"pool,omitempty"`
+
+		// Sticky assigns consecutive builds of the same
+		// repository, branch and stage to the same pool host,
+		// so that caches on the host remain warm.
+		Sticky bool `json:"sticky,omitempty"`
+
+		// Provision requests an ephemeral host from the runner
+		// provisioning hook, which provides the host and
+		// credentials, and removes the host when the stage
+		// completes.
+		Provision bool `json:"provision,omitempty"`
+	}
+
+	// Netrc configures the netrc file.
+	Netrc struct {
+		Mode     string          `json:"mode,omitempty"`
+		Machines []*NetrcMachine `json:"machines,omitempty"`
+	}
+
+	// NetrcMachine defines additional machine credentials that
+	// are appended to the netrc file.
+	NetrcMachine struct {
+		Machine  string            `json:"machine,omitempty"`
+		Login    manifest.Variable `json:"login,omitempty"`
 		Password manifest.Variable `json:"password,omitempty"`
-		SSHKey   manifest.Variable `json:"ssh_key,omitempty" yaml:"ssh_key"`
+	}
+
+	// DockerAuth defines docker registry credentials that are
+	// written to the docker configuration file in the home
+	// directory.
+	DockerAuth struct {
+		Registry string            `json:"registry,omitempty"`
+		Username manifest.Variable `json:"username,omitempty"`
+		Password manifest.Variable `json:"password,omitempty"`
+	}
+
+	// File defines a file uploaded to the remote server before
+	// pipeline execution. The file content may be loaded from
+	// a secret. Relative paths are resolved against the
+	// workspace directory.
+	File struct {
+		Path    string            `json:"path,omitempty"`
+		Mode    string            `json:"mode,omitempty"`
+		Content manifest.Variable `json:"content,omitempty"`
+	}
+
+	// Tunnel defines an ssh port forward that is open for the
+	// duration of the pipeline. A local tunnel forwards
+	// connections to the local address on the runner host to
+	// the remote address, dialed from the remote server. A
+	// remote tunnel forwards connections to the remote address
+	// on the remote server to the local address, dialed from
+	// the runner host. An address without a host is bound to
+	// the loopback interface.
+	Tunnel struct {
+		Direction string `json:"direction,omitempty"`
+		Local     string `json:"local,omitempty"`
+		Remote    string `json:"remote,omitempty"`
+	}
+
+	// Permissions defines the octal file modes of the
+	// workspace directory and the directories created in
+	// the workspace. The group owns the workspace directories
+	// and is shared by the ssh user and the step users.
+	Permissions struct {
+		Workspace   string `json:"workspace,omitempty"`
+		Directories string `json:"directories,omitempty"`
+		Group       string `json:"group,omitempty"`
+	}
+
+	// Workspace configures the pipeline workspace. The base
+	// directory overrides the temporary directory in which the
+	// workspace is created on the remote server. The path
+	// overrides the source directory, relative to the
+	// workspace.
+	Workspace struct {
+		Base string `json:"base,omitempty"`
+		Path string `json:"path,omitempty"`
+	}
+
+	// Failure defines the step failure policy. The policy may
+	// apply to all non-zero exit codes, or only to the listed
+	// exit codes.
+	Failure struct {
+		Action    string `json:"action,omitempty"`
+		ExitCodes []int  `json:"exit_codes,omitempty" yaml:"exit_codes"`
+	}
+
+	// Variable defines a step environment variable, which may
+	// be loaded from a secret. If the secret is optional the
+	// step runs with an empty value when the secret is not
+	// found.
+	Variable struct {
+		Value    string `json:"value,omitempty"`
+		Secret   string `json:"from_secret,omitempty" yaml:"from_secret"`
+		Optional bool   `json:"optional,omitempty"`
 	}
 
 	// Step defines a Pipeline step.
 	Step struct {
-		Name        string                        `json:"name,omitempty"`
-		Shell       string                        `json:"shell,omitempty"`
-		DependsOn   []string                      `json:"depends_on,omitempty" yaml:"depends_on"`
-		Detach      bool                          `json:"detach,omitempty"`
-		Environment map[string]*manifest.Variable `json:"environment,omitempty"`
-		Failure     string                        `json:"failure,omitempty"`
-		Commands    []string                      `json:"commands,omitempty"`
-		When        manifest.Conditions           `json:"when,omitempty"`
+		Name        string               `json:"name,omitempty"`
+		Shell       string               `json:"shell,omitempty"`
+		DependsOn   []string             `json:"depends_on,omitempty" yaml:"depends_on"`
+		Group       string               `json:"group,omitempty"`
+		Detach      bool                 `json:"detach,omitempty"`
+		Ready       *Ready               `json:"ready,omitempty"`
+		Environment map[string]*Variable `json:"environment,omitempty"`
+		Settings    map[string]*Variable `json:"settings,omitempty"`
+		Failure     Failure              `json:"failure,omitempty"`
+		Commands    []string             `json:"commands,omitempty"`
+		User        string               `json:"user,omitempty"`
+		When        Conditions           `json:"when,omitempty"`
+		Errexit     *bool                `json:"errexit,omitempty"`
+		Pipefail    bool                 `json:"pipefail,omitempty"`
+		Trace       *bool                `json:"trace,omitempty"`
+		Term        string               `json:"term,omitempty"`
+		Color       *bool                `json:"color,omitempty"`
+		Umask       string               `json:"umask,omitempty"`
+		WorkingDir  string               `json:"working_dir,omitempty" yaml:"working_dir"`
+		Files       []*File              `json:"files,omitempty"`
+		Sync        *Sync                `json:"sync,omitempty"`
+		Matrix      map[string][]string  `json:"matrix,omitempty"`
+
+		// Axis provides the matrix axis values of a step
+		// expanded from a matrix. It is set by the compiler
+		// and is never parsed from the configuration.
+		Axis map[string]string `json:"-" yaml:"-"`
+
+		// ForwardAgent forwards the ssh agent of the runner
+		// host, and ForwardX11 forwards the x11 display of the
+		// runner host, to the step ssh session.
+		ForwardAgent bool `json:"forward_agent,omitempty" yaml:"forward_agent"`
+		ForwardX11   bool `json:"forward_x11,omitempty" yaml:"forward_x11"`
+	}
+
+	// Sync defines a built-in step that copies the files
+	// matching the source pattern between the runner host and
+	// the remote server. Relative remote paths are resolved
+	// against the workspace directory.
+	Sync struct {
+		Direction string `json:"direction,omitempty"`
+		Source    string `json:"source,omitempty"`
+		Target    string `json:"target,omitempty"`
+	}
+
+	// Ready defines the readiness probe of a detached step.
+	// The probe succeeds when the port accepts connections on
+	// the remote server, or when the command exits with a zero
+	// exit code. Subsequent steps are not started until the
+	// probe succeeds.
+	Ready struct {
+		Port     int    `json:"port,omitempty"`
+		Command  string `json:"command,omitempty"`
+		Timeout  string `json:"timeout,omitempty"`
+		Interval string `json:"interval,omitempty"`
 	}
 )
 
+// UnmarshalYAML implements yaml unmarshalling. The netrc may
+// be configured as a boolean, a mode, or a list of additional
+// machines.
+func (n *Netrc) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var b bool
+	if err := unmarshal(&b); err == nil {
+		if !b {
+			n.Mode = NetrcDisabled
+		}
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err == nil {
+		n.Mode = s
+		return nil
+	}
+	var machines []*NetrcMachine
+	if err := unmarshal(&machines); err == nil {
+		n.Machines = machines
+		return nil
+	}
+	type netrc Netrc
+	out := new(netrc)
+	err := unmarshal(out)
+	*n = Netrc(*out)
+	return err
+}
+
+// UnmarshalYAML implements yaml unmarshalling. The failure
+// policy may be configured as an action, or as an object with
+// an action and a list of exit codes.
+func (f *Failure) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		f.Action = s
+		return nil
+	}
+	type failure Failure
+	out := new(failure)
+	err := unmarshal(out)
+	*f = Failure(*out)
+	return err
+}
+
+// UnmarshalYAML implements yaml unmarshalling. The variable
+// may be configured as a value, or as an object that loads the
+// value from a secret.
+func (v *Variable) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		v.Value = s
+		return nil
+	}
+	type variable Variable
+	out := new(variable)
+	err := unmarshal(out)
+	*v = Variable(*out)
+	return err
+}
+
 // GetVersion returns the resource version.
 func (p *Pipeline) GetVersion() string { return p.Version }
 
@@ -79,6 +373,27 @@ func (p *Pipeline) GetTrigger() manifest.Conditions { return p.Trigger }
 // GetPlatform returns the resource platform.
 func (p *Pipeline) GetPlatform() manifest.Platform { return p.Platform }
 
+// GetVersion returns the resource version.
+func (d *ServerDefaults) GetVersion() string { return d.Version }
+
+// GetKind returns the resource kind.
+func (d *ServerDefaults) GetKind() string { return d.Kind }
+
+// GetType returns the resource type.
+func (d *ServerDefaults) GetType() string { return d.Type }
+
+// GetName returns the resource name.
+func (d *ServerDefaults) GetName() string { return d.Name }
+
+// GetShell returns the shell of the step, or the pipeline
+// shell if the step does not override it.
+func (p *Pipeline) GetShell(step *Step) string {
+	if step.Shell != "" {
+		return step.Shell
+	}
+	return p.Shell
+}
+
 // GetStep returns the named step. If no step exists with the
 // given name, a nil value is returned.
 func (p *Pipeline) GetStep(name string) *Step {
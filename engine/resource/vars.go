@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/drone/envsubst"
+)
+
+// varName matches a valid pipeline variable name.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// varRef matches a ${vars.name} pipeline variable reference.
+var varRef = regexp.MustCompile(`\$\{vars\.([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// varEscape matches a ${vars.name} pipeline variable reference
+// preceded by any number of dollar signs.
+var varEscape = regexp.MustCompile(`\$+\{vars\.[A-Za-z_][A-Za-z0-9_-]*\}`)
+
+// Substitute evaluates the string replacement expressions in
+// the configuration file. The ${vars.name} references are not
+// environment variables, and are escaped so that they are
+// expanded by the compiler with the pipeline variables.
+func Substitute(config string, subf func(string) string) (string, error) {
+	return envsubst.Eval(escapeVars(config), subf)
+}
+
+// helper function escapes the ${vars.name} references in the
+// string. References that are already escaped with an even
+// number of dollar signs are not modified.
+func escapeVars(s string) string {
+	return varEscape.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.Index(ref, "{")%2 == 1 {
+			return "$" + ref
+		}
+		return ref
+	})
+}
+
+// ExpandVars replaces the ${vars.name} references in the
+// string with the pipeline variables. References to undefined
+// variables are not modified.
+func ExpandVars(s string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return s
+	}
+	return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := vars[varRef.FindStringSubmatch(ref)[1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// helper function returns the name of the first variable
+// referenced in the strings that is not defined, or an empty
+// string if all referenced variables are defined.
+func undefinedVar(vars map[string]string, values ...string) string {
+	for _, s := range values {
+		for _, match := range varRef.FindAllStringSubmatch(s, -1) {
+			if _, ok := vars[match[1]]; !ok {
+				return match[1]
+			}
+		}
+	}
+	return ""
+}
+
+// helper function returns the strings of the step that may
+// reference pipeline variables.
+func stepValues(step *Step) []string {
+	values := append([]string{step.WorkingDir}, step.Commands...)
+	for _, v := range step.Environment {
+		if v != nil {
+			values = append(values, v.Value)
+		}
+	}
+	for _, v := range step.Settings {
+		if v != nil {
+			values = append(values, v.Value)
+		}
+	}
+	for _, axis := range step.Matrix {
+		values = append(values, axis...)
+	}
+	return values
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"go_version": "1.21",
+		"image-tag":  "latest",
+	}
+	tests := []struct {
+		s, want string
+	}{
+		{"go${vars.go_version}", "go1.21"},
+		{"${vars.go_version}-${vars.image-tag}", "1.21-latest"},
+		{"${vars.undefined}", "${vars.undefined}"},
+		{"${go_version}", "${go_version}"},
+		{"$vars.go_version", "$vars.go_version"},
+		{"go build", "go build"},
+	}
+	for _, test := range tests {
+		if got := ExpandVars(test.s, vars); got != test.want {
+			t.Errorf("Want %q expanded to %q, got %q", test.s, test.want, got)
+		}
+	}
+	if got, want := ExpandVars("${vars.go_version}", nil), "${vars.go_version}"; got != want {
+		t.Errorf("Want %q unchanged without variables, got %q", want, got)
+	}
+}
+
+func TestUndefinedVar(t *testing.T) {
+	vars := map[string]string{"go_version": "1.21"}
+	if name := undefinedVar(vars, "go${vars.go_version}", "go build"); name != "" {
+		t.Errorf("Want no undefined variable, got %s", name)
+	}
+	if name := undefinedVar(vars, "go${vars.go_version}", "${vars.goos}"); name != "goos" {
+		t.Errorf("Want undefined variable goos, got %q", name)
+	}
+	if name := undefinedVar(nil, "${vars.go_version}"); name != "go_version" {
+		t.Errorf("Want undefined variable go_version, got %q", name)
+	}
+}
+
+// This test verifies that the pipeline variable references
+// are not evaluated as environment variables when the string
+// replacement expressions are evaluated, and are expanded
+// with the pipeline variables once the configuration is parsed.
+func TestSubstitute(t *testing.T) {
+	envs := map[string]string{"DRONE_BRANCH": "main"}
+	subf := func(k string) string { return envs[k] }
+
+	config := `
+kind: pipeline
+type: ssh
+name: default
+
+vars:
+  go_version: "1.21"
+
+steps:
+- name: build
+  commands:
+  - echo ${DRONE_BRANCH} go${vars.go_version} $${vars.go_version}
+`
+	config, err := Substitute(config, subf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	m, err := manifest.ParseString(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pipeline, err := Lookup("default", m)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got := ExpandVars(pipeline.Steps[0].Commands[0], pipeline.Vars)
+	if want := "echo main go1.21 1.21"; got != want {
+		t.Errorf("Want command %q, got %q", want, got)
+	}
+}
+
+func TestEscapeVars(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"${vars.go_version}", "$${vars.go_version}"},
+		{"$${vars.go_version}", "$${vars.go_version}"},
+		{"$$${vars.go_version}", "$$$${vars.go_version}"},
+		{"${DRONE_BRANCH}", "${DRONE_BRANCH}"},
+	}
+	for _, test := range tests {
+		if got := escapeVars(test.s); got != test.want {
+			t.Errorf("Want %q escaped to %q, got %q", test.s, test.want, got)
+		}
+	}
+}
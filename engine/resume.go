@@ -0,0 +1,231 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// interval at which the remote step log file is polled for
+// new output.
+var pollInterval = time.Second
+
+// helper function runs the pipeline step detached from the ssh
+// session. The step output is written to a log file on the remote
+// server that is streamed to the output writer. If the connection
+// is lost the engine reconnects and resumes streaming from the
+// last received offset, up to the configured number of attempts.
+func runResumable(ctx context.Context, client *ssh.Client, clientftp *sftp.Client, spec *Spec, step *Step, output io.Writer) (*State, error) {
+	log := logger.FromContext(ctx)
+
+	base := resumePath(spec, step)
+	logfile, exitfile := base+".log", base+".exit"
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	cmd := detachCommand(execCommand(spec, step), logfile, exitfile)
+	auditCommand(spec, cmd)
+	out, err := session.Output(cmd)
+	session.Close()
+	if err != nil {
+		return nil, err
+	}
+	pid := strings.TrimSpace(string(out))
+
+	log = log.WithField("ssh.pid", pid)
+	log.Debug("ssh process detached")
+
+	// the original connection is owned by the caller. connections
+	// established when reconnecting are closed on return.
+	original := client
+	defer func() {
+		if client != nil && client != original {
+			clientftp.Close()
+			closeClient(spec, client)
+		}
+	}()
+
+	var offset int64
+	var attempts int
+	for {
+		if client == nil {
+			client, clientftp, err = reconnect(spec)
+			if err == nil {
+				writeReason(output, "ssh connection restored")
+			}
+		}
+
+		var code int
+		var exited bool
+		if err == nil {
+			var n int64
+			n, code, exited, err = follow(clientftp, logfile, exitfile, offset, output)
+			offset += n
+		}
+
+		switch {
+		case err == nil && exited:
+			log.WithField("ssh.exit", code).
+				Debug("ssh process finished")
+			return &State{ExitCode: code, Exited: true}, nil
+		case err == nil:
+			attempts = 0
+		default:
+			// the original connection is owned by the caller,
+			// and may be shared with other steps, and is
+			// therefore left open. the engine reconnects using
+			// a separate connection.
+			if client != nil && client != original {
+				clientftp.Close()
+				closeClient(spec, client)
+			}
+			clientftp, client = nil, nil
+			if attempts >= spec.Server.Reconnect {
+				return nil, fmt.Errorf("%w: %s", ErrConnectionLost, err)
+			}
+			attempts++
+
+			log.WithError(err).
+				WithField("attempt", attempts).
+				Warn("ssh connection lost, reconnecting")
+		}
+
+		select {
+		case <-ctx.Done():
+			if client != nil {
+				killDetached(client, spec, pid)
+			}
+			log.Debug("ssh process killed")
+			if ctx.Err() == context.DeadlineExceeded {
+				writeReason(output, "step timed out")
+			} else {
+				writeReason(output, "step cancelled")
+			}
+			return nil, ctx.Err()
+		case <-time.After(pollInterval * time.Duration(attempts+1)):
+		}
+	}
+}
+
+// helper function copies new output from the remote log file to
+// the writer and checks whether the remote process has exited.
+func follow(client *sftp.Client, logfile, exitfile string, offset int64, w io.Writer) (int64, int, bool, error) {
+	n, err := copyFrom(client, logfile, offset, w)
+	if err != nil {
+		return n, 0, false, err
+	}
+	code, exited, err := readExit(client, exitfile)
+	if err != nil || !exited {
+		return n, code, exited, err
+	}
+	// the process has exited. copy any output written after
+	// the previous read before returning.
+	m, err := copyFrom(client, logfile, offset+n, w)
+	return n + m, code, exited, err
+}
+
+// helper function dials the remote server and opens an sftp
+// session.
+func reconnect(spec *Spec) (*ssh.Client, *sftp.Client, error) {
+	client, err := dial(spec.Server)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientftp, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	auditOpen(spec)
+	return client, clientftp, nil
+}
+
+// helper function copies the remote file, beginning at the
+// offset, to the writer.
+func copyFrom(client *sftp.Client, path string, offset int64, w io.Writer) (int64, error) {
+	f, err := client.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, f)
+}
+
+// helper function reads the exit code from the remote file. If
+// the file does not exist the process has not yet exited.
+func readExit(client *sftp.Client, path string) (int, bool, error) {
+	f, err := client.Open(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(f); err != nil {
+		return 0, false, err
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(buf.String()))
+	if err != nil {
+		return 0, false, err
+	}
+	return exitCode(code), true, nil
+}
+
+// helper function terminates the detached process.
+func killDetached(client *ssh.Client, spec *Spec, pid string) {
+	session, err := client.NewSession()
+	if err != nil {
+		return
+	}
+	defer session.Close()
+	cmd := fmt.Sprintf("pkill -KILL -P %s; kill -KILL %s", pid, pid)
+	auditCommand(spec, cmd)
+	session.Run(cmd)
+}
+
+// helper function returns the base path of the files used to
+// store the output and exit code of a detached step.
+func resumePath(spec *Spec, step *Step) string {
+	return joinPath(spec.Platform.OS, spec.Root, "opt",
+		fmt.Sprintf("%x", sha1.Sum([]byte(step.Name))))
+}
+
+// helper function returns a shell command that executes the
+// command detached from the ssh session, writing the output and
+// exit code to files, and prints the process id.
+func detachCommand(cmd, logfile, exitfile string) string {
+	script := fmt.Sprintf("%s > %s 2>&1; echo $? > %s.tmp && mv %s.tmp %s",
+		cmd, logfile, exitfile, exitfile, exitfile)
+	return fmt.Sprintf("nohup /bin/sh -c %s > /dev/null 2>&1 & echo $!", shellQuote(script))
+}
+
+// helper function quotes the string for use in a posix shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
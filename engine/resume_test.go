@@ -0,0 +1,33 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestDetachCommand(t *testing.T) {
+	got := detachCommand("/bin/sh -e /tmp/drone-temp/opt/build", "/tmp/drone-temp/opt/build.log", "/tmp/drone-temp/opt/build.exit")
+	want := `nohup /bin/sh -c '/bin/sh -e /tmp/drone-temp/opt/build > /tmp/drone-temp/opt/build.log 2>&1; echo $? > /tmp/drone-temp/opt/build.exit.tmp && mv /tmp/drone-temp/opt/build.exit.tmp /tmp/drone-temp/opt/build.exit' > /dev/null 2>&1 & echo $!`
+	if got != want {
+		t.Errorf("Want detach command %q, got %q", want, got)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	got := shellQuote("echo 'hello world'")
+	want := `'echo '\''hello world'\'''`
+	if got != want {
+		t.Errorf("Want quoted string %q, got %q", want, got)
+	}
+}
+
+func TestResumePath(t *testing.T) {
+	spec := &Spec{Root: "/tmp/drone-temp"}
+	step := &Step{Name: "build"}
+	got := resumePath(spec, step)
+	want := "/tmp/drone-temp/opt/80754af91bfb6d1073585b046fe0a474ce868509"
+	if got != want {
+		t.Errorf("Want resume path %q, got %q", want, got)
+	}
+}
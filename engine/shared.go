@@ -0,0 +1,82 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/semaphore"
+)
+
+// stepSessions is the number of ssh sessions opened by each
+// pipeline step, one for the sftp subsystem and one for the
+// command.
+const stepSessions = 2
+
+// sharedClient is an ssh connection shared by the steps of a
+// pipeline. The number of concurrent sessions is limited to
+// respect the MaxSessions setting of the remote server.
+type sharedClient struct {
+	client *ssh.Client
+	sem    *semaphore.Weighted
+}
+
+// sharedClients tracks the shared ssh connections, keyed by
+// the pipeline specification.
+type sharedClients struct {
+	sync.Mutex
+	clients map[*Spec]*sharedClient
+}
+
+// helper function returns the ssh connection shared by the
+// pipeline steps, dialing the server if the connection is not
+// yet open.
+func (s *sharedClients) get(ctx context.Context, spec *Spec) (*sharedClient, error) {
+	s.Lock()
+	defer s.Unlock()
+	if c, ok := s.clients[spec]; ok {
+		return c, nil
+	}
+	client, err := dialServer(ctx, spec.Server)
+	if err != nil {
+		return nil, err
+	}
+	auditOpen(spec)
+	c := &sharedClient{
+		client: client,
+		sem:    semaphore.NewWeighted(int64(spec.Server.MaxSessions)),
+	}
+	if s.clients == nil {
+		s.clients = map[*Spec]*sharedClient{}
+	}
+	s.clients[spec] = c
+	return c, nil
+}
+
+// helper function closes the ssh connection shared by the
+// pipeline steps, if open. A subsequent step dials a new
+// connection.
+func (s *sharedClients) close(spec *Spec, c *sharedClient) {
+	s.Lock()
+	defer s.Unlock()
+	if c == nil {
+		c = s.clients[spec]
+	}
+	if c != nil && s.clients[spec] == c {
+		delete(s.clients, spec)
+		closeClient(spec, c.client)
+	}
+}
+
+// helper function returns true if the pipeline steps share
+// a single ssh connection. Resumable steps dial their own
+// connection so that a dropped connection is re-established
+// independently.
+func isShared(spec *Spec) bool {
+	return spec.Server.MaxSessions >= stepSessions &&
+		spec.Server.Reconnect == 0
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestIsShared(t *testing.T) {
+	tests := []struct {
+		sessions  int
+		reconnect int
+		want      bool
+	}{
+		{sessions: 0, want: false},
+		{sessions: 1, want: false},
+		{sessions: 2, want: true},
+		{sessions: 10, want: true},
+		{sessions: 10, reconnect: 3, want: false},
+	}
+	for _, test := range tests {
+		spec := new(Spec)
+		spec.Server.MaxSessions = test.sessions
+		spec.Server.Reconnect = test.reconnect
+		if got := isShared(spec); got != test.want {
+			t.Errorf("Want shared %v for max sessions %d and reconnect %d",
+				test.want, test.sessions, test.reconnect)
+		}
+	}
+}
+
+func TestSharedClients_Close(t *testing.T) {
+	// closing a connection that was never opened is a no-op.
+	shared := new(sharedClients)
+	shared.close(new(Spec), nil)
+	if len(shared.clients) != 0 {
+		t.Errorf("Want no shared clients")
+	}
+}
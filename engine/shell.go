@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Shells used to execute powershell scripts. Powershell core
+// (pwsh) may also be used on posix servers.
+const (
+	ShellPowershell = "powershell"
+	ShellPwsh       = "pwsh"
+)
+
+// helper function returns the powershell executable available
+// on the windows server. Windows OpenSSH may be configured with
+// cmd or pwsh as the default shell, and Windows PowerShell is
+// not installed on all editions, such as Nano Server. Windows
+// PowerShell is preferred if both are installed.
+func detectShell(client *ssh.Client, spec *Spec) string {
+	for _, shell := range []string{ShellPowershell, ShellPwsh} {
+		session, err := client.NewSession()
+		if err != nil {
+			return ShellPowershell
+		}
+		cmd := shell + " -noprofile -noninteractive -command exit 0"
+		auditCommand(spec, cmd)
+		err = session.Run(cmd)
+		session.Close()
+		if err == nil {
+			return shell
+		}
+	}
+	return ShellPowershell
+}
+
+// helper function returns the command, replacing the windows
+// powershell executable with the detected shell.
+func withShell(shell, cmd string) string {
+	if shell == "" || shell == ShellPowershell {
+		return cmd
+	}
+	if strings.HasPrefix(cmd, ShellPowershell+" ") {
+		return shell + strings.TrimPrefix(cmd, ShellPowershell)
+	}
+	return cmd
+}
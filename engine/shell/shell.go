@@ -0,0 +1,81 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package shell defines the shell profiles used to execute
+// pipeline step scripts. Additional shells are supported by
+// registering a profile.
+package shell
+
+import (
+	"strings"
+	"sync"
+)
+
+// Script dialects. The dialect determines the syntax of the
+// generated script, including the syntax used to export
+// environment variables.
+const (
+	Posix      = "posix"
+	Powershell = "powershell"
+)
+
+// Profile defines how a shell executes a step script.
+type Profile struct {
+	// Name is the name of the shell, used to select the
+	// shell in the pipeline step.
+	Name string
+
+	// Command is the shell executable.
+	Command string
+
+	// Args are passed to the shell before the script path,
+	// and configure the shell to exit on error.
+	Args []string
+
+	// Dialect is the script dialect understood by the shell.
+	Dialect string
+}
+
+var (
+	mu       sync.RWMutex
+	profiles = map[string]*Profile{}
+)
+
+func init() {
+	Register(&Profile{Name: "sh", Command: "/bin/sh", Args: []string{"-e"}, Dialect: Posix})
+	Register(&Profile{Name: "bash", Command: "bash", Args: []string{"-e"}, Dialect: Posix})
+	Register(&Profile{Name: "zsh", Command: "zsh", Args: []string{"-e"}, Dialect: Posix})
+	Register(&Profile{Name: "ksh", Command: "ksh", Args: []string{"-e"}, Dialect: Posix})
+	Register(&Profile{Name: "pwsh", Command: "pwsh", Args: []string{"-noprofile", "-noninteractive", "-command"}, Dialect: Powershell})
+}
+
+// Register registers the shell profile, replacing any profile
+// registered with the same name.
+func Register(profile *Profile) {
+	mu.Lock()
+	profiles[profile.Name] = profile
+	mu.Unlock()
+}
+
+// Lookup returns the shell profile by name. If the name is an
+// absolute path, the profile is selected by the base name and
+// the shell is executed at the given path.
+func Lookup(name string) (*Profile, bool) {
+	base := name
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		base = name[i+1:]
+	}
+	mu.RLock()
+	profile, ok := profiles[base]
+	mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	if base == name {
+		return profile, true
+	}
+	out := *profile
+	out.Command = name
+	return &out, true
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package shell
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		dialect string
+	}{
+		{name: "zsh", command: "zsh", dialect: Posix},
+		{name: "ksh", command: "ksh", dialect: Posix},
+		{name: "/bin/sh", command: "/bin/sh", dialect: Posix},
+		{name: "/usr/local/bin/zsh", command: "/usr/local/bin/zsh", dialect: Posix},
+		{name: "pwsh", command: "pwsh", dialect: Powershell},
+	}
+	for _, test := range tests {
+		profile, ok := Lookup(test.name)
+		if !ok {
+			t.Errorf("Want shell profile %s", test.name)
+			continue
+		}
+		if got, want := profile.Command, test.command; got != want {
+			t.Errorf("Want command %s, got %s", want, got)
+		}
+		if got, want := profile.Dialect, test.dialect; got != want {
+			t.Errorf("Want dialect %s, got %s", want, got)
+		}
+	}
+
+	// the registered profile must not be modified when the
+	// shell is selected by path.
+	if profile, _ := Lookup("zsh"); profile.Command != "zsh" {
+		t.Errorf("Want registered profile unchanged, got command %s", profile.Command)
+	}
+
+	if _, ok := Lookup("fish"); ok {
+		t.Errorf("Want unknown shell profile not found")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(&Profile{Name: "dash", Command: "dash", Args: []string{"-e"}, Dialect: Posix})
+	defer func() {
+		mu.Lock()
+		delete(profiles, "dash")
+		mu.Unlock()
+	}()
+	if _, ok := Lookup("/usr/bin/dash"); !ok {
+		t.Errorf("Want registered shell profile")
+	}
+}
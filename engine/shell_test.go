@@ -0,0 +1,41 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestWithShell(t *testing.T) {
+	tests := []struct {
+		shell string
+		cmd   string
+		want  string
+	}{
+		{
+			shell: "",
+			cmd:   "powershell -noprofile -noninteractive -command C:\\drone\\step.ps1",
+			want:  "powershell -noprofile -noninteractive -command C:\\drone\\step.ps1",
+		},
+		{
+			shell: ShellPowershell,
+			cmd:   "powershell -noprofile -noninteractive -command C:\\drone\\step.ps1",
+			want:  "powershell -noprofile -noninteractive -command C:\\drone\\step.ps1",
+		},
+		{
+			shell: ShellPwsh,
+			cmd:   "powershell -noprofile -noninteractive -command C:\\drone\\step.ps1",
+			want:  "pwsh -noprofile -noninteractive -command C:\\drone\\step.ps1",
+		},
+		{
+			shell: ShellPwsh,
+			cmd:   "/bin/sh -e /tmp/drone/step",
+			want:  "/bin/sh -e /tmp/drone/step",
+		},
+	}
+	for _, test := range tests {
+		if got := withShell(test.shell, test.cmd); got != test.want {
+			t.Errorf("Want command %q, got %q", test.want, got)
+		}
+	}
+}
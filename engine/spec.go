@@ -4,43 +4,137 @@
 
 package engine
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
 type (
 	// Spec provides the pipeline spec. This provides the
 	// required instructions for reproducable pipeline
 	// execution.
 	Spec struct {
-		Server   Server   `json:"server,omitempty"`
-		Platform Platform `json:"platform,omitempty"`
-		Root     string   `json:"root,omitempty"`
-		Files    []*File  `json:"files,omitempty"`
-		Steps    []*Step  `json:"steps,omitempty"`
+		Server      Server         `json:"server,omitempty"`
+		Platform    Platform       `json:"platform,omitempty"`
+		Root        string         `json:"root,omitempty"`
+		RootMode    uint32         `json:"root_mode,omitempty"`
+		Group       string         `json:"group,omitempty"`
+		Artifacts   string         `json:"artifacts,omitempty"`
+		Files       []*File        `json:"files,omitempty"`
+		Steps       []*Step        `json:"steps,omitempty"`
+		Setup       *Step          `json:"setup,omitempty"`
+		Teardown    *Step          `json:"teardown,omitempty"`
+		Batch       *Step          `json:"batch,omitempty"`
+		BatchNonce  string         `json:"batch_nonce,omitempty"`
+		Compress    bool           `json:"compress,omitempty"`
+		Verify      bool           `json:"verify,omitempty"`
+		Requires    []*Requirement `json:"requires,omitempty"`
+		Renewal     *Renewal       `json:"renewal,omitempty"`
+		Push        *Push          `json:"push,omitempty"`
+		Forwards    []*Forward     `json:"forwards,omitempty"`
+		Debug       bool           `json:"debug,omitempty"`
+		Retain      bool           `json:"retain,omitempty"`
+		DumpScripts string         `json:"dump_scripts,omitempty"`
+		Encoding    string         `json:"encoding,omitempty"`
+		Shell       string         `json:"shell,omitempty"`
+		Timestamps  string         `json:"timestamps,omitempty"`
+
+		// Timeout is the stage timeout. It is applied to steps
+		// that are re-executed once the stage completes.
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		// CorrelationID identifies the build in the logs of
+		// the remote server. If Syslog is true, a marker with
+		// the correlation id is written to the remote syslog
+		// when each step starts.
+		CorrelationID string `json:"correlation_id,omitempty"`
+		Syslog        bool   `json:"syslog,omitempty"`
+
+		// Audit records the commands executed and the files
+		// uploaded on the remote server. If nil, the pipeline
+		// is not audited.
+		Audit Auditor `json:"-"`
+
+		// HookOutput receives the output of the setup and
+		// teardown scripts. If nil, the output is discarded.
+		HookOutput io.Writer `json:"-"`
+
+		// Retained is true if the workspace was retained on the
+		// remote server for debugging when the pipeline
+		// environment was destroyed.
+		Retained bool `json:"-"`
 	}
 
 	// Server provides the secret configuration.
 	Server struct {
-		Hostname string `json:"hostname,omitempty"`
-		Username string `json:"username,omitempty"`
-		Password string `json:"password,omitempty"`
-		SSHKey   string `json:"ssh_key,omitempty"`
+		Hostname     string   `json:"hostname,omitempty"`
+		Username     string   `json:"username,omitempty"`
+		Password     string   `json:"password,omitempty"`
+		SSHKey       string   `json:"ssh_key,omitempty"`
+		Reconnect    int      `json:"reconnect,omitempty"`
+		Ciphers      []string `json:"ciphers,omitempty"`
+		KeyExchanges []string `json:"key_exchanges,omitempty"`
+		MACs         []string `json:"macs,omitempty"`
+		Agent        string   `json:"agent,omitempty"`
+		MaxSessions  int      `json:"max_sessions,omitempty"`
+		Tunnel       string   `json:"tunnel,omitempty"`
+		Zone         string   `json:"zone,omitempty"`
 	}
 
 	// Step defines a pipeline step.
 	Step struct {
 		Args         []string          `json:"args,omitempty"`
+		Batch        []*Step           `json:"batch,omitempty"`
 		Command      string            `json:"command,omitempty"`
 		Detach       bool              `json:"detach,omitempty"`
 		DependsOn    []string          `json:"depends_on,omitempty"`
 		Envs         map[string]string `json:"environment,omitempty"`
 		Files        []*File           `json:"files,omitempty"`
+		ForwardAgent string            `json:"forward_agent,omitempty"`
+		ForwardX11   string            `json:"forward_x11,omitempty"`
 		IgnoreErr    bool              `json:"ignore_err,omitempty"`
+		IgnoreCodes  []int             `json:"ignore_codes,omitempty"`
+		SkipCodes    []int             `json:"skip_codes,omitempty"`
 		IgnoreStdout bool              `json:"ignore_stderr,omitempty"`
 		IgnoreStderr bool              `json:"ignore_stdout,omitempty"`
 		Name         string            `json:"name,omitempt"`
+		Path         []string          `json:"path,omitempty"`
+		Ready        *Probe            `json:"ready,omitempty"`
 		RunPolicy    RunPolicy         `json:"run_policy,omitempty"`
+		StripANSI    bool              `json:"strip_ansi,omitempty"`
+		Term         string            `json:"term,omitempty"`
+		Umask        string            `json:"umask,omitempty"`
 		Secrets      []*Secret         `json:"secrets,omitempty"`
+		SecretsFile  string            `json:"secrets_file,omitempty"`
+		Shell        string            `json:"shell,omitempty"`
+		Sync         *Sync             `json:"sync,omitempty"`
+		User         string            `json:"user,omitempty"`
 		WorkingDir   string            `json:"working_dir,omitempty"`
 	}
 
+	// Sync defines files copied between the runner host and
+	// the remote server over sftp, instead of executing the
+	// step commands.
+	Sync struct {
+		Direction string `json:"direction,omitempty"`
+		Source    string `json:"source,omitempty"`
+		Target    string `json:"target,omitempty"`
+	}
+
+	// Probe defines the readiness probe of a detached step.
+	// The probe succeeds when the port accepts connections on
+	// the remote server, or when the command exits with a zero
+	// exit code.
+	Probe struct {
+		Port     int           `json:"port,omitempty"`
+		Command  string        `json:"command,omitempty"`
+		Timeout  time.Duration `json:"timeout,omitempty"`
+		Interval time.Duration `json:"interval,omitempty"`
+	}
+
 	// File defines a file that should be uploaded or
 	// mounted somewhere in the step container or virtual
 	// machine prior to command execution.
@@ -51,6 +145,28 @@ type (
 		IsDir bool   `json:"is_dir,omitempty"`
 	}
 
+	// Push defines a local working tree that is pushed to
+	// the workspace before pipeline execution. The tree is
+	// mirrored incrementally to the cache directory on the
+	// remote server, comparing files by size and modification
+	// time, or optionally by checksum.
+	Push struct {
+		Source   string `json:"source,omitempty"`
+		Target   string `json:"target,omitempty"`
+		Cache    string `json:"cache,omitempty"`
+		Checksum bool   `json:"checksum,omitempty"`
+	}
+
+	// Forward defines an ssh port forward that is open for
+	// the duration of the pipeline. The listener is opened on
+	// the runner host for local forwards, and on the remote
+	// server for remote forwards.
+	Forward struct {
+		Direction string `json:"direction,omitempty"`
+		Local     string `json:"local,omitempty"`
+		Remote    string `json:"remote,omitempty"`
+	}
+
 	// Platform defines the target platform.
 	Platform struct {
 		OS      string `json:"os,omitempty"`
@@ -59,27 +175,117 @@ type (
 		Version string `json:"version,omitempty"`
 	}
 
+	// Renewal defines the secret renewal configuration. The
+	// secrets are periodically renewed during pipeline
+	// execution and written to the secrets file of each step.
+	Renewal struct {
+		Interval time.Duration `json:"interval,omitempty"`
+
+		mu      sync.Mutex
+		renewed []*Secret
+	}
+
+	// Requirement defines a tool that must be installed on
+	// the remote server, with an optional version constraint.
+	Requirement struct {
+		Name    string `json:"name,omitempty"`
+		Op      string `json:"op,omitempty"`
+		Version string `json:"version,omitempty"`
+	}
+
 	// Secret represents a secret variable.
 	Secret struct {
-		Name string `json:"name,omitempty"`
-		Env  string `json:"env,omitempty"`
-		Data []byte `json:"data,omitempty"`
-		Mask bool   `json:"mask,omitempty"`
+		Name     string `json:"name,omitempty"`
+		Env      string `json:"env,omitempty"`
+		Data     []byte `json:"data,omitempty"`
+		Mask     bool   `json:"mask,omitempty"`
+		Optional bool   `json:"optional,omitempty"`
 	}
 
 	// State represents the process state.
 	State struct {
-		ExitCode  int  // Container exit code
-		Exited    bool // Container exited
-		OOMKilled bool // Container is oom killed
+		ExitCode  int    // Container exit code
+		Exited    bool   // Container exited
+		OOMKilled bool   // Container is oom killed
+		Signal    string // Signal that killed the process
 	}
 )
 
+// Reason returns a human-readable explanation if the process
+// was killed by a signal or crashed with a known windows exit
+// code, or an empty string.
+func (s *State) Reason() string {
+	switch {
+	case s.Signal == "" && s.ExitCode > 255:
+		if desc, ok := ntstatus[uint32(s.ExitCode)]; ok {
+			return fmt.Sprintf("step exited with code 0x%08X, %s", uint32(s.ExitCode), desc)
+		}
+		return ""
+	case s.Signal == "":
+		return ""
+	default:
+		return fmt.Sprintf("step killed by signal %s", s.Signal)
+	}
+}
+
+// Add records the renewed secrets, so that the renewed values
+// are masked in the step logs. Previously renewed values remain
+// masked, since a step may still print an earlier value.
+func (r *Renewal) Add(secrets []*Secret) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if len(s.Data) == 0 || !s.Mask || hasSecretData(r.renewed, s.Data) {
+			continue
+		}
+		r.renewed = append(r.renewed, s)
+	}
+}
+
+// Renewed returns the renewed secrets.
+func (r *Renewal) Renewed() []*Secret {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.renewed
+}
+
+// helper function returns true if the list includes a secret
+// with the same value.
+func hasSecretData(secrets []*Secret, data []byte) bool {
+	for _, s := range secrets {
+		if bytes.Equal(s.Data, data) {
+			return true
+		}
+	}
+	return false
+}
+
 // RunPolicy defines the policy for starting containers
 // based on the point-in-time pass or fail state of
 // the pipeline.
 type RunPolicy int
 
+// Defines the markers written to the output of a batch step
+// when each pipeline step starts and exits. The markers are
+// followed by the batch nonce, so that the output of a step
+// cannot forge the markers.
+const (
+	BatchStart = "##[drone:start]"
+	BatchExit  = "##[drone:exit]"
+)
+
+// Sync direction enumeration.
+const (
+	SyncUpload   = "upload"
+	SyncDownload = "download"
+)
+
+// Forward direction enumeration.
+const (
+	ForwardLocal  = "local"
+	ForwardRemote = "remote"
+)
+
 // RunPolicy enumeration.
 const (
 	RunOnSuccess RunPolicy = iota
@@ -0,0 +1,43 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestStateReason(t *testing.T) {
+	tests := []struct {
+		state *State
+		want  string
+	}{
+		{&State{ExitCode: 1, Exited: true}, ""},
+		{&State{ExitCode: 143, Exited: true, Signal: "TERM"}, "step killed by signal TERM"},
+		{&State{ExitCode: 137, Exited: true, Signal: "KILL"}, "step killed by signal KILL"},
+	}
+	for _, test := range tests {
+		if got := test.state.Reason(); got != test.want {
+			t.Errorf("Want reason %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestStateReason_Windows(t *testing.T) {
+	state := &State{ExitCode: exitCode(-1073741819), Exited: true}
+	if got, want := state.Reason(), "step exited with code 0xC0000005, access violation"; got != want {
+		t.Errorf("Want reason %q, got %q", want, got)
+	}
+	state = &State{ExitCode: 1000, Exited: true}
+	if got := state.Reason(); got != "" {
+		t.Errorf("Want no reason for unknown exit code, got %q", got)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got, want := uint32(exitCode(-1073741819)), uint32(0xC0000005); got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+	if got, want := exitCode(137), 137; got != want {
+		t.Errorf("Want exit code %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// runSync copies the files matching the sync source pattern
+// between the runner host and the remote server. Matching
+// directories are copied recursively into the target directory.
+func runSync(ctx context.Context, clientftp *sftp.Client, step *Step, output io.Writer) (*State, error) {
+	var err error
+	switch step.Sync.Direction {
+	case SyncDownload:
+		err = syncDownload(ctx, clientftp, step.Sync.Source, step.Sync.Target, output)
+	default:
+		err = syncUpload(ctx, clientftp, step.Sync.Source, step.Sync.Target, output)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		writeReason(output, err.Error())
+		return &State{ExitCode: 1, Exited: true}, nil
+	}
+	return &State{ExitCode: 0, Exited: true}, nil
+}
+
+// helper function uploads the local files matching the pattern
+// to the remote target directory.
+func syncUpload(ctx context.Context, clientftp *sftp.Client, pattern, target string, output io.Writer) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+	if err := clientftp.MkdirAll(target); err != nil {
+		return err
+	}
+	for _, match := range matches {
+		base := filepath.Dir(match)
+		err := filepath.Walk(match, func(local string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, local)
+			if err != nil {
+				return err
+			}
+			remote := path.Join(target, filepath.ToSlash(rel))
+			if info.IsDir() {
+				return clientftp.MkdirAll(remote)
+			}
+			fmt.Fprintf(output, "upload %s to %s\n", local, remote)
+			return syncFileUp(clientftp, local, remote, info.Mode())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function downloads the remote files matching the
+// pattern to the local target directory.
+func syncDownload(ctx context.Context, clientftp *sftp.Client, pattern, target string, output io.Writer) error {
+	matches, err := clientftp.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for _, match := range matches {
+		base := path.Dir(match)
+		walker := clientftp.Walk(match)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			remote := walker.Path()
+			rel := strings.TrimPrefix(strings.TrimPrefix(remote, base), "/")
+			local := filepath.Join(target, filepath.FromSlash(rel))
+			if walker.Stat().IsDir() {
+				if err := os.MkdirAll(local, 0755); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(output, "download %s to %s\n", remote, local)
+			if err := syncFileDown(clientftp, remote, local, walker.Stat().Mode()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// helper function copies the local file to the remote server.
+func syncFileUp(clientftp *sftp.Client, local, remote string, mode os.FileMode) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := clientftp.Create(remote)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Chmod(mode.Perm())
+}
+
+// helper function copies the remote file to the runner host.
+func syncFileDown(clientftp *sftp.Client, remote, local string, mode os.FileMode) error {
+	src, err := clientftp.Open(remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defines the log line timestamp formats.
+const (
+	TimestampsRFC3339  = "rfc3339"
+	TimestampsRelative = "relative"
+)
+
+// timestampWriter is an io.Writer that prefixes each line of
+// output with a timestamp.
+type timestampWriter struct {
+	sync.Mutex
+
+	w       io.Writer
+	format  string
+	start   time.Time
+	now     func() time.Time
+	newline bool
+}
+
+// WithTimestamps returns a writer that prefixes each line of
+// output with a timestamp in the given format. If the format
+// is empty, the writer is returned unchanged.
+func WithTimestamps(w io.Writer, format string) io.Writer {
+	switch format {
+	case TimestampsRFC3339, TimestampsRelative:
+	default:
+		return w
+	}
+	return &timestampWriter{
+		w:       w,
+		format:  format,
+		start:   time.Now(),
+		now:     time.Now,
+		newline: true,
+	}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	var buf bytes.Buffer
+	for _, b := range p {
+		if t.newline {
+			buf.WriteString(t.timestamp())
+			t.newline = false
+		}
+		buf.WriteByte(b)
+		if b == '\n' {
+			t.newline = true
+		}
+	}
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// helper function returns the timestamp prefix.
+func (t *timestampWriter) timestamp() string {
+	now := t.now()
+	if t.format == TimestampsRelative {
+		elapsed := now.Sub(t.start)
+		return fmt.Sprintf("[%02d:%02d] ", int(elapsed.Minutes()), int(elapsed.Seconds())%60)
+	}
+	return now.UTC().Format(time.RFC3339) + " "
+}
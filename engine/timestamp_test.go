@@ -0,0 +1,47 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWithTimestamps(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if w := WithTimestamps(buf, ""); w != buf {
+		t.Errorf("Expect writer unchanged when timestamps disabled")
+	}
+}
+
+func TestTimestampWriter_RFC3339(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := WithTimestamps(buf, TimestampsRFC3339).(*timestampWriter)
+	w.now = func() time.Time {
+		return time.Date(2019, 10, 1, 12, 30, 0, 0, time.UTC)
+	}
+	w.Write([]byte("hello\nwor"))
+	w.Write([]byte("ld\n"))
+
+	want := "2019-10-01T12:30:00Z hello\n2019-10-01T12:30:00Z world\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestTimestampWriter_Relative(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := WithTimestamps(buf, TimestampsRelative).(*timestampWriter)
+	w.now = func() time.Time {
+		return w.start.Add(90 * time.Second)
+	}
+	w.Write([]byte("hello\n"))
+
+	want := "[01:30] hello\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
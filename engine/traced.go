@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
+)
+
+// Traced returns an engine that records tracing spans for the
+// pipeline setup, step execution and teardown. Spans are only
+// recorded if the context provides a tracer.
+func Traced(engine Engine) Engine {
+	return &traced{engine}
+}
+
+type traced struct {
+	engine Engine
+}
+
+func (t *traced) Setup(ctx context.Context, spec *Spec) error {
+	ctx, span := tracing.Start(ctx, "engine.setup")
+	span.SetAttribute("net.peer.name", spec.Server.Hostname)
+	err := t.engine.Setup(ctx, spec)
+	span.End(err)
+	return err
+}
+
+func (t *traced) Destroy(ctx context.Context, spec *Spec) error {
+	ctx, span := tracing.Start(ctx, "engine.destroy")
+	span.SetAttribute("net.peer.name", spec.Server.Hostname)
+	err := t.engine.Destroy(ctx, spec)
+	span.End(err)
+	return err
+}
+
+func (t *traced) Run(ctx context.Context, spec *Spec, step *Step, output io.Writer) (*State, error) {
+	ctx, span := tracing.Start(ctx, "engine.run")
+	span.SetAttribute("step.name", step.Name)
+	span.SetAttribute("net.peer.name", spec.Server.Hostname)
+	state, err := t.engine.Run(ctx, spec, step, output)
+	if state != nil {
+		span.SetAttribute("step.exit_code", strconv.Itoa(state.ExitCode))
+	}
+	span.End(err)
+	return state, err
+}
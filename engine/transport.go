@@ -0,0 +1,101 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/websocket"
+)
+
+// Transport schemes supported in the server hostname, in
+// addition to plain tcp host and port pairs.
+const (
+	TransportUnix      = "unix"
+	TransportWebsocket = "ws"
+	TransportSecure    = "wss"
+)
+
+// IsTransport returns true if the hostname is a transport url
+// rather than a tcp host and port pair.
+func IsTransport(hostname string) bool {
+	return strings.Contains(hostname, "://")
+}
+
+// HostPort returns the server address in host:port format. If
+// the port is not provided it is parsed from the host,
+// defaulting to 22. IPv6 literals are supported with or without
+// brackets. Transport urls are returned unchanged.
+func HostPort(host, port string) string {
+	if IsTransport(host) {
+		return host
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if port == "" {
+			port = p
+		}
+	} else {
+		host = strings.TrimPrefix(host, "[")
+		host = strings.TrimSuffix(host, "]")
+	}
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// helper function dials the ssh server over the transport
+// defined by the hostname url.
+func dialTransport(hostname string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialConn(hostname)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, hostname, config)
+}
+
+// helper function performs the ssh handshake over an open
+// network connection.
+func newClient(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// helper function opens the network connection defined by the
+// transport url.
+func dialConn(hostname string) (net.Conn, error) {
+	u, err := url.Parse(hostname)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case TransportUnix:
+		return net.Dial("unix", u.Path)
+	case TransportWebsocket, TransportSecure:
+		origin := "http://localhost/"
+		if u.Scheme == TransportSecure {
+			origin = "https://localhost/"
+		}
+		conn, err := websocket.Dial(hostname, "", origin)
+		if err != nil {
+			return nil, err
+		}
+		// the ssh protocol is binary and must not be sent
+		// as websocket text frames.
+		conn.PayloadType = websocket.BinaryFrame
+		return conn, nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", u.Scheme)
+	}
+}
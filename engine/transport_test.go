@@ -0,0 +1,84 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsTransport(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost:22", false},
+		{"[2001:db8::1]:22", false},
+		{"unix:///run/sshd.sock", true},
+		{"ws://localhost:8080/ssh", true},
+		{"wss://gateway.company.com/ssh", true},
+	}
+	for _, test := range tests {
+		if got := IsTransport(test.host); got != test.want {
+			t.Errorf("Want transport %v for host %s", test.want, test.host)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		host string
+		port string
+		want string
+	}{
+		{host: "localhost", want: "localhost:22"},
+		{host: "localhost:2222", want: "localhost:2222"},
+		{host: "localhost", port: "2222", want: "localhost:2222"},
+		{host: "localhost:22", port: "2222", want: "localhost:2222"},
+		{host: "10.0.0.1", want: "10.0.0.1:22"},
+		{host: "2001:db8::1", want: "[2001:db8::1]:22"},
+		{host: "[2001:db8::1]", want: "[2001:db8::1]:22"},
+		{host: "[2001:db8::1]:2222", want: "[2001:db8::1]:2222"},
+		{host: "2001:db8::1", port: "2222", want: "[2001:db8::1]:2222"},
+		{host: "unix:///run/sshd.sock", want: "unix:///run/sshd.sock"},
+		{host: "wss://gateway.company.com/ssh", port: "2222", want: "wss://gateway.company.com/ssh"},
+	}
+	for _, test := range tests {
+		if got := HostPort(test.host, test.port); got != test.want {
+			t.Errorf("Want address %s for host %q port %q, got %s", test.want, test.host, test.port, got)
+		}
+	}
+}
+
+func TestDialConn_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sshd.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	conn, err := dialConn("unix://" + path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+}
+
+func TestDialConn_Unsupported(t *testing.T) {
+	if _, err := dialConn("http://localhost"); err == nil {
+		t.Errorf("Expect error for unsupported transport")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Tunnel drivers establish the connection to servers without
+// public ssh access through a cloud bastion service.
+const (
+	TunnelAWS = "aws-ssm"
+	TunnelGCP = "gcp-iap"
+)
+
+// tunnelName matches the valid tunnel instance and zone names.
+// The name cannot start with a dash, which the command line
+// tool would otherwise parse as a flag.
+var tunnelName = regexp.MustCompile(`^[A-Za-z0-9._:][A-Za-z0-9._:-]*$`)
+
+// IsTunnelName returns true if the name is a valid tunnel
+// instance or zone name.
+func IsTunnelName(s string) bool {
+	return tunnelName.MatchString(s)
+}
+
+// helper function dials the ssh server through the tunnel. The
+// tunnel is opened by the cloud provider command line tool,
+// which must be installed and authenticated on the runner host,
+// and relays the connection over stdin and stdout.
+func dialTunnel(driver, hostname, zone string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return nil, err
+	}
+	name, args, err := tunnelCommand(driver, host, port, zone)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialCommand(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, hostname, config)
+}
+
+// helper function returns the command that opens the tunnel
+// to the instance port. The zone of the instance is required
+// by gcp-iap if the project does not define a default zone.
+func tunnelCommand(driver, instance, port, zone string) (string, []string, error) {
+	if !IsTunnelName(instance) {
+		return "", nil, fmt.Errorf("invalid tunnel instance %q", instance)
+	}
+	if zone != "" && !IsTunnelName(zone) {
+		return "", nil, fmt.Errorf("invalid tunnel zone %q", zone)
+	}
+	switch driver {
+	case TunnelAWS:
+		return "aws", []string{
+			"ssm", "start-session",
+			"--target", instance,
+			"--document-name", "AWS-StartSSHSession",
+			"--parameters", "portNumber=" + port,
+		}, nil
+	case TunnelGCP:
+		args := []string{
+			"compute", "start-iap-tunnel",
+			instance, port,
+			"--listen-on-stdin",
+		}
+		if zone != "" {
+			args = append(args, "--zone", zone)
+		}
+		return "gcloud", args, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported tunnel %q", driver)
+	}
+}
+
+// helper function starts the command and returns a connection
+// that reads from the command stdout and writes to the command
+// stdin.
+func dialCommand(name string, args ...string) (net.Conn, error) {
+	cmd := exec.Command(name, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdConn{Reader: stdout, stdin: stdin, cmd: cmd}, nil
+}
+
+// cmdConn implements a net.Conn over the stdin and stdout of
+// a command. Deadlines are not supported.
+type cmdConn struct {
+	io.Reader
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func (c *cmdConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *cmdConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	return c.cmd.Wait()
+}
+
+func (c *cmdConn) LocalAddr() net.Addr                { return cmdAddr{} }
+func (c *cmdConn) RemoteAddr() net.Addr               { return cmdAddr{} }
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// cmdAddr is the address of a command connection.
+type cmdAddr struct{}
+
+func (cmdAddr) Network() string { return "cmd" }
+func (cmdAddr) String() string  { return "cmd" }
@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"io"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestTunnelCommand(t *testing.T) {
+	name, args, err := tunnelCommand(TunnelAWS, "i-0123456789abcdef0", "22", "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := name, "aws"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	want := []string{
+		"ssm", "start-session",
+		"--target", "i-0123456789abcdef0",
+		"--document-name", "AWS-StartSSHSession",
+		"--parameters", "portNumber=22",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Want args %v, got %v", want, args)
+	}
+
+	name, args, err = tunnelCommand(TunnelGCP, "build-1", "22", "us-central1-a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := name, "gcloud"; got != want {
+		t.Errorf("Want command %s, got %s", want, got)
+	}
+	want = []string{"compute", "start-iap-tunnel", "build-1", "22", "--listen-on-stdin", "--zone", "us-central1-a"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("Want args %v, got %v", want, args)
+	}
+
+	if _, _, err := tunnelCommand("azure-bastion", "build-1", "22", ""); err == nil {
+		t.Errorf("Expect error for unsupported tunnel")
+	}
+	if _, _, err := tunnelCommand(TunnelGCP, "--impersonate-service-account=admin", "22", ""); err == nil {
+		t.Errorf("Expect error for instance parsed as a flag")
+	}
+	if _, _, err := tunnelCommand(TunnelGCP, "build-1", "22", "us central1"); err == nil {
+		t.Errorf("Expect error for invalid zone")
+	}
+}
+
+func TestDialCommand(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("cat command not found")
+	}
+	conn, err := dialCommand("cat")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := string(buf), "hello"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+}
@@ -6,9 +6,32 @@ package engine
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sort"
+	"strings"
+
+	"github.com/drone-runners/drone-runner-ssh/engine/shell"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Defines the environment variable encodings.
+const (
+	EncodingBase64 = "base64"
+	EncodingQuote  = "quote"
+)
+
+var (
+	// ErrExitMissing is returned when the remote process exits
+	// without reporting an exit status.
+	ErrExitMissing = errors.New("remote process exited without an exit status")
+
+	// ErrConnectionLost is returned when the connection to the
+	// remote server is lost before the remote process exits.
+	ErrConnectionLost = errors.New("ssh connection lost")
 )
 
 // helper function writes a shell command to the io.Writer that
@@ -18,34 +41,134 @@ func writeWorkdir(w io.Writer, path string) {
 	fmt.Fprintln(w)
 }
 
+// helper function writes a shell command to the io.Writer that
+// writes a marker to the remote syslog, so that the remote host
+// logs can be correlated with the build. Markers are written on
+// a best-effort basis, and only by posix scripts.
+func writeMarker(w io.Writer, os, id, step string) {
+	if id == "" || os == "windows" || os == ShellPwsh {
+		return
+	}
+	msg := fmt.Sprintf("correlation_id=%s step=%s", id, step)
+	fmt.Fprintf(w, "logger -t drone %s > /dev/null 2>&1 || true", shellQuote(msg))
+	fmt.Fprintln(w)
+}
+
+// helper function writes a shell command to the io.Writer that
+// configures the file mode creation mask. The umask is ignored
+// on windows and in pwsh scripts.
+func writeUmask(w io.Writer, os, umask string) {
+	if umask == "" || os == "windows" || os == ShellPwsh {
+		return
+	}
+	fmt.Fprintf(w, "umask %s", umask)
+	fmt.Fprintln(w)
+}
+
+// helper function writes a shell command to the io.Writer that
+// configures the console to use utf-8 output on windows.
+func writeCodepage(w io.Writer, os string) {
+	if os != "windows" {
+		return
+	}
+	fmt.Fprintln(w, "chcp 65001 > $null")
+	fmt.Fprintln(w, "[Console]::OutputEncoding = [Text.Encoding]::UTF8")
+	fmt.Fprintln(w, "$OutputEncoding = [Text.Encoding]::UTF8")
+}
+
 // helper function writes a shell command to the io.Writer that
 // exports all secrets as environment variables.
-func writeSecrets(w io.Writer, os string, secrets []*Secret) {
+func writeSecrets(w io.Writer, os, encoding string, secrets []*Secret) {
 	for _, s := range secrets {
-		writeEnv(w, os, s.Env, string(s.Data))
+		writeEnv(w, os, encoding, s.Env, string(s.Data))
 	}
 }
 
+// helper function writes a shell command to the io.Writer that
+// sources the secrets file and immediately removes it, so that
+// secrets are not persisted on the remote server.
+func writeSourceSecrets(w io.Writer, os, path string) {
+	switch os {
+	case "windows", ShellPwsh:
+		quoted := strings.Replace(path, "'", "''", -1)
+		fmt.Fprintf(w, ". '%s'; Remove-Item -Force '%s'", quoted, quoted)
+		fmt.Fprintln(w)
+	default:
+		fmt.Fprintf(w, ". %s; rm -f %s", shellQuote(path), shellQuote(path))
+		fmt.Fprintln(w)
+	}
+}
+
+// helper function returns the path of the file that holds the
+// secrets for the script at the given path.
+func secretsPath(os, path string) string {
+	if os == "windows" || os == ShellPwsh {
+		return strings.TrimSuffix(path, ".ps1") + ".env.ps1"
+	}
+	return path + ".env"
+}
+
 // helper function writes a shell command to the io.Writer that
 // exports the key value pairs as environment variables.
-func writeEnviron(w io.Writer, os string, envs map[string]string) {
+func writeEnviron(w io.Writer, os, encoding string, envs map[string]string) {
 	var keys []string
 	for k := range envs {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		writeEnv(w, os, k, envs[k])
+		writeEnv(w, os, encoding, k, envs[k])
+	}
+}
+
+// helper function writes a shell command to the io.Writer that
+// prepends the directories to the PATH environment variable.
+func writePath(w io.Writer, os string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	switch os {
+	case "windows", ShellPwsh:
+		sep := ";"
+		if os == ShellPwsh {
+			sep = ":"
+		}
+		var quoted []string
+		for _, path := range paths {
+			quoted = append(quoted, strings.Replace(path, "'", "''", -1))
+		}
+		fmt.Fprintf(w, `$Env:PATH = '%s%s' + $Env:PATH`, strings.Join(quoted, sep), sep)
+		fmt.Fprintln(w)
+	default:
+		var quoted []string
+		for _, path := range paths {
+			quoted = append(quoted, shellQuote(path))
+		}
+		fmt.Fprintf(w, `export PATH=%s:"$PATH"`, strings.Join(quoted, ":"))
+		fmt.Fprintln(w)
 	}
 }
 
 // helper function writes a shell command to the io.Writer that
 // exports and key value pair as an environment variable.
-func writeEnv(w io.Writer, os, key, value string) {
+func writeEnv(w io.Writer, os, encoding, key, value string) {
+	// the value is quoted instead of base64 encoded for hosts
+	// that do not provide the base64 command.
+	if encoding == EncodingQuote {
+		switch os {
+		case "windows", ShellPwsh:
+			fmt.Fprintf(w, `$Env:%s = '%s'`, key, strings.Replace(value, "'", "''", -1))
+			fmt.Fprintln(w)
+		default:
+			fmt.Fprintf(w, `export %s=%s`, key, shellQuote(value))
+			fmt.Fprintln(w)
+		}
+		return
+	}
 	// we are encoding the value as base64 to avoid any accidental escaping
 	encodedValue := base64.StdEncoding.EncodeToString([]byte(value))
 	switch os {
-	case "windows":
+	case "windows", ShellPwsh:
 		fmt.Fprintf(w, `$Env:%s = "$([Text.Encoding]::Utf8.GetString([Convert]::FromBase64String('%s')))"`, key, encodedValue)
 		fmt.Fprintln(w)
 	default:
@@ -54,6 +177,17 @@ func writeEnv(w io.Writer, os, key, value string) {
 	}
 }
 
+// helper function returns the scripting dialect of the step,
+// which is the target operating system, or pwsh if the step is
+// executed with a powershell profile on a posix server.
+func scriptOS(spec *Spec, step *Step) string {
+	profile, ok := shell.Lookup(step.Shell)
+	if ok && profile.Dialect == shell.Powershell && spec.Platform.OS != "windows" {
+		return ShellPwsh
+	}
+	return spec.Platform.OS
+}
+
 // helper function returns a shell command for removing a
 // directory that is compatible with the operating system.
 func removeCommand(os, path string) string {
@@ -64,3 +198,140 @@ func removeCommand(os, path string) string {
 		return fmt.Sprintf("rm -rf %s", path)
 	}
 }
+
+// helper function returns a shell command for terminating the
+// processes started by pipeline steps, such as daemons, that is
+// compatible with the operating system. On posix hosts the
+// process groups listed in the pid file are terminated. On
+// windows hosts the processes that reference the workspace
+// path are terminated.
+func killCommand(os, root, pidfile string) string {
+	switch os {
+	case "windows":
+		root = strings.Replace(root, "'", "''", -1)
+		return fmt.Sprintf("powershell -noprofile -noninteractive -command \"Get-CimInstance Win32_Process | Where-Object { $_.ProcessId -ne $PID -and $_.CommandLine -like '*%s*' } | ForEach-Object { Stop-Process -Id $_.ProcessId -Force }\"", root)
+	default:
+		script := `test -f "$0" || exit 0; for pid in $(cat "$0"); do kill -9 "-$pid" 2>/dev/null || kill -9 "$pid" 2>/dev/null; done; exit 0`
+		return fmt.Sprintf("/bin/sh -c %s %s", shellQuote(script), shellQuote(pidfile))
+	}
+}
+
+// helper function returns a posix shell command that executes
+// the command in a new session, so that the command and the
+// processes it starts share a process group, and appends the
+// process group id to the pid file. If setsid is not installed
+// the command is executed in the current process group, and
+// only its process id can be terminated.
+func groupCommand(pidfile, cmd string) string {
+	script := `if command -v setsid >/dev/null 2>&1; then setsid /bin/sh -c "$0"; else /bin/sh -c "$0"; fi`
+	inner := fmt.Sprintf("echo $$ >> %s; exec %s", shellQuote(pidfile), cmd)
+	return fmt.Sprintf("/bin/sh -c %s %s", shellQuote(script), shellQuote(inner))
+}
+
+// helper function returns the command that executes the step.
+// On posix hosts the step is executed in its own process group,
+// which is terminated when the pipeline is destroyed.
+func execCommand(spec *Spec, step *Step) string {
+	cmd := step.Command + " " + strings.Join(step.Args, " ")
+	if spec.Platform.OS == "windows" {
+		return withShell(spec.Shell, cmd)
+	}
+	return groupCommand(pidsPath(spec), cmd)
+}
+
+// helper function returns a posix shell command that changes
+// the owner of the files to the named user.
+func chownCommand(user string, paths []string) string {
+	args := []string{"sudo", "-n", "chown", shellQuote(user)}
+	for _, path := range paths {
+		args = append(args, shellQuote(path))
+	}
+	return strings.Join(args, " ")
+}
+
+// helper function returns a posix shell command that changes
+// the group of the directories to the named group. The setgid
+// bit is set so that new files inherit the group, and because
+// changing the group may clear the bit.
+func chgrpCommand(group string, paths []string) string {
+	var quoted []string
+	for _, path := range paths {
+		quoted = append(quoted, shellQuote(path))
+	}
+	return fmt.Sprintf("chgrp %s %s && chmod g+s %s",
+		shellQuote(group),
+		strings.Join(quoted, " "),
+		strings.Join(quoted, " "),
+	)
+}
+
+// helper function joins the file paths using the path separator
+// that is compatible with the operating system.
+func joinPath(os string, paths ...string) string {
+	switch os {
+	case "windows":
+		return strings.Join(paths, "\\")
+	default:
+		return strings.Join(paths, "/")
+	}
+}
+
+// helper function converts the error returned by the ssh
+// session to the process state. An error is returned if the
+// remote process did not exit with a known exit code.
+func convertExit(err error) (*State, error) {
+	switch v := err.(type) {
+	case nil:
+		return &State{ExitCode: 0, Exited: true}, nil
+	case *ssh.ExitError:
+		// if the remote process is killed by a signal the exit
+		// status is 128 plus the signal number. The signal does
+		// not identify the sender, since a process is killed
+		// with SIGKILL by the oom killer, and also by the runner
+		// when the step is cancelled or times out, so only the
+		// signal is reported.
+		return &State{
+			ExitCode: exitCode(v.ExitStatus()),
+			Exited:   true,
+			Signal:   v.Signal(),
+		}, nil
+	case *ssh.ExitMissingError:
+		return nil, ErrExitMissing
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrConnectionLost, err)
+	}
+}
+
+// helper function writes a human-readable explanation to the
+// step logs when the step does not exit normally.
+func writeReason(w io.Writer, reason string) {
+	fmt.Fprintf(w, "\n[drone] %s\n", reason)
+}
+
+// helper function returns the path of the file that marks the
+// workspace to be retained for debugging. The marker is a dot
+// file in the workspace root, which cannot collide with the
+// step scripts in the opt directory.
+func debugPath(spec *Spec) string {
+	return joinPath(spec.Platform.OS, spec.Root, ".drone-debug")
+}
+
+// helper function returns the path of the file to which the
+// process group ids of the pipeline steps are appended, so
+// that the processes are terminated when the pipeline is
+// destroyed.
+func pidsPath(spec *Spec) string {
+	return joinPath(spec.Platform.OS, spec.Root, ".drone-pids")
+}
+
+// helper function writes instructions to connect to the remote
+// server to debug the failed step.
+func writeDebug(w io.Writer, spec *Spec, step *Step) {
+	host, port, err := net.SplitHostPort(spec.Server.Hostname)
+	if err != nil {
+		host, port = spec.Server.Hostname, "22"
+	}
+	fmt.Fprintf(w, "\n[drone] step failed, the workspace is retained for debugging\n")
+	fmt.Fprintf(w, "[drone] connect with: ssh -p %s %s@%s\n", port, spec.Server.Username, host)
+	fmt.Fprintf(w, "[drone] working directory: %s\n", step.WorkingDir)
+}
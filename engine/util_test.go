@@ -6,7 +6,11 @@ package engine
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestWriteWorkdir(t *testing.T) {
@@ -22,7 +26,7 @@ func TestWriteWorkdir(t *testing.T) {
 func TestWriteSecrets(t *testing.T) {
 	buf := new(bytes.Buffer)
 	sec := []*Secret{{Env: "a", Data: []byte("b")}}
-	writeSecrets(buf, "linux", sec)
+	writeSecrets(buf, "linux", "", sec)
 
 	want := `export a="$(echo Yg== | base64 -d)"` + "\n"
 	if got := buf.String(); got != want {
@@ -30,17 +34,33 @@ func TestWriteSecrets(t *testing.T) {
 	}
 
 	buf.Reset()
-	writeSecrets(buf, "windows", sec)
+	writeSecrets(buf, "windows", "", sec)
 	want = `$Env:a = "$([Text.Encoding]::Utf8.GetString([Convert]::FromBase64String('Yg==')))"` + "\n"
 	if got := buf.String(); got != want {
 		t.Errorf("Want secret script %q, got %q", want, got)
 	}
 }
 
+func TestWriteSourceSecrets(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeSourceSecrets(buf, "linux", secretsPath("linux", "/tmp/drone/opt/build"))
+	want := `. '/tmp/drone/opt/build.env'; rm -f '/tmp/drone/opt/build.env'` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want source secrets script %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	writeSourceSecrets(buf, "windows", secretsPath("windows", `C:\Temp\drone\opt\build.ps1`))
+	want = `. 'C:\Temp\drone\opt\build.env.ps1'; Remove-Item -Force 'C:\Temp\drone\opt\build.env.ps1'` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want source secrets script %q, got %q", want, got)
+	}
+}
+
 func TestWriteEnv(t *testing.T) {
 	buf := new(bytes.Buffer)
 	env := map[string]string{"a": "b", "c": "d"}
-	writeEnviron(buf, "linux", env)
+	writeEnviron(buf, "linux", "", env)
 
 	want := `export a="$(echo Yg== | base64 -d)"` + "\n" + `export c="$(echo ZA== | base64 -d)"` + "\n"
 	if got := buf.String(); got != want {
@@ -48,13 +68,129 @@ func TestWriteEnv(t *testing.T) {
 	}
 
 	buf.Reset()
-	writeEnviron(buf, "windows", env)
+	writeEnviron(buf, "windows", "", env)
 	want = `$Env:a = "$([Text.Encoding]::Utf8.GetString([Convert]::FromBase64String('Yg==')))"` + "\n" + `$Env:c = "$([Text.Encoding]::Utf8.GetString([Convert]::FromBase64String('ZA==')))"` + "\n"
 	if got := buf.String(); got != want {
 		t.Errorf("Want environment script %q, got %q", want, got)
 	}
 }
 
+func TestWriteEnv_Quote(t *testing.T) {
+	buf := new(bytes.Buffer)
+	env := map[string]string{"a": "it's", "c": "$HOME\n"}
+	writeEnviron(buf, "linux", EncodingQuote, env)
+
+	want := `export a='it'\''s'` + "\n" + "export c='$HOME\n'\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want environment script %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	writeEnviron(buf, "windows", EncodingQuote, env)
+	want = `$Env:a = 'it''s'` + "\n" + "$Env:c = '$HOME\n'\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want environment script %q, got %q", want, got)
+	}
+}
+
+func TestWriteCodepage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeCodepage(buf, "linux")
+	if got := buf.String(); got != "" {
+		t.Errorf("Want empty codepage script, got %q", got)
+	}
+
+	writeCodepage(buf, "windows")
+	want := "chcp 65001 > $null\n" +
+		"[Console]::OutputEncoding = [Text.Encoding]::UTF8\n" +
+		"$OutputEncoding = [Text.Encoding]::UTF8\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want codepage script %q, got %q", want, got)
+	}
+}
+
+func TestWriteUmask(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeUmask(buf, "windows", "027")
+	writeUmask(buf, "linux", "")
+	if got := buf.String(); got != "" {
+		t.Errorf("Want empty umask script, got %q", got)
+	}
+
+	writeUmask(buf, "linux", "027")
+	if got, want := buf.String(), "umask 027\n"; got != want {
+		t.Errorf("Want umask script %q, got %q", want, got)
+	}
+}
+
+func TestWriteMarker(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeMarker(buf, "linux", "", "build")
+	writeMarker(buf, "windows", "drone-build-42", "build")
+	writeMarker(buf, "pwsh", "drone-build-42", "build")
+	if got := buf.String(); got != "" {
+		t.Errorf("Want empty marker script, got %q", got)
+	}
+
+	writeMarker(buf, "linux", "drone-build-42", "go test")
+	want := `logger -t drone 'correlation_id=drone-build-42 step=go test' > /dev/null 2>&1 || true` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want marker script %q, got %q", want, got)
+	}
+}
+
+func TestWritePath(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writePath(buf, "linux", nil)
+	if got := buf.String(); got != "" {
+		t.Errorf("Want empty path script, got %q", got)
+	}
+
+	writePath(buf, "linux", []string{"/usr/local/go/bin", "/opt/bin"})
+	want := `export PATH='/usr/local/go/bin':'/opt/bin':"$PATH"` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want path script %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	writePath(buf, "windows", []string{`C:\Go\bin`, `C:\Tools`})
+	want = `$Env:PATH = 'C:\Go\bin;C:\Tools;' + $Env:PATH` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want path script %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	writePath(buf, "pwsh", []string{"/usr/local/go/bin", "/opt/bin"})
+	want = `$Env:PATH = '/usr/local/go/bin:/opt/bin:' + $Env:PATH` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want path script %q, got %q", want, got)
+	}
+}
+
+func TestScriptOS(t *testing.T) {
+	spec := &Spec{Platform: Platform{OS: "linux"}}
+	if got, want := scriptOS(spec, &Step{}), "linux"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+	if got, want := scriptOS(spec, &Step{Shell: "pwsh"}), "pwsh"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+	spec.Platform.OS = "windows"
+	if got, want := scriptOS(spec, &Step{Shell: "pwsh"}), "windows"; got != want {
+		t.Errorf("Want dialect %s, got %s", want, got)
+	}
+
+	buf := new(bytes.Buffer)
+	writeEnv(buf, "pwsh", "", "GOOS", "linux")
+	want := `$Env:GOOS = "$([Text.Encoding]::Utf8.GetString([Convert]::FromBase64String('bGludXg=')))"` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want env script %q, got %q", want, got)
+	}
+	if got, want := secretsPath("pwsh", "/tmp/drone/opt/build.ps1"), "/tmp/drone/opt/build.env.ps1"; got != want {
+		t.Errorf("Want secrets path %s, got %s", want, got)
+	}
+}
+
 func TestRemoveCommand(t *testing.T) {
 	got := removeCommand("linux", "/tmp/drone-temp")
 	want := "rm -rf /tmp/drone-temp"
@@ -68,3 +204,110 @@ func TestRemoveCommand(t *testing.T) {
 		t.Errorf("Want rm script %q, got %q", want, got)
 	}
 }
+
+func TestKillCommand(t *testing.T) {
+	got := killCommand("linux", "/tmp/drone-temp", "/tmp/drone-temp/.drone-pids")
+	want := `/bin/sh -c 'test -f "$0" || exit 0; for pid in $(cat "$0"); do kill -9 "-$pid" 2>/dev/null || kill -9 "$pid" 2>/dev/null; done; exit 0' '/tmp/drone-temp/.drone-pids'`
+	if got != want {
+		t.Errorf("Want kill script %q, got %q", want, got)
+	}
+
+	got = killCommand("windows", `C:\Windows\Temp\Drone-temp`, `C:\Windows\Temp\Drone-temp\.drone-pids`)
+	want = `powershell -noprofile -noninteractive -command "Get-CimInstance Win32_Process | Where-Object { $_.ProcessId -ne $PID -and $_.CommandLine -like '*C:\Windows\Temp\Drone-temp*' } | ForEach-Object { Stop-Process -Id $_.ProcessId -Force }"`
+	if got != want {
+		t.Errorf("Want kill script %q, got %q", want, got)
+	}
+}
+
+func TestGroupCommand(t *testing.T) {
+	got := groupCommand("/tmp/drone-temp/.drone-pids", "/bin/sh -e /tmp/drone-temp/opt/build")
+	want := `/bin/sh -c 'if command -v setsid >/dev/null 2>&1; then setsid /bin/sh -c "$0"; else /bin/sh -c "$0"; fi' 'echo $$ >> '\''/tmp/drone-temp/.drone-pids'\''; exec /bin/sh -e /tmp/drone-temp/opt/build'`
+	if got != want {
+		t.Errorf("Want group script %q, got %q", want, got)
+	}
+}
+
+func TestChownCommand(t *testing.T) {
+	got := chownCommand("nobody", []string{"/tmp/drone-temp/opt/build", "/tmp/drone-temp/opt/build.env"})
+	want := "sudo -n chown 'nobody' '/tmp/drone-temp/opt/build' '/tmp/drone-temp/opt/build.env'"
+	if got != want {
+		t.Errorf("Want chown script %q, got %q", want, got)
+	}
+}
+
+func TestChgrpCommand(t *testing.T) {
+	got := chgrpCommand("builders", []string{"/tmp/drone-temp", "/tmp/drone-temp/home"})
+	want := "chgrp 'builders' '/tmp/drone-temp' '/tmp/drone-temp/home' && chmod g+s '/tmp/drone-temp' '/tmp/drone-temp/home'"
+	if got != want {
+		t.Errorf("Want chgrp script %q, got %q", want, got)
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	got := joinPath("linux", "/tmp", "drone-random")
+	want := "/tmp/drone-random"
+	if got != want {
+		t.Errorf("Want path %q, got %q", want, got)
+	}
+
+	got = joinPath("windows", `C:\Windows\Temp`, "drone-random")
+	want = `C:\Windows\Temp\drone-random`
+	if got != want {
+		t.Errorf("Want path %q, got %q", want, got)
+	}
+}
+
+func TestConvertExit(t *testing.T) {
+	state, err := convertExit(nil)
+	if err != nil {
+		t.Error(err)
+	} else if state.ExitCode != 0 || !state.Exited {
+		t.Errorf("Want exit code 0, got %d", state.ExitCode)
+	}
+
+	_, err = convertExit(new(ssh.ExitMissingError))
+	if err != ErrExitMissing {
+		t.Errorf("Want exit missing error, got %v", err)
+	}
+
+	_, err = convertExit(io.EOF)
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("Want connection lost error, got %v", err)
+	}
+}
+
+func TestWriteReason(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeReason(buf, "step cancelled")
+
+	want := "\n[drone] step cancelled\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want reason %q, got %q", want, got)
+	}
+}
+
+func Test_writeDebug(t *testing.T) {
+	spec := &Spec{
+		Server: Server{Hostname: "10.0.0.1:2222", Username: "root"},
+	}
+	step := &Step{WorkingDir: "/tmp/drone-random/drone/src"}
+	buf := new(bytes.Buffer)
+	writeDebug(buf, spec, step)
+
+	want := "\n[drone] step failed, the workspace is retained for debugging\n" +
+		"[drone] connect with: ssh -p 2222 root@10.0.0.1\n" +
+		"[drone] working directory: /tmp/drone-random/drone/src\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Want debug instructions %q, got %q", want, got)
+	}
+}
+
+// This test verifies that the debug marker cannot overwrite
+// the script of a step named debug.
+func Test_debugPath(t *testing.T) {
+	spec := &Spec{Root: "/tmp/drone-random"}
+	spec.Platform.OS = "linux"
+	if got, want := debugPath(spec), "/tmp/drone-random/.drone-debug"; got != want {
+		t.Errorf("Want debug marker %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "regexp"
+
+// workspaceName matches the name of a pipeline workspace
+// directory, which is the drone- prefix followed by a random
+// 16 character alphanumeric suffix.
+var workspaceName = regexp.MustCompile(`^drone-[A-Za-z0-9]{16}$`)
+
+// IsWorkspace returns true if the directory name is the name
+// of a pipeline workspace. Other directories in the workspace
+// base directory, such as caches and collected artifacts, are
+// not workspaces.
+func IsWorkspace(name string) bool {
+	return workspaceName.MatchString(name)
+}
+
+// TempBase returns the default base directory in which
+// pipeline workspaces are created on the remote server.
+func TempBase(os string) string {
+	switch os {
+	case "windows":
+		return "C:\\Windows\\Temp"
+	default:
+		return "/tmp"
+	}
+}
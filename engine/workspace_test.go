@@ -0,0 +1,38 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestIsWorkspace(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"drone-Fx5lmm7cgGrFkc4Q", true},
+		{"drone-Fx5lmm7cgGrFkc4", false},
+		{"drone-Fx5lmm7cgGrFkc4Q.bak", false},
+		{"drone-Fx5lmm7cgGrFkc4-", false},
+		{"drone-", false},
+		{"drone", false},
+		{"systemd-private-Fx5lmm7cgGrFkc4Q", false},
+		{"drone-push", false},
+		{"drone-artifacts", false},
+	}
+	for _, test := range tests {
+		if got := IsWorkspace(test.name); got != test.want {
+			t.Errorf("Want workspace %v for %q, got %v", test.want, test.name, got)
+		}
+	}
+}
+
+func TestTempBase(t *testing.T) {
+	if got, want := TempBase("linux"), "/tmp"; got != want {
+		t.Errorf("Want base %s, got %s", want, got)
+	}
+	if got, want := TempBase("windows"), `C:\Windows\Temp`; got != want {
+		t.Errorf("Want base %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// x11 authentication protocol.
+const x11Protocol = "MIT-MAGIC-COOKIE-1"
+
+// x11Request is the payload of the x11-req request, as defined
+// in RFC 4254 section 6.3.1.
+type x11Request struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+// helper function returns true if the step forwards the ssh
+// agent or x11 display. Forwarded channels are registered on
+// the connection, and these steps therefore cannot share the
+// pipeline connection.
+func isForwarded(step *Step) bool {
+	return step.ForwardAgent != "" || step.ForwardX11 != ""
+}
+
+// helper function requests agent and x11 forwarding for the
+// step session.
+func requestForwarding(client *ssh.Client, session *ssh.Session, step *Step) error {
+	if step.ForwardAgent != "" {
+		if err := agent.ForwardToRemote(client, step.ForwardAgent); err != nil {
+			return err
+		}
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return err
+		}
+	}
+	if step.ForwardX11 != "" {
+		return requestX11(client, session, step.ForwardX11)
+	}
+	return nil
+}
+
+// helper function requests x11 forwarding for the session, and
+// forwards the x11 channels opened by the remote server to the
+// display of the runner host.
+func requestX11(client *ssh.Client, session *ssh.Session, display string) error {
+	network, address, screen, err := parseDisplay(display)
+	if err != nil {
+		return err
+	}
+	channels := client.HandleChannelOpen("x11")
+	if channels == nil {
+		return errors.New("x11 forwarding is already enabled")
+	}
+	ok, err := session.SendRequest("x11-req", true, ssh.Marshal(&x11Request{
+		AuthProtocol: x11Protocol,
+		AuthCookie:   x11Cookie(display),
+		ScreenNumber: screen,
+	}))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("x11 forwarding request denied")
+	}
+	go func() {
+		for ch := range channels {
+			channel, requests, err := ch.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				conn, err := net.Dial(network, address)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				pipe(channel, conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// helper function returns the network, address and screen of
+// the x11 display. A display without a host, for example :0,
+// is a unix socket, and a display with a host, for example
+// localhost:10.0, is a tcp address.
+func parseDisplay(display string) (network, address string, screen uint32, err error) {
+	i := strings.LastIndex(display, ":")
+	if i == -1 {
+		return "", "", 0, fmt.Errorf("invalid x11 display %q", display)
+	}
+	host, number := display[:i], display[i+1:]
+	if j := strings.Index(number, "."); j != -1 {
+		s, err := strconv.ParseUint(number[j+1:], 10, 32)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid x11 display %q", display)
+		}
+		number, screen = number[:j], uint32(s)
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return "", "", 0, fmt.Errorf("invalid x11 display %q", display)
+	}
+	if host == "" || host == "unix" {
+		return "unix", "/tmp/.X11-unix/X" + number, screen, nil
+	}
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), screen, nil
+}
+
+// helper function returns the x11 authentication cookie of the
+// display using the xauth command of the runner host. If the
+// cookie cannot be found, a random cookie is returned, and the
+// display must accept connections without authentication.
+func x11Cookie(display string) string {
+	out, err := exec.Command("xauth", "list", display).Output()
+	if err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[1] == x11Protocol {
+				return fields[2]
+			}
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import "testing"
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		network string
+		address string
+		screen  uint32
+		valid   bool
+	}{
+		{display: ":0", network: "unix", address: "/tmp/.X11-unix/X0", valid: true},
+		{display: ":1.2", network: "unix", address: "/tmp/.X11-unix/X1", screen: 2, valid: true},
+		{display: "unix:0", network: "unix", address: "/tmp/.X11-unix/X0", valid: true},
+		{display: "localhost:10.0", network: "tcp", address: "localhost:6010", valid: true},
+		{display: "0"},
+		{display: ":a"},
+		{display: ":0.a"},
+	}
+	for _, test := range tests {
+		network, address, screen, err := parseDisplay(test.display)
+		if got, want := err == nil, test.valid; got != want {
+			t.Errorf("Want display %q valid %v, got error %v", test.display, want, err)
+			continue
+		}
+		if network != test.network || address != test.address || screen != test.screen {
+			t.Errorf("Want display %q parsed as %s %s %d, got %s %s %d", test.display,
+				test.network, test.address, test.screen, network, address, screen)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package admission provides an external policy hook that
+// approves or rejects a pipeline stage before it executes on
+// the remote server, for example to restrict pipelines to
+// approved hosts or to business hours.
+package admission
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Provider approves or rejects pipeline stages.
+	Provider interface {
+		// Admit returns the admission decision for the
+		// pipeline stage.
+		Admit(context.Context, *Request) (*Response, error)
+	}
+
+	// Request provides the repository, build and stage
+	// details, and the compiled server target.
+	Request struct {
+		Repo     *drone.Repo  `json:"repo,omitempty"`
+		Build    *drone.Build `json:"build,omitempty"`
+		Stage    *drone.Stage `json:"stage,omitempty"`
+		Host     string       `json:"host,omitempty"`
+		User     string       `json:"user,omitempty"`
+		Platform Platform     `json:"platform,omitempty"`
+	}
+
+	// Platform provides the remote host platform.
+	Platform struct {
+		OS      string `json:"os,omitempty"`
+		Arch    string `json:"arch,omitempty"`
+		Variant string `json:"variant,omitempty"`
+		Version string `json:"version,omitempty"`
+	}
+
+	// Response provides the admission decision. If the stage
+	// is rejected, the reason is displayed in the user
+	// interface.
+	Response struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason,omitempty"`
+	}
+)
+
+// Nop returns a provider that admits all stages.
+func Nop() Provider {
+	return new(nop)
+}
+
+type nop struct{}
+
+func (*nop) Admit(context.Context, *Request) (*Response, error) {
+	return &Response{Allow: true}, nil
+}
+
+// External returns a provider that requests the admission
+// decision from an external http endpoint. If the endpoint is
+// empty, a no-op provider is returned.
+func External(endpoint, token string, skipverify bool) Provider {
+	if endpoint == "" {
+		return Nop()
+	}
+	return &external{
+		client: plugin.New(endpoint, token, skipverify),
+	}
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) Admit(ctx context.Context, in *Request) (*Response, error) {
+	out := new(Response)
+	if err := p.client.Do(ctx, in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/httpsignatures-go"
+	"github.com/drone/drone-go/drone"
+)
+
+var noContext = context.Background()
+
+func TestExternal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signature, err := httpsignatures.FromRequest(r); err != nil || !signature.IsValid("correct-horse-battery-staple", r) {
+			t.Errorf("Expect request signed with the shared secret")
+		}
+		in := new(Request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Host, "prod1.example.com:22"; got != want {
+			t.Errorf("Want host %s, got %s", want, got)
+		}
+		json.NewEncoder(w).Encode(&Response{
+			Reason: "deployments are only permitted during business hours",
+		})
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "correct-horse-battery-staple", false)
+	got, err := provider.Admit(noContext, &Request{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{},
+		Stage: &drone.Stage{},
+		Host:  "prod1.example.com:22",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Allow {
+		t.Errorf("Expect stage rejected")
+	}
+	if got, want := got.Reason, "deployments are only permitted during business hours"; got != want {
+		t.Errorf("Want reason %q, got %q", want, got)
+	}
+}
+
+func TestExternal_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	if _, err := provider.Admit(noContext, &Request{}); err == nil {
+		t.Errorf("Expect error when unexpected status code")
+	}
+}
+
+func TestNop(t *testing.T) {
+	got, err := External("", "", false).Admit(noContext, &Request{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !got.Allow {
+		t.Errorf("Expect stage admitted")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package audit records the ssh sessions opened, the commands
+// executed and the files uploaded on remote servers, to an
+// append-only json lines file or an http endpoint, for
+// compliance review.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/sirupsen/logrus"
+)
+
+// Event types.
+const (
+	EventOpen    = "session.open"
+	EventClose   = "session.close"
+	EventCommand = "command"
+	EventUpload  = "upload"
+)
+
+var _ engine.Auditor = (*Logger)(nil)
+
+type (
+	// Event is a single audited action on the remote server.
+	Event struct {
+		Time    time.Time `json:"time"`
+		Type    string    `json:"type"`
+		Repo    string    `json:"repo,omitempty"`
+		Build   int64     `json:"build,omitempty"`
+		Stage   string    `json:"stage,omitempty"`
+		Host    string    `json:"host,omitempty"`
+		Command string    `json:"command,omitempty"`
+		Path    string    `json:"path,omitempty"`
+		Size    int       `json:"size,omitempty"`
+		SHA256  string    `json:"sha256,omitempty"`
+	}
+
+	// Sink writes audit events.
+	Sink interface {
+		Write(*Event) error
+	}
+)
+
+// File returns a sink that appends events to the file as json
+// lines. The file is created if it does not exist.
+func File(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &file{f: f}, nil
+}
+
+type file struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (s *file) Write(event *Event) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(raw, '\n'))
+	return err
+}
+
+// HTTP returns a sink that posts each event to the http
+// endpoint as json.
+func HTTP(endpoint, token string, skipverify bool) Sink {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if skipverify {
+		client.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	return &remote{
+		endpoint: endpoint,
+		token:    token,
+		client:   client,
+	}
+}
+
+type remote struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (s *remote) Write(event *Event) error {
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(event); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("audit: unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Logger records the audit events of a pipeline stage.
+type Logger struct {
+	sink  Sink
+	repo  string
+	build int64
+	stage string
+}
+
+// New returns a Logger that writes the audit events of the
+// pipeline stage to the sink.
+func New(sink Sink, repo *drone.Repo, build *drone.Build, stage *drone.Stage) *Logger {
+	return &Logger{
+		sink:  sink,
+		repo:  repo.Slug,
+		build: build.Number,
+		stage: stage.Name,
+	}
+}
+
+// NewRunner returns a Logger that writes the audit events of
+// the runner that are not associated with a pipeline stage,
+// such as removing orphaned workspaces, to the sink.
+func NewRunner(sink Sink) *Logger {
+	return &Logger{sink: sink}
+}
+
+// Open records an ssh connection opened to the host.
+func (l *Logger) Open(host string) {
+	l.write(&Event{Type: EventOpen, Host: host})
+}
+
+// Close records an ssh connection to the host closed.
+func (l *Logger) Close(host string) {
+	l.write(&Event{Type: EventClose, Host: host})
+}
+
+// Command records a command executed on the host.
+func (l *Logger) Command(host, command string) {
+	l.write(&Event{Type: EventCommand, Host: host, Command: command})
+}
+
+// Upload records a file uploaded to the host. The file contents
+// are not recorded.
+func (l *Logger) Upload(host, path string, data []byte) {
+	sum := sha256.Sum256(data)
+	l.write(&Event{
+		Type:   EventUpload,
+		Host:   host,
+		Path:   path,
+		Size:   len(data),
+		SHA256: hex.EncodeToString(sum[:]),
+	})
+}
+
+// helper function writes the event to the sink. Errors are
+// logged and do not fail the pipeline.
+func (l *Logger) write(event *Event) {
+	event.Time = time.Now().UTC()
+	event.Repo = l.repo
+	event.Build = l.build
+	event.Stage = l.stage
+	if err := l.sink.Write(event); err != nil {
+		logrus.WithError(err).
+			WithField("audit.type", event.Type).
+			Warn("cannot write audit event")
+	}
+}
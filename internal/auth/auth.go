@@ -0,0 +1,177 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package auth provides http middleware that authenticates
+// dashboard and admin requests with a shared bearer token or
+// an oidc login session, in addition to basic authentication.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// session cookie name.
+const cookieSession = "_runner_session"
+
+// default session duration.
+const sessionExpiry = 24 * time.Hour
+
+// Config provides the middleware configuration.
+type Config struct {
+	// Username and Password are the basic auth credentials
+	// expected by the wrapped handler. Requests authenticated
+	// with a token or session are forwarded with these
+	// credentials.
+	Username string
+	Password string
+
+	// Token is the shared bearer token. Token authentication
+	// is disabled if empty.
+	Token string
+
+	// OIDC configures the oidc login. OIDC login is disabled
+	// if nil.
+	OIDC *OIDC
+
+	// Secret is the key used to sign session cookies.
+	Secret []byte
+}
+
+// Handler returns an http.Handler that authenticates requests
+// with a bearer token or oidc session before passing them to
+// the next handler. Other requests are passed to the next
+// handler unchanged, which authenticates them with basic auth.
+func Handler(config Config, next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	if config.OIDC != nil {
+		mux.HandleFunc("/login", config.handleLogin)
+		mux.HandleFunc("/login/callback", config.handleCallback)
+		mux.HandleFunc("/logout", config.handleLogout)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case config.authenticated(r):
+			r = r.Clone(r.Context())
+			r.Header.Del("Authorization")
+			r.SetBasicAuth(config.Username, config.Password)
+		case config.OIDC != nil && isBrowser(r):
+			// browsers without credentials are redirected to
+			// the oidc login instead of the basic auth prompt.
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// helper function returns true if the request provides a valid
+// bearer token or session cookie.
+func (c *Config) authenticated(r *http.Request) bool {
+	if c.Token != "" {
+		if token := bearer(r); token != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+			return true
+		}
+	}
+	if c.OIDC != nil {
+		if cookie, err := r.Cookie(cookieSession); err == nil {
+			_, ok := c.verify(cookie.Value, time.Now())
+			return ok
+		}
+	}
+	return false
+}
+
+func (c *Config) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieSession,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// helper function sets the signed session cookie for the
+// authenticated user.
+func (c *Config) setSession(w http.ResponseWriter, r *http.Request, user string) {
+	expires := time.Now().Add(sessionExpiry)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieSession,
+		Value:    c.sign(user, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// helper function returns a session value containing the user
+// and expiry, signed with the secret.
+func (c *Config) sign(user string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(user + "|" + strconv.FormatInt(expires.Unix(), 10)),
+	)
+	return payload + "." + c.mac(payload)
+}
+
+// helper function verifies the session signature and expiry,
+// and returns the session user.
+func (c *Config) verify(value string, now time.Time) (string, bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || len(c.Secret) == 0 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(c.mac(parts[0]))) {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(string(raw), "|")
+	if i == -1 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(string(raw[i+1:]), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", false
+	}
+	return string(raw[:i]), true
+}
+
+func (c *Config) mac(payload string) string {
+	h := hmac.New(sha256.New, c.Secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// helper function returns the bearer token from the request
+// authorization header.
+func bearer(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// helper function returns true if the request is made by a
+// browser without basic auth credentials.
+func isBrowser(r *http.Request) bool {
+	if _, _, ok := r.BasicAuth(); ok || bearer(r) != "" {
+		return false
+	}
+	return r.Method == http.MethodGet &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
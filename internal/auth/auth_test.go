@@ -0,0 +1,260 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// next is a handler that requires basic auth credentials.
+var next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username != "admin" || password != "hunter2" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+})
+
+func TestHandler_Token(t *testing.T) {
+	h := Handler(Config{
+		Username: "admin",
+		Password: "hunter2",
+		Token:    "correct-horse-battery-staple",
+	}, next)
+
+	tests := []struct {
+		header string
+		code   int
+	}{
+		{"Bearer correct-horse-battery-staple", 200},
+		{"bearer correct-horse-battery-staple", 200},
+		{"Bearer incorrect", 401},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:hunter2")), 200},
+		{"", 401},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/capacity", nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("Want status code %d for header %q, got %d", want, test.header, got)
+		}
+	}
+}
+
+func TestHandler_TokenDisabled(t *testing.T) {
+	h := Handler(Config{Username: "admin", Password: "hunter2"}, next)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, 401; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
+
+func TestHandler_Session(t *testing.T) {
+	config := Config{
+		Username: "admin",
+		Password: "hunter2",
+		OIDC:     &OIDC{},
+		Secret:   []byte("secret"),
+	}
+	h := Handler(config, next)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{
+		Name:  cookieSession,
+		Value: config.sign("octocat@example.com", time.Now().Add(time.Hour)),
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, 200; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+
+	// browsers without a session are redirected to the login.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusSeeOther; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	if got, want := w.Header().Get("Location"), "/login"; got != want {
+		t.Errorf("Want redirect to %s, got %s", want, got)
+	}
+}
+
+func TestSession(t *testing.T) {
+	config := Config{Secret: []byte("secret")}
+	now := time.Now()
+	value := config.sign("octocat@example.com", now.Add(time.Hour))
+
+	user, ok := config.verify(value, now)
+	if !ok || user != "octocat@example.com" {
+		t.Errorf("Want valid session for octocat@example.com, got %q", user)
+	}
+	if _, ok := config.verify(value, now.Add(2*time.Hour)); ok {
+		t.Errorf("Want expired session rejected")
+	}
+	if _, ok := config.verify("Zm9v."+strings.Split(value, ".")[1], now); ok {
+		t.Errorf("Want tampered session rejected")
+	}
+	other := Config{Secret: []byte("other")}
+	if _, ok := other.verify(value, now); ok {
+		t.Errorf("Want session signed with a different secret rejected")
+	}
+}
+
+func TestOIDC(t *testing.T) {
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "shh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss":   issuer,
+			"aud":   []string{"client"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"email": "octocat@example.com",
+		})
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig",
+		})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	issuer = ts.URL
+
+	h := Handler(Config{
+		Username: "admin",
+		Password: "hunter2",
+		Secret:   []byte("secret"),
+		OIDC: &OIDC{
+			Issuer:       issuer,
+			ClientID:     "client",
+			ClientSecret: "shh",
+			RedirectURL:  "http://runner.company.com/login/callback",
+			Allowed:      []string{"@example.com"},
+		},
+	}, next)
+
+	// the login redirects to the provider.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := location.Path, "/authorize"; got != want {
+		t.Errorf("Want redirect to %s, got %s", want, got)
+	}
+	state := location.Query().Get("state")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != state {
+		t.Fatalf("Want state cookie")
+	}
+
+	// the callback exchanges the code and sets the session.
+	r := httptest.NewRequest("GET", "/login/callback?code=abc&state="+state, nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusSeeOther; got != want {
+		t.Fatalf("Want status code %d, got %d", want, got)
+	}
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieSession {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatalf("Want session cookie")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, 200; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+
+	// the callback rejects an invalid state.
+	r = httptest.NewRequest("GET", "/login/callback?code=abc&state=invalid", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	verified, unverified := true, false
+	o := &OIDC{Issuer: "https://accounts.company.com/", ClientID: "client"}
+	now := time.Now()
+	tests := []struct {
+		claims *claims
+		valid  bool
+	}{
+		{&claims{Issuer: "https://accounts.company.com", Audience: "client", Expiry: now.Unix() + 60, Email: "octocat@example.com"}, true},
+		{&claims{Issuer: "https://accounts.company.com", Audience: []interface{}{"other", "client"}, Expiry: now.Unix() + 60, Email: "octocat@example.com", EmailVerified: &verified}, true},
+		{&claims{Issuer: "https://evil.com", Audience: "client", Expiry: now.Unix() + 60, Email: "octocat@example.com"}, false},
+		{&claims{Issuer: "https://accounts.company.com", Audience: "other", Expiry: now.Unix() + 60, Email: "octocat@example.com"}, false},
+		{&claims{Issuer: "https://accounts.company.com", Audience: "client", Expiry: now.Unix() - 60, Email: "octocat@example.com"}, false},
+		{&claims{Issuer: "https://accounts.company.com", Audience: "client", Expiry: now.Unix() + 60}, false},
+		{&claims{Issuer: "https://accounts.company.com", Audience: "client", Expiry: now.Unix() + 60, Email: "octocat@example.com", EmailVerified: &unverified}, false},
+	}
+	for i, test := range tests {
+		_, err := o.validate(test.claims, now)
+		if got, want := err == nil, test.valid; got != want {
+			t.Errorf("Want claims %d valid %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	o := &OIDC{Allowed: []string{"octocat@github.com", "@Company.com"}}
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"octocat@github.com", true},
+		{"hubot@github.com", false},
+		{"jane@company.com", true},
+		{"jane@evilcompany.com", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := o.allowed(test.email); got != test.want {
+			t.Errorf("Want allowed %v for %q, got %v", test.want, test.email, got)
+		}
+	}
+}
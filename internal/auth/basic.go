@@ -0,0 +1,35 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// Basic provides the basic authentication credentials.
+type Basic struct {
+	Username string
+	Password string
+	Realm    string
+}
+
+// BasicAuth returns an http.Handler that authenticates requests
+// with basic authentication before passing them to the next
+// handler. Requests are rejected if the password is not
+// configured.
+func BasicAuth(config Basic, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || config.Password == "" ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(config.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+config.Realm+`"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		password string
+		username string
+		input    string
+		code     int
+	}{
+		{password: "hunter2", username: "admin", input: "hunter2", code: 200},
+		{password: "hunter2", username: "admin", input: "incorrect", code: 401},
+		{password: "hunter2", username: "root", input: "hunter2", code: 401},
+		{password: "", username: "admin", input: "", code: 401},
+	}
+	for _, test := range tests {
+		h := BasicAuth(Basic{Username: "admin", Password: test.password, Realm: "drone"}, ok)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(test.username, test.input)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got, want := w.Code, test.code; got != want {
+			t.Errorf("Want status code %d, got %d", want, got)
+		}
+		if w.Code == 401 && w.Header().Get("WWW-Authenticate") != `Basic realm="drone"` {
+			t.Errorf("Want basic auth challenge with realm")
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// state cookie name.
+const cookieState = "_runner_oidc_state"
+
+// OIDC configures login with an openid connect provider using
+// the authorization code flow.
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Allowed is the list of email addresses, or domains
+	// prefixed with @, permitted to log in.
+	Allowed []string
+
+	// Client is the http client used to communicate with the
+	// provider. If nil, a default client is used.
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+}
+
+// discovery is the provider metadata.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// claims are the id token claims.
+type claims struct {
+	Issuer        string      `json:"iss"`
+	Audience      interface{} `json:"aud"`
+	Expiry        int64       `json:"exp"`
+	Email         string      `json:"email"`
+	EmailVerified *bool       `json:"email_verified"`
+}
+
+func (c *Config) handleLogin(w http.ResponseWriter, r *http.Request) {
+	d, err := c.OIDC.discover()
+	if err != nil {
+		logrus.WithError(err).Warnln("cannot discover the oidc provider")
+		http.Error(w, "cannot discover the oidc provider", http.StatusBadGateway)
+		return
+	}
+	state := random()
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieState,
+		Value:    state,
+		Path:     "/login",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.OIDC.ClientID},
+		"redirect_uri":  {c.OIDC.RedirectURL},
+		"scope":         {"openid email"},
+		"state":         {state},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+params.Encode(), http.StatusFound)
+}
+
+func (c *Config) handleCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(cookieState)
+	if err != nil || cookie.Value == "" || cookie.Value != r.FormValue("state") {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	if errmsg := r.FormValue("error"); errmsg != "" {
+		http.Error(w, errmsg, http.StatusUnauthorized)
+		return
+	}
+	user, err := c.OIDC.exchange(r.FormValue("code"))
+	if err != nil {
+		logrus.WithError(err).Warnln("oidc login failed")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !c.OIDC.allowed(user) {
+		logrus.WithField("user", user).Warnln("oidc user is not allowed")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   cookieState,
+		Path:   "/login",
+		MaxAge: -1,
+	})
+	c.setSession(w, r, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// helper function fetches and caches the provider metadata.
+// The metadata is fetched on first login so that the runner
+// starts when the provider is unavailable.
+func (o *OIDC) discover() (*discovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	endpoint := strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+	res, err := o.client().Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("oidc: unexpected discovery status code %d", res.StatusCode)
+	}
+	d := new(discovery)
+	if err := json.NewDecoder(res.Body).Decode(d); err != nil {
+		return nil, err
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, errors.New("oidc: invalid discovery document")
+	}
+	o.discovery = d
+	return d, nil
+}
+
+// helper function exchanges the authorization code for an id
+// token, and returns the verified email address. The id token
+// is received directly from the token endpoint, and is
+// therefore authenticated by the tls connection to the
+// provider instead of the token signature.
+func (o *OIDC) exchange(code string) (string, error) {
+	if code == "" {
+		return "", errors.New("oidc: missing authorization code")
+	}
+	d, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	res, err := o.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("oidc: unexpected token status code %d", res.StatusCode)
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	cl, err := parseToken(token.IDToken)
+	if err != nil {
+		return "", err
+	}
+	return o.validate(cl, time.Now())
+}
+
+// helper function validates the id token claims and returns the
+// email address.
+func (o *OIDC) validate(cl *claims, now time.Time) (string, error) {
+	issuer := o.Issuer
+	if o.discovery != nil && o.discovery.Issuer != "" {
+		issuer = o.discovery.Issuer
+	}
+	switch {
+	case strings.TrimSuffix(cl.Issuer, "/") != strings.TrimSuffix(issuer, "/"):
+		return "", errors.New("oidc: invalid issuer")
+	case !audience(cl.Audience, o.ClientID):
+		return "", errors.New("oidc: invalid audience")
+	case now.Unix() > cl.Expiry:
+		return "", errors.New("oidc: token expired")
+	case cl.Email == "":
+		return "", errors.New("oidc: missing email claim")
+	case cl.EmailVerified != nil && !*cl.EmailVerified:
+		return "", errors.New("oidc: email is not verified")
+	}
+	return cl.Email, nil
+}
+
+// helper function returns true if the email address is in the
+// list of allowed users or domains.
+func (o *OIDC) allowed(email string) bool {
+	email = strings.ToLower(email)
+	for _, allowed := range o.Allowed {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		switch {
+		case allowed == "":
+		case strings.HasPrefix(allowed, "@") && strings.HasSuffix(email, allowed):
+			return true
+		case allowed == email:
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// helper function decodes the id token payload.
+func parseToken(token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id token")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	cl := new(claims)
+	err = json.Unmarshal(raw, cl)
+	return cl, err
+}
+
+// helper function returns true if the audience claim, which is
+// a string or list of strings, contains the client id.
+func audience(aud interface{}, client string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == client
+	case []interface{}:
+		for _, s := range v {
+			if s == client {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function returns a random url-safe string.
+func random() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package capacity provides an http handler that reports the
+// runner capacity usage and the utilization of each host
+// pool, which external autoscalers use to add or remove hosts.
+package capacity
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/auth"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+// Config provides the handler credentials.
+type Config = auth.Basic
+
+// Handler returns an http.Handler that writes the runner
+// capacity usage to the response body.
+func Handler(runner *runtime.Runner, config Config) http.Handler {
+	return auth.BasicAuth(config, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runner.Capacity())
+	})
+}
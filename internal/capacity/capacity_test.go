@@ -0,0 +1,62 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package capacity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+func TestHandler(t *testing.T) {
+	runner := &runtime.Runner{
+		MaxStages: 10,
+		Pools: map[string]*runtime.Pool{
+			"linux-large": {Hosts: []string{"10.0.0.1", "10.0.0.2"}},
+		},
+	}
+	handler := Handler(runner, Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("GET", "/api/capacity", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	out := new(runtime.Capacity)
+	if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := out.Capacity, 10; got != want {
+		t.Errorf("Want capacity %d, got %d", want, got)
+	}
+	pool, ok := out.Pools["linux-large"]
+	if !ok {
+		t.Errorf("Want linux-large pool capacity")
+		return
+	}
+	if got, want := pool.Hosts, 2; got != want {
+		t.Errorf("Want %d pool hosts, got %d", want, got)
+	}
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	handler := Handler(new(runtime.Runner), Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("GET", "/api/capacity", nil)
+	r.SetBasicAuth("admin", "incorrect")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package changes provides the list of files changed by a
+// build, used to evaluate step path conditions.
+package changes
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Provider returns the list of changed files.
+	Provider interface {
+		// List returns the files changed by the build. A nil
+		// list is returned if the changes are unknown.
+		List(context.Context, *Request) ([]string, error)
+	}
+
+	// Request provides the repository and build details.
+	Request struct {
+		Repo  *drone.Repo  `json:"repo,omitempty"`
+		Build *drone.Build `json:"build,omitempty"`
+	}
+)
+
+// Nop returns a provider that always returns a nil list,
+// indicating the changes are unknown.
+func Nop() Provider {
+	return new(nop)
+}
+
+type nop struct{}
+
+func (*nop) List(context.Context, *Request) ([]string, error) {
+	return nil, nil
+}
+
+// External returns a provider that requests the list of
+// changed files from an external http endpoint. If the endpoint
+// is empty, a no-op provider is returned.
+func External(endpoint, token string, skipverify bool) Provider {
+	if endpoint == "" {
+		return Nop()
+	}
+	return &external{
+		client: plugin.New(endpoint, token, skipverify),
+	}
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) List(ctx context.Context, in *Request) ([]string, error) {
+	// the list is nil if the endpoint returns No Content,
+	// indicating the changes are unknown.
+	var out []string
+	if err := p.client.Do(ctx, in, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+)
+
+var noContext = context.Background()
+
+func TestExternal(t *testing.T) {
+	want := []string{"docs/index.md", "main.go"}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := new(Request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Build.After, "7fd1a60b01f91b314f59955a4e4d4e80d8edf11d"; got != want {
+			t.Errorf("Want commit %s, got %s", want, got)
+		}
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	got, err := provider.List(noContext, &Request{
+		Repo:  &drone.Repo{},
+		Build: &drone.Build{After: "7fd1a60b01f91b314f59955a4e4d4e80d8edf11d"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want changed files %v, got %v", want, got)
+	}
+}
+
+func TestExternal_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	if _, err := provider.List(noContext, &Request{}); err == nil {
+		t.Errorf("Expect error when the endpoint returns an error status")
+	}
+}
+
+func TestExternal_Partial(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["main.go", `))
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	got, err := provider.List(noContext, &Request{})
+	if err == nil {
+		t.Errorf("Expect error when the response is truncated")
+	}
+	if got != nil {
+		t.Errorf("Expect nil changes on error, got %v", got)
+	}
+}
+
+func TestNop(t *testing.T) {
+	got, err := Nop().List(noContext, &Request{})
+	if err != nil || got != nil {
+		t.Errorf("Expect nil changes from nop provider")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package credentials provides credential files, such as
+// .npmrc or .docker/config.json, that are written to the home
+// directory of the remote server before pipeline execution.
+package credentials
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Provider returns a list of credential files.
+	Provider interface {
+		// List returns the credential files for the build.
+		List(context.Context, *Request) ([]*File, error)
+	}
+
+	// Request provides the repository and build details.
+	Request struct {
+		Repo  *drone.Repo  `json:"repo,omitempty"`
+		Build *drone.Build `json:"build,omitempty"`
+	}
+
+	// File represents a credential file. The path is relative
+	// to the home directory.
+	File struct {
+		Path string `json:"path"`
+		Mode uint32 `json:"mode,omitempty"`
+		Data string `json:"data"`
+	}
+)
+
+// Nop returns a provider that returns an empty list.
+func Nop() Provider {
+	return new(nop)
+}
+
+type nop struct{}
+
+func (*nop) List(context.Context, *Request) ([]*File, error) {
+	return nil, nil
+}
+
+// External returns a provider that requests the credential
+// files from an external http endpoint. If the endpoint is
+// empty, a no-op provider is returned.
+func External(endpoint, token string, skipverify bool) Provider {
+	if endpoint == "" {
+		return Nop()
+	}
+	return &external{
+		client: plugin.New(endpoint, token, skipverify),
+	}
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) List(ctx context.Context, in *Request) ([]*File, error) {
+	var out []*File
+	err := p.client.Do(ctx, in, &out)
+	return out, err
+}
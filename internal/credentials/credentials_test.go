@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/httpsignatures-go"
+	"github.com/drone/drone-go/drone"
+	"github.com/google/go-cmp/cmp"
+)
+
+var noContext = context.Background()
+
+func TestExternal(t *testing.T) {
+	want := []*File{
+		{Path: ".npmrc", Mode: 0600, Data: "//registry.npmjs.org/:_authToken=secret"},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signature, err := httpsignatures.FromRequest(r); err != nil || !signature.IsValid("correct-horse-battery-staple", r) {
+			t.Errorf("Expect request signed with the shared secret")
+		}
+		in := new(Request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Repo.Slug, "octocat/hello-world"; got != want {
+			t.Errorf("Want repository %s, got %s", want, got)
+		}
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "correct-horse-battery-staple", false)
+	got, err := provider.List(noContext, &Request{
+		Repo:  &drone.Repo{Slug: "octocat/hello-world"},
+		Build: &drone.Build{},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected credential files")
+		t.Log(diff)
+	}
+}
+
+func TestExternal_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	_, err := provider.List(noContext, &Request{})
+	if err == nil {
+		t.Errorf("Expect error when the endpoint returns an error status")
+	}
+}
+
+func TestExternal_Nop(t *testing.T) {
+	if _, ok := External("", "", false).(*nop); !ok {
+		t.Errorf("Expect nop provider when endpoint is empty")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package hosts provides an http handler that renders the
+// status of the configured and observed remote hosts.
+package hosts
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/auth"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+// Config provides the handler credentials.
+type Config = auth.Basic
+
+// Handler returns an http.Handler that renders the status of
+// the remote hosts. The status is written in json format if
+// requested by the Accept header.
+func Handler(hosts *runtime.Hosts, config Config) http.Handler {
+	return auth.BasicAuth(config, func(w http.ResponseWriter, r *http.Request) {
+		list := hosts.List()
+		if r.Header.Get("Accept") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.Execute(w, list)
+	})
+}
+
+// helper function formats the time, or returns a placeholder
+// if the host has not been seen.
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+var page = template.Must(template.New("hosts").Funcs(template.FuncMap{
+	"timestamp": timestamp,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hosts</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; vertical-align: top; }
+.failure { color: #c0392b; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Hosts</h1>
+<table>
+<tr><th>Host</th><th>Last Seen</th><th>Last Build</th><th>Load</th><th>Recent Failures</th></tr>
+{{ range . }}
+<tr>
+<td>{{ .Host }}</td>
+<td>{{ timestamp .LastSeen }}</td>
+<td>{{ .LastBuild }}</td>
+<td>{{ .Active }}</td>
+<td>{{ range .Failures }}<div class="failure">{{ timestamp .Time }} {{ .Build }}: {{ .Message }}</div>{{ end }}</td>
+</tr>
+{{ else }}
+<tr><td colspan="5">No hosts</td></tr>
+{{ end }}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package hosts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+func TestHandler(t *testing.T) {
+	registry := new(runtime.Hosts)
+	registry.Start("10.0.0.1:22", "octocat/hello-world#1")
+	registry.Done("10.0.0.1:22", "octocat/hello-world#1", "<exit code 1>")
+	handler := Handler(registry, Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("GET", "/hosts", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "octocat/hello-world#1") {
+		t.Errorf("Expect last build rendered")
+	}
+	if !strings.Contains(body, "&lt;exit code 1&gt;") {
+		t.Errorf("Expect failure message escaped")
+	}
+}
+
+func TestHandler_JSON(t *testing.T) {
+	registry := new(runtime.Hosts)
+	registry.Add("10.0.0.1")
+	handler := Handler(registry, Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("GET", "/hosts", nil)
+	r.Header.Set("Accept", "application/json")
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var out []*runtime.HostStatus
+	if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(out) != 1 || out[0].Host != "10.0.0.1" {
+		t.Errorf("Expect configured host listed")
+	}
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	handler := Handler(new(runtime.Hosts), Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("GET", "/hosts", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package keygen generates ssh keypairs used by pipelines to
+// authenticate with the remote server.
+package keygen
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Defines the supported key types.
+const (
+	TypeEd25519 = "ed25519"
+	TypeECDSA   = "ecdsa"
+)
+
+// Generate generates a keypair of the given type, and returns
+// the pem encoded private key and the public key in
+// authorized_keys format with the optional comment.
+func Generate(typ, comment string) (private, public []byte, err error) {
+	var key crypto.Signer
+	var block *pem.Block
+	switch typ {
+	case TypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, block = priv, &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case TypeECDSA:
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, block = priv, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %q", typ)
+	}
+
+	pub, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	public = bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub))
+	if comment != "" {
+		public = append(public, ' ')
+		public = append(public, comment...)
+	}
+	public = append(public, '\n')
+	return pem.EncodeToMemory(block), public, nil
+}
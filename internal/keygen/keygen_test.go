@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package keygen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		typ  string
+		algo string
+	}{
+		{TypeEd25519, ssh.KeyAlgoED25519},
+		{TypeECDSA, ssh.KeyAlgoECDSA256},
+	}
+	for _, test := range tests {
+		private, public, err := Generate(test.typ, "drone@example.com")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(private)
+		if err != nil {
+			t.Errorf("Cannot parse %s private key: %s", test.typ, err)
+			continue
+		}
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(public)
+		if err != nil {
+			t.Errorf("Cannot parse %s public key: %s", test.typ, err)
+			continue
+		}
+		if got := pub.Type(); got != test.algo {
+			t.Errorf("Want key type %s, got %s", test.algo, got)
+		}
+		if comment != "drone@example.com" {
+			t.Errorf("Want public key comment, got %q", comment)
+		}
+		if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("Want public key to match private key")
+		}
+	}
+}
+
+func TestGenerate_Unsupported(t *testing.T) {
+	_, _, err := Generate("dsa", "")
+	if err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("Want unsupported key type error, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package livelog provides a pipeline streamer that batches
+// step output and streams to the remote server at a
+// configurable interval.
+package livelog
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// defaultInterval is the default interval at which pending
+// lines are streamed to the remote server.
+const defaultInterval = time.Second
+
+// truncated is the marker written to the log stream when the
+// step output exceeds the size limit.
+const truncated = "\n[output truncated: log size limit exceeded]\n"
+
+type (
+	// Client uploads log lines to the remote server.
+	Client interface {
+		// Batch batch writes logs to the streaming endpoint.
+		Batch(ctx context.Context, step int64, lines []*drone.Line) error
+
+		// Upload uploads the full logs to the server.
+		Upload(ctx context.Context, step int64, lines []*drone.Line) error
+	}
+
+	// Config configures the log stream.
+	Config struct {
+		// Interval is the interval at which pending lines are
+		// streamed to the remote server.
+		Interval time.Duration
+
+		// Batch is the maximum number of pending lines. Once
+		// exceeded, the pending lines are streamed immediately
+		// rather than waiting for the interval. If zero, the
+		// number of pending lines is not limited.
+		Batch int
+
+		// Limit is the maximum size of the step output in
+		// bytes. Once exceeded, the output is truncated and
+		// no longer streamed to the remote server. If zero,
+		// the size is not limited.
+		Limit int
+	}
+)
+
+// New returns a streamer that streams step output to the
+// remote server.
+func New(client Client, config Config) pipeline.Streamer {
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	return &streamer{
+		client: client,
+		config: config,
+	}
+}
+
+type streamer struct {
+	client Client
+	config Config
+}
+
+func (s *streamer) Stream(_ context.Context, state *pipeline.State, name string) io.WriteCloser {
+	state.Lock()
+	var id int64
+	for _, step := range state.Stage.Steps {
+		if step.Name == name {
+			id = step.ID
+			break
+		}
+	}
+	state.Unlock()
+	return NewWriter(s.client, id, s.config)
+}
+
+// Writer is an io.WriteCloser that sends logs to the remote
+// server in batches.
+type Writer struct {
+	sync.Mutex
+
+	client Client
+	config Config
+	id     int64
+	num    int
+	now    time.Time
+	size   int
+
+	// serializes batch uploads to preserve line order.
+	upload sync.Mutex
+
+	pending []*drone.Line
+	history []*drone.Line
+
+	closed bool
+	close  chan struct{}
+	ready  chan struct{}
+}
+
+// NewWriter returns a new Writer that streams the step
+// output and uploads the full logs when closed.
+func NewWriter(client Client, id int64, config Config) *Writer {
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	w := &Writer{
+		client: client,
+		config: config,
+		id:     id,
+		now:    time.Now(),
+		close:  make(chan struct{}),
+		ready:  make(chan struct{}, 1),
+	}
+	go w.start()
+	return w
+}
+
+// Write uploads the live log stream to the server.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	w.Lock()
+	for _, part := range split(p) {
+		// once the output exceeds the size limit, the step
+		// continues to execute, however, the output is
+		// discarded.
+		if w.config.Limit > 0 && w.size > w.config.Limit {
+			break
+		}
+		w.size += len(part)
+		if w.config.Limit > 0 && w.size > w.config.Limit {
+			part = truncated
+		}
+		line := &drone.Line{
+			Number:    w.num,
+			Message:   part,
+			Timestamp: int64(time.Since(w.now).Seconds()),
+		}
+		w.num++
+		w.pending = append(w.pending, line)
+		w.history = append(w.history, line)
+	}
+	full := w.config.Batch > 0 && len(w.pending) >= w.config.Batch
+	w.Unlock()
+
+	// if the number of pending lines exceeds the batch size,
+	// signal the writer to flush the pending lines.
+	if full {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the writer and uploads the full contents to
+// the server.
+func (w *Writer) Close() error {
+	if w.stop() {
+		w.flush()
+	}
+	w.Lock()
+	lines := w.history
+	w.Unlock()
+	return w.client.Upload(context.Background(), w.id, lines)
+}
+
+// flush batch uploads all buffered logs to the server.
+func (w *Writer) flush() error {
+	w.upload.Lock()
+	defer w.upload.Unlock()
+
+	w.Lock()
+	lines := w.pending
+	w.pending = nil
+	w.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+	return w.client.Batch(context.Background(), w.id, lines)
+}
+
+// stop closes the writer, returning true if the writer was
+// not previously closed.
+func (w *Writer) stop() bool {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return false
+	}
+	w.closed = true
+	close(w.close)
+	return true
+}
+
+// start streams the pending lines at the configured interval,
+// or once the number of pending lines exceeds the batch size.
+func (w *Writer) start() {
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-ticker.C:
+			w.flush()
+		case <-w.ready:
+			w.flush()
+		}
+	}
+}
+
+// helper function splits the output into lines, retaining
+// the trailing newline character.
+func split(p []byte) []string {
+	s := string(p)
+	v := []string{s}
+	// the remote shell may buffer the output and combine
+	// multiple lines into a single block of output.
+	if strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		v = strings.SplitAfter(s, "\n")
+		if v[len(v)-1] == "" {
+			v = v[:len(v)-1]
+		}
+	}
+	return v
+}
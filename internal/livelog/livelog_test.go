@@ -0,0 +1,126 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package livelog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockClient struct {
+	sync.Mutex
+	batches  [][]*drone.Line
+	uploaded []*drone.Line
+}
+
+func (m *mockClient) Batch(_ context.Context, _ int64, lines []*drone.Line) error {
+	m.Lock()
+	m.batches = append(m.batches, lines)
+	m.Unlock()
+	return nil
+}
+
+func (m *mockClient) Upload(_ context.Context, _ int64, lines []*drone.Line) error {
+	m.Lock()
+	m.uploaded = lines
+	m.Unlock()
+	return nil
+}
+
+func (m *mockClient) count() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.batches)
+}
+
+func TestWriter_Batch(t *testing.T) {
+	client := new(mockClient)
+	w := NewWriter(client, 1, Config{Interval: time.Hour, Batch: 2})
+	w.Write([]byte("hello\n"))
+	w.Write([]byte("world\n"))
+
+	for i := 0; i < 100 && client.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.count() != 1 {
+		t.Errorf("Expect pending lines streamed when batch size exceeded")
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	if got, want := len(client.uploaded), 2; got != want {
+		t.Errorf("Want %d uploaded lines, got %d", want, got)
+	}
+}
+
+func TestWriter_Interval(t *testing.T) {
+	client := new(mockClient)
+	w := NewWriter(client, 1, Config{Interval: 10 * time.Millisecond})
+	w.Write([]byte("hello\n"))
+
+	for i := 0; i < 100 && client.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.count() != 1 {
+		t.Errorf("Expect pending lines streamed at interval")
+	}
+	w.Close()
+}
+
+func TestWriter_Close(t *testing.T) {
+	client := new(mockClient)
+	w := NewWriter(client, 1, Config{Interval: time.Hour})
+	w.Write([]byte("hello\nworld\n"))
+	w.Close()
+
+	want := []string{"hello\n", "world\n"}
+	var got []string
+	for _, line := range client.uploaded {
+		got = append(got, line.Message)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+	if client.count() != 1 {
+		t.Errorf("Expect pending lines streamed on close")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	got := split([]byte("hello\nworld\n"))
+	want := []string{"hello\n", "world\n"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+	got = split([]byte("hello"))
+	want = []string{"hello"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestWriter_Limit(t *testing.T) {
+	client := new(mockClient)
+	w := NewWriter(client, 1, Config{Interval: time.Hour, Limit: 12})
+	w.Write([]byte("hello\n"))
+	w.Write([]byte("world\n"))
+	w.Write([]byte("truncated\n"))
+	w.Write([]byte("ignored\n"))
+	w.Close()
+
+	want := []string{"hello\n", "world\n", truncated}
+	var got []string
+	for _, line := range client.uploaded {
+		got = append(got, line.Message)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf(diff)
+	}
+}
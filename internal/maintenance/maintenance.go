@@ -0,0 +1,47 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package maintenance provides an http handler that drains
+// remote hosts for maintenance, so that running stages
+// complete but no new stages are scheduled to the host.
+package maintenance
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/auth"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+// Config provides the handler credentials.
+type Config = auth.Basic
+
+// Handler returns an http.Handler that drains the host on
+// POST, undrains the host on DELETE, and writes the list of
+// drained hosts to the response body. The host is provided by
+// the host query parameter.
+func Handler(maintenance *runtime.Maintenance, config Config) http.Handler {
+	return auth.BasicAuth(config, func(w http.ResponseWriter, r *http.Request) {
+		host := r.FormValue("host")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodDelete:
+			if host == "" {
+				http.Error(w, "invalid host", http.StatusBadRequest)
+				return
+			}
+			if r.Method == http.MethodPost {
+				maintenance.Drain(host)
+			} else {
+				maintenance.Undrain(host)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(maintenance.List())
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+func TestHandler(t *testing.T) {
+	m := new(runtime.Maintenance)
+	handler := Handler(m, Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("POST", "/api/maintenance?host=10.0.0.1", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	if got, want := w.Body.String(), "[\"10.0.0.1\"]\n"; got != want {
+		t.Errorf("Want drained hosts %q, got %q", want, got)
+	}
+	if !m.Drained("10.0.0.1:22") {
+		t.Errorf("Expect host drained")
+	}
+
+	r = httptest.NewRequest("DELETE", "/api/maintenance?host=10.0.0.1", nil)
+	r.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if m.Drained("10.0.0.1:22") {
+		t.Errorf("Expect host undrained")
+	}
+}
+
+func TestHandler_MissingHost(t *testing.T) {
+	handler := Handler(new(runtime.Maintenance), Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("POST", "/api/maintenance", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	handler := Handler(new(runtime.Maintenance), Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("POST", "/api/maintenance?host=10.0.0.1", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+}
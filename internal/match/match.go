@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
 )
 
 // NOTE most runners do not require match capabilities. This is
@@ -18,8 +19,9 @@ import (
 
 // Func returns a new match function that returns true if the
 // repository and build do not match the allowd repository names
-// and build events.
-func Func(repos, events []string, trusted bool) func(*drone.Repo, *drone.Build) bool {
+// and build events. Repositories matching the ignored names are
+// never matched, even if they match the allowed names.
+func Func(repos, ignore, events []string, trusted bool) func(*drone.Repo, *drone.Build) bool {
 	return func(repo *drone.Repo, build *drone.Build) bool {
 		// if trusted mode is enabled, only match repositories
 		// that are trusted.
@@ -29,6 +31,9 @@ func Func(repos, events []string, trusted bool) func(*drone.Repo, *drone.Build)
 		if match(repo.Slug, repos) == false {
 			return false
 		}
+		if len(ignore) != 0 && match(repo.Slug, ignore) {
+			return false
+		}
 		if match(build.Event, events) == false {
 			return false
 		}
@@ -36,6 +41,67 @@ func Func(repos, events []string, trusted bool) func(*drone.Repo, *drone.Build)
 	}
 }
 
+// Limits defines additional runner limits, evaluated once the
+// stage details are known.
+type Limits struct {
+	// Branches limits the runner to builds with a target
+	// branch matching the glob patterns.
+	Branches []string
+
+	// Instances limits the runner to builds from a drone
+	// server with a hostname matching the glob patterns.
+	Instances []string
+}
+
+// Match returns true if the build target branch and drone
+// server instance match the limits. Empty limits are always
+// considered a match. The build is only available once the
+// stage is accepted, so the limits cannot be used to decline
+// a stage.
+func (l Limits) Match(system *drone.System, build *drone.Build) bool {
+	if match(build.Target, l.Branches) == false {
+		return false
+	}
+	var host string
+	if system != nil {
+		host = system.Host
+	}
+	return match(host, l.Instances)
+}
+
+// Trigger returns true if the build matches the pipeline
+// trigger conditions. Status and path conditions are not
+// evaluated, since they are evaluated by the server. The build
+// and pipeline are only available once the stage is accepted,
+// so a stage that does not match is skipped, not declined.
+func Trigger(trigger manifest.Conditions, repo *drone.Repo, build *drone.Build, system *drone.System) bool {
+	var host string
+	if system != nil {
+		host = system.Host
+	}
+	return trigger.Action.Match(build.Action) &&
+		trigger.Branch.Match(build.Target) &&
+		trigger.Cron.Match(build.Cron) &&
+		trigger.Event.Match(build.Event) &&
+		trigger.Instance.Match(host) &&
+		trigger.Ref.Match(build.Ref) &&
+		trigger.Repo.Match(repo.Slug) &&
+		trigger.Target.Match(build.Deploy)
+}
+
+// Labels returns true if the runner labels satisfy the pipeline
+// node selector. Every key and value in the node selector must
+// be present in the runner labels. An empty node selector is
+// always considered a match.
+func Labels(node, labels map[string]string) bool {
+	for k, v := range node {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 func match(s string, patterns []string) bool {
 	// if no matching patterns are defined the string
 	// is always considered a match.
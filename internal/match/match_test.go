@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
 )
 
 func TestFunc(t *testing.T) {
@@ -28,7 +29,7 @@ func TestFunc(t *testing.T) {
 			event:   "push",
 			trusted: true,
 			match:   true,
-			matcher: Func([]string{"spaceghost/*", "octocat/*"}, []string{"push"}, true),
+			matcher: Func([]string{"spaceghost/*", "octocat/*"}, nil, []string{"push"}, true),
 		},
 		// repoisitory matching
 		{
@@ -36,7 +37,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: false,
 			match:   true,
-			matcher: Func([]string{"spaceghost/*", "octocat/*"}, []string{}, false),
+			matcher: Func([]string{"spaceghost/*", "octocat/*"}, nil, []string{}, false),
 		},
 		// event matching
 		{
@@ -44,7 +45,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: false,
 			match:   true,
-			matcher: Func([]string{}, []string{"pull_request"}, false),
+			matcher: Func([]string{}, nil, []string{"pull_request"}, false),
 		},
 		// trusted flag matching
 		{
@@ -52,7 +53,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: true,
 			match:   true,
-			matcher: Func([]string{}, []string{}, true),
+			matcher: Func([]string{}, nil, []string{}, true),
 		},
 
 		//
@@ -65,7 +66,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: false,
 			match:   false,
-			matcher: Func([]string{"octocat/*"}, []string{}, false),
+			matcher: Func([]string{"octocat/*"}, nil, []string{}, false),
 		},
 		// event matching
 		{
@@ -73,7 +74,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: false,
 			match:   false,
-			matcher: Func([]string{}, []string{"push"}, false),
+			matcher: Func([]string{}, nil, []string{"push"}, false),
 		},
 		// trusted flag matching
 		{
@@ -81,7 +82,7 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: false,
 			match:   false,
-			matcher: Func([]string{}, []string{}, true),
+			matcher: Func([]string{}, nil, []string{}, true),
 		},
 		// does not match repository
 		{
@@ -89,7 +90,7 @@ func TestFunc(t *testing.T) {
 			event:   "push",
 			trusted: true,
 			match:   false,
-			matcher: Func([]string{"spaceghost/*", "octocat/*"}, []string{"push"}, true),
+			matcher: Func([]string{"spaceghost/*", "octocat/*"}, nil, []string{"push"}, true),
 		},
 		// does not match event
 		{
@@ -97,7 +98,31 @@ func TestFunc(t *testing.T) {
 			event:   "pull_request",
 			trusted: true,
 			match:   false,
-			matcher: Func([]string{"spaceghost/*", "octocat/*"}, []string{"push"}, true),
+			matcher: Func([]string{"spaceghost/*", "octocat/*"}, nil, []string{"push"}, true),
+		},
+		// repository is ignored
+		{
+			repo:    "octocat/spoon-knife",
+			event:   "push",
+			trusted: true,
+			match:   false,
+			matcher: Func([]string{"octocat/*"}, []string{"octocat/spoon-*"}, []string{}, false),
+		},
+		// repository is ignored with no allowed repositories
+		{
+			repo:    "octocat/spoon-knife",
+			event:   "push",
+			trusted: true,
+			match:   false,
+			matcher: Func([]string{}, []string{"*/spoon-knife"}, []string{}, false),
+		},
+		// repository is not ignored
+		{
+			repo:    "octocat/hello-world",
+			event:   "push",
+			trusted: true,
+			match:   true,
+			matcher: Func([]string{}, []string{"*/spoon-knife"}, []string{}, false),
 		},
 		// does not match trusted flag
 		{
@@ -105,7 +130,7 @@ func TestFunc(t *testing.T) {
 			event:   "push",
 			trusted: false,
 			match:   false,
-			matcher: Func([]string{"spaceghost/*", "octocat/*"}, []string{"push"}, true),
+			matcher: Func([]string{"spaceghost/*", "octocat/*"}, nil, []string{"push"}, true),
 		},
 	}
 
@@ -123,3 +148,71 @@ func TestFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestLabels(t *testing.T) {
+	labels := map[string]string{"region": "us-east", "os": "linux"}
+	tests := []struct {
+		node  map[string]string
+		match bool
+	}{
+		{node: nil, match: true},
+		{node: map[string]string{"region": "us-east"}, match: true},
+		{node: map[string]string{"region": "us-east", "os": "linux"}, match: true},
+		{node: map[string]string{"region": "eu-west"}, match: false},
+		{node: map[string]string{"gpu": "true"}, match: false},
+	}
+	for i, test := range tests {
+		if got := Labels(test.node, labels); got != test.match {
+			t.Errorf("Expect match %v at index %d", test.match, i)
+		}
+	}
+	if Labels(map[string]string{"region": "us-east"}, nil) {
+		t.Errorf("Expect node selector to not match empty labels")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		branch string
+		host   string
+		match  bool
+	}{
+		{limits: Limits{}, branch: "master", host: "drone.company.com", match: true},
+		{limits: Limits{Branches: []string{"master", "release/*"}}, branch: "release/1.0", match: true},
+		{limits: Limits{Branches: []string{"master", "release/*"}}, branch: "feature/foo", match: false},
+		{limits: Limits{Instances: []string{"*.company.com"}}, host: "drone.company.com", match: true},
+		{limits: Limits{Instances: []string{"*.company.com"}}, host: "drone.example.com", match: false},
+	}
+	for i, test := range tests {
+		system := &drone.System{Host: test.host}
+		build := &drone.Build{Target: test.branch}
+		if got := test.limits.Match(system, build); got != test.match {
+			t.Errorf("Expect match %v at index %d", test.match, i)
+		}
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	repo := &drone.Repo{Slug: "octocat/hello-world"}
+	system := &drone.System{Host: "drone.company.com"}
+	tests := []struct {
+		trigger manifest.Conditions
+		match   bool
+	}{
+		{trigger: manifest.Conditions{}, match: true},
+		{trigger: manifest.Conditions{Branch: manifest.Condition{Include: []string{"master"}}}, match: true},
+		{trigger: manifest.Conditions{Branch: manifest.Condition{Exclude: []string{"master"}}}, match: false},
+		{trigger: manifest.Conditions{Event: manifest.Condition{Include: []string{"tag"}}}, match: false},
+		{trigger: manifest.Conditions{Repo: manifest.Condition{Include: []string{"octocat/*"}}}, match: true},
+		{trigger: manifest.Conditions{Instance: manifest.Condition{Include: []string{"drone.example.com"}}}, match: false},
+		// status conditions are evaluated by the server.
+		{trigger: manifest.Conditions{Status: manifest.Condition{Include: []string{"failure"}}}, match: true},
+	}
+	for i, test := range tests {
+		build := &drone.Build{Event: "push", Target: "master"}
+		if got := Trigger(test.trigger, repo, build, system); got != test.match {
+			t.Errorf("Expect match %v at index %d", test.match, i)
+		}
+	}
+}
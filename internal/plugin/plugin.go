@@ -0,0 +1,140 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package plugin provides the http client used to call
+// external plugin endpoints. Requests are signed with the
+// shared secret using the http signature scheme of the drone
+// plugin protocol, so that plugins built with the drone-go
+// plugin handlers can verify the runner.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+
+	"github.com/99designs/httpsignatures-go"
+)
+
+// required http headers. The request target is excluded
+// because reverse proxies may rewrite the request path, which
+// fails verification.
+var headers = []string{
+	"accept",
+	"accept-encoding",
+	"content-type",
+	"date",
+	"digest",
+}
+
+var signer = httpsignatures.NewSigner(
+	httpsignatures.AlgorithmHmacSha256,
+	headers...,
+)
+
+// Client makes signed requests to an external plugin endpoint.
+type Client struct {
+	endpoint string
+	secret   string
+	client   *http.Client
+}
+
+// New returns a new plugin client. If skipverify is true,
+// the tls certificate of the endpoint is not verified.
+func New(endpoint, secret string, skipverify bool) *Client {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if skipverify {
+		client.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	return &Client{
+		endpoint: endpoint,
+		secret:   secret,
+		client:   client,
+	}
+}
+
+// Do posts the json-encoded input to the plugin endpoint and
+// decodes the json response body into out. If the endpoint
+// returns No Content, out is not modified. If the endpoint
+// returns an error status, a *drone.Error with the status code
+// is returned.
+func (c *Client) Do(ctx context.Context, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Digest", "SHA-256="+digest(data))
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if err := signer.SignRequest("hmac-key", c.secret, req); err != nil {
+		return err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// drain the response body so the connection can
+		// be reused.
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+	}()
+
+	if res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		err := &drone.Error{
+			Code:    res.StatusCode,
+			Message: string(bytes.TrimSpace(body)),
+		}
+		if err.Message == "" {
+			err.Message = http.StatusText(res.StatusCode)
+		}
+		return err
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// IsStatus returns true if the error is a plugin error with
+// the given http status code.
+func IsStatus(err error, code int) bool {
+	e, ok := err.(*drone.Error)
+	return ok && e.Code == code
+}
+
+// helper function returns the base64-encoded sha256 digest
+// of the request body.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
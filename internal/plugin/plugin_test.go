@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/httpsignatures-go"
+)
+
+var noContext = context.Background()
+
+func TestDo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, err := httpsignatures.FromRequest(r)
+		if err != nil {
+			t.Errorf("Expect signed request, got %s", err)
+		} else if !signature.IsValid("correct-horse-battery-staple", r) {
+			t.Errorf("Expect valid request signature")
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expect no authorization header")
+		}
+		in := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&in)
+		json.NewEncoder(w).Encode(map[string]string{"name": in["name"]})
+	}))
+	defer ts.Close()
+
+	out := map[string]string{}
+	client := New(ts.URL, "correct-horse-battery-staple", false)
+	err := client.Do(noContext, map[string]string{"name": "octocat"}, &out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := out["name"], "octocat"; got != want {
+		t.Errorf("Want name %s, got %s", want, got)
+	}
+}
+
+func TestDo_NoContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	var out []string
+	if err := New(ts.URL, "", false).Do(noContext, nil, &out); err != nil {
+		t.Error(err)
+	}
+	if out != nil {
+		t.Errorf("Expect output unchanged when no content")
+	}
+}
+
+func TestDo_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	err := New(ts.URL, "", false).Do(noContext, nil, nil)
+	if err == nil {
+		t.Errorf("Expect error when the endpoint returns an error status")
+		return
+	}
+	if !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expect not found error, got %s", err)
+	}
+	if got, want := err.Error(), "not found"; got != want {
+		t.Errorf("Want error message %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package provision provides remote hosts from an external
+// provider, which may create an ephemeral virtual machine for
+// each pipeline stage and remove it when the stage completes.
+package provision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// Actions sent to the provider.
+const (
+	ActionProvision   = "provision"
+	ActionDeprovision = "deprovision"
+)
+
+type (
+	// Provider provisions remote hosts.
+	Provider interface {
+		// Provision returns the connection details of a host
+		// provisioned for the pipeline stage.
+		Provision(context.Context, *Request) (*Host, error)
+
+		// Deprovision removes the host provisioned for the
+		// pipeline stage.
+		Deprovision(context.Context, *Request) error
+	}
+
+	// Request provides the repository, build and stage
+	// details, and the provisioned host when the host is
+	// removed.
+	Request struct {
+		Action   string       `json:"action"`
+		Repo     *drone.Repo  `json:"repo,omitempty"`
+		Build    *drone.Build `json:"build,omitempty"`
+		Stage    *drone.Stage `json:"stage,omitempty"`
+		Platform Platform     `json:"platform,omitempty"`
+		Host     *Host        `json:"host,omitempty"`
+	}
+
+	// Platform provides the requested host platform.
+	Platform struct {
+		OS      string `json:"os,omitempty"`
+		Arch    string `json:"arch,omitempty"`
+		Variant string `json:"variant,omitempty"`
+		Version string `json:"version,omitempty"`
+	}
+
+	// Host provides the connection details of the host. The
+	// identifier is opaque to the runner, and is returned to
+	// the provider when the host is removed.
+	Host struct {
+		ID       string `json:"id,omitempty"`
+		Host     string `json:"host"`
+		Username string `json:"username,omitempty"`
+		Password string `json:"password,omitempty"`
+		SSHKey   string `json:"ssh_key,omitempty"`
+	}
+)
+
+// Nop returns a provider that does not provision hosts.
+func Nop() Provider {
+	return new(nop)
+}
+
+type nop struct{}
+
+func (*nop) Provision(context.Context, *Request) (*Host, error) {
+	return nil, nil
+}
+
+func (*nop) Deprovision(context.Context, *Request) error {
+	return nil
+}
+
+// External returns a provider that provisions hosts using an
+// external http endpoint. If the endpoint is empty, a no-op
+// provider is returned.
+func External(endpoint, token string, skipverify bool) Provider {
+	if endpoint == "" {
+		return Nop()
+	}
+	return &external{
+		client: plugin.New(endpoint, token, skipverify),
+	}
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) Provision(ctx context.Context, in *Request) (*Host, error) {
+	in.Action = ActionProvision
+	out := new(Host)
+	if err := p.client.Do(ctx, in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *external) Deprovision(ctx context.Context, in *Request) error {
+	in.Action = ActionDeprovision
+	return p.client.Do(ctx, in, nil)
+}
+
+// Command returns a provider that provisions hosts using an
+// executable on the runner host, such as a terraform wrapper
+// script. The action is passed as the first argument, the
+// request is written to stdin, and the host is read from stdout.
+// If the path is empty, a no-op provider is returned.
+func Command(path string) Provider {
+	if path == "" {
+		return Nop()
+	}
+	return &command{path: path}
+}
+
+type command struct {
+	path string
+}
+
+func (p *command) Provision(ctx context.Context, in *Request) (*Host, error) {
+	in.Action = ActionProvision
+	stdout, err := p.run(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	out := new(Host)
+	if err := json.Unmarshal(stdout, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *command) Deprovision(ctx context.Context, in *Request) error {
+	in.Action = ActionDeprovision
+	_, err := p.run(ctx, in)
+	return err
+}
+
+func (p *command) run(ctx context.Context, in *Request) ([]byte, error) {
+	stdin := new(bytes.Buffer)
+	if err := json.NewEncoder(stdin).Encode(in); err != nil {
+		return nil, err
+	}
+	stderr := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, p.path, in.Action)
+	cmd.Stdin = stdin
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("provision: %s: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
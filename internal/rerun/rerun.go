@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package rerun provides an http handler that re-executes a
+// single pipeline step against a workspace retained on the
+// remote server.
+package rerun
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/replacer"
+	"github.com/drone-runners/drone-runner-ssh/internal/auth"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+// Config provides the handler credentials.
+type Config = auth.Basic
+
+// Handler returns an http.Handler that re-executes the named
+// step of the stage, and streams the step output to the
+// response. The stage and step are provided by the stage and
+// step query parameters. The step is executed by the execer,
+// subject to the same limits as the pipeline steps.
+func Handler(registry *runtime.Registry, execer runtime.StepExecer, config Config) http.Handler {
+	return auth.BasicAuth(config, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("stage"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid stage", http.StatusBadRequest)
+			return
+		}
+		spec, ok := registry.Find(id)
+		if !ok {
+			http.Error(w, "workspace not retained", http.StatusNotFound)
+			return
+		}
+		step := findStep(spec, r.FormValue("step"))
+		if step == nil {
+			http.Error(w, "step not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		out := replacer.New(&flushWriter{w}, step.Secrets)
+		state, err := execer.ExecStep(r.Context(), spec, step, out)
+		switch {
+		case err != nil:
+			fmt.Fprintf(out, "\n[drone] %s\n", err)
+		default:
+			fmt.Fprintf(out, "\n[drone] exit code %d\n", state.ExitCode)
+		}
+	})
+}
+
+// helper function returns the named step.
+func findStep(spec *engine.Spec, name string) *engine.Step {
+	for _, step := range spec.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// flushWriter flushes the response after each write so that
+// the step output is streamed to the client.
+type flushWriter struct {
+	w io.Writer
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func (f *flushWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package rerun
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/runtime"
+)
+
+type mockExecer struct {
+	step *engine.Step
+}
+
+func (m *mockExecer) ExecStep(ctx context.Context, spec *engine.Spec, step *engine.Step, w io.Writer) (*engine.State, error) {
+	m.step = step
+	io.WriteString(w, "running tests")
+	return &engine.State{ExitCode: 1, Exited: true}, nil
+}
+
+func TestHandler(t *testing.T) {
+	registry := new(runtime.Registry)
+	registry.Add(1, &engine.Spec{
+		Steps: []*engine.Step{
+			{
+				Name: "test",
+				Secrets: []*engine.Secret{
+					{Name: "password", Data: []byte("correct-horse-battery-staple"), Mask: true},
+				},
+			},
+		},
+	})
+	mock := new(mockExecer)
+	handler := Handler(registry, mock, Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("POST", "/api/rerun?stage=1&step=test", nil)
+	r.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	if mock.step == nil || mock.step.Name != "test" {
+		t.Errorf("Expect step re-executed")
+		return
+	}
+	if len(mock.step.Secrets) != 0 {
+		t.Errorf("Expect secrets removed from the retained specification")
+	}
+	want := "running tests\n[drone] exit code 1\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	registry := new(runtime.Registry)
+	registry.Add(1, &engine.Spec{Steps: []*engine.Step{{Name: "test"}}})
+	handler := Handler(registry, new(mockExecer), Config{Username: "admin", Password: "secret"})
+
+	for _, target := range []string{
+		"/api/rerun?stage=2&step=test",
+		"/api/rerun?stage=1&step=build",
+	} {
+		r := httptest.NewRequest("POST", target, nil)
+		r.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusNotFound; got != want {
+			t.Errorf("Want status code %d for %s, got %d", want, target, got)
+		}
+	}
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	handler := Handler(new(runtime.Registry), new(mockExecer), Config{Username: "admin", Password: "secret"})
+
+	r := httptest.NewRequest("POST", "/api/rerun?stage=1&step=test", nil)
+	r.SetBasicAuth("admin", "wrong")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("Want status code %d, got %d", want, got)
+	}
+	if !strings.Contains(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Errorf("Expect basic authentication challenge")
+	}
+}
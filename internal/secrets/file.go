@@ -0,0 +1,99 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path"
+
+	"github.com/buildkite/yaml"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/secret"
+)
+
+// Secret defines a named secret in the secrets file.
+type Secret struct {
+	Name string `yaml:"name" json:"name"`
+	Data string `yaml:"data" json:"data"`
+
+	// Repos restricts the secret to repositories matching the
+	// glob patterns, for example octocat/*. The secret is
+	// available to all repositories if empty.
+	Repos []string `yaml:"repos" json:"repos"`
+
+	// PullRequest exposes the secret to pull requests.
+	PullRequest bool `yaml:"pull_request" json:"pull_request"`
+}
+
+// ParseFile parses the list of secrets from the yaml or json
+// file.
+func ParseFile(filename string) ([]*Secret, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var secrets []*Secret
+	if err := yaml.Unmarshal(raw, &secrets); err != nil {
+		return nil, err
+	}
+	for _, s := range secrets {
+		if s.Name == "" {
+			return nil, errors.New("secrets: secret name is required")
+		}
+		for _, pattern := range s.Repos {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.New("secrets: invalid repository pattern " + pattern)
+			}
+		}
+	}
+	return secrets, nil
+}
+
+// File returns a secret provider that returns secrets from the
+// list of secrets loaded from a file on the runner host.
+func File(secrets []*Secret) secret.Provider {
+	return &file{secrets: secrets}
+}
+
+type file struct {
+	secrets []*Secret
+}
+
+func (p *file) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	for _, s := range p.secrets {
+		if s.Name != in.Name || !matchRepo(s.Repos, in.Repo) {
+			continue
+		}
+		// the secret can be restricted to non-pull request
+		// events. If the secret is restricted, return nil.
+		if in.Build != nil && in.Build.Event == drone.EventPullRequest && !s.PullRequest {
+			return nil, nil
+		}
+		return &drone.Secret{
+			Name: s.Name,
+			Data: s.Data,
+		}, nil
+	}
+	return nil, nil
+}
+
+// helper function returns true if the repository matches one
+// of the glob patterns, or if the list of patterns is empty.
+func matchRepo(patterns []string, repo *drone.Repo) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	if repo == nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo.Slug); ok {
+			return true
+		}
+	}
+	return false
+}
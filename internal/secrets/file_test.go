@@ -0,0 +1,69 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/secret"
+)
+
+func TestParseFile(t *testing.T) {
+	for _, path := range []string{"testdata/secrets.yml", "testdata/secrets.json"} {
+		secrets, err := ParseFile(path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(secrets) == 0 || secrets[0].Name != "docker_password" {
+			t.Errorf("Want docker_password secret parsed from %s", path)
+		}
+	}
+	if _, err := ParseFile("testdata/missing.yml"); err == nil {
+		t.Errorf("Expect error when file does not exist")
+	}
+}
+
+func TestFile(t *testing.T) {
+	secrets, err := ParseFile("testdata/secrets.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := File(secrets)
+
+	tests := []struct {
+		name  string
+		repo  string
+		event string
+		data  string
+	}{
+		{"docker_password", "octocat/hello-world", drone.EventPush, "correct-horse-battery-staple"},
+		// secret is restricted to matching repositories.
+		{"docker_password", "spaceghost/hello-world", drone.EventPush, ""},
+		// secret is restricted to non-pull request events.
+		{"docker_password", "octocat/hello-world", drone.EventPullRequest, ""},
+		{"slack_webhook", "spaceghost/hello-world", drone.EventPullRequest, "https://hooks.slack.com/services/T0000/B0000/XXXX"},
+		{"aws_secret_key", "octocat/hello-world", drone.EventPush, ""},
+	}
+	for _, test := range tests {
+		got, err := provider.Find(context.Background(), &secret.Request{
+			Name:  test.name,
+			Repo:  &drone.Repo{Slug: test.repo},
+			Build: &drone.Build{Event: test.event},
+		})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		switch {
+		case test.data == "" && got != nil:
+			t.Errorf("Want nil secret %s for %s", test.name, test.repo)
+		case test.data != "" && (got == nil || got.Data != test.data):
+			t.Errorf("Want secret %s for %s", test.name, test.repo)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package secrets provides an external secret provider that
+// includes the requesting step, pipeline and remote host in
+// the plugin request, so that secrets can be restricted to
+// specific steps or hosts.
+package secrets
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/secret"
+)
+
+type key struct{}
+
+// Context provides the context in which the secret is
+// requested.
+type Context struct {
+	Step     string `json:"step,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	Host     string `json:"host,omitempty"`
+}
+
+// WithContext returns a new context with the secret request
+// context.
+func WithContext(ctx context.Context, c Context) context.Context {
+	return context.WithValue(ctx, key{}, c)
+}
+
+// FromContext returns the secret request context.
+func FromContext(ctx context.Context) Context {
+	c, _ := ctx.Value(key{}).(Context)
+	return c
+}
+
+// request is the secret plugin request payload.
+type request struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Repo     *drone.Repo  `json:"repo,omitempty"`
+	Build    *drone.Build `json:"build,omitempty"`
+	Step     string       `json:"step,omitempty"`
+	Pipeline string       `json:"pipeline,omitempty"`
+	Host     string       `json:"host,omitempty"`
+}
+
+// External returns a secret provider that requests secrets
+// from an external http endpoint. The request includes the
+// step, pipeline and remote host from the context. If the
+// endpoint is empty, a nil secret is always returned.
+func External(endpoint, token string, skipverify bool) secret.Provider {
+	provider := &external{}
+	if endpoint != "" {
+		provider.client = plugin.New(endpoint, token, skipverify)
+	}
+	return provider
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) Find(ctx context.Context, in *secret.Request) (*drone.Secret, error) {
+	if p.client == nil {
+		return nil, nil
+	}
+
+	// lookup the named secret in the manifest. If the secret
+	// does not exist, return a nil secret, allowing the next
+	// secret provider in the chain to be invoked.
+	path, name, ok := getExternal(in.Conf, in.Name)
+	if !ok {
+		return nil, nil
+	}
+
+	c := FromContext(ctx)
+	req := &request{
+		Name:     name,
+		Path:     path,
+		Repo:     in.Repo,
+		Build:    in.Build,
+		Step:     c.Step,
+		Pipeline: c.Pipeline,
+		Host:     c.Host,
+	}
+	out := new(drone.Secret)
+	err := p.client.Do(ctx, req, out)
+	if plugin.IsStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// if the secret is empty return nil, allowing the next
+	// secret provider in the chain to be invoked.
+	if out.Data == "" {
+		return nil, nil
+	}
+
+	// the secret can be restricted to non-pull request
+	// events. If the secret is restricted, return nil.
+	if in.Build != nil && in.Build.Event == drone.EventPullRequest && !out.PullRequest {
+		return nil, nil
+	}
+
+	return &drone.Secret{
+		Name: in.Name,
+		Data: out.Data,
+	}, nil
+}
+
+// helper function returns the path and name of the external
+// secret defined in the manifest.
+func getExternal(conf *manifest.Manifest, match string) (path, name string, ok bool) {
+	if conf == nil {
+		return
+	}
+	for _, resource := range conf.Resources {
+		res, ok := resource.(*manifest.Secret)
+		if !ok || res.Name != match {
+			continue
+		}
+		if res.Get.Name == "" && res.Get.Path == "" {
+			continue
+		}
+		return res.Get.Path, res.Get.Name, true
+	}
+	return
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/httpsignatures-go"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/secret"
+)
+
+func TestExternal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signature, err := httpsignatures.FromRequest(r); err != nil || !signature.IsValid("secret", r) {
+			t.Errorf("Expect request signed with the shared secret")
+		}
+		in := new(request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Path, "secret/data/production"; got != want {
+			t.Errorf("Want path %s, got %s", want, got)
+		}
+		if got, want := in.Step, "deploy"; got != want {
+			t.Errorf("Want step %s, got %s", want, got)
+		}
+		if got, want := in.Pipeline, "default"; got != want {
+			t.Errorf("Want pipeline %s, got %s", want, got)
+		}
+		if got, want := in.Host, "prod.example.com:22"; got != want {
+			t.Errorf("Want host %s, got %s", want, got)
+		}
+		json.NewEncoder(w).Encode(&drone.Secret{Data: "correct-horse-battery-staple"})
+	}))
+	defer ts.Close()
+
+	ctx := WithContext(context.Background(), Context{
+		Step:     "deploy",
+		Pipeline: "default",
+		Host:     "prod.example.com:22",
+	})
+	provider := External(ts.URL, "secret", false)
+	got, err := provider.Find(ctx, &secret.Request{
+		Name:  "password",
+		Build: &drone.Build{Event: drone.EventPush},
+		Repo:  &drone.Repo{},
+		Conf: &manifest.Manifest{
+			Resources: []manifest.Resource{
+				&manifest.Secret{
+					Name: "password",
+					Get:  manifest.SecretGet{Path: "secret/data/production", Name: "password"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got == nil || got.Data != "correct-horse-battery-staple" {
+		t.Errorf("Expect secret returned by the external provider")
+	}
+}
+
+func TestExternal_NotDefined(t *testing.T) {
+	provider := External("http://localhost:1", "secret", false)
+	got, err := provider.Find(context.Background(), &secret.Request{
+		Name: "password",
+		Conf: &manifest.Manifest{},
+	})
+	if err != nil || got != nil {
+		t.Errorf("Expect nil secret when not defined in the manifest")
+	}
+}
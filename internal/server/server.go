@@ -0,0 +1,161 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package server provides the http server for the dashboard and
+// metrics endpoints, with optional tls using a static
+// certificate or certificates issued by Let's Encrypt.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/errgroup"
+)
+
+// Server is an http server.
+type Server struct {
+	// Addr is the address the server listens on. It is
+	// ignored if Acme is enabled, in which case the server
+	// listens on the standard http and https ports.
+	Addr string
+
+	// Handler handles the http requests.
+	Handler http.Handler
+
+	// Cert and Key are the paths of the tls certificate and
+	// private key. If set, the server listens for https
+	// requests on the configured address.
+	Cert string
+	Key  string
+
+	// Acme enables certificates issued by Let's Encrypt for
+	// the host.
+	Acme bool
+
+	// Host is the public hostname of the server, for which
+	// certificates are issued.
+	Host string
+
+	// Email is the contact address registered with Let's
+	// Encrypt. It is optional.
+	Email string
+
+	// CacheDir is the directory in which issued certificates
+	// are cached. If empty, the user cache directory is used.
+	CacheDir string
+}
+
+// ListenAndServe listens for http requests until the context
+// is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	switch {
+	case s.Acme:
+		return s.listenAndServeAcme(ctx)
+	case s.Cert != "" && s.Key != "":
+		return s.listenAndServeTLS(ctx)
+	default:
+		return s.listenAndServe(ctx)
+	}
+}
+
+func (s *Server) listenAndServe(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    s.Addr,
+		Handler: s.Handler,
+	}
+	return serve(ctx, func() error {
+		return srv.ListenAndServe()
+	}, srv)
+}
+
+func (s *Server) listenAndServeTLS(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:      s.Addr,
+		Handler:   s.Handler,
+		TLSConfig: tlsConfig(),
+	}
+	return serve(ctx, func() error {
+		return srv.ListenAndServeTLS(s.Cert, s.Key)
+	}, srv)
+}
+
+func (s *Server) listenAndServeAcme(ctx context.Context) error {
+	m := &autocert.Manager{
+		Email:      s.Email,
+		Cache:      autocert.DirCache(s.cacheDir()),
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(s.Host),
+	}
+	config := tlsConfig()
+	config.GetCertificate = m.GetCertificate
+
+	// the http server responds to the acme http-01 challenge,
+	// and redirects all other requests to https.
+	srv1 := &http.Server{
+		Addr:    ":http",
+		Handler: m.HTTPHandler(nil),
+	}
+	srv2 := &http.Server{
+		Addr:      ":https",
+		Handler:   s.Handler,
+		TLSConfig: config,
+	}
+
+	var g errgroup.Group
+	g.Go(func() error {
+		return serve(ctx, func() error {
+			return srv1.ListenAndServe()
+		}, srv1)
+	})
+	g.Go(func() error {
+		return serve(ctx, func() error {
+			return srv2.ListenAndServeTLS("", "")
+		}, srv2)
+	})
+	return g.Wait()
+}
+
+// helper function returns the certificate cache directory.
+func (s *Server) cacheDir() string {
+	if s.CacheDir != "" {
+		return s.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "drone-runner-ssh", "autocert")
+}
+
+// helper function returns the tls configuration shared by the
+// static certificate and acme servers.
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// helper function runs the server until the context is
+// cancelled, and then gracefully shuts down the server. The
+// server is also shut down if it fails to listen.
+func serve(ctx context.Context, listen func() error, srv *http.Server) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		<-ctx.Done()
+		return srv.Shutdown(context.Background())
+	})
+	g.Go(func() error {
+		if err := listen(); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	return g.Wait()
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenAndServe(t *testing.T) {
+	addr := freeAddr(t)
+	s := &Server{
+		Addr:    addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	testServe(t, s, "http://"+addr, http.DefaultClient)
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeCert(t, dir)
+	addr := freeAddr(t)
+	s := &Server{
+		Addr:    addr,
+		Cert:    cert,
+		Key:     key,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	testServe(t, s, "https://"+addr, client)
+}
+
+func TestListenAndServeTLS_Error(t *testing.T) {
+	s := &Server{
+		Addr: freeAddr(t),
+		Cert: "testdata/missing.crt",
+		Key:  "testdata/missing.key",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.ListenAndServe(ctx); err == nil {
+		t.Errorf("Expect error when certificate is missing")
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Expect server to return before the context is cancelled")
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	s := &Server{CacheDir: "/var/lib/drone/autocert"}
+	if got, want := s.cacheDir(), "/var/lib/drone/autocert"; got != want {
+		t.Errorf("Want cache dir %s, got %s", want, got)
+	}
+	s.CacheDir = ""
+	if got := s.cacheDir(); filepath.Base(got) != "autocert" {
+		t.Errorf("Want default cache dir, got %s", got)
+	}
+}
+
+// helper function starts the server, verifies it responds to
+// requests, and verifies it shuts down when the context is
+// cancelled.
+func testServe(t *testing.T, s *Server, url string, client *http.Client) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.ListenAndServe(ctx)
+	}()
+
+	var err error
+	for i := 0; i < 50; i++ {
+		var res *http.Response
+		res, err = client.Get(url)
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error(err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expect server shut down when context is cancelled")
+	}
+}
+
+// helper function returns a free local address.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// helper function writes a self-signed certificate and key to
+// the directory.
+func writeCert(t *testing.T, dir string) (string, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := filepath.Join(dir, "server.crt")
+	key := filepath.Join(dir, "server.key")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder}), 0600)
+	return cert, key
+}
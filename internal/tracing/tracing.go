@@ -0,0 +1,282 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package tracing provides tracing of pipeline execution. Spans
+// are exported to an OpenTelemetry collector using the OTLP/HTTP
+// protocol with json encoding.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type key int
+
+const (
+	tracerKey key = iota
+	spanKey
+)
+
+// Tracer records spans and exports them to an OpenTelemetry
+// collector.
+type Tracer struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// New returns a new Tracer that exports spans to the OTLP/HTTP
+// endpoint, for example http://localhost:4318/v1/traces. If the
+// endpoint is empty, a nil Tracer is returned and spans are not
+// recorded.
+func New(endpoint, service string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &Tracer{
+		endpoint: endpoint,
+		service:  service,
+		client:   http.DefaultClient,
+	}
+}
+
+// Span represents a single traced operation.
+type Span struct {
+	tracer  *Tracer
+	name    string
+	traceID string
+	spanID  string
+	parent  string
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     error
+}
+
+// WithContext returns a new context with the tracer.
+func WithContext(ctx context.Context, tracer *Tracer) context.Context {
+	if tracer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey, tracer)
+}
+
+// Detach returns a new background context with the tracer and
+// current span of the parent context. The returned context is
+// not cancelled when the parent context is cancelled.
+func Detach(ctx context.Context) context.Context {
+	out := context.Background()
+	if tracer, ok := ctx.Value(tracerKey).(*Tracer); ok {
+		out = context.WithValue(out, tracerKey, tracer)
+	}
+	if span, ok := ctx.Value(spanKey).(*Span); ok {
+		out = context.WithValue(out, spanKey, span)
+	}
+	return out
+}
+
+// Start starts a new span that is a child of the current span
+// in the context. If the context does not have a tracer, a nil
+// span is returned. It is safe to invoke methods on a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	tracer, ok := ctx.Value(tracerKey).(*Tracer)
+	if !ok {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer: tracer,
+		name:   name,
+		spanID: randomID(8),
+		start:  time.Now(),
+		attrs:  map[string]string{},
+	}
+	if parent, ok := ctx.Value(spanKey).(*Span); ok && parent != nil {
+		span.traceID = parent.traceID
+		span.parent = parent.spanID
+	} else {
+		span.traceID = randomID(16)
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Traceparent returns the W3C trace context of the current span
+// in the context, propagated to the pipeline steps. If tracing
+// is disabled, an empty string is returned.
+func Traceparent(ctx context.Context) string {
+	span, ok := ctx.Value(spanKey).(*Span)
+	if !ok || span == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", span.traceID, span.spanID)
+}
+
+// SetAttribute sets the span attribute.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// End ends the span, recording the error if not nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Start starts a loop that periodically exports the recorded
+// spans until the context is cancelled.
+func (t *Tracer) Start(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return t.Flush(context.Background())
+		case <-ticker.C:
+			t.Flush(ctx)
+		}
+	}
+}
+
+// Flush exports the recorded spans to the collector.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(t.convert(spans)); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return fmt.Errorf("tracing: unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// helper function converts the spans to the otlp json format.
+func (t *Tracer) convert(spans []*Span) *export {
+	out := &export{}
+	rs := resourceSpans{}
+	rs.Resource.Attributes = []attribute{newAttribute("service.name", t.service)}
+	ss := scopeSpans{}
+	ss.Scope.Name = "drone-runner-ssh"
+	for _, span := range spans {
+		s := span.convert()
+		ss.Spans = append(ss.Spans, s)
+	}
+	rs.ScopeSpans = append(rs.ScopeSpans, ss)
+	out.ResourceSpans = append(out.ResourceSpans, rs)
+	return out
+}
+
+func (s *Span) convert() *otlpSpan {
+	out := &otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parent,
+		Name:         s.name,
+		Kind:         1, // internal
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	for k, v := range s.attrs {
+		out.Attributes = append(out.Attributes, newAttribute(k, v))
+	}
+	if s.err != nil {
+		out.Status.Code = 2 // error
+		out.Status.Message = s.err.Error()
+	}
+	return out
+}
+
+// helper function returns a random hex-encoded identifier of
+// n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type (
+	export struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource struct {
+			Attributes []attribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []*otlpSpan `json:"spans"`
+	}
+
+	otlpSpan struct {
+		TraceID      string      `json:"traceId"`
+		SpanID       string      `json:"spanId"`
+		ParentSpanID string      `json:"parentSpanId,omitempty"`
+		Name         string      `json:"name"`
+		Kind         int         `json:"kind"`
+		Start        string      `json:"startTimeUnixNano"`
+		End          string      `json:"endTimeUnixNano"`
+		Attributes   []attribute `json:"attributes,omitempty"`
+		Status       struct {
+			Code    int    `json:"code,omitempty"`
+			Message string `json:"message,omitempty"`
+		} `json:"status"`
+	}
+
+	attribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+)
+
+func newAttribute(key, value string) attribute {
+	a := attribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
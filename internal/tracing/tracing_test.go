@@ -0,0 +1,91 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	got := new(export)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(got)
+	}))
+	defer ts.Close()
+
+	tracer := New(ts.URL, "drone-runner-ssh")
+	ctx := WithContext(context.Background(), tracer)
+
+	ctx, parent := Start(ctx, "stage")
+	_, child := Start(ctx, "ssh.dial")
+	child.SetAttribute("host", "localhost:22")
+	child.End(errors.New("connection refused"))
+	parent.End(nil)
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Errorf("Expect spans exported")
+		return
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Errorf("Want 2 spans exported, got %d", len(spans))
+		return
+	}
+	if got, want := spans[0].Name, "ssh.dial"; got != want {
+		t.Errorf("Want span name %s, got %s", want, got)
+	}
+	if spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("Expect child span shares trace id with parent")
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("Expect child span references parent span")
+	}
+	if got, want := spans[0].Status.Code, 2; got != want {
+		t.Errorf("Want error status code %d, got %d", want, got)
+	}
+	if len(spans[0].Attributes) != 1 || spans[0].Attributes[0].Value.StringValue != "localhost:22" {
+		t.Errorf("Expect span attribute exported")
+	}
+}
+
+func TestTracer_Disabled(t *testing.T) {
+	if New("", "drone-runner-ssh") != nil {
+		t.Errorf("Expect nil tracer when endpoint is empty")
+	}
+	ctx := WithContext(context.Background(), nil)
+	_, span := Start(ctx, "stage")
+	if span != nil {
+		t.Errorf("Expect nil span when tracing is disabled")
+	}
+	// methods are safe to invoke on a nil span.
+	span.SetAttribute("host", "localhost:22")
+	span.End(nil)
+
+	if got := Traceparent(ctx); got != "" {
+		t.Errorf("Want empty traceparent when tracing is disabled, got %s", got)
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	tracer := New("http://localhost:4318/v1/traces", "drone-runner-ssh")
+	ctx, span := Start(WithContext(context.Background(), tracer), "stage")
+	want := "00-" + span.traceID + "-" + span.spanID + "-01"
+	if got := Traceparent(ctx); got != want {
+		t.Errorf("Want traceparent %s, got %s", want, got)
+	}
+	if len(want) != 55 {
+		t.Errorf("Want traceparent of 55 characters, got %d", len(want))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package variables provides environment variables from an
+// external provider, which may return variables specific to
+// the remote host, such as local mirror urls.
+package variables
+
+import (
+	"context"
+
+	"github.com/drone-runners/drone-runner-ssh/internal/plugin"
+
+	"github.com/drone/drone-go/drone"
+)
+
+type (
+	// Provider returns a list of environment variables.
+	Provider interface {
+		// List returns the environment variables for the
+		// build and remote host.
+		List(context.Context, *Request) (map[string]string, error)
+	}
+
+	// Request provides the repository, build and remote host
+	// details.
+	Request struct {
+		Repo     *drone.Repo  `json:"repo,omitempty"`
+		Build    *drone.Build `json:"build,omitempty"`
+		Host     string       `json:"host,omitempty"`
+		Platform Platform     `json:"platform,omitempty"`
+	}
+
+	// Platform provides the remote host platform.
+	Platform struct {
+		OS      string `json:"os,omitempty"`
+		Arch    string `json:"arch,omitempty"`
+		Variant string `json:"variant,omitempty"`
+		Version string `json:"version,omitempty"`
+	}
+)
+
+// Nop returns a provider that returns an empty list.
+func Nop() Provider {
+	return new(nop)
+}
+
+type nop struct{}
+
+func (*nop) List(context.Context, *Request) (map[string]string, error) {
+	return nil, nil
+}
+
+// External returns a provider that requests the environment
+// variables from an external http endpoint. If the endpoint is
+// empty, a no-op provider is returned.
+func External(endpoint, token string, skipverify bool) Provider {
+	if endpoint == "" {
+		return Nop()
+	}
+	return &external{
+		client: plugin.New(endpoint, token, skipverify),
+	}
+}
+
+type external struct {
+	client *plugin.Client
+}
+
+func (p *external) List(ctx context.Context, in *Request) (map[string]string, error) {
+	var out map[string]string
+	err := p.client.Do(ctx, in, &out)
+	return out, err
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package variables
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/99designs/httpsignatures-go"
+	"github.com/drone/drone-go/drone"
+	"github.com/google/go-cmp/cmp"
+)
+
+var noContext = context.Background()
+
+func TestExternal(t *testing.T) {
+	want := map[string]string{
+		"GOPROXY": "https://mirror.dc1.example.com",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signature, err := httpsignatures.FromRequest(r); err != nil || !signature.IsValid("correct-horse-battery-staple", r) {
+			t.Errorf("Expect request signed with the shared secret")
+		}
+		in := new(Request)
+		json.NewDecoder(r.Body).Decode(in)
+		if got, want := in.Host, "build1.dc1.example.com"; got != want {
+			t.Errorf("Want host %s, got %s", want, got)
+		}
+		if got, want := in.Platform.OS, "linux"; got != want {
+			t.Errorf("Want platform os %s, got %s", want, got)
+		}
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "correct-horse-battery-staple", false)
+	got, err := provider.List(noContext, &Request{
+		Repo:     &drone.Repo{Slug: "octocat/hello-world"},
+		Build:    &drone.Build{},
+		Host:     "build1.dc1.example.com",
+		Platform: Platform{OS: "linux", Arch: "amd64"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected environment variables")
+		t.Log(diff)
+	}
+}
+
+func TestExternal_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	provider := External(ts.URL, "", false)
+	if _, err := provider.List(noContext, &Request{}); err == nil {
+		t.Errorf("Expect error when unexpected status code")
+	}
+}
+
+func TestNop(t *testing.T) {
+	if got, _ := External("", "", false).List(noContext, &Request{}); got != nil {
+		t.Errorf("Expect nil environment variables")
+	}
+}
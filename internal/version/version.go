@@ -0,0 +1,52 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package version provides the runner build metadata, which
+// is injected at build time using linker flags.
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+)
+
+var (
+	// Version is the semantic version of the runner.
+	Version = "0.0.0"
+
+	// Commit is the git commit sha of the runner build.
+	Commit = ""
+)
+
+// Info provides the runner build metadata.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+	Kind      string `json:"kind"`
+	Type      string `json:"type"`
+}
+
+// Get returns the runner build metadata.
+func Get() *Info {
+	return &Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Kind:      resource.Kind,
+		Type:      resource.Type,
+	}
+}
+
+// Handler returns an http.Handler that writes the runner build
+// metadata to the response body.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package version
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandler(t *testing.T) {
+	Version, Commit = "1.0.0", "8a7a8f6"
+	defer func() {
+		Version, Commit = "0.0.0", ""
+	}()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/version", nil)
+	Handler().ServeHTTP(w, r)
+
+	got := new(Info)
+	json.NewDecoder(w.Body).Decode(got)
+	want := &Info{
+		Version:   "1.0.0",
+		Commit:    "8a7a8f6",
+		GoVersion: runtime.Version(),
+		Kind:      "pipeline",
+		Type:      "ssh",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Unexpected version info")
+		t.Log(diff)
+	}
+}
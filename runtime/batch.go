@@ -0,0 +1,259 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/replacer"
+	"github.com/drone/runner-go/environ"
+	"github.com/drone/runner-go/pipeline"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// execBatch executes the pipeline steps serially in a single
+// remote script. The script output is parsed to update the
+// individual step status and logs.
+func (e *execer) execBatch(ctx context.Context, state *pipeline.State, spec *engine.Spec, hooks *hookLog) error {
+	var result error
+
+	// steps that are never executed are excluded from the
+	// batch, and are not stored in the pipeline state.
+	var steps []*engine.Step
+	for _, step := range spec.Steps {
+		if step.RunPolicy == engine.RunNever {
+			continue
+		}
+		copy := cloneStep(step)
+		state.Lock()
+		copy.Envs = environ.Combine(
+			copy.Envs,
+			environ.Build(state.Build),
+			environ.Stage(state.Stage),
+			environ.Step(findStep(state, step.Name)),
+		)
+		state.Unlock()
+		steps = append(steps, copy)
+	}
+
+	batch := cloneStep(spec.Batch)
+	batch.Batch = steps
+
+	w := &batchWriter{
+		execer:     e,
+		state:      state,
+		renewal:    spec.Renewal,
+		hooks:      hooks,
+		nonce:      spec.BatchNonce,
+		timestamps: spec.Timestamps,
+		steps:      steps,
+		index:      -1,
+	}
+	exited, err := e.engine.Run(ctx, spec, batch, w)
+	if err := w.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if exited != nil {
+		return result
+	}
+
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		state.Cancel()
+		return nil
+	}
+
+	// if the batch failed with an internal error the running
+	// step is failed. If no step is running, all steps are
+	// failed.
+	if step := w.current(); step != nil {
+		state.Fail(step.Name, err)
+		if err := e.reporter.ReportStep(noContext, state, step.Name); err != nil {
+			result = multierror.Append(result, err)
+		}
+	} else {
+		state.FailAll(err)
+	}
+	return result
+}
+
+// batchWriter is an io.WriteCloser that parses the batch script
+// output, writing the output of each step to the step log stream
+// and updating the step status when the step starts and exits.
+// The markers are parsed before the output is prefixed with
+// timestamps.
+type batchWriter struct {
+	sync.Mutex
+
+	execer     *execer
+	state      *pipeline.State
+	renewal    *engine.Renewal
+	hooks      *hookLog
+	nonce      string
+	timestamps string
+	steps      []*engine.Step
+	index      int
+	active     bool
+	stream     io.WriteCloser
+	buf        bytes.Buffer
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// retain the partial line until the remainder of
+			// the line is written.
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.writeLine(line)
+	}
+	return len(p), nil
+}
+
+// Close writes any partial line and closes the log stream of
+// the running step.
+func (w *batchWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.buf.Len() != 0 {
+		w.writeLine(w.buf.String())
+		w.buf.Reset()
+	}
+	if w.stream != nil {
+		err := w.stream.Close()
+		w.stream = nil
+		return err
+	}
+	return nil
+}
+
+// helper function returns the running step, or nil if no step
+// is running.
+func (w *batchWriter) current() *engine.Step {
+	w.Lock()
+	defer w.Unlock()
+	if !w.active {
+		return nil
+	}
+	return w.steps[w.index]
+}
+
+// helper function writes the line to the log stream of the
+// running step, or updates the step status if the line is a
+// batch marker with the batch nonce.
+func (w *batchWriter) writeLine(line string) {
+	start := engine.BatchStart + " " + w.nonce + " "
+	exit := engine.BatchExit + " " + w.nonce + " "
+
+	var marker string
+	switch {
+	case strings.Contains(line, start):
+		marker = start
+	case strings.Contains(line, exit):
+		marker = exit
+	default:
+		if w.stream != nil {
+			w.stream.Write([]byte(line))
+		}
+		return
+	}
+
+	// output written before the marker without a trailing
+	// newline belongs to the running step.
+	i := strings.Index(line, marker)
+	if i > 0 && w.stream != nil {
+		w.stream.Write([]byte(line[:i] + "\n"))
+	}
+
+	fields := strings.Fields(line[i+len(marker):])
+	switch marker {
+	case start:
+		if len(fields) == 1 {
+			w.start(fields[0])
+		}
+	case exit:
+		if len(fields) == 2 {
+			w.exit(fields[0], fields[1])
+		}
+	}
+}
+
+// helper function updates the step status when the step with
+// the given index starts, and opens the step log stream.
+func (w *batchWriter) start(index string) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(w.steps) {
+		return
+	}
+	step := w.steps[i]
+	w.index = i
+	w.active = true
+
+	w.state.Start(step.Name)
+	w.execer.reporter.ReportStep(noContext, w.state, step.Name)
+
+	stream := w.execer.streamer.Stream(noContext, w.state, step.Name)
+	w.stream = replacer.New(stream, step.Secrets)
+	if w.renewal != nil {
+		w.stream = replacer.Renewed(w.stream, w.renewal)
+	}
+	w.stream = w.hooks.wrap(step.Name, w.stream)
+	w.stream = &timestampStream{
+		Writer: engine.WithTimestamps(w.stream, w.timestamps),
+		Closer: w.stream,
+	}
+}
+
+// helper function updates the step status when the step with
+// the given index exits, and closes the step log stream.
+func (w *batchWriter) exit(index, code string) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i != w.index || !w.active {
+		return
+	}
+	exitCode, err := strconv.Atoi(code)
+	if err != nil {
+		return
+	}
+	step := w.steps[i]
+	w.active = false
+
+	if w.stream != nil {
+		w.stream.Close()
+		w.stream = nil
+	}
+
+	w.state.Finish(step.Name, exitCode)
+	w.execer.reporter.ReportStep(noContext, w.state, step.Name)
+
+	// if the exit code is 78 the system will skip all
+	// subsequent pending steps in the pipeline.
+	if exitCode == 78 {
+		w.state.SkipAll()
+	}
+}
+
+// timestampStream is a log stream that prefixes each line of
+// output with a timestamp.
+type timestampStream struct {
+	io.Writer
+	io.Closer
+}
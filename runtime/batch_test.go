@@ -0,0 +1,120 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+type nopReporter struct{}
+
+func (nopReporter) ReportStage(context.Context, *pipeline.State) error        { return nil }
+func (nopReporter) ReportStep(context.Context, *pipeline.State, string) error { return nil }
+
+type bufferStreamer map[string]*bytes.Buffer
+
+func (s bufferStreamer) Stream(_ context.Context, _ *pipeline.State, name string) io.WriteCloser {
+	buf := new(bytes.Buffer)
+	s[name] = buf
+	return nopCloser{buf}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestBatchWriter(t *testing.T) {
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Repo:  &drone.Repo{},
+		Stage: &drone.Stage{
+			Steps: []*drone.Step{
+				{Name: "clone", Status: drone.StatusPending},
+				{Name: "build", Status: drone.StatusPending},
+				{Name: "test", Status: drone.StatusPending},
+			},
+		},
+	}
+	streams := bufferStreamer{}
+	w := &batchWriter{
+		execer: &execer{reporter: nopReporter{}, streamer: streams},
+		state:  state,
+		nonce:  "n0nce",
+		steps:  []*engine.Step{{Name: "clone"}, {Name: "build"}, {Name: "test"}},
+		index:  -1,
+	}
+
+	w.Write([]byte("##[drone:start] n0nce 0\ncloning\n##[drone:exit] n0nce 0 0\n"))
+	w.Write([]byte("##[drone:start] n0nce 1\nbuil"))
+	w.Write([]byte("ding\n##[drone:exit] forged 1 0\nno newline##[drone:exit] n0nce 1 2\n"))
+	w.Close()
+
+	if got, want := streams["clone"].String(), "cloning\n"; got != want {
+		t.Errorf("Want clone output %q, got %q", want, got)
+	}
+	if got, want := streams["build"].String(), "building\n##[drone:exit] forged 1 0\nno newline\n"; got != want {
+		t.Errorf("Want build output %q, got %q", want, got)
+	}
+	if _, ok := streams["test"]; ok {
+		t.Errorf("Expect test step not started")
+	}
+
+	steps := state.Stage.Steps
+	if got, want := steps[0].Status, drone.StatusPassing; got != want {
+		t.Errorf("Want clone status %s, got %s", want, got)
+	}
+	if got, want := steps[1].Status, drone.StatusFailing; got != want {
+		t.Errorf("Want build status %s, got %s", want, got)
+	}
+	if got, want := steps[1].ExitCode, 2; got != want {
+		t.Errorf("Want build exit code %d, got %d", want, got)
+	}
+	if got, want := steps[2].Status, drone.StatusPending; got != want {
+		t.Errorf("Want test status %s, got %s", want, got)
+	}
+	if w.current() != nil {
+		t.Errorf("Expect no running step")
+	}
+}
+
+// This test verifies that the batch markers are parsed before
+// the step output is prefixed with timestamps.
+func TestBatchWriter_Timestamps(t *testing.T) {
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Repo:  &drone.Repo{},
+		Stage: &drone.Stage{
+			Steps: []*drone.Step{
+				{Name: "build", Status: drone.StatusPending},
+			},
+		},
+	}
+	streams := bufferStreamer{}
+	w := &batchWriter{
+		execer:     &execer{reporter: nopReporter{}, streamer: streams},
+		state:      state,
+		nonce:      "n0nce",
+		timestamps: engine.TimestampsRelative,
+		steps:      []*engine.Step{{Name: "build"}},
+		index:      -1,
+	}
+
+	w.Write([]byte("##[drone:start] n0nce 0\nbuilding\n##[drone:exit] n0nce 0 0\n"))
+	w.Close()
+
+	if got, want := streams["build"].String(), "[00:00] building\n"; got != want {
+		t.Errorf("Want build output %q, got %q", want, got)
+	}
+	if got, want := state.Stage.Steps[0].Status, drone.StatusPassing; got != want {
+		t.Errorf("Want build status %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import "sync/atomic"
+
+type (
+	// Capacity describes the capacity usage of the runner,
+	// used by external autoscalers to add or remove hosts.
+	Capacity struct {
+		Capacity int                      `json:"capacity"`
+		Active   int                      `json:"active"`
+		Pools    map[string]*PoolCapacity `json:"pools,omitempty"`
+		Drained  []string                 `json:"drained,omitempty"`
+	}
+
+	// PoolCapacity describes the capacity usage of a named
+	// pool of remote hosts. The capacity is zero if the
+	// number of stages per host is not limited, in which case
+	// the utilization is the average number of active stages
+	// per host.
+	PoolCapacity struct {
+		Hosts       int            `json:"hosts"`
+		Capacity    int            `json:"capacity"`
+		Active      int            `json:"active"`
+		Utilization float64        `json:"utilization"`
+		PerHost     map[string]int `json:"per_host"`
+	}
+)
+
+// Capacity returns the current capacity usage of the runner
+// and the host pools.
+func (s *Runner) Capacity() *Capacity {
+	out := &Capacity{
+		Capacity: s.MaxStages,
+		Active:   int(atomic.LoadInt64(&s.active)),
+	}
+	if s.Maintenance != nil {
+		out.Drained = s.Maintenance.List()
+	}
+	max := 0
+	if s.Scheduler != nil {
+		max = s.Scheduler.Max
+	}
+	for name, pool := range s.Pools {
+		if out.Pools == nil {
+			out.Pools = map[string]*PoolCapacity{}
+		}
+		out.Pools[name] = pool.capacity(max)
+	}
+	return out
+}
+
+// helper function returns the capacity usage of the pool,
+// given the maximum number of stages per host.
+func (p *Pool) capacity(max int) *PoolCapacity {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := &PoolCapacity{
+		Hosts:   len(p.Hosts),
+		PerHost: map[string]int{},
+	}
+	for _, host := range p.Hosts {
+		out.PerHost[host] = p.active[host]
+		out.Active += p.active[host]
+	}
+	if max > 0 {
+		out.Capacity = max * out.Hosts
+	}
+	switch {
+	case out.Capacity > 0:
+		out.Utilization = float64(out.Active) / float64(out.Capacity)
+	case out.Hosts > 0:
+		out.Utilization = float64(out.Active) / float64(out.Hosts)
+	}
+	return out
+}
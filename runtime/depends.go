@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+
+	"github.com/drone/drone-go/drone"
+)
+
+// Dependencies tracks the stages executing on the runner, and
+// defers dependent stages until the stages they depend on have
+// completed, including the workspace teardown. This prevents
+// stages in the same build from racing on shared remote state.
+type Dependencies struct {
+	mu     sync.Mutex
+	stages map[stageKey]chan struct{}
+}
+
+// stageKey identifies a stage by build and name.
+type stageKey struct {
+	build int64
+	name  string
+}
+
+// Start marks the stage as executing.
+func (d *Dependencies) Start(stage *drone.Stage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stages == nil {
+		d.stages = map[stageKey]chan struct{}{}
+	}
+	d.stages[stageKey{stage.BuildID, stage.Name}] = make(chan struct{})
+}
+
+// Done marks the stage as complete, releasing any dependent
+// stages waiting for the stage to complete.
+func (d *Dependencies) Done(stage *drone.Stage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := stageKey{stage.BuildID, stage.Name}
+	if done, ok := d.stages[key]; ok {
+		close(done)
+		delete(d.stages, key)
+	}
+}
+
+// Wait blocks until the stages the stage depends on are no
+// longer executing, or until the context is cancelled.
+func (d *Dependencies) Wait(ctx context.Context, stage *drone.Stage) error {
+	for _, name := range stage.DependsOn {
+		d.mu.Lock()
+		done, ok := d.stages[stageKey{stage.BuildID, name}]
+		d.mu.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
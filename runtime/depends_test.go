@@ -0,0 +1,42 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+)
+
+func TestDependencies(t *testing.T) {
+	d := new(Dependencies)
+	ctx := context.Background()
+
+	backend := &drone.Stage{BuildID: 1, Name: "backend"}
+	frontend := &drone.Stage{BuildID: 1, Name: "frontend", DependsOn: []string{"backend"}}
+	other := &drone.Stage{BuildID: 2, Name: "frontend", DependsOn: []string{"backend"}}
+
+	if err := d.Wait(ctx, frontend); err != nil {
+		t.Errorf("Expect no wait when dependencies are not executing, got %s", err)
+	}
+
+	d.Start(backend)
+	if err := d.Wait(ctx, other); err != nil {
+		t.Errorf("Expect dependencies tracked per build, got %s", err)
+	}
+
+	ctxtimeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := d.Wait(ctxtimeout, frontend); err == nil {
+		t.Errorf("Expect wait deferred while dependencies are executing")
+	}
+
+	d.Done(backend)
+	if err := d.Wait(ctx, frontend); err != nil {
+		t.Errorf("Expect no wait when dependencies are complete, got %s", err)
+	}
+}
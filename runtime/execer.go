@@ -8,10 +8,12 @@ package runtime
 
 import (
 	"context"
+	"io"
 	"sync"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/replacer"
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
@@ -28,12 +30,23 @@ type Execer interface {
 	Exec(context.Context, *engine.Spec, *pipeline.State) error
 }
 
+// StepExecer executes a single step of a pipeline once the
+// pipeline completes, for example to re-execute a failed step.
+type StepExecer interface {
+	ExecStep(context.Context, *engine.Spec, *engine.Step, io.Writer) (*engine.State, error)
+}
+
 type execer struct {
 	mu       sync.Mutex
 	engine   engine.Engine
 	reporter pipeline.Reporter
 	streamer pipeline.Streamer
 	sem      *semaphore.Weighted
+
+	// optional semaphores that limit the number of steps
+	// that can execute concurrently on each remote host.
+	procs int64
+	sems  map[string]*semaphore.Weighted
 }
 
 // NewExecer returns a new execer used
@@ -56,49 +69,102 @@ func NewExecer(
 	return exec
 }
 
+// NewHostExecer returns a new execer that limits the number of
+// steps that can execute concurrently on each remote host, as
+// opposed to across all remote hosts.
+func NewHostExecer(
+	reporter pipeline.Reporter,
+	streamer pipeline.Streamer,
+	engine engine.Engine,
+	procs int64,
+) Execer {
+	exec := &execer{
+		reporter: reporter,
+		streamer: streamer,
+		engine:   engine,
+	}
+	if procs > 0 {
+		exec.procs = procs
+		exec.sems = map[string]*semaphore.Weighted{}
+	}
+	return exec
+}
+
 // Exec executes the intermediate representation of the pipeline
 // and returns an error if execution fails.
 func (e *execer) Exec(ctx context.Context, spec *engine.Spec, state *pipeline.State) error {
-	defer e.engine.Destroy(noContext, spec)
-
-	if err := e.engine.Setup(noContext, spec); err != nil {
+	// the pipeline environment is setup and destroyed using a
+	// context that is not cancelled, but that retains the
+	// tracing span of the stage.
+	detached := tracing.Detach(ctx)
+
+	// the output of the setup and teardown scripts is written
+	// to the step logs.
+	hooks := newHookLog(spec)
+	spec.HookOutput = hooks
+
+	if err := e.engine.Setup(detached, spec); err != nil {
+		hooks.flush(e.streamer, state, spec)
+		// the workspace may be partially created when setup
+		// fails, and is removed on a best-effort basis unless
+		// configured to retain the workspace for inspection.
+		if !spec.Retain {
+			if err := e.engine.Destroy(detached, spec); err != nil {
+				logger.FromContext(ctx).
+					WithError(err).
+					Warn("cannot destroy partially created workspace")
+			}
+		}
 		state.FailAll(err)
 		return e.reporter.ReportStage(noContext, state)
 	}
+	defer func() {
+		e.engine.Destroy(detached, spec)
+		hooks.Close()
+	}()
 
-	// create a directed graph, where each vertex in the graph
-	// is a pipeline step.
-	var d dag.Runner
-	for _, s := range spec.Steps {
-		step := s
-		d.AddVertex(step.Name, func() error {
-			return e.exec(ctx, state, spec, step)
-		})
-	}
+	var result error
+	if spec.Batch != nil {
+		// if batch mode is enabled, the pipeline steps are
+		// executed serially in a single remote script.
+		if err := e.execBatch(ctx, state, spec, hooks); err != nil {
+			multierror.Append(result, err)
+		}
+	} else {
+		// create a directed graph, where each vertex in the
+		// graph is a pipeline step.
+		var d dag.Runner
+		for _, s := range spec.Steps {
+			step := s
+			d.AddVertex(step.Name, func() error {
+				return e.exec(ctx, state, spec, step, hooks)
+			})
+		}
 
-	// create the vertex edges from the values configured in the
-	// depends_on attribute.
-	for _, s := range spec.Steps {
-		for _, dep := range s.DependsOn {
-			d.AddEdge(dep, s.Name)
+		// create the vertex edges from the values configured
+		// in the depends_on attribute.
+		for _, s := range spec.Steps {
+			for _, dep := range s.DependsOn {
+				d.AddEdge(dep, s.Name)
+			}
 		}
-	}
 
-	var result error
-	if err := d.Run(); err != nil {
-		multierror.Append(result, err)
+		if err := d.Run(); err != nil {
+			multierror.Append(result, err)
+		}
 	}
 
 	// once pipeline execution completes, notify the state
 	// manageer that all steps are finished.
 	state.FinishAll()
+	logSummary(logger.FromContext(ctx), state, spec.Server.Hostname)
 	if err := e.reporter.ReportStage(noContext, state); err != nil {
 		multierror.Append(result, err)
 	}
 	return result
 }
 
-func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.Spec, step *engine.Step) error {
+func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.Spec, step *engine.Step, hooks *hookLog) error {
 	var result error
 
 	select {
@@ -112,11 +178,11 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	log = log.WithField("step.name", step.Name)
 	ctx = logger.WithContext(ctx, log)
 
-	if e.sem != nil {
+	if sem := e.semaphore(spec.Server.Hostname); sem != nil {
 		// the semaphore limits the number of steps that can run
 		// concurrently. acquire the semaphore and release when
 		// the pipeline completes.
-		if err := e.sem.Acquire(ctx, 1); err != nil {
+		if err := sem.Acquire(ctx, 1); err != nil {
 			return nil
 		}
 
@@ -128,7 +194,7 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 				// TODO(bradrydzewsi) log the panic.
 			}
 			// release the semaphore
-			e.sem.Release(1)
+			sem.Release(1)
 		}()
 	}
 
@@ -171,6 +237,10 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	// writer used to stream build logs.
 	wc := e.streamer.Stream(noContext, state, step.Name)
 	wc = replacer.New(wc, step.Secrets)
+	if spec.Renewal != nil {
+		wc = replacer.Renewed(wc, spec.Renewal)
+	}
+	wc = hooks.wrap(step.Name, wc)
 
 	// if the step is configured as a daemon, it is detached
 	// from the main process and executed separately.
@@ -180,6 +250,13 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 			e.engine.Run(ctx, spec, copy, wc)
 			wc.Close()
 		}()
+		// subsequent steps are not started until the readiness
+		// probe succeeds. The step fails if the probe times out.
+		if err := engine.WaitReady(ctx, spec, copy); err != nil {
+			log.WithError(err).Warn("detached step is not ready")
+			state.Fail(step.Name, err)
+			return e.reporter.ReportStep(noContext, state, step.Name)
+		}
 		return nil
 	}
 
@@ -192,7 +269,32 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	}
 
 	if exited != nil {
-		state.Finish(step.Name, exited.ExitCode)
+		// the step failure policy may ignore or skip specific
+		// non-zero exit codes instead of failing the stage.
+		switch {
+		case containsCode(step.IgnoreCodes, exited.ExitCode):
+			state.Lock()
+			findStep(state, step.Name).ErrIgnore = true
+			state.Unlock()
+			state.Finish(step.Name, exited.ExitCode)
+		case containsCode(step.SkipCodes, exited.ExitCode):
+			state.Lock()
+			findStep(state, step.Name).ErrIgnore = true
+			state.Unlock()
+			state.Finish(step.Name, exited.ExitCode)
+			state.Lock()
+			findStep(state, step.Name).Status = drone.StatusSkipped
+			state.Unlock()
+		default:
+			state.Finish(step.Name, exited.ExitCode)
+		}
+		// if the process was killed by a signal the reason is
+		// reported with the step status.
+		if reason := exited.Reason(); reason != "" {
+			state.Lock()
+			findStep(state, step.Name).Error = reason
+			state.Unlock()
+		}
 		err := e.reporter.ReportStep(noContext, state, step.Name)
 		if err != nil {
 			multierror.Append(result, err)
@@ -221,6 +323,49 @@ func (e *execer) exec(ctx context.Context, state *pipeline.State, spec *engine.S
 	return result
 }
 
+// ExecStep executes a single step of the pipeline, subject to
+// the same concurrency limits as the steps executed by Exec.
+// The step is cancelled if the stage timeout elapses.
+func (e *execer) ExecStep(ctx context.Context, spec *engine.Spec, step *engine.Step, w io.Writer) (*engine.State, error) {
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+	if sem := e.semaphore(spec.Server.Hostname); sem != nil {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer sem.Release(1)
+	}
+
+	// the connection shared by the pipeline steps is closed
+	// when the pipeline is destroyed. The step therefore dials
+	// its own connection, which opens no more sessions than
+	// the session limit of the remote server allows.
+	copy := new(engine.Spec)
+	*copy = *spec
+	copy.Server.MaxSessions = 0
+	return e.engine.Run(ctx, copy, cloneStep(step), w)
+}
+
+// helper function returns the semaphore that limits the number
+// of steps that can execute concurrently on the host. A nil
+// value is returned if the number of steps is not limited.
+func (e *execer) semaphore(host string) *semaphore.Weighted {
+	if e.sems == nil {
+		return e.sem
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sem, ok := e.sems[host]
+	if !ok {
+		sem = semaphore.NewWeighted(e.procs)
+		e.sems[host] = sem
+	}
+	return sem
+}
+
 // helper function to clone a step. The runner mutates a step to
 // update the environment variables to reflect the current
 // pipeline state.
@@ -231,6 +376,17 @@ func cloneStep(src *engine.Step) *engine.Step {
 	return dst
 }
 
+// helper function returns true if the exit code is in the
+// list of exit codes.
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 // helper function returns the named step from the state.
 func findStep(state *pipeline.State, name string) *drone.Step {
 	for _, step := range state.Stage.Steps {
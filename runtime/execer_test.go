@@ -7,7 +7,16 @@
 package runtime
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
 	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
 )
 
 func TestExec(t *testing.T) {
@@ -37,3 +46,209 @@ func TestExec_ReportError(t *testing.T) {
 func TestExec_SkipCtxDone(t *testing.T) {
 	t.Skip()
 }
+
+func Test_containsCode(t *testing.T) {
+	if containsCode(nil, 2) {
+		t.Errorf("Want false for empty exit code list")
+	}
+	if !containsCode([]int{2, 3}, 3) {
+		t.Errorf("Want true for matching exit code")
+	}
+	if containsCode([]int{2, 3}, 1) {
+		t.Errorf("Want false for non-matching exit code")
+	}
+}
+
+func TestExecer_Semaphore(t *testing.T) {
+	exec := NewExecer(nil, nil, nil, 0).(*execer)
+	if exec.semaphore("a.example.com") != nil {
+		t.Errorf("Want nil semaphore when procs not limited")
+	}
+
+	exec = NewExecer(nil, nil, nil, 2).(*execer)
+	if exec.semaphore("a.example.com") != exec.semaphore("b.example.com") {
+		t.Errorf("Want global semaphore shared across hosts")
+	}
+
+	exec = NewHostExecer(nil, nil, nil, 2).(*execer)
+	a := exec.semaphore("a.example.com")
+	if a == nil {
+		t.Errorf("Want host semaphore when procs limited")
+	}
+	if a != exec.semaphore("a.example.com") {
+		t.Errorf("Want same semaphore for the same host")
+	}
+	if a == exec.semaphore("b.example.com") {
+		t.Errorf("Want separate semaphore for each host")
+	}
+}
+
+// setupFailure is an engine that fails pipeline setup and
+// records whether the pipeline environment was destroyed.
+type setupFailure struct {
+	engine.Engine
+	destroyed bool
+}
+
+func (e *setupFailure) Setup(context.Context, *engine.Spec) error {
+	return errors.New("cannot create workspace directory")
+}
+
+func (e *setupFailure) Destroy(context.Context, *engine.Spec) error {
+	e.destroyed = true
+	return nil
+}
+
+func TestExec_SetupFailure(t *testing.T) {
+	eng := new(setupFailure)
+	exec := NewExecer(nopReporter{}, nil, eng, 0)
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Stage: &drone.Stage{},
+	}
+	exec.Exec(context.Background(), &engine.Spec{}, state)
+	if !eng.destroyed {
+		t.Errorf("Expect partially created workspace destroyed when setup fails")
+	}
+
+	eng = new(setupFailure)
+	exec = NewExecer(nopReporter{}, nil, eng, 0)
+	exec.Exec(context.Background(), &engine.Spec{Retain: true}, state)
+	if eng.destroyed {
+		t.Errorf("Expect workspace retained when setup fails")
+	}
+}
+
+// hookOutput is an engine that writes the setup and teardown
+// script output, and the step name as the step output.
+type hookOutput struct {
+	engine.Engine
+}
+
+func (e *hookOutput) Setup(_ context.Context, spec *engine.Spec) error {
+	spec.HookOutput.Write([]byte("installing dependencies\n"))
+	return nil
+}
+
+func (e *hookOutput) Destroy(_ context.Context, spec *engine.Spec) error {
+	spec.HookOutput.Write([]byte("removing dependencies\n"))
+	return nil
+}
+
+func (e *hookOutput) Run(_ context.Context, _ *engine.Spec, step *engine.Step, w io.Writer) (*engine.State, error) {
+	w.Write([]byte(step.Name + "\n"))
+	return &engine.State{Exited: true}, nil
+}
+
+func TestExec_HookOutput(t *testing.T) {
+	streams := bufferStreamer{}
+	exec := NewExecer(nopReporter{}, streams, new(hookOutput), 0)
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Repo:  &drone.Repo{},
+		Stage: &drone.Stage{
+			Steps: []*drone.Step{
+				{Name: "build", Status: drone.StatusPending},
+				{Name: "test", Status: drone.StatusPending},
+			},
+		},
+	}
+	spec := &engine.Spec{
+		Setup:    &engine.Step{Name: "setup"},
+		Teardown: &engine.Step{Name: "teardown"},
+		Steps: []*engine.Step{
+			{Name: "build"},
+			{Name: "test", DependsOn: []string{"build"}},
+		},
+	}
+	exec.Exec(context.Background(), spec, state)
+
+	if got, want := streams["build"].String(), "installing dependencies\nbuild\n"; got != want {
+		t.Errorf("Expect setup output in the first step log, got %q", got)
+	}
+	if got, want := streams["test"].String(), "test\nremoving dependencies\n"; got != want {
+		t.Errorf("Expect teardown output in the last step log, got %q", got)
+	}
+}
+
+// failingHook is an engine that fails the setup script.
+type failingHook struct {
+	setupFailure
+}
+
+func (e *failingHook) Setup(_ context.Context, spec *engine.Spec) error {
+	spec.HookOutput.Write([]byte("apt-get: command not found\n"))
+	return errors.New("exit status 127")
+}
+
+func TestExec_HookOutputSetupFailure(t *testing.T) {
+	streams := bufferStreamer{}
+	exec := NewExecer(nopReporter{}, streams, new(failingHook), 0)
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Stage: &drone.Stage{
+			Steps: []*drone.Step{{Name: "build"}},
+		},
+	}
+	spec := &engine.Spec{
+		Setup: &engine.Step{Name: "setup"},
+		Steps: []*engine.Step{{Name: "build"}},
+	}
+	exec.Exec(context.Background(), spec, state)
+	stream, ok := streams["build"]
+	if !ok {
+		t.Fatalf("Expect setup output written to the first step log")
+	}
+	if got, want := stream.String(), "apt-get: command not found\n"; got != want {
+		t.Errorf("Want setup output %q, got %q", want, got)
+	}
+}
+
+// stepDeadline is an engine that records the context deadline
+// and specification of the executed step.
+type stepDeadline struct {
+	engine.Engine
+	deadline bool
+	spec     *engine.Spec
+}
+
+func (e *stepDeadline) Run(ctx context.Context, spec *engine.Spec, _ *engine.Step, _ io.Writer) (*engine.State, error) {
+	_, e.deadline = ctx.Deadline()
+	e.spec = spec
+	return &engine.State{Exited: true}, nil
+}
+
+func TestExecStep(t *testing.T) {
+	eng := new(stepDeadline)
+	exec := NewExecer(nil, nil, eng, 1).(*execer)
+	spec := &engine.Spec{Timeout: time.Hour}
+	spec.Server.MaxSessions = 10
+	if _, err := exec.ExecStep(context.Background(), spec, &engine.Step{Name: "test"}, ioutil.Discard); err != nil {
+		t.Error(err)
+		return
+	}
+	if !eng.deadline {
+		t.Errorf("Expect stage timeout applied to the step")
+	}
+	if eng.spec.Server.MaxSessions != 0 {
+		t.Errorf("Expect step to dial its own connection")
+	}
+	if spec.Server.MaxSessions != 10 {
+		t.Errorf("Expect retained specification not modified")
+	}
+	if !exec.sem.TryAcquire(1) {
+		t.Errorf("Expect semaphore released")
+	}
+}
+
+func TestExecStep_Semaphore(t *testing.T) {
+	exec := NewExecer(nil, nil, new(stepDeadline), 1).(*execer)
+	exec.sem.Acquire(context.Background(), 1)
+	defer exec.sem.Release(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := exec.ExecStep(ctx, &engine.Spec{}, &engine.Step{}, ioutil.Discard); err == nil {
+		t.Errorf("Expect step to wait for the concurrency limit")
+	}
+}
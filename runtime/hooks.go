@@ -0,0 +1,127 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/replacer"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// hookLog writes the output of the setup and teardown scripts,
+// which execute outside of the pipeline steps, to the step logs.
+// The setup output is written to the log of the first step that
+// starts. The teardown output is written to the log of the last
+// step, which is held open until the pipeline is destroyed.
+type hookLog struct {
+	sync.Mutex
+
+	last   string
+	buf    bytes.Buffer
+	opened bool
+	held   io.WriteCloser
+}
+
+// newHookLog returns a hookLog for the pipeline.
+func newHookLog(spec *engine.Spec) *hookLog {
+	h := new(hookLog)
+	if spec.Teardown == nil {
+		return h
+	}
+	// detached steps are excluded because the log stream is
+	// closed when the detached step exits, which may happen
+	// after the teardown script executes.
+	for _, step := range spec.Steps {
+		if step.RunPolicy == engine.RunNever || step.Detach {
+			continue
+		}
+		h.last = step.Name
+	}
+	return h
+}
+
+// Write writes the script output to the held log stream of the
+// last step, or buffers the output until the first step log
+// stream is opened.
+func (h *hookLog) Write(p []byte) (int, error) {
+	h.Lock()
+	defer h.Unlock()
+	switch {
+	case h.held != nil:
+		return h.held.Write(p)
+	case !h.opened:
+		return h.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// wrap writes the buffered setup output to the log stream if it
+// is the first step log stream opened. The log stream of the
+// last step is not closed until the hookLog is closed.
+func (h *hookLog) wrap(name string, wc io.WriteCloser) io.WriteCloser {
+	if h == nil {
+		return wc
+	}
+	h.Lock()
+	defer h.Unlock()
+	if !h.opened {
+		h.opened = true
+		if h.buf.Len() != 0 {
+			wc.Write(h.buf.Bytes())
+			h.buf.Reset()
+		}
+	}
+	if h.last != "" && name == h.last {
+		return &heldStream{WriteCloser: wc, hooks: h}
+	}
+	return wc
+}
+
+// flush writes the buffered setup output to the log stream of
+// the first step. This is used when the setup script fails and
+// no steps are started.
+func (h *hookLog) flush(streamer pipeline.Streamer, state *pipeline.State, spec *engine.Spec) {
+	h.Lock()
+	defer h.Unlock()
+	if h.opened || h.buf.Len() == 0 || len(spec.Steps) == 0 {
+		return
+	}
+	h.opened = true
+	step := spec.Steps[0]
+	wc := replacer.New(streamer.Stream(noContext, state, step.Name), step.Secrets)
+	wc.Write(h.buf.Bytes())
+	wc.Close()
+	h.buf.Reset()
+}
+
+// Close closes the held log stream of the last step.
+func (h *hookLog) Close() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.held == nil {
+		return nil
+	}
+	err := h.held.Close()
+	h.held = nil
+	return err
+}
+
+// heldStream is a log stream that is held open when closed,
+// so that the teardown output can be appended.
+type heldStream struct {
+	io.WriteCloser
+	hooks *hookLog
+}
+
+func (s *heldStream) Close() error {
+	s.hooks.Lock()
+	s.hooks.held = s.WriteCloser
+	s.hooks.Unlock()
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFailures is the number of recent failures retained for
+// each host.
+const maxFailures = 5
+
+type (
+	// HostStatus describes a configured or observed remote
+	// host.
+	HostStatus struct {
+		Host      string        `json:"host"`
+		LastSeen  time.Time     `json:"last_seen,omitempty"`
+		LastBuild string        `json:"last_build,omitempty"`
+		Active    int           `json:"active"`
+		Failures  []HostFailure `json:"failures,omitempty"`
+	}
+
+	// HostFailure describes a failed stage on the host.
+	HostFailure struct {
+		Build   string    `json:"build"`
+		Message string    `json:"message"`
+		Time    time.Time `json:"time"`
+	}
+)
+
+// Hosts tracks the status of the remote hosts. If the path is
+// set, the registry is persisted to the file so that the host
+// history survives a runner restart.
+type Hosts struct {
+	Path string
+
+	mu    sync.Mutex
+	hosts map[string]*HostStatus
+}
+
+// Load loads the persisted registry, if the file exists.
+func (h *Hosts) Load() error {
+	if h.Path == "" {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(h.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var hosts []*HostStatus
+	if err := json.Unmarshal(raw, &hosts); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, host := range hosts {
+		// stages that were active when the runner stopped
+		// are no longer running.
+		host.Active = 0
+		h.lookup(host.Host)
+		h.hosts[host.Host] = host
+	}
+	return nil
+}
+
+// Add adds the configured host to the registry.
+func (h *Hosts) Add(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lookup(host)
+}
+
+// Start records that a stage for the build started on the
+// host.
+func (h *Hosts) Start(host, build string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := h.lookup(host)
+	status.Active++
+	status.LastSeen = time.Now()
+	status.LastBuild = build
+}
+
+// Done records that a stage for the build completed on the
+// host. If the message is not empty, the stage failed.
+func (h *Hosts) Done(host, build, message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := h.lookup(host)
+	if status.Active > 0 {
+		status.Active--
+	}
+	status.LastSeen = time.Now()
+	if message != "" {
+		status.Failures = append(status.Failures, HostFailure{
+			Build:   build,
+			Message: message,
+			Time:    status.LastSeen,
+		})
+		if n := len(status.Failures); n > maxFailures {
+			status.Failures = status.Failures[n-maxFailures:]
+		}
+	}
+	h.save()
+}
+
+// List returns the status of the hosts, ordered by name.
+func (h *Hosts) List() []*HostStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.list()
+}
+
+// helper function returns a copy of the host statuses.
+func (h *Hosts) list() []*HostStatus {
+	out := []*HostStatus{}
+	for _, status := range h.hosts {
+		dup := *status
+		dup.Failures = append([]HostFailure(nil), status.Failures...)
+		out = append(out, &dup)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// helper function returns the host status, creating the host
+// status if it does not exist.
+func (h *Hosts) lookup(host string) *HostStatus {
+	host = hostname(host)
+	if h.hosts == nil {
+		h.hosts = map[string]*HostStatus{}
+	}
+	status, ok := h.hosts[host]
+	if !ok {
+		status = &HostStatus{Host: host}
+		h.hosts[host] = status
+	}
+	return status
+}
+
+// helper function persists the registry to the file, if
+// configured. The file is replaced atomically so that a crash
+// does not leave a truncated registry.
+func (h *Hosts) save() error {
+	if h.Path == "" {
+		return nil
+	}
+	raw, err := json.Marshal(h.list())
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.Path), ".hosts")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), h.Path)
+}
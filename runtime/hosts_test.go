@@ -0,0 +1,86 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	h := new(Hosts)
+	h.Add("10.0.0.2")
+	h.Start("10.0.0.1:22", "octocat/hello-world#1")
+
+	hosts := h.List()
+	if got, want := len(hosts), 2; got != want {
+		t.Errorf("Want %d hosts, got %d", want, got)
+		return
+	}
+	if got, want := hosts[0].Host, "10.0.0.1"; got != want {
+		t.Errorf("Want host %s, got %s", want, got)
+	}
+	if got, want := hosts[0].Active, 1; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+	if got, want := hosts[0].LastBuild, "octocat/hello-world#1"; got != want {
+		t.Errorf("Want last build %s, got %s", want, got)
+	}
+	if !hosts[1].LastSeen.IsZero() {
+		t.Errorf("Want configured host not yet seen")
+	}
+
+	h.Done("10.0.0.1:22", "octocat/hello-world#1", "exit code 1")
+	hosts = h.List()
+	if got, want := hosts[0].Active, 0; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+	if got, want := len(hosts[0].Failures), 1; got != want {
+		t.Errorf("Want %d failures, got %d", want, got)
+	}
+}
+
+func TestHosts_MaxFailures(t *testing.T) {
+	h := new(Hosts)
+	for i := 0; i < maxFailures+2; i++ {
+		h.Start("10.0.0.1", "octocat/hello-world#1")
+		h.Done("10.0.0.1", "octocat/hello-world#1", "exit code 1")
+	}
+	if got, want := len(h.List()[0].Failures), maxFailures; got != want {
+		t.Errorf("Want %d recent failures, got %d", want, got)
+	}
+}
+
+func TestHosts_Persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	h := &Hosts{Path: path}
+	h.Start("10.0.0.1", "octocat/hello-world#1")
+	h.Done("10.0.0.1", "octocat/hello-world#1", "exit code 1")
+
+	loaded := &Hosts{Path: path}
+	if err := loaded.Load(); err != nil {
+		t.Error(err)
+		return
+	}
+	hosts := loaded.List()
+	if got, want := len(hosts), 1; got != want {
+		t.Errorf("Want %d hosts, got %d", want, got)
+		return
+	}
+	if got, want := hosts[0].LastBuild, "octocat/hello-world#1"; got != want {
+		t.Errorf("Want last build %s, got %s", want, got)
+	}
+	if got, want := len(hosts[0].Failures), 1; got != want {
+		t.Errorf("Want %d failures, got %d", want, got)
+	}
+}
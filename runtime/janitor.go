@@ -0,0 +1,139 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+
+	"github.com/drone/runner-go/logger"
+)
+
+// Janitor periodically removes orphaned pipeline workspaces
+// from the configured remote servers.
+type Janitor struct {
+	// Servers provides the list of remote servers, including
+	// credentials, that are pruned by the janitor.
+	Servers []engine.Server
+
+	// Platform provides the platform of the remote servers,
+	// used to determine the workspace base directory and the
+	// command used to remove workspaces.
+	Platform engine.Platform
+
+	// Bases provides the base directories where pipeline
+	// workspaces are created. If empty, the default temporary
+	// directory for the platform is used.
+	Bases []string
+
+	// MaxAge provides the age after which a workspace is
+	// considered orphaned.
+	MaxAge time.Duration
+
+	// Interval provides the interval at which the remote
+	// servers are pruned. The interval must be greater than
+	// zero.
+	Interval time.Duration
+
+	// Audit is an optional auditor that records the commands
+	// executed on the remote servers.
+	Audit engine.Auditor
+
+	// Registry is an optional registry of stages with retained
+	// workspaces. Stages are removed from the registry when the
+	// workspace is pruned.
+	Registry *Registry
+
+	mu      sync.Mutex
+	tracked []string
+}
+
+// Track adds the base directory of a pipeline workspace to the
+// directories pruned by the janitor. Pipelines may override the
+// workspace base directory, in which case the workspaces are
+// not created in the configured base directories. Directories
+// for a different platform are ignored.
+func (j *Janitor) Track(os, base string) {
+	if base == "" || os != j.Platform.OS {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, b := range j.tracked {
+		if b == base {
+			return
+		}
+	}
+	j.tracked = append(j.tracked, base)
+}
+
+// helper function returns the configured and tracked base
+// directories, excluding duplicates.
+func (j *Janitor) bases() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var bases []string
+	seen := map[string]struct{}{}
+	for _, base := range append(append([]string{}, j.Bases...), j.tracked...) {
+		if _, ok := seen[base]; ok || base == "" {
+			continue
+		}
+		seen[base] = struct{}{}
+		bases = append(bases, base)
+	}
+	if len(bases) == 0 {
+		bases = append(bases, engine.TempBase(j.Platform.OS))
+	}
+	return bases
+}
+
+// Start starts the janitor and prunes the remote servers at the
+// configured interval until the context is cancelled.
+func (j *Janitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		j.Prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune removes orphaned pipeline workspaces from each of the
+// configured remote servers.
+func (j *Janitor) Prune(ctx context.Context) {
+	bases := j.bases()
+	for _, server := range j.Servers {
+		server.Hostname = engine.HostPort(server.Hostname, "")
+		for _, base := range bases {
+			log := logger.FromContext(ctx).
+				WithField("host", server.Hostname).
+				WithField("path", base)
+
+			spec := &engine.Spec{
+				Server:   server,
+				Platform: j.Platform,
+				Audit:    j.Audit,
+			}
+			removed, err := engine.Prune(ctx, spec, base, j.MaxAge)
+			if err != nil {
+				log.WithError(err).Warn("cannot prune orphaned workspaces")
+			}
+			for _, path := range removed {
+				log.WithField("workspace", path).
+					Info("removed orphaned workspace")
+				if j.Registry != nil {
+					j.Registry.RemoveWorkspace(path)
+				}
+			}
+		}
+	}
+}
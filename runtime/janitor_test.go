@@ -0,0 +1,36 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJanitor_Bases(t *testing.T) {
+	j := &Janitor{
+		Platform: engine.Platform{OS: "linux"},
+		Bases:    []string{"", "/tmp", "/var/lib/drone"},
+	}
+	j.Track("linux", "/var/lib/drone")
+	j.Track("linux", "/opt/builds")
+	j.Track("linux", "")
+	j.Track("windows", "C:\\builds")
+
+	want := []string{"/tmp", "/var/lib/drone", "/opt/builds"}
+	if diff := cmp.Diff(want, j.bases()); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestJanitor_BasesDefault(t *testing.T) {
+	j := &Janitor{Platform: engine.Platform{OS: "windows"}}
+	want := []string{"C:\\Windows\\Temp"}
+	if diff := cmp.Diff(want, j.bases()); diff != "" {
+		t.Errorf(diff)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+)
+
+// errDrained is returned when the remote host is drained for
+// maintenance.
+var errDrained = errors.New("remote host is drained for maintenance")
+
+// Maintenance tracks remote hosts that are drained for
+// maintenance. Running stages complete, but new stages are not
+// scheduled to a drained host. Hosts are matched by name,
+// ignoring the port.
+type Maintenance struct {
+	mu      sync.Mutex
+	hosts   map[string]struct{}
+	changed chan struct{}
+}
+
+// Drain marks the host as drained.
+func (m *Maintenance) Drain(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hosts == nil {
+		m.hosts = map[string]struct{}{}
+	}
+	m.hosts[hostname(host)] = struct{}{}
+}
+
+// Undrain marks the host as available, and resumes stages
+// waiting for the host.
+func (m *Maintenance) Undrain(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.hosts, hostname(host))
+	if m.changed != nil {
+		close(m.changed)
+		m.changed = nil
+	}
+}
+
+// Drained returns true if the host is drained.
+func (m *Maintenance) Drained(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.hosts[hostname(host)]
+	return ok
+}
+
+// List returns the drained hosts.
+func (m *Maintenance) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hosts := []string{}
+	for host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Wait blocks until the host is not drained, or until the
+// context is cancelled.
+func (m *Maintenance) Wait(ctx context.Context, host string) error {
+	for {
+		m.mu.Lock()
+		if _, ok := m.hosts[hostname(host)]; !ok {
+			m.mu.Unlock()
+			return nil
+		}
+		if m.changed == nil {
+			m.changed = make(chan struct{})
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// helper function returns the host name without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
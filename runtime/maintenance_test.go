@@ -0,0 +1,59 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := new(Maintenance)
+	if m.Drained("10.0.0.1:22") {
+		t.Errorf("Expect host not drained")
+	}
+
+	m.Drain("10.0.0.1")
+	if !m.Drained("10.0.0.1:22") {
+		t.Errorf("Expect host drained, ignoring the port")
+	}
+	if got, want := m.List(), []string{"10.0.0.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want drained hosts %v, got %v", want, got)
+	}
+
+	m.Undrain("10.0.0.1:22")
+	if m.Drained("10.0.0.1") {
+		t.Errorf("Expect host undrained")
+	}
+}
+
+func TestMaintenance_Wait(t *testing.T) {
+	m := new(Maintenance)
+	m.Drain("10.0.0.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.Wait(ctx, "10.0.0.1:22"); err == nil {
+		t.Errorf("Expect wait deferred while host is drained")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- m.Wait(context.Background(), "10.0.0.1:22")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.Undrain("10.0.0.1")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expect wait resumed when host is undrained")
+	}
+}
@@ -9,6 +9,7 @@ package runtime
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/logger"
@@ -22,6 +23,11 @@ type Poller struct {
 	Client client.Client
 	Filter *client.Filter
 	Runner *Runner
+
+	// Backoff is the interval the poller waits before
+	// requesting a stage while the remote hosts are saturated.
+	// If zero, a default of 5 seconds is used.
+	Backoff time.Duration
 }
 
 // Poll opens N connections to the server to poll for pending
@@ -38,6 +44,10 @@ func (p *Poller) Poll(ctx context.Context, n int) {
 					wg.Done()
 					return
 				default:
+					if p.saturated() {
+						p.wait(ctx)
+						continue
+					}
 					p.poll(ctx, i+1)
 				}
 			}
@@ -74,3 +84,29 @@ func (p *Poller) poll(ctx context.Context, thread int) error {
 	return p.Runner.Run(
 		logger.WithContext(noContext, log), stage)
 }
+
+// helper function returns true if stages received by the
+// runner are waiting for remote host capacity. Stages are not
+// requested while the hosts are saturated, so that they remain
+// queued on the server instead of timing out on the runner.
+func (p *Poller) saturated() bool {
+	return p.Runner != nil &&
+		p.Runner.Scheduler != nil &&
+		p.Runner.Scheduler.Saturated()
+}
+
+// helper function waits for the backoff interval, or until the
+// context is cancelled.
+func (p *Poller) wait(ctx context.Context) {
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+	logger.FromContext(ctx).
+		WithField("waiting", p.Runner.Scheduler.Waiting()).
+		Debug("remote hosts saturated, deferring stage request")
+	select {
+	case <-time.After(backoff):
+	case <-ctx.Done():
+	}
+}
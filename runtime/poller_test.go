@@ -7,7 +7,10 @@
 package runtime
 
 import (
+	"context"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestPoll(t *testing.T) {
@@ -25,3 +28,19 @@ func TestPoll_EmptyStage(t *testing.T) {
 func TestPoll_RequestError(t *testing.T) {
 	t.Skip()
 }
+
+func TestPoll_Saturated(t *testing.T) {
+	p := &Poller{Runner: &Runner{Scheduler: &Scheduler{Max: 1}}}
+	if p.saturated() {
+		t.Errorf("Expect poller not saturated")
+	}
+	atomic.AddInt64(&p.Runner.Scheduler.waiting, 1)
+	if !p.saturated() {
+		t.Errorf("Expect poller saturated when stages are waiting")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Backoff = time.Hour
+	p.wait(ctx) // returns immediately when the context is cancelled
+}
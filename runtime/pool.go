@@ -0,0 +1,152 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/buildkite/yaml"
+)
+
+// ErrNoHealthyHost is returned when none of the hosts in the
+// pool pass the health check.
+var ErrNoHealthyHost = errors.New("no healthy host in pool")
+
+// Pool defines a named pool of remote hosts that share the
+// same credentials. Pipelines select a pool by name, and the
+// runner assigns the least-loaded healthy host.
+type Pool struct {
+	Hosts      []string `yaml:"hosts"`
+	Username   string   `yaml:"username"`
+	Password   string   `yaml:"password"`
+	SSHKey     string   `yaml:"ssh_key"`
+	SSHKeyFile string   `yaml:"ssh_key_file"`
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// ParsePools parses the named host pools from the yaml file.
+// Private keys referenced by file are read from the runner
+// host.
+func ParsePools(path string) (map[string]*Pool, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pools := map[string]*Pool{}
+	if err := yaml.Unmarshal(raw, &pools); err != nil {
+		return nil, err
+	}
+	for name, pool := range pools {
+		if len(pool.Hosts) == 0 {
+			return nil, errors.New("pool " + name + " has no hosts")
+		}
+		if pool.SSHKeyFile != "" {
+			key, err := ioutil.ReadFile(pool.SSHKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pool.SSHKey = string(key)
+		}
+	}
+	return pools, nil
+}
+
+// Select returns the least-loaded host that passes the health
+// check, and a function that releases the host when the stage
+// completes. Hosts with equal load are selected in the order
+// they are defined.
+func (p *Pool) Select(ctx context.Context, check func(context.Context, string) error) (string, func(), error) {
+	return p.selectFrom(ctx, p.candidates(), check)
+}
+
+// SelectAffinity returns the host preferred for the key, using
+// rendezvous hashing, so that consecutive stages with the same
+// key are assigned the same host while it remains healthy. The
+// key is typically the repository, branch and stage name.
+func (p *Pool) SelectAffinity(ctx context.Context, key string, check func(context.Context, string) error) (string, func(), error) {
+	return p.selectFrom(ctx, affinity(p.Hosts, key), check)
+}
+
+// helper function returns the first host that passes the
+// health check, in order.
+func (p *Pool) selectFrom(ctx context.Context, hosts []string, check func(context.Context, string) error) (string, func(), error) {
+	for _, host := range hosts {
+		if check != nil {
+			if err := check(ctx, host); err != nil {
+				continue
+			}
+		}
+		p.mu.Lock()
+		if p.active == nil {
+			p.active = map[string]int{}
+		}
+		p.active[host]++
+		p.mu.Unlock()
+		return host, func() { p.release(host) }, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, ErrNoHealthyHost
+}
+
+// Active returns the number of active stages assigned to the
+// host.
+func (p *Pool) Active(host string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active[host]
+}
+
+// helper function returns the pool hosts ordered by the number
+// of active stages.
+func (p *Pool) candidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == nil {
+		p.active = map[string]int{}
+	}
+	hosts := make([]string, len(p.Hosts))
+	copy(hosts, p.Hosts)
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return p.active[hosts[i]] < p.active[hosts[j]]
+	})
+	return hosts
+}
+
+// helper function returns the hosts ordered by the rendezvous
+// hash of the key and host, which changes the preferred host
+// for only a fraction of keys when hosts are added or removed.
+func affinity(hosts []string, key string) []string {
+	out := make([]string, len(hosts))
+	copy(out, hosts)
+	weight := func(host string) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(host))
+		return h.Sum64()
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return weight(out[i]) > weight(out[j])
+	})
+	return out
+}
+
+// helper function releases the host.
+func (p *Pool) release(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active[host] > 0 {
+		p.active[host]--
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParsePools(t *testing.T) {
+	pools, err := ParsePools("testdata/pools.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := len(pools), 2; got != want {
+		t.Errorf("Want %d pools, got %d", want, got)
+		return
+	}
+	pool, ok := pools["linux-large"]
+	if !ok {
+		t.Errorf("Want linux-large pool")
+		return
+	}
+	if got, want := len(pool.Hosts), 2; got != want {
+		t.Errorf("Want %d hosts, got %d", want, got)
+	}
+	if got, want := pool.Username, "root"; got != want {
+		t.Errorf("Want username %s, got %s", want, got)
+	}
+	if pools["windows"].SSHKey == "" {
+		t.Errorf("Want windows pool ssh key")
+	}
+}
+
+func TestParsePools_NotFound(t *testing.T) {
+	if _, err := ParsePools("testdata/missing.yml"); err == nil {
+		t.Errorf("Expect error when pool file not found")
+	}
+}
+
+func TestPool_Select(t *testing.T) {
+	pool := &Pool{Hosts: []string{"host1", "host2"}}
+	ctx := context.Background()
+
+	host1, release1, err := pool.Select(ctx, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := host1, "host1"; got != want {
+		t.Errorf("Want host %s, got %s", want, got)
+	}
+
+	// the least-loaded host is selected.
+	host2, release2, err := pool.Select(ctx, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := host2, "host2"; got != want {
+		t.Errorf("Want least-loaded host %s, got %s", want, got)
+	}
+
+	release1()
+	if got, want := pool.Active("host1"), 0; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+	host, _, _ := pool.Select(ctx, nil)
+	if got, want := host, "host1"; got != want {
+		t.Errorf("Want released host %s, got %s", want, got)
+	}
+	release2()
+}
+
+func TestPool_SelectHealthy(t *testing.T) {
+	pool := &Pool{Hosts: []string{"host1", "host2"}}
+	check := func(ctx context.Context, host string) error {
+		if host == "host1" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	host, _, err := pool.Select(context.Background(), check)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := host, "host2"; got != want {
+		t.Errorf("Want healthy host %s, got %s", want, got)
+	}
+	if got, want := pool.Active("host1"), 0; got != want {
+		t.Errorf("Want unhealthy host not assigned, got %d active stages", got)
+	}
+}
+
+func TestPool_SelectUnhealthy(t *testing.T) {
+	pool := &Pool{Hosts: []string{"host1"}}
+	check := func(ctx context.Context, host string) error {
+		return errors.New("connection refused")
+	}
+	if _, _, err := pool.Select(context.Background(), check); err != ErrNoHealthyHost {
+		t.Errorf("Want no healthy host error, got %v", err)
+	}
+}
+
+func TestPool_SelectAffinity(t *testing.T) {
+	pool := &Pool{Hosts: []string{"host1", "host2", "host3", "host4"}}
+	ctx := context.Background()
+	key := "octocat/hello-world/master/default"
+
+	want, release, err := pool.SelectAffinity(ctx, key, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	release()
+
+	// the same host is selected for the key, regardless of
+	// the host load.
+	pool.Select(ctx, nil)
+	pool.Select(ctx, nil)
+	for i := 0; i < 3; i++ {
+		got, _, _ := pool.SelectAffinity(ctx, key, nil)
+		if got != want {
+			t.Errorf("Want sticky host %s, got %s", want, got)
+		}
+	}
+
+	// the next preferred host is selected if the preferred
+	// host is unhealthy.
+	check := func(ctx context.Context, host string) error {
+		if host == want {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	got, _, err := pool.SelectAffinity(ctx, key, check)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got == want {
+		t.Errorf("Expect unhealthy sticky host skipped")
+	}
+}
+
+func Test_affinity(t *testing.T) {
+	hosts := []string{"host1", "host2", "host3"}
+	a := affinity(hosts, "octocat/hello-world/master/default")
+	b := affinity([]string{"host3", "host1", "host2"}, "octocat/hello-world/master/default")
+	if a[0] != b[0] {
+		t.Errorf("Expect preferred host independent of host order")
+	}
+
+	// removing a host that is not preferred does not change
+	// the preferred host.
+	var remaining []string
+	for _, host := range hosts {
+		if host != a[len(a)-1] {
+			remaining = append(remaining, host)
+		}
+	}
+	if got := affinity(remaining, "octocat/hello-world/master/default"); got[0] != a[0] {
+		t.Errorf("Expect preferred host unchanged when another host is removed")
+	}
+}
+
+func TestPool_Capacity(t *testing.T) {
+	pool := &Pool{Hosts: []string{"host1", "host2"}}
+	pool.Select(context.Background(), nil)
+
+	out := pool.capacity(2)
+	if got, want := out.Capacity, 4; got != want {
+		t.Errorf("Want pool capacity %d, got %d", want, got)
+	}
+	if got, want := out.Active, 1; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+	if got, want := out.Utilization, 0.25; got != want {
+		t.Errorf("Want utilization %v, got %v", want, got)
+	}
+
+	// if the stages per host are not limited, the utilization
+	// is the average number of stages per host.
+	out = pool.capacity(0)
+	if got, want := out.Utilization, 0.5; got != want {
+		t.Errorf("Want utilization %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+)
+
+// Registry tracks the pipeline specifications of stages with
+// workspaces that are retained on the remote server, so that
+// failed steps can be re-executed.
+type Registry struct {
+	// Max is the maximum number of specifications retained
+	// in the registry. The oldest specification is evicted
+	// when the limit is exceeded. If zero, a default of 100
+	// is used.
+	Max int
+
+	mu    sync.Mutex
+	specs map[int64]*engine.Spec
+	order []int64
+}
+
+// Add adds the stage specification to the registry. The secret
+// values are removed from the stored specification, and are not
+// available to re-executed steps.
+func (r *Registry) Add(stage int64, spec *engine.Spec) {
+	spec = redact(spec)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.specs == nil {
+		r.specs = map[int64]*engine.Spec{}
+	}
+	if _, ok := r.specs[stage]; !ok {
+		r.order = append(r.order, stage)
+	}
+	r.specs[stage] = spec
+
+	max := r.Max
+	if max <= 0 {
+		max = 100
+	}
+	for len(r.order) > max {
+		delete(r.specs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// Find returns the stage specification from the registry.
+func (r *Registry) Find(stage int64) (*engine.Spec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spec, ok := r.specs[stage]
+	return spec, ok
+}
+
+// Remove removes the stage specification from the registry.
+func (r *Registry) Remove(stage int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(stage)
+}
+
+// RemoveWorkspace removes the stage specification with the
+// workspace from the registry. It is used when the workspace
+// is removed from the remote server.
+func (r *Registry) RemoveWorkspace(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for stage, spec := range r.specs {
+		if baseName(spec.Root) == baseName(path) {
+			r.remove(stage)
+		}
+	}
+}
+
+func (r *Registry) remove(stage int64) {
+	if _, ok := r.specs[stage]; !ok {
+		return
+	}
+	delete(r.specs, stage)
+	for i, id := range r.order {
+		if id == stage {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// helper function returns a copy of the specification without
+// the secret values, which must not be retained in memory once
+// the stage completes. The server credentials are retained,
+// since they are required to re-execute a step.
+func redact(src *engine.Spec) *engine.Spec {
+	dst := new(engine.Spec)
+	*dst = *src
+	dst.Files = nil
+	dst.Batch = nil
+	dst.Renewal = nil
+	dst.HookOutput = nil
+	dst.Steps = make([]*engine.Step, len(src.Steps))
+	for i, step := range src.Steps {
+		copy := new(engine.Step)
+		*copy = *step
+		copy.Secrets = nil
+		dst.Steps[i] = copy
+	}
+	return dst
+}
+
+// helper function returns the last element of the posix or
+// windows path.
+func baseName(path string) string {
+	path = strings.TrimRight(path, "/\\")
+	if i := strings.LastIndexAny(path, "/\\"); i != -1 {
+		return path[i+1:]
+	}
+	return path
+}
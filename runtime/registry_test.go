@@ -0,0 +1,81 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+)
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{Max: 2}
+	r.Add(1, &engine.Spec{Root: "/tmp/drone-1"})
+	r.Add(2, &engine.Spec{Root: "/tmp/drone-2"})
+
+	spec, ok := r.Find(1)
+	if !ok {
+		t.Errorf("Expect stage found in registry")
+		return
+	}
+	if got, want := spec.Root, "/tmp/drone-1"; got != want {
+		t.Errorf("Want workspace %s, got %s", want, got)
+	}
+
+	r.Add(3, &engine.Spec{Root: "/tmp/drone-3"})
+	if _, ok := r.Find(1); ok {
+		t.Errorf("Expect oldest stage evicted from registry")
+	}
+	if _, ok := r.Find(3); !ok {
+		t.Errorf("Expect newest stage found in registry")
+	}
+}
+
+func TestRegistry_Remove(t *testing.T) {
+	r := new(Registry)
+	r.Add(1, &engine.Spec{Root: "/tmp/drone-1"})
+	r.Add(2, &engine.Spec{Root: "/tmp/drone-2"})
+	r.Add(3, &engine.Spec{Root: "C:\\Windows\\Temp\\drone-3"})
+
+	r.Remove(1)
+	if _, ok := r.Find(1); ok {
+		t.Errorf("Expect stage removed from registry")
+	}
+	r.RemoveWorkspace("/tmp//drone-2")
+	if _, ok := r.Find(2); ok {
+		t.Errorf("Expect stage with pruned workspace removed from registry")
+	}
+	r.RemoveWorkspace("C:\\Windows\\Temp\\drone-3")
+	if _, ok := r.Find(3); ok {
+		t.Errorf("Expect stage with pruned windows workspace removed from registry")
+	}
+	if len(r.order) != 0 {
+		t.Errorf("Expect removed stages removed from the eviction order")
+	}
+}
+
+func TestRegistry_Redact(t *testing.T) {
+	r := new(Registry)
+	spec := &engine.Spec{
+		Files: []*engine.File{{Path: "/root/.netrc", Data: []byte("password")}},
+		Steps: []*engine.Step{
+			{
+				Name:    "test",
+				Secrets: []*engine.Secret{{Name: "password", Data: []byte("password")}},
+			},
+		},
+	}
+	r.Add(1, spec)
+	stored, _ := r.Find(1)
+	if len(stored.Files) != 0 {
+		t.Errorf("Expect files removed from the stored specification")
+	}
+	if len(stored.Steps[0].Secrets) != 0 {
+		t.Errorf("Expect secrets removed from the stored specification")
+	}
+	if len(spec.Steps[0].Secrets) == 0 {
+		t.Errorf("Expect the original specification is not modified")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone-runners/drone-runner-ssh/engine/compiler"
+	"github.com/drone/runner-go/logger"
+)
+
+// helper function periodically renews the pipeline secrets and
+// writes the renewed values to the secrets file on the remote
+// server, until the context is cancelled.
+func renewSecrets(ctx context.Context, comp *compiler.Compiler, spec *engine.Spec) {
+	log := logger.FromContext(ctx)
+	ticker := time.NewTicker(spec.Renewal.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		renewed, err := comp.RenewSecrets(ctx, spec)
+		if err != nil {
+			log.WithError(err).Warn("cannot renew secrets")
+			continue
+		}
+		// the renewed values are masked before they are
+		// written to the remote server, so that they are
+		// never written to the step logs unmasked.
+		for _, secrets := range renewed {
+			spec.Renewal.Add(secrets)
+		}
+		if err := engine.Renew(ctx, spec, renewed); err != nil {
+			log.WithError(err).Warn("cannot write renewed secrets")
+			continue
+		}
+		log.Debug("renewed secrets")
+	}
+}
@@ -10,15 +10,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/drone-runners/drone-runner-ssh/engine"
 	"github.com/drone-runners/drone-runner-ssh/engine/compiler"
 	"github.com/drone-runners/drone-runner-ssh/engine/resource"
+	"github.com/drone-runners/drone-runner-ssh/internal/admission"
+	"github.com/drone-runners/drone-runner-ssh/internal/audit"
+	"github.com/drone-runners/drone-runner-ssh/internal/changes"
+	"github.com/drone-runners/drone-runner-ssh/internal/credentials"
+	"github.com/drone-runners/drone-runner-ssh/internal/match"
+	"github.com/drone-runners/drone-runner-ssh/internal/provision"
+	"github.com/drone-runners/drone-runner-ssh/internal/tracing"
+	"github.com/drone-runners/drone-runner-ssh/internal/variables"
 
 	"github.com/drone/drone-go/drone"
-	"github.com/drone/envsubst"
 	"github.com/drone/runner-go/client"
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/logger"
@@ -45,6 +56,11 @@ type Runner struct {
 	// that are added to every pipeline step.
 	Environ map[string]string
 
+	// Secrets provides custom, global secret environment
+	// variables that are added to every pipeline step and
+	// masked in the build logs.
+	Secrets map[string]string
+
 	// Machine provides the runner with the name of the host
 	// machine executing the pipeline.
 	Machine string
@@ -55,8 +71,172 @@ type Runner struct {
 	// processing an unwanted pipeline.
 	Match func(*drone.Repo, *drone.Build) bool
 
+	// Limits provides additional limits on the build branch
+	// and drone server instance.
+	Limits match.Limits
+
+	// Labels provides the runner labels that are matched
+	// against the pipeline node selector.
+	Labels map[string]string
+
+	// HealthCheck is the timeout for the remote host health
+	// check performed before the stage is started. If zero,
+	// the health check is disabled.
+	HealthCheck time.Duration
+
+	// Debug retains the workspace on the remote server when a
+	// pipeline step fails.
+	Debug bool
+
+	// Retain retains the partially created workspace on the
+	// remote server when the pipeline setup fails.
+	Retain bool
+
+	// Ciphers, KeyExchanges and MACs provide the default ssh
+	// algorithms used to connect to the remote servers.
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+
+	// SSHAgent provides the socket of the ssh agent on the
+	// runner host, used by pipelines that authenticate with
+	// the agent.
+	SSHAgent string
+
+	// ForwardAgent provides the socket of the ssh agent on the
+	// runner host, forwarded to pipeline steps of trusted
+	// repositories that request agent forwarding.
+	ForwardAgent string
+
+	// X11Display provides the x11 display of the runner host,
+	// forwarded to pipeline steps that request x11 forwarding.
+	X11Display string
+
+	// SyncDir provides the local directory to which the local
+	// paths of sync steps are relative. If empty, sync steps
+	// are disabled.
+	SyncDir string
+
+	// SyncUntrusted enables sync steps for repositories that
+	// are not trusted.
+	SyncUntrusted bool
+
+	// TunnelAllow provides the addresses, in CIDR:port format,
+	// that pipeline tunnels may dial from the runner host.
+	TunnelAllow []string
+
+	// TransportAllow provides the unix socket and websocket
+	// urls that the pipeline server host may connect through.
+	TransportAllow []string
+
+	// ServerTunnelAllow provides the cloud bastion tunnels, in
+	// driver:instance format, that pipelines may connect
+	// through.
+	ServerTunnelAllow []string
+
+	// MaxSessions provides the default limit of concurrent
+	// sessions over the connection shared by pipeline steps.
+	MaxSessions int
+
+	// DumpScripts provides a local directory to which the
+	// generated scripts are written before upload.
+	DumpScripts string
+
+	// Tracer is an optional tracer that records spans for
+	// pipeline execution.
+	Tracer *tracing.Tracer
+
+	// Locale provides the default locale exported to the
+	// pipeline steps.
+	Locale string
+
+	// Timestamps provides the default timestamp format used
+	// to prefix step output.
+	Timestamps string
+
+	// Syslog writes a marker with the build correlation id to
+	// the remote syslog when each step starts.
+	Syslog bool
+
+	// WorkspaceBase provides the default base directory in
+	// which the workspace is created on posix hosts.
+	WorkspaceBase string
+
+	// WorkspaceBaseWindows provides the default base directory
+	// in which the workspace is created on windows hosts.
+	WorkspaceBaseWindows string
+
+	// OptionalSecrets disables strict secret mode, allowing
+	// steps to run when a secret cannot be found.
+	OptionalSecrets bool
+
+	// Registry is an optional registry of stages with
+	// workspaces retained for debugging.
+	Registry *Registry
+
+	// Janitor is an optional janitor that prunes orphaned
+	// workspaces, including workspaces created in the base
+	// directory defined by the pipeline.
+	Janitor *Janitor
+
+	// MaxStages is the maximum number of stages the runner
+	// executes concurrently, reported to external autoscalers.
+	MaxStages int
+
+	// Pools provides the named pools of remote hosts from
+	// which pipelines select a host.
+	Pools map[string]*Pool
+
+	// Provisioner is an optional provider that provisions an
+	// ephemeral remote host for pipelines that request one.
+	Provisioner provision.Provider
+
+	// Admission is an optional hook that approves or rejects
+	// stages before they execute on the remote host.
+	Admission admission.Provider
+
+	// Audit is an optional sink that records the commands
+	// executed and the files uploaded on the remote host.
+	Audit audit.Sink
+
+	// Maintenance is an optional tracker of remote hosts that
+	// are drained for maintenance.
+	Maintenance *Maintenance
+
+	// Hosts is an optional registry that records the status
+	// of the remote hosts.
+	Hosts *Hosts
+
+	// Scheduler is an optional scheduler that limits the
+	// number of concurrent stages per remote host.
+	Scheduler *Scheduler
+
+	// RepoScheduler is an optional scheduler that limits the
+	// number of concurrent stages per repository, keyed by the
+	// repository slug.
+	RepoScheduler *Scheduler
+
 	// Secret provides the compiler with secrets.
 	Secret secret.Provider
+
+	// Credentials provides the compiler with credential files.
+	Credentials credentials.Provider
+
+	// Changes provides the compiler with the list of files
+	// changed by the build.
+	Changes changes.Provider
+
+	// Variables provides the compiler with environment
+	// variables specific to the remote host.
+	Variables variables.Provider
+
+	// Dependencies is an optional tracker that defers stages
+	// until the stages they depend on have completed.
+	Dependencies *Dependencies
+
+	// active is the number of stages accepted by the runner
+	// that have not yet completed.
+	active int64
 }
 
 // Run runs the pipeline stage.
@@ -68,10 +248,39 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 
 	log.Debug("stage received")
 
+	// evaluates whether or not the pipeline node selector
+	// matches the runner labels. The node selector is included
+	// in the stage, so the stage is declined before waiting on
+	// dependencies or fetching the stage details, allowing a
+	// runner with matching labels to accept the stage.
+	if match.Labels(stage.Labels, s.Labels) == false {
+		log.Debug("ignoring stage, node does not match runner labels")
+		return nil
+	}
+
+	// the stage is not accepted until the stages it depends
+	// on have completed on this runner, including the workspace
+	// teardown, to prevent racing on shared remote state.
+	if s.Dependencies != nil && len(stage.DependsOn) != 0 {
+		log.Debug("waiting for stage dependencies")
+		if err := s.Dependencies.Wait(ctx, stage); err != nil {
+			log.WithError(err).Error("cannot wait for stage dependencies")
+			return err
+		}
+	}
+
 	// delivery to a single agent is not guaranteed, which means
 	// we need confirm receipt. The first agent that confirms
 	// receipt of the stage can assume ownership.
-
+	//
+	// The pipeline trigger and the branch and instance limits
+	// are evaluated after the stage is accepted. The stage
+	// received from the queue does not include the repository,
+	// build or yaml configuration, which are fetched once the
+	// runner owns the stage.
+	// Once accepted, a stage that does not match is skipped or
+	// failed and reported, instead of being declined, since a
+	// declined stage would remain pending on the server.
 	stage.Machine = s.Machine
 	err := s.Client.Accept(ctx, stage)
 	if err != nil {
@@ -81,6 +290,14 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 
 	log.Debug("stage accepted")
 
+	atomic.AddInt64(&s.active, 1)
+	defer atomic.AddInt64(&s.active, -1)
+
+	if s.Dependencies != nil {
+		s.Dependencies.Start(stage)
+		defer s.Dependencies.Done(stage)
+	}
+
 	data, err := s.Client.Detail(ctx, stage)
 	if err != nil {
 		log.WithError(err).Error("cannot get stage details")
@@ -95,14 +312,21 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 
 	log.Debug("stage details fetched")
 
-	ctxdone, cancel := context.WithCancel(ctx)
-	defer cancel()
+	// the stage execution is traced if a tracer is configured.
+	// the stage span is the parent of all step spans.
+	ctx, span := tracing.Start(tracing.WithContext(ctx, s.Tracer), "stage")
+	span.SetAttribute("repo.slug", data.Repo.Slug)
+	span.SetAttribute("build.number", strconv.FormatInt(data.Build.Number, 10))
+	span.SetAttribute("stage.name", stage.Name)
+	defer func() {
+		span.SetAttribute("stage.status", stage.Status)
+		span.End(nil)
+	}()
 
-	timeout := time.Duration(data.Repo.Timeout) * time.Minute
-	ctxtimeout, cancel := context.WithTimeout(ctxdone, timeout)
+	ctxdone, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	ctxcancel, cancel := context.WithCancel(ctxtimeout)
+	ctxcancel, cancel := context.WithCancel(ctxdone)
 	defer cancel()
 
 	// next we opens a connection to the server to watch for
@@ -154,10 +378,15 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		state.FailAll(errors.New("insufficient permission to run the pipeline"))
 		return s.Reporter.ReportStage(noContext, state)
 	}
+	if s.Limits.Match(data.System, data.Build) == false {
+		log.Error("cannot process stage, branch or instance denied")
+		state.FailAll(errors.New("insufficient permission to run the pipeline"))
+		return s.Reporter.ReportStage(noContext, state)
+	}
 
 	// evaluates string replacement expressions and returns an
 	// update configuration file string.
-	config, err := envsubst.Eval(string(data.Config.Data), subf)
+	config, err := resource.Substitute(string(data.Config.Data), subf)
 	if err != nil {
 		log.WithError(err).Error("cannot emulate bash substitution")
 		state.FailAll(err)
@@ -180,27 +409,184 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		return s.Reporter.ReportStage(noContext, state)
 	}
 
+	// evaluates whether or not the build matches the pipeline
+	// trigger. A stage that does not match the trigger is
+	// skipped before the remote host is provisioned or
+	// connected. The trigger requires the stage details, and
+	// is therefore evaluated after the stage is accepted.
+	if match.Trigger(resource.Trigger, data.Repo, data.Build, data.System) == false {
+		log.Info("skipping stage, build does not match the pipeline trigger")
+		skipAll(state)
+		return s.Reporter.ReportStage(noContext, state)
+	}
+
+	// the stage is deferred until the repository has capacity
+	// to execute the stage, so that concurrent builds of the
+	// same repository do not race against the same remote host.
+	// The capacity is acquired before a host is selected from
+	// the pool or provisioned.
+	if s.RepoScheduler != nil {
+		repo := data.Repo.Slug
+		log.WithField("active", s.RepoScheduler.Active(repo)).
+			Debug("waiting for repository capacity")
+		if err := s.RepoScheduler.Acquire(ctxcancel, repo); err != nil {
+			log.WithError(err).Error("cannot acquire repository capacity")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+		defer s.RepoScheduler.Release(repo)
+	}
+
+	// the remote host is selected from the named runner pool,
+	// which also provides the host credentials. The least-loaded
+	// host that passes the health check is selected.
+	if name := resource.Server.Pool; name != "" {
+		pool, ok := s.Pools[name]
+		if !ok {
+			log.WithField("pool", name).Error("cannot find host pool")
+			state.FailAll(fmt.Errorf("host pool %q not found", name))
+			return s.Reporter.ReportStage(noContext, state)
+		}
+		var host string
+		var release func()
+		if resource.Server.Sticky {
+			key := path.Join(data.Repo.Slug, data.Build.Target, stage.Name)
+			host, release, err = pool.SelectAffinity(ctxcancel, key, s.checkHost(pool))
+		} else {
+			host, release, err = pool.Select(ctxcancel, s.checkHost(pool))
+		}
+		if err != nil {
+			log.WithError(err).WithField("pool", name).Error("cannot select host from pool")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+		defer release()
+
+		log = log.WithField("pool", name).WithField("host", host)
+		log.Debug("selected host from pool")
+
+		resource.Server.Host.Value = host
+		resource.Server.User.Value = pool.Username
+		resource.Server.Password.Value = pool.Password
+		resource.Server.SSHKey.Value = pool.SSHKey
+	}
+
+	// the remote host is provisioned by the runner hook, and
+	// is removed when the stage completes, including when the
+	// stage fails before the pipeline is executed.
+	if resource.Server.Provision {
+		req := &provision.Request{
+			Repo:  data.Repo,
+			Build: data.Build,
+			Stage: stage,
+			Platform: provision.Platform{
+				OS:      resource.Platform.OS,
+				Arch:    resource.Platform.Arch,
+				Variant: resource.Platform.Variant,
+				Version: resource.Platform.Version,
+			},
+		}
+		host, err := s.provisioner().Provision(ctxcancel, req)
+		if err == nil && (host == nil || host.Host == "") {
+			err = errors.New("no host provisioned for the pipeline")
+		}
+		if err != nil {
+			log.WithError(err).Error("cannot provision host")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+		req.Host = host
+		defer func() {
+			if err := s.provisioner().Deprovision(noContext, req); err != nil {
+				log.WithError(err).
+					WithField("host", host.Host).
+					Error("cannot deprovision host")
+			}
+		}()
+
+		log = log.WithField("host", host.Host)
+		log.Debug("provisioned host")
+
+		resource.Server.Host.Value = host.Host
+		resource.Server.User.Value = host.Username
+		resource.Server.Password.Value = host.Password
+		resource.Server.SSHKey.Value = host.SSHKey
+	}
+
 	secrets := secret.Combine(
 		secret.Static(data.Secrets),
 		secret.Encrypted(),
 		s.Secret,
 	)
 
+	// fetch the list of files changed by the build, used to
+	// evaluate step path conditions. If the list cannot be
+	// fetched the changes are unknown and path conditions are
+	// ignored.
+	var changed []string
+	if s.Changes != nil {
+		changed, err = s.Changes.List(ctx, &changes.Request{
+			Repo:  data.Repo,
+			Build: data.Build,
+		})
+		if err != nil {
+			log.WithError(err).Warn("cannot fetch changed files")
+			changed = nil
+		}
+	}
+
 	// compile the yaml configuration file to an intermediate
 	// representation, and then
 	comp := &compiler.Compiler{
-		Pipeline: resource,
-		Manifest: manifest,
-		Environ:  s.Environ,
-		Build:    data.Build,
-		Stage:    stage,
-		Repo:     data.Repo,
-		System:   data.System,
-		Netrc:    data.Netrc,
-		Secret:   secrets,
-	}
-
-	spec := comp.Compile(ctx)
+		Pipeline:             resource,
+		Manifest:             manifest,
+		Environ:              s.Environ,
+		Build:                data.Build,
+		Stage:                stage,
+		Repo:                 data.Repo,
+		System:               data.System,
+		Netrc:                data.Netrc,
+		Secret:               secrets,
+		OptionalSecrets:      s.OptionalSecrets,
+		Secrets:              s.Secrets,
+		Credentials:          s.Credentials,
+		Variables:            s.Variables,
+		Changes:              changed,
+		Debug:                s.Debug,
+		Retain:               s.Retain,
+		DumpScripts:          s.DumpScripts,
+		Ciphers:              s.Ciphers,
+		KeyExchanges:         s.KeyExchanges,
+		MACs:                 s.MACs,
+		SSHAgent:             s.SSHAgent,
+		ForwardAgent:         s.ForwardAgent,
+		X11Display:           s.X11Display,
+		SyncDir:              s.SyncDir,
+		SyncUntrusted:        s.SyncUntrusted,
+		TunnelAllow:          s.TunnelAllow,
+		TransportAllow:       s.TransportAllow,
+		ServerTunnelAllow:    s.ServerTunnelAllow,
+		MaxSessions:          s.MaxSessions,
+		Locale:               s.Locale,
+		Timestamps:           s.Timestamps,
+		Traceparent:          tracing.Traceparent(ctx),
+		Syslog:               s.Syslog,
+		WorkspaceBase:        s.WorkspaceBase,
+		WorkspaceBaseWindows: s.WorkspaceBaseWindows,
+	}
+
+	spec, err := comp.Compile(ctx)
+	if err != nil {
+		log.WithError(err).Error("cannot compile pipeline")
+		state.FailAll(err)
+		return s.Reporter.ReportStage(noContext, state)
+	}
+	if s.Audit != nil {
+		spec.Audit = audit.New(s.Audit, data.Repo, data.Build, stage)
+	}
+	if s.Janitor != nil {
+		s.Janitor.Track(resource.Platform.OS, resource.Workspace.Base)
+	}
 	for _, src := range spec.Steps {
 		// steps that are skipped are ignored and are not stored
 		// in the drone database, nor displayed in the UI.
@@ -216,6 +602,97 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 		})
 	}
 
+	// the stage is submitted to the admission hook, which may
+	// reject the stage based on the server target and pipeline
+	// metadata. The stage is rejected if the hook fails.
+	if s.Admission != nil {
+		res, err := s.Admission.Admit(ctxcancel, &admission.Request{
+			Repo:  data.Repo,
+			Build: data.Build,
+			Stage: stage,
+			Host:  spec.Server.Hostname,
+			User:  spec.Server.Username,
+			Platform: admission.Platform{
+				OS:      spec.Platform.OS,
+				Arch:    spec.Platform.Arch,
+				Variant: spec.Platform.Variant,
+				Version: spec.Platform.Version,
+			},
+		})
+		if err == nil && !res.Allow {
+			err = fmt.Errorf("stage rejected: %s", res.Reason)
+			if res.Reason == "" {
+				err = errors.New("stage rejected by admission policy")
+			}
+		}
+		if err != nil {
+			log.WithError(err).Error("stage not admitted")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
+	// the stage is deferred while the remote host is drained
+	// for maintenance.
+	if s.Maintenance != nil && s.Maintenance.Drained(spec.Server.Hostname) {
+		log.WithField("host", spec.Server.Hostname).
+			Info("waiting for remote host maintenance")
+		if err := s.Maintenance.Wait(ctxcancel, spec.Server.Hostname); err != nil {
+			log.WithError(err).Error("cannot wait for remote host maintenance")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
+	// the stage is deferred until the remote host has capacity
+	// to execute the stage, preventing resource exhaustion on
+	// the remote host.
+	if s.Scheduler != nil {
+		host := spec.Server.Hostname
+		log.WithField("host", host).
+			WithField("active", s.Scheduler.Active(host)).
+			Debug("waiting for remote host capacity")
+		if err := s.Scheduler.Acquire(ctxcancel, host); err != nil {
+			log.WithError(err).Error("cannot acquire remote host capacity")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+		defer s.Scheduler.Release(host)
+	}
+
+	// the stage timeout starts once the remote host capacity
+	// is acquired, so that the time spent waiting for capacity
+	// does not count against the repository timeout.
+	timeout := time.Duration(data.Repo.Timeout) * time.Minute
+	ctxtimeout, cancel := context.WithTimeout(ctxcancel, timeout)
+	defer cancel()
+	spec.Timeout = timeout
+
+	// the remote host status, including stage failures, is
+	// recorded for the hosts dashboard.
+	if s.Hosts != nil {
+		host := spec.Server.Hostname
+		build := fmt.Sprintf("%s#%d", data.Repo.Slug, data.Build.Number)
+		s.Hosts.Start(host, build)
+		defer func() {
+			s.Hosts.Done(host, build, failure(state, err))
+		}()
+	}
+
+	// the remote host is checked before the stage is started
+	// to fail fast if the host is unreachable, instead of
+	// waiting for the stage to timeout.
+	if s.HealthCheck > 0 {
+		ctxping, cancel := context.WithTimeout(ctxtimeout, s.HealthCheck)
+		err := engine.Ping(ctxping, spec.Server)
+		cancel()
+		if err != nil {
+			log.WithError(err).Error("remote host health check failed")
+			state.FailAll(err)
+			return s.Reporter.ReportStage(noContext, state)
+		}
+	}
+
 	stage.Started = time.Now().Unix()
 	stage.Status = drone.StatusRunning
 	if err := s.Client.Update(ctx, stage); err != nil {
@@ -225,8 +702,24 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 
 	log.Debug("updated stage to running")
 
-	ctxcancel = logger.WithContext(ctxcancel, log)
-	err = s.Execer.Exec(ctxcancel, spec, state)
+	ctxtimeout = logger.WithContext(ctxtimeout, log)
+
+	// the secrets are periodically renewed while the stage is
+	// executing, if secret renewal is enabled.
+	if spec.Renewal != nil {
+		ctxrenew, cancel := context.WithCancel(ctxtimeout)
+		defer cancel()
+		go renewSecrets(ctxrenew, comp, spec)
+	}
+
+	err = s.Execer.Exec(ctxtimeout, spec, state)
+
+	// the pipeline specification is registered if the workspace
+	// is retained for debugging, so that failed steps can be
+	// re-executed from the dashboard.
+	if s.Registry != nil && spec.Retained {
+		s.Registry.Add(stage.ID, spec)
+	}
 	if err != nil {
 		log.WithError(err).Debug("stage failed")
 		return err
@@ -234,3 +727,75 @@ func (s *Runner) Run(ctx context.Context, stage *drone.Stage) error {
 	log.Debug("updated stage to complete")
 	return nil
 }
+
+// helper function returns the health check for hosts in the
+// pool. Drained hosts are excluded. If the health check is
+// disabled, all other hosts are assumed to be healthy.
+func (s *Runner) checkHost(pool *Pool) func(context.Context, string) error {
+	return func(ctx context.Context, host string) error {
+		if s.Maintenance != nil && s.Maintenance.Drained(host) {
+			return errDrained
+		}
+		if s.HealthCheck <= 0 {
+			return nil
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "22")
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.HealthCheck)
+		defer cancel()
+		return engine.Ping(ctx, engine.Server{
+			Hostname:     host,
+			Username:     pool.Username,
+			Password:     pool.Password,
+			SSHKey:       pool.SSHKey,
+			Ciphers:      s.Ciphers,
+			KeyExchanges: s.KeyExchanges,
+			MACs:         s.MACs,
+		})
+	}
+}
+
+// helper function returns the host provisioner, defaulting
+// to a no-op provisioner if not configured.
+func (s *Runner) provisioner() provision.Provider {
+	if s.Provisioner == nil {
+		return provision.Nop()
+	}
+	return s.Provisioner
+}
+
+// helper function skips the stage and all pending pipeline
+// steps.
+func skipAll(state *pipeline.State) {
+	state.Lock()
+	defer state.Unlock()
+	now := time.Now().Unix()
+	if state.Stage.Started == 0 {
+		state.Stage.Started = now
+	}
+	state.Stage.Stopped = now
+	state.Stage.Status = drone.StatusSkipped
+	for _, step := range state.Stage.Steps {
+		if step.Status == drone.StatusPending {
+			step.Status = drone.StatusSkipped
+			step.Started = now
+			step.Stopped = now
+		}
+	}
+}
+
+// helper function returns the failure message of the stage,
+// or an empty string if the stage succeeded.
+func failure(state *pipeline.State, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case state.Stage.Error != "":
+		return state.Stage.Error
+	case state.Failed():
+		return "stage failed"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Scheduler tracks the number of active stages executing on
+// each remote host, and defers execution of stages that would
+// exceed the maximum number of stages per host.
+type Scheduler struct {
+	// Max is the maximum number of stages that can execute
+	// concurrently on a single remote host. If zero, the
+	// number of stages is not limited.
+	Max int
+
+	mu      sync.Mutex
+	hosts   map[string]chan struct{}
+	waiting int64
+}
+
+// Acquire blocks until the host has capacity to execute the
+// stage, or until the context is cancelled.
+func (s *Scheduler) Acquire(ctx context.Context, host string) error {
+	if s.Max <= 0 {
+		return nil
+	}
+	sem := s.semaphore(host)
+	select {
+	case sem <- struct{}{}:
+		return nil
+	default:
+	}
+	atomic.AddInt64(&s.waiting, 1)
+	defer atomic.AddInt64(&s.waiting, -1)
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Waiting returns the number of stages waiting for remote host
+// capacity.
+func (s *Scheduler) Waiting() int {
+	return int(atomic.LoadInt64(&s.waiting))
+}
+
+// Saturated returns true if stages are waiting for remote host
+// capacity and no remote host has capacity, in which case the
+// runner should not request more stages until the hosts have
+// capacity. A saturated host does not prevent the runner from
+// requesting stages for the other hosts.
+func (s *Scheduler) Saturated() bool {
+	if s.Waiting() == 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sem := range s.hosts {
+		if len(sem) < s.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// Release releases the capacity acquired for the host.
+func (s *Scheduler) Release(host string) {
+	if s.Max <= 0 {
+		return
+	}
+	select {
+	case <-s.semaphore(host):
+	default:
+	}
+}
+
+// Active returns the number of active stages executing on
+// the host.
+func (s *Scheduler) Active(host string) int {
+	if s.Max <= 0 {
+		return 0
+	}
+	return len(s.semaphore(host))
+}
+
+// helper function returns the semaphore for the host, creating
+// the semaphore if it does not exist.
+func (s *Scheduler) semaphore(host string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hosts == nil {
+		s.hosts = map[string]chan struct{}{}
+	}
+	sem, ok := s.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, s.Max)
+		s.hosts[host] = sem
+	}
+	return sem
+}
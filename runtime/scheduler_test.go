@@ -0,0 +1,379 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-ssh/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/client"
+	"github.com/drone/runner-go/pipeline"
+)
+
+func TestScheduler(t *testing.T) {
+	s := &Scheduler{Max: 2}
+	ctx := context.Background()
+
+	if err := s.Acquire(ctx, "server1:22"); err != nil {
+		t.Error(err)
+	}
+	if err := s.Acquire(ctx, "server1:22"); err != nil {
+		t.Error(err)
+	}
+	if err := s.Acquire(ctx, "server2:22"); err != nil {
+		t.Errorf("Expect capacity tracked per host, got %s", err)
+	}
+	if got, want := s.Active("server1:22"), 2; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+
+	ctxtimeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctxtimeout, "server1:22"); err == nil {
+		t.Errorf("Expect acquire deferred when host is at capacity")
+	}
+
+	s.Release("server1:22")
+	if got, want := s.Active("server1:22"), 1; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+	if err := s.Acquire(ctx, "server1:22"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScheduler_Unlimited(t *testing.T) {
+	s := new(Scheduler)
+	for i := 0; i < 100; i++ {
+		if err := s.Acquire(context.Background(), "server1:22"); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if got := s.Active("server1:22"); got != 0 {
+		t.Errorf("Expect active stages not tracked when unlimited")
+	}
+}
+
+func TestScheduler_Saturated(t *testing.T) {
+	s := &Scheduler{Max: 1}
+	ctx := context.Background()
+
+	s.Acquire(ctx, "server1:22")
+	if s.Saturated() {
+		t.Errorf("Expect scheduler not saturated when no stages are waiting")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Acquire(ctx, "server1:22")
+		close(done)
+	}()
+	for i := 0; i < 100 && s.Waiting() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !s.Saturated() {
+		t.Errorf("Expect scheduler saturated when stages are waiting")
+	}
+
+	// the scheduler is not saturated while another host has
+	// capacity.
+	s.Acquire(ctx, "server2:22")
+	s.Release("server2:22")
+	if s.Saturated() {
+		t.Errorf("Expect scheduler not saturated when a host has capacity")
+	}
+
+	s.Release("server1:22")
+	<-done
+	if got, want := s.Waiting(), 0; got != want {
+		t.Errorf("Want %d waiting stages, got %d", want, got)
+	}
+}
+
+// acceptClient is a client that records whether the stage was
+// accepted, and blocks watching for cancellation until the
+// context is cancelled.
+type acceptClient struct {
+	client.Client
+	accepted int32
+	slug     string
+}
+
+func (c *acceptClient) Accept(context.Context, *drone.Stage) error {
+	atomic.StoreInt32(&c.accepted, 1)
+	return nil
+}
+
+func (c *acceptClient) Detail(context.Context, *drone.Stage) (*client.Context, error) {
+	return &client.Context{
+		Build:  &drone.Build{},
+		Repo:   &drone.Repo{Slug: c.slug, Timeout: 60},
+		System: &drone.System{},
+		Netrc:  &drone.Netrc{},
+		Config: &client.File{Data: []byte("kind: pipeline\ntype: ssh\nname: default\nserver:\n  host: server1\n  user: root\n  password: root\nsteps:\n- name: build\n  commands:\n  - go build\n")},
+	}, nil
+}
+
+func (c *acceptClient) Watch(ctx context.Context, _ int64) (bool, error) {
+	<-ctx.Done()
+	return false, nil
+}
+
+func (c *acceptClient) Update(context.Context, *drone.Stage) error {
+	return nil
+}
+
+// cancelClient is a client that reports the build cancelled
+// when the cancel channel is closed.
+type cancelClient struct {
+	acceptClient
+	cancel chan struct{}
+}
+
+func (c *cancelClient) Watch(ctx context.Context, _ int64) (bool, error) {
+	select {
+	case <-c.cancel:
+		return true, nil
+	case <-ctx.Done():
+		return false, nil
+	}
+}
+
+// deadlineExecer is an execer that records the deadline of the
+// stage context.
+type deadlineExecer struct {
+	deadline time.Time
+}
+
+func (e *deadlineExecer) Exec(ctx context.Context, _ *engine.Spec, _ *pipeline.State) error {
+	e.deadline, _ = ctx.Deadline()
+	return nil
+}
+
+// This test verifies that the stage is accepted before waiting
+// for remote host capacity, and that the stage timeout starts
+// once the remote host capacity is acquired.
+func TestScheduler_AcceptBeforeAcquire(t *testing.T) {
+	s := &Scheduler{Max: 1}
+	s.Acquire(context.Background(), "server1:22")
+
+	cli := new(acceptClient)
+	execer := new(deadlineExecer)
+	runner := &Runner{
+		Client:    cli,
+		Execer:    execer,
+		Reporter:  nopReporter{},
+		Scheduler: s,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(context.Background(), &drone.Stage{ID: 1, Name: "default"})
+	}()
+
+	for s.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&cli.accepted) == 0 {
+		t.Errorf("Expect stage accepted while waiting for remote host capacity")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	acquired := time.Now()
+	s.Release("server1:22")
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if want := acquired.Add(time.Hour); execer.deadline.Before(want) {
+		t.Errorf("Expect stage timeout started after acquiring remote host capacity")
+	}
+}
+
+// blockExecer is an execer that signals the stage started and
+// blocks until the stage context is cancelled.
+type blockExecer struct {
+	started chan struct{}
+}
+
+func (e *blockExecer) Exec(ctx context.Context, _ *engine.Spec, _ *pipeline.State) error {
+	close(e.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// This test verifies that a stage is deferred while the
+// repository is at capacity, that stages for other repositories
+// are not deferred, and that the capacity is released when the
+// stage completes.
+func TestRunner_RepoScheduler(t *testing.T) {
+	s := &Scheduler{Max: 1}
+	s.Acquire(context.Background(), "octocat/hello-world")
+
+	execer := new(deadlineExecer)
+	runner := &Runner{
+		Client:        &acceptClient{slug: "octocat/hello-world"},
+		Execer:        execer,
+		Reporter:      nopReporter{},
+		RepoScheduler: s,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(context.Background(), &drone.Stage{ID: 1, Name: "default"})
+	}()
+	for s.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a stage for another repository is not deferred while
+	// the repository is at capacity.
+	other := &Runner{
+		Client:        &acceptClient{slug: "octocat/spoon-knife"},
+		Execer:        new(deadlineExecer),
+		Reporter:      nopReporter{},
+		RepoScheduler: s,
+	}
+	if err := other.Run(context.Background(), &drone.Stage{ID: 2, Name: "default"}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.Active("octocat/spoon-knife"), 0; got != want {
+		t.Errorf("Want %d active stages for other repository, got %d", want, got)
+	}
+
+	select {
+	case <-done:
+		t.Errorf("Expect stage deferred while repository is at capacity")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !execer.deadline.IsZero() {
+		t.Errorf("Expect stage not executed while repository is at capacity")
+	}
+
+	s.Release("octocat/hello-world")
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if execer.deadline.IsZero() {
+		t.Errorf("Expect stage executed after acquiring repository capacity")
+	}
+	if got, want := s.Active("octocat/hello-world"), 0; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+}
+
+// This test verifies that a stage cancelled while waiting for
+// repository capacity stops waiting without acquiring capacity.
+func TestRunner_RepoSchedulerCancelWaiting(t *testing.T) {
+	s := &Scheduler{Max: 1}
+	s.Acquire(context.Background(), "octocat/hello-world")
+
+	cli := &cancelClient{cancel: make(chan struct{})}
+	cli.slug = "octocat/hello-world"
+	execer := new(deadlineExecer)
+	runner := &Runner{
+		Client:        cli,
+		Execer:        execer,
+		Reporter:      nopReporter{},
+		RepoScheduler: s,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(context.Background(), &drone.Stage{ID: 1, Name: "default"})
+	}()
+	for s.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(cli.cancel)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expect stage stops waiting for repository capacity when cancelled")
+		return
+	}
+	if !execer.deadline.IsZero() {
+		t.Errorf("Expect cancelled stage not executed")
+	}
+	if got, want := s.Waiting(), 0; got != want {
+		t.Errorf("Want %d waiting stages, got %d", want, got)
+	}
+	if got, want := s.Active("octocat/hello-world"), 1; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+}
+
+// This test verifies that the repository capacity is released
+// when a running stage is cancelled.
+func TestRunner_RepoSchedulerCancelRunning(t *testing.T) {
+	s := &Scheduler{Max: 1}
+
+	cli := &cancelClient{cancel: make(chan struct{})}
+	cli.slug = "octocat/hello-world"
+	execer := &blockExecer{started: make(chan struct{})}
+	runner := &Runner{
+		Client:        cli,
+		Execer:        execer,
+		Reporter:      nopReporter{},
+		RepoScheduler: s,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- runner.Run(context.Background(), &drone.Stage{ID: 1, Name: "default"})
+	}()
+
+	<-execer.started
+	if got, want := s.Active("octocat/hello-world"), 1; got != want {
+		t.Errorf("Want %d active stages, got %d", want, got)
+	}
+
+	close(cli.cancel)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expect running stage stops when cancelled")
+		return
+	}
+	if got, want := s.Active("octocat/hello-world"), 0; got != want {
+		t.Errorf("Want %d active stages after cancellation, got %d", want, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx, "octocat/hello-world"); err != nil {
+		t.Errorf("Expect repository capacity available after cancellation")
+	}
+}
+
+// This test verifies that a stage with a node selector that
+// does not match the runner labels is declined without being
+// accepted, so that a matching runner can accept the stage.
+func TestRunner_LabelsDeclined(t *testing.T) {
+	cli := new(acceptClient)
+	runner := &Runner{
+		Client:   cli,
+		Reporter: nopReporter{},
+		Labels:   map[string]string{"region": "us-east"},
+	}
+
+	stage := &drone.Stage{
+		ID:     1,
+		Name:   "default",
+		Labels: map[string]string{"region": "eu-west"},
+	}
+	if err := runner.Run(context.Background(), stage); err != nil {
+		t.Error(err)
+	}
+	if atomic.LoadInt32(&cli.accepted) != 0 {
+		t.Errorf("Expect stage with mismatched labels not accepted")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// WriteSummary writes a table that summarizes the duration and
+// exit code of each pipeline step to the writer.
+func WriteSummary(w io.Writer, state *pipeline.State, host string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tDURATION\tEXIT CODE\tHOST")
+	for _, step := range state.Stage.Steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n",
+			step.Name,
+			step.Status,
+			duration(step),
+			step.ExitCode,
+			host,
+		)
+	}
+	tw.Flush()
+}
+
+// helper function writes the duration and exit code of each
+// pipeline step to the logger in structured form.
+func logSummary(log logger.Logger, state *pipeline.State, host string) {
+	for _, step := range state.Stage.Steps {
+		log.WithField("step.name", step.Name).
+			WithField("step.status", step.Status).
+			WithField("step.duration", duration(step).String()).
+			WithField("step.exit_code", step.ExitCode).
+			WithField("host", host).
+			Info("step summary")
+	}
+}
+
+// helper function returns the step duration. Steps that did
+// not execute have a zero duration.
+func duration(step *drone.Step) time.Duration {
+	if step.Started == 0 || step.Stopped < step.Started {
+		return 0
+	}
+	return time.Duration(step.Stopped-step.Started) * time.Second
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+func TestWriteSummary(t *testing.T) {
+	state := &pipeline.State{
+		Stage: &drone.Stage{
+			Steps: []*drone.Step{
+				{Name: "clone", Status: drone.StatusPassing, Started: 100, Stopped: 102},
+				{Name: "test", Status: drone.StatusFailing, Started: 102, Stopped: 192, ExitCode: 1},
+				{Name: "deploy", Status: drone.StatusSkipped},
+			},
+		},
+	}
+	buf := new(bytes.Buffer)
+	WriteSummary(buf, state, "10.0.0.1:22")
+
+	want := "" +
+		"STEP    STATUS   DURATION  EXIT CODE  HOST\n" +
+		"clone   success  2s        0          10.0.0.1:22\n" +
+		"test    failure  1m30s     1          10.0.0.1:22\n" +
+		"deploy  skipped  0s        0          10.0.0.1:22\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Unexpected summary\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
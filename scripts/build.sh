@@ -9,7 +9,12 @@ export CGO_ENABLED=0
 set -e
 set -x
 
+# build metadata
+VERSION=${DRONE_TAG:-0.0.0}
+COMMIT=${DRONE_COMMIT_SHA:-$(git rev-parse HEAD)}
+LDFLAGS="-X github.com/drone-runners/drone-runner-ssh/internal/version.Version=${VERSION#v} -X github.com/drone-runners/drone-runner-ssh/internal/version.Commit=${COMMIT}"
+
 # linux
-GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/drone-runner-ssh
-GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/drone-runner-ssh
-GOOS=linux GOARCH=arm   go build -o release/linux/arm/drone-runner-ssh
+GOOS=linux GOARCH=amd64 go build -ldflags "$LDFLAGS" -o release/linux/amd64/drone-runner-ssh
+GOOS=linux GOARCH=arm64 go build -ldflags "$LDFLAGS" -o release/linux/arm64/drone-runner-ssh
+GOOS=linux GOARCH=arm   go build -ldflags "$LDFLAGS" -o release/linux/arm/drone-runner-ssh